@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2Params describes the cost of an argon2id hash
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Time        uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follows the OWASP baseline for argon2id
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Time:        3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var ErrInvalidHash = errors.New("invalid hash format")
+
+// HashArgon2id hashes secret and returns it encoded as a PHC string:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func HashArgon2id(secret string, p Argon2Params) (string, error) {
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(secret), salt, p.Time, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyArgon2id checks secret against a PHC encoded argon2id hash
+func VerifyArgon2id(secret, encoded string) (bool, error) {
+	p, salt, key, err := DecodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(secret), salt, p.Time, p.Memory, p.Parallelism, p.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// DecodeArgon2id parses a PHC encoded argon2id hash
+func DecodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrInvalidHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Parallelism); err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
@@ -11,13 +11,24 @@ var jwtSecret = []byte(Config.AppConfig.JWTToken)
 type Claims struct {
 	UserID   int64  `json:"user_id"`
 	Username string `json:"username"`
+	// Permissions is a snapshot of the user's RBAC permissions, only trusted
+	// while PermVersion matches users.perm_version
+	Permissions []string `json:"perms,omitempty"`
+	PermVersion int64    `json:"pv,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func GenerateJWT(userID int64, username string) (string, error) {
+	return GenerateJWTWithPermissions(userID, username, nil, 0)
+}
+
+// GenerateJWTWithPermissions embeds the user's permissions and their version
+func GenerateJWTWithPermissions(userID int64, username string, perms []string, version int64) (string, error) {
 	claims := &Claims{
-		UserID:   userID,
-		Username: username,
+		UserID:      userID,
+		Username:    username,
+		Permissions: perms,
+		PermVersion: version,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(2 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"strings"
+
+	bip39 "github.com/tyler-smith/go-bip39"
+)
+
+// NormalizeMnemonic lowercases the phrase and collapses whitespace so that
+// "Word1  word2\n" and "word1 word2" hash to the same value
+func NormalizeMnemonic(phrase string) string {
+	return strings.Join(strings.Fields(strings.ToLower(phrase)), " ")
+}
+
+// IsValidMnemonic reports whether the normalized phrase is a valid BIP39 mnemonic
+func IsValidMnemonic(phrase string) bool {
+	return bip39.IsMnemonicValid(NormalizeMnemonic(phrase))
+}
+
+// HashMnemonic returns an argon2id hash of the normalized recovery phrase
+func HashMnemonic(phrase string) (string, error) {
+	return HashArgon2id(NormalizeMnemonic(phrase), DefaultArgon2Params)
+}
+
+// VerifyMnemonic checks a recovery phrase against its stored hash
+func VerifyMnemonic(phrase, hash string) bool {
+	ok, err := VerifyArgon2id(NormalizeMnemonic(phrase), hash)
+	return err == nil && ok
+}
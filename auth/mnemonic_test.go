@@ -0,0 +1,34 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+
+	"mFrelance/auth"
+)
+
+func TestHashMnemonic_VerifyNormalized(t *testing.T) {
+	phrase := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	hash, err := auth.HashMnemonic(phrase)
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	if strings.Contains(hash, "abandon") {
+		t.Fatalf("hash leaks plaintext: %s", hash)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		t.Fatalf("unexpected hash format: %s", hash)
+	}
+	if !auth.VerifyMnemonic("  ABANDON abandon abandon abandon abandon abandon\nabandon abandon abandon abandon abandon about ", hash) {
+		t.Fatalf("normalized phrase did not verify")
+	}
+	if auth.VerifyMnemonic("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon", hash) {
+		t.Fatalf("wrong phrase verified")
+	}
+}
+
+func TestVerifyMnemonic_InvalidHash(t *testing.T) {
+	if auth.VerifyMnemonic("abandon about", "not-a-hash") {
+		t.Fatalf("garbage hash verified")
+	}
+}
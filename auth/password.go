@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"mFrelance/config"
+)
+
+// PasswordParams returns the argon2id cost used for new password hashes.
+// Zero values in the config fall back to DefaultArgon2Params
+func PasswordParams() Argon2Params {
+	p := DefaultArgon2Params
+	if config.AppConfig.PasswordArgon2Memory > 0 {
+		p.Memory = config.AppConfig.PasswordArgon2Memory
+	}
+	if config.AppConfig.PasswordArgon2Time > 0 {
+		p.Time = config.AppConfig.PasswordArgon2Time
+	}
+	if config.AppConfig.PasswordArgon2Parallelism > 0 {
+		p.Parallelism = config.AppConfig.PasswordArgon2Parallelism
+	}
+	return p
+}
+
+// HashPassword hashes a password with argon2id using the current policy
+func HashPassword(password string) (string, error) {
+	return HashArgon2id(password, PasswordParams())
+}
+
+func isBcryptHash(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+// VerifyPassword checks password against a stored hash. Both argon2id PHC
+// strings and legacy bcrypt hashes are accepted. needsRehash is true when
+// the password matched but the hash is not argon2id with the current params
+func VerifyPassword(password, encoded string) (ok bool, needsRehash bool) {
+	if isBcryptHash(encoded) {
+		if bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) != nil {
+			return false, false
+		}
+		return true, true
+	}
+
+	p, _, _, err := DecodeArgon2id(encoded)
+	if err != nil {
+		return false, false
+	}
+	ok, err = VerifyArgon2id(password, encoded)
+	if err != nil || !ok {
+		return false, false
+	}
+	cur := PasswordParams()
+	needsRehash = p.Memory != cur.Memory || p.Time != cur.Time ||
+		p.Parallelism != cur.Parallelism || p.KeyLength != cur.KeyLength
+	return true, needsRehash
+}
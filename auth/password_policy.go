@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"mFrelance/config"
+)
+
+// MaxPasswordLength caps the work an attacker can force per login attempt
+const MaxPasswordLength = 128
+
+var (
+	ErrPasswordTooShort = errors.New("password too small")
+	ErrPasswordTooLong  = errors.New("password too long")
+	ErrPasswordBreached = errors.New("password found in a list of breached passwords")
+)
+
+var (
+	breachedOnce sync.Once
+	breached     map[string]struct{}
+	sha1Line     = regexp.MustCompile(`^[0-9A-Fa-f]{40}(:\d+)?$`)
+)
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// loadBreachedList reads the breached password file once. Each line is either
+// a plaintext password or a hex SHA-1 in the HIBP
+// "HASH:count" format
+func loadBreachedList() {
+	path := config.AppConfig.PasswordBreachedListPath
+	if path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Println("[PasswordPolicy] breached password list not loaded:", err)
+		return
+	}
+	defer f.Close()
+
+	set := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if sha1Line.MatchString(line) {
+			set[strings.ToUpper(line[:40])] = struct{}{}
+			continue
+		}
+		set[sha1Hex(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println("[PasswordPolicy] failed to read breached password list:", err)
+		return
+	}
+	breached = set
+	log.Printf("[PasswordPolicy] loaded %d breached passwords", len(set))
+}
+
+// IsBreachedPassword reports whether password is in the configured breached list
+func IsBreachedPassword(password string) bool {
+	breachedOnce.Do(loadBreachedList)
+	if breached == nil {
+		return false
+	}
+	_, ok := breached[sha1Hex(password)]
+	return ok
+}
+
+// ValidatePassword enforces the password policy for new passwords
+func ValidatePassword(password string) error {
+	n := utf8.RuneCountInString(password)
+	if n < config.AppConfig.PasswordMinLength {
+		return ErrPasswordTooShort
+	}
+	if n > MaxPasswordLength {
+		return ErrPasswordTooLong
+	}
+	if IsBreachedPassword(password) {
+		return ErrPasswordBreached
+	}
+	return nil
+}
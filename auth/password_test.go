@@ -0,0 +1,34 @@
+package auth_test
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"mFrelance/auth"
+)
+
+func TestVerifyPassword_Argon2id(t *testing.T) {
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	ok, rehash := auth.VerifyPassword("correct horse", hash)
+	if !ok || rehash {
+		t.Fatalf("ok=%v rehash=%v, want ok=true rehash=false", ok, rehash)
+	}
+	if ok, _ := auth.VerifyPassword("wrong horse", hash); ok {
+		t.Fatalf("wrong password verified")
+	}
+}
+
+func TestVerifyPassword_LegacyBcryptNeedsRehash(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	ok, rehash := auth.VerifyPassword("correct horse", string(legacy))
+	if !ok || !rehash {
+		t.Fatalf("ok=%v rehash=%v, want ok=true rehash=true", ok, rehash)
+	}
+}
@@ -0,0 +1,185 @@
+// Package avatar turns an uploaded picture into square avatar thumbnails.
+// Images are decoded and encoded again, which drops EXIF and every other
+// piece of metadata; the EXIF orientation of JPEG photos is applied first so
+// pictures taken on phones aren't shown sideways.
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"sort"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// maxPixels guards against decompression bombs
+const maxPixels = 40_000_000
+
+var (
+	ErrFormat   = errors.New("avatar must be a PNG, JPEG or WebP image")
+	ErrTooLarge = errors.New("avatar dimensions too large")
+)
+
+// Variant is one encoded thumbnail. Size is the requested edge length; the
+// image is smaller when the source was.
+type Variant struct {
+	Size        int
+	ContentType string
+	Data        []byte
+}
+
+// Process decodes data and renders a square, center-cropped thumbnail for
+// each size, largest first. JPEG sources give JPEG thumbnails, PNG and WebP
+// give PNG so transparency survives.
+func Process(data []byte, sizes []int) ([]Variant, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "png" && format != "jpeg" && format != "webp") {
+		return nil, ErrFormat
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrFormat
+	}
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(data)
+	}
+
+	sizes = append([]int(nil), sizes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	// The center square and the EXIF transforms commute, so the picture is
+	// cropped and scaled first and only the small result gets rotated.
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(b.Min).Add(image.Pt((b.Dx()-side)/2, (b.Dy()-side)/2))
+
+	variants := make([]Variant, 0, len(sizes))
+	var prev *image.RGBA
+	for _, size := range sizes {
+		edge := min(size, side)
+		dst := image.NewRGBA(image.Rect(0, 0, edge, edge))
+		if prev == nil {
+			draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+		} else {
+			draw.CatmullRom.Scale(dst, dst.Bounds(), prev, prev.Bounds(), draw.Src, nil)
+		}
+		prev = dst
+
+		v := Variant{Size: size}
+		var buf bytes.Buffer
+		if format == "jpeg" {
+			v.ContentType = "image/jpeg"
+			err = jpeg.Encode(&buf, orient(dst, orientation), &jpeg.Options{Quality: 90})
+		} else {
+			v.ContentType = "image/png"
+			err = png.Encode(&buf, orient(dst, orientation))
+		}
+		if err != nil {
+			return nil, err
+		}
+		v.Data = buf.Bytes()
+		variants = append(variants, v)
+	}
+	return variants, nil
+}
+
+// orient turns a square image as stored in the file into the way it is
+// meant to be shown, per its EXIF orientation (1-8)
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	n := img.Bounds().Dx()
+	out := image.NewRGBA(img.Bounds())
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirror
+				sx, sy = n-1-x, y
+			case 3: // rotate 180°
+				sx, sy = n-1-x, n-1-y
+			case 4: // flip
+				sx, sy = x, n-1-y
+			case 5: // transpose
+				sx, sy = y, x
+			case 6: // rotate 90° clockwise
+				sx, sy = y, n-1-x
+			case 7: // transverse
+				sx, sy = n-1-y, n-1-x
+			case 8: // rotate 90° counter-clockwise
+				sx, sy = n-1-y, x
+			}
+			out.SetRGBA(x, y, img.RGBAAt(sx, sy))
+		}
+	}
+	return out
+}
+
+// jpegOrientation reads the orientation tag from the EXIF segment of a
+// JPEG. Returns 1 when there is none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // image data starts
+			return 1
+		}
+		length := int(data[i+2])<<8 | int(data[i+3])
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		seg := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(seg) > 6 && string(seg[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(seg[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation finds tag 0x0112 in IFD0 of a TIFF header
+func tiffOrientation(t []byte) int {
+	if len(t) < 8 {
+		return 1
+	}
+	var u16 func([]byte) int
+	var u32 func([]byte) int
+	switch string(t[:2]) {
+	case "II":
+		u16 = func(b []byte) int { return int(b[0]) | int(b[1])<<8 }
+		u32 = func(b []byte) int { return u16(b) | u16(b[2:])<<16 }
+	case "MM":
+		u16 = func(b []byte) int { return int(b[0])<<8 | int(b[1]) }
+		u32 = func(b []byte) int { return u16(b)<<16 | u16(b[2:]) }
+	default:
+		return 1
+	}
+	ifd := u32(t[4:])
+	if ifd < 8 || ifd+2 > len(t) {
+		return 1
+	}
+	count := u16(t[ifd:])
+	for e := ifd + 2; count > 0 && e+12 <= len(t); e, count = e+12, count-1 {
+		if u16(t[e:]) == 0x0112 {
+			if o := u16(t[e+8:]); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
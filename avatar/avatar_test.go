@@ -0,0 +1,120 @@
+package avatar
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// quadrants draws a w×h image with a red top-left quadrant on white
+func quadrants(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{255, 255, 255, 255}
+			if x < w/2 && y < h/2 {
+				c = color.RGBA{255, 0, 0, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// withOrientation inserts an EXIF segment with the orientation tag right
+// after the SOI marker of a JPEG
+func withOrientation(t *testing.T, jpg []byte, orientation byte) []byte {
+	t.Helper()
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8, // big endian, IFD0 at 8
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0, // orientation SHORT
+		0, 0, 0, 0, // no next IFD
+	}
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+	n := len(seg) + 2
+	app1 := append([]byte{0xFF, 0xE1, byte(n >> 8), byte(n)}, seg...)
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, app1...)
+	return append(out, jpg[2:]...)
+}
+
+func TestProcessSizes(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, quadrants(300, 200)); err != nil {
+		t.Fatal(err)
+	}
+	variants, err := Process(buf.Bytes(), []int{64, 512, 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ size, edge int }{{512, 200}, {128, 128}, {64, 64}}
+	if len(variants) != len(want) {
+		t.Fatalf("got %d variants, want %d", len(variants), len(want))
+	}
+	for i, v := range variants {
+		if v.Size != want[i].size || v.ContentType != "image/png" {
+			t.Errorf("variant %d: size %d type %s", i, v.Size, v.ContentType)
+		}
+		cfg, err := png.DecodeConfig(bytes.NewReader(v.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Width != want[i].edge || cfg.Height != want[i].edge {
+			t.Errorf("variant %d is %dx%d, want %d square", i, cfg.Width, cfg.Height, want[i].edge)
+		}
+	}
+}
+
+func TestProcessRejectsOtherFormats(t *testing.T) {
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, quadrants(10, 10), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Process(buf.Bytes(), []int{64}); err != ErrFormat {
+		t.Errorf("gif: got %v, want ErrFormat", err)
+	}
+	if _, err := Process([]byte("<svg></svg>"), []int{64}); err != ErrFormat {
+		t.Errorf("svg: got %v, want ErrFormat", err)
+	}
+}
+
+func TestProcessStripsExifAndAppliesOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, quadrants(64, 64), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	// Orientation 6: shown rotated 90° clockwise, so the red quadrant moves
+	// to the top right
+	data := withOrientation(t, buf.Bytes(), 6)
+	if jpegOrientation(data) != 6 {
+		t.Fatal("test image lacks the orientation tag")
+	}
+
+	variants, err := Process(data, []int{64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := variants[0].Data
+	if variants[0].ContentType != "image/jpeg" {
+		t.Errorf("got %s, want image/jpeg", variants[0].ContentType)
+	}
+	if bytes.Contains(out, []byte("Exif")) {
+		t.Error("EXIF segment survived")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	isRed := func(x, y int) bool {
+		r, g, _, _ := img.At(x, y).RGBA()
+		return r > 0xc000 && g < 0x4000
+	}
+	if !isRed(48, 16) || isRed(16, 16) {
+		t.Error("orientation wasn't applied")
+	}
+}
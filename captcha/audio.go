@@ -0,0 +1,173 @@
+package captcha
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"mFrelance/config"
+)
+
+const sampleRate = 8000
+
+// audioProvider reads the digits out loud. With captcha.audio_dir set it
+// splices the recorded clips 0.wav..9.wav (8 kHz, 16-bit mono PCM), otherwise
+// every digit is played as a series of beeps (0 is ten beeps).
+type audioProvider struct{}
+
+func (audioProvider) Name() string { return Audio }
+
+func (audioProvider) Generate() (*Challenge, error) {
+	text := generateText()
+	var samples []int16
+	samples = append(samples, silence(500)...)
+	for _, r := range text {
+		d := int(r - '0')
+		if clip := digitClip(d); clip != nil {
+			samples = append(samples, clip...)
+		} else {
+			samples = append(samples, beeps(d)...)
+		}
+		samples = append(samples, silence(900)...)
+	}
+	addNoise(samples, 600)
+	return &Challenge{Secret: text, ContentType: "audio/wav", Body: encodeWAV(samples)}, nil
+}
+
+func (audioProvider) Check(secret, answer string) bool {
+	return secret == answer
+}
+
+var (
+	clipsOnce sync.Once
+	clips     [][]int16
+)
+
+func digitClip(d int) []int16 {
+	clipsOnce.Do(loadClips)
+	if clips == nil {
+		return nil
+	}
+	return clips[d]
+}
+
+func loadClips() {
+	dir := config.AppConfig.CaptchaAudioDir
+	if dir == "" {
+		return
+	}
+	loaded := make([][]int16, 10)
+	for d := 0; d < 10; d++ {
+		data, err := os.ReadFile(filepath.Join(dir, strconv.Itoa(d)+".wav"))
+		if err != nil {
+			log.Println("[Captcha] audio clips not loaded, falling back to beeps:", err)
+			return
+		}
+		pcm, err := decodeWAV(data)
+		if err != nil {
+			log.Printf("[Captcha] bad audio clip %d.wav, falling back to beeps: %v", d, err)
+			return
+		}
+		loaded[d] = pcm
+	}
+	clips = loaded
+}
+
+func silence(ms int) []int16 {
+	return make([]int16, sampleRate*ms/1000)
+}
+
+func tone(ms int, freq float64) []int16 {
+	n := sampleRate * ms / 1000
+	out := make([]int16, n)
+	for i := range out {
+		out[i] = int16(8000 * math.Sin(2*math.Pi*freq*float64(i)/sampleRate))
+	}
+	return out
+}
+
+func beeps(d int) []int16 {
+	if d == 0 {
+		d = 10
+	}
+	var out []int16
+	for i := 0; i < d; i++ {
+		out = append(out, tone(150, 880)...)
+		out = append(out, silence(150)...)
+	}
+	return out
+}
+
+func addNoise(samples []int16, amp int) {
+	for i, s := range samples {
+		v := int(s) + rand.Intn(2*amp+1) - amp
+		if v > math.MaxInt16 {
+			v = math.MaxInt16
+		} else if v < math.MinInt16 {
+			v = math.MinInt16
+		}
+		samples[i] = int16(v)
+	}
+}
+
+func encodeWAV(samples []int16) []byte {
+	var buf bytes.Buffer
+	dataLen := uint32(len(samples) * 2)
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, 36+dataLen)
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // mono
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate*2))
+	binary.Write(&buf, binary.LittleEndian, uint16(2))
+	binary.Write(&buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, dataLen)
+	binary.Write(&buf, binary.LittleEndian, samples)
+	return buf.Bytes()
+}
+
+// decodeWAV extracts the samples of an 8 kHz 16-bit mono PCM file
+func decodeWAV(data []byte) ([]int16, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, errors.New("not a WAV file")
+	}
+	var formatOK bool
+	for off := 12; off+8 <= len(data); {
+		id := string(data[off : off+4])
+		size := int(binary.LittleEndian.Uint32(data[off+4 : off+8]))
+		body := off + 8
+		if body+size > len(data) {
+			return nil, errors.New("truncated chunk")
+		}
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, errors.New("short fmt chunk")
+			}
+			f := data[body:]
+			formatOK = binary.LittleEndian.Uint16(f[0:2]) == 1 &&
+				binary.LittleEndian.Uint16(f[2:4]) == 1 &&
+				binary.LittleEndian.Uint32(f[4:8]) == sampleRate &&
+				binary.LittleEndian.Uint16(f[14:16]) == 16
+		case "data":
+			if !formatOK {
+				return nil, errors.New("expected 8 kHz 16-bit mono PCM")
+			}
+			pcm := make([]int16, size/2)
+			binary.Read(bytes.NewReader(data[body:body+size/2*2]), binary.LittleEndian, pcm)
+			return pcm, nil
+		}
+		off = body + size + size%2
+	}
+	return nil, errors.New("no data chunk")
+}
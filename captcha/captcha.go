@@ -0,0 +1,158 @@
+// Package captcha implements the challenge providers used by the public
+// auth endpoints. Each provider keeps its own Redis key space so an answer
+// issued by one provider can never be redeemed against another: ids never
+// contain ':', which separates the provider from the id in every key but the
+// legacy image one.
+package captcha
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"mFrelance/config"
+)
+
+const (
+	Image = "image"
+	Audio = "audio"
+	PoW   = "pow"
+
+	// Endpoints that can require a captcha, used as keys in captcha.endpoints
+	EndpointRegister = "register"
+	EndpointAuth     = "auth"
+	EndpointRestore  = "restore"
+
+	ChallengeTTL = 5 * time.Minute
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown captcha provider")
+	ErrNotAllowed      = errors.New("captcha provider not allowed for this endpoint")
+)
+
+// Challenge is a freshly generated captcha. Secret is what gets stored in
+// Redis and is never sent to the client.
+type Challenge struct {
+	ID          string
+	Provider    string
+	Secret      string
+	ContentType string
+	Body        []byte                 // image/audio payload, nil for JSON challenges
+	Meta        map[string]interface{} // extra fields returned as JSON
+}
+
+// Provider generates and checks one kind of challenge
+type Provider interface {
+	Name() string
+	Generate() (*Challenge, error)
+	Check(secret, answer string) bool
+}
+
+var providers = map[string]Provider{
+	Image: imageProvider{},
+	Audio: audioProvider{},
+	PoW:   powProvider{},
+}
+
+// Get returns the provider registered under name
+func Get(name string) (Provider, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+// Key returns the Redis key for a challenge. Image captchas keep the legacy
+// "captcha:<id>" key so existing clients and Lua scripts keep working.
+func Key(provider, id string) string {
+	if provider == Image {
+		return "captcha:" + id
+	}
+	return "captcha:" + provider + ":" + id
+}
+
+// AllowedProviders returns the providers configured for endpoint. Endpoints
+// missing from the config only accept the image captcha.
+func AllowedProviders(endpoint string) []string {
+	if list, ok := config.AppConfig.CaptchaEndpoints[endpoint]; ok && len(list) > 0 {
+		return list
+	}
+	return []string{Image}
+}
+
+// Resolve picks the provider for endpoint. An empty name selects the first
+// configured provider.
+func Resolve(endpoint, name string) (string, error) {
+	allowed := AllowedProviders(endpoint)
+	if name == "" {
+		return allowed[0], nil
+	}
+	for _, a := range allowed {
+		if a == name {
+			return name, nil
+		}
+	}
+	return "", ErrNotAllowed
+}
+
+// Create generates a challenge with the named provider and stores its secret
+func Create(ctx context.Context, rdb *redis.Client, name string) (*Challenge, error) {
+	p, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	c, err := p.Generate()
+	if err != nil {
+		return nil, err
+	}
+	c.ID = strconv.Itoa(int(time.Now().UnixNano()))
+	c.Provider = p.Name()
+	if err := rdb.Set(ctx, Key(c.Provider, c.ID), c.Secret, ChallengeTTL).Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Verify checks answer against the stored challenge and consumes it on success
+func Verify(ctx context.Context, rdb *redis.Client, name, id, answer string) (bool, error) {
+	p, err := Get(name)
+	if err != nil {
+		return false, err
+	}
+	// an id with a ':' would reach into another provider's key space, e.g.
+	// "pow:<id>" as an image id
+	if id == "" || strings.Contains(id, ":") {
+		return false, nil
+	}
+	key := Key(name, id)
+	secret, err := rdb.Get(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	if !p.Check(secret, answer) {
+		return false, nil
+	}
+	// only the request that deletes the challenge redeems it, so concurrent
+	// requests with the same solution can't both pass
+	n, err := rdb.Del(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// VerifyFor resolves the provider for endpoint and verifies the answer
+func VerifyFor(ctx context.Context, rdb *redis.Client, endpoint, provider, id, answer string) bool {
+	name, err := Resolve(endpoint, provider)
+	if err != nil {
+		return false
+	}
+	ok, err := Verify(ctx, rdb, name, id, answer)
+	return err == nil && ok
+}
@@ -0,0 +1,140 @@
+package captcha_test
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"mFrelance/captcha"
+	"mFrelance/config"
+	"mFrelance/server/testutil"
+)
+
+func solve(challenge string, difficulty int) string {
+	for n := 0; ; n++ {
+		nonce := strconv.Itoa(n)
+		sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+		if captcha.LeadingZeroBits(sum[:]) >= difficulty {
+			return nonce
+		}
+	}
+}
+
+func TestPoW_SolveAndConsume(t *testing.T) {
+	prev := config.AppConfig
+	config.AppConfig.CaptchaPoWDifficulty = 8
+	t.Cleanup(func() { config.AppConfig = prev })
+
+	mr, rdb := testutil.NewMiniRedis(t)
+	defer mr.Close()
+	ctx := t.Context()
+
+	c, err := captcha.Create(ctx, rdb, captcha.PoW)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	challenge := c.Meta["challenge"].(string)
+
+	if ok, _ := captcha.Verify(ctx, rdb, captcha.PoW, c.ID, "not-a-solution"); ok {
+		t.Fatalf("bogus nonce accepted")
+	}
+	nonce := solve(challenge, 8)
+	if ok, err := captcha.Verify(ctx, rdb, captcha.PoW, c.ID, nonce); err != nil || !ok {
+		t.Fatalf("valid nonce rejected: ok=%v err=%v", ok, err)
+	}
+	if ok, _ := captcha.Verify(ctx, rdb, captcha.PoW, c.ID, nonce); ok {
+		t.Fatalf("challenge redeemed twice")
+	}
+}
+
+func TestVerify_CrossProvider(t *testing.T) {
+	prev := config.AppConfig
+	config.AppConfig.CaptchaPoWDifficulty = 8
+	t.Cleanup(func() { config.AppConfig = prev })
+
+	mr, rdb := testutil.NewMiniRedis(t)
+	defer mr.Close()
+	ctx := t.Context()
+
+	c, err := captcha.Create(ctx, rdb, captcha.PoW)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	// the client knows the stored secret of a pow challenge, it must not pass
+	// as the answer to an image captcha
+	secret := strconv.Itoa(c.Meta["difficulty"].(int)) + ":" + c.Meta["challenge"].(string)
+	if secret != c.Secret {
+		t.Fatalf("secret=%q, test assumes %q", c.Secret, secret)
+	}
+	for _, p := range []string{captcha.Image, captcha.Audio} {
+		if ok, _ := captcha.Verify(ctx, rdb, p, "pow:"+c.ID, secret); ok {
+			t.Fatalf("pow challenge redeemed as %s", p)
+		}
+	}
+	if !mr.Exists(captcha.Key(captcha.PoW, c.ID)) {
+		t.Fatalf("pow challenge consumed by another provider")
+	}
+}
+
+func TestPoW_ConcurrentRedeem(t *testing.T) {
+	prev := config.AppConfig
+	config.AppConfig.CaptchaPoWDifficulty = 8
+	t.Cleanup(func() { config.AppConfig = prev })
+
+	mr, rdb := testutil.NewMiniRedis(t)
+	defer mr.Close()
+	ctx := t.Context()
+
+	c, err := captcha.Create(ctx, rdb, captcha.PoW)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	nonce := solve(c.Meta["challenge"].(string), 8)
+
+	var wg sync.WaitGroup
+	var redeemed atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := captcha.Verify(ctx, rdb, captcha.PoW, c.ID, nonce); ok {
+				redeemed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := redeemed.Load(); n != 1 {
+		t.Fatalf("challenge redeemed %d times", n)
+	}
+}
+
+func TestResolve_PerEndpoint(t *testing.T) {
+	prev := config.AppConfig
+	config.AppConfig.CaptchaEndpoints = map[string][]string{
+		captcha.EndpointAuth: {captcha.PoW, captcha.Image},
+	}
+	t.Cleanup(func() { config.AppConfig = prev })
+
+	if p, _ := captcha.Resolve(captcha.EndpointAuth, ""); p != captcha.PoW {
+		t.Fatalf("default provider=%q, want pow", p)
+	}
+	if _, err := captcha.Resolve(captcha.EndpointAuth, captcha.Audio); err == nil {
+		t.Fatalf("audio accepted on auth")
+	}
+	if p, _ := captcha.Resolve(captcha.EndpointRegister, ""); p != captcha.Image {
+		t.Fatalf("unconfigured endpoint provider=%q, want image", p)
+	}
+}
+
+func TestAudio_WAV(t *testing.T) {
+	p, _ := captcha.Get(captcha.Audio)
+	c, err := p.Generate()
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if c.ContentType != "audio/wav" || string(c.Body[:4]) != "RIFF" {
+		t.Fatalf("unexpected audio payload %q", c.ContentType)
+	}
+}
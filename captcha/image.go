@@ -0,0 +1,92 @@
+package captcha
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math/rand"
+	"os"
+	"strconv"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"mFrelance/config"
+)
+
+type imageProvider struct{}
+
+func (imageProvider) Name() string { return Image }
+
+func (imageProvider) Generate() (*Challenge, error) {
+	text := generateText()
+	img, err := renderImage(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return &Challenge{Secret: text, ContentType: "image/png", Body: buf.Bytes()}, nil
+}
+
+func (imageProvider) Check(secret, answer string) bool {
+	return secret == answer
+}
+
+func generateText() string {
+	return strconv.Itoa(1000 + rand.Intn(9000)) // 4-digit number
+}
+
+func renderImage(text string) (image.Image, error) {
+	width, height := 200, 60
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	for i := 0; i < 100; i++ {
+		x := rand.Intn(width)
+		y := rand.Intn(height)
+		img.Set(x, y, color.Black)
+	}
+
+	fontBytes, err := os.ReadFile(config.AppConfig.CaptchaFontPath)
+	if err != nil {
+		return nil, err
+	}
+	ttfFont, err := opentype.Parse(fontBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	face, err := opentype.NewFace(ttfFont, &opentype.FaceOptions{
+		Size:    32,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.Black),
+		Face: face,
+	}
+
+	textWidth := d.MeasureString(text).Ceil()
+	x := (width - textWidth) / 2
+	y := (height + face.Metrics().Ascent.Ceil() - face.Metrics().Descent.Ceil()) / 2
+
+	d.Dot = fixed.Point26_6{
+		X: fixed.I(x),
+		Y: fixed.I(y),
+	}
+
+	d.DrawString(text)
+
+	return img, nil
+}
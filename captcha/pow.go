@@ -0,0 +1,75 @@
+package captcha
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"mFrelance/config"
+)
+
+const defaultPoWDifficulty = 20
+
+// powProvider issues a hashcash style challenge: the client has to find a
+// nonce so that sha256(challenge + ":" + nonce) starts with at least
+// `difficulty` zero bits. No images or audio are involved, which suits Tor
+// users and bots.
+type powProvider struct{}
+
+func (powProvider) Name() string { return PoW }
+
+func powDifficulty() int {
+	if d := config.AppConfig.CaptchaPoWDifficulty; d > 0 {
+		return d
+	}
+	return defaultPoWDifficulty
+}
+
+func (powProvider) Generate() (*Challenge, error) {
+	seed := make([]byte, 16)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	challenge := hex.EncodeToString(seed)
+	difficulty := powDifficulty()
+	return &Challenge{
+		// the difficulty is stored with the seed so a config change does not
+		// invalidate challenges already handed out
+		Secret:      strconv.Itoa(difficulty) + ":" + challenge,
+		ContentType: "application/json",
+		Meta: map[string]interface{}{
+			"challenge":  challenge,
+			"difficulty": difficulty,
+			"algorithm":  "sha256",
+			"input":      "challenge + \":\" + nonce",
+		},
+	}, nil
+}
+
+func (powProvider) Check(secret, answer string) bool {
+	diffStr, challenge, ok := strings.Cut(secret, ":")
+	if !ok || answer == "" || len(answer) > 64 {
+		return false
+	}
+	difficulty, err := strconv.Atoi(diffStr)
+	if err != nil {
+		return false
+	}
+	sum := sha256.Sum256([]byte(challenge + ":" + answer))
+	return LeadingZeroBits(sum[:]) >= difficulty
+}
+
+// LeadingZeroBits counts the zero bits at the start of b
+func LeadingZeroBits(b []byte) int {
+	n := 0
+	for _, x := range b {
+		if x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
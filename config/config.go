@@ -6,6 +6,7 @@ import (
 	"github.com/spf13/viper"
 	"log"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -54,6 +55,103 @@ type Config struct {
 	CaptchaRateLimitPerMinute  int
 	CaptchaRateLimitPerHour    int
 	CaptchaFontPath		   string
+	CaptchaAudioDir            string
+	CaptchaPoWDifficulty       int
+	CaptchaEndpoints           map[string][]string
+
+	PasswordMinLength         int
+	PasswordBreachedListPath  string
+	PasswordArgon2Memory      uint32
+	PasswordArgon2Time        uint32
+	PasswordArgon2Parallelism uint8
+
+	WebhookMaxAttempts      int
+	WebhookBackoffBase      time.Duration
+	WebhookBackoffMax       time.Duration
+	WebhookTimeout          time.Duration
+	WebhookDispatchInterval time.Duration
+	WebhookBatchSize        int
+	WebhookAllowPrivate     bool
+
+	SMTPEnabled      bool
+	SMTPHost         string
+	SMTPPort         int
+	SMTPUsername     string
+	SMTPPassword     string
+	SMTPFrom         string
+	SMTPTLS          string
+	SMTPMaxAttempts  int
+	SMTPBackoffBase  time.Duration
+	SMTPBackoffMax   time.Duration
+	SMTPSendInterval time.Duration
+	SMTPBatchSize    int
+
+	EmailVerifyTTL     time.Duration
+	EmailVerifyURL     string
+	EmailDefaultLocale string
+
+	FilesDir           string
+	FilesMaxSize       int64
+	ChatMaxAttachments int
+
+	// SLA targets per ticket priority
+	TicketFirstResponseSLA map[string]time.Duration
+	TicketResolutionSLA    map[string]time.Duration
+
+	ReviewDoubleBlind     bool
+	ReviewBlindWindow     time.Duration
+	ReviewPublishInterval time.Duration
+
+	ReputationWeights           map[string]float64
+	ReputationPriorRating       float64
+	ReputationPriorWeight       float64
+	ReputationEarningsReference map[string]float64
+	ReputationFullAge           time.Duration
+	ReputationRefreshInterval   time.Duration
+
+	PortfolioMaxItems  int
+	PortfolioMaxImages int
+	PortfolioMaxLinks  int
+	TopRatedScore      float64
+	TopRatedMinReviews int
+
+	// Edge lengths of the avatar thumbnails
+	AvatarSizes []int
+
+	// Hourly contracts: how long the client has to review a week before it
+	// is approved, and how often weeks are closed and paid
+	ContractReviewWindow   time.Duration
+	ContractSettleInterval time.Duration
+
+	// Offers expire after OfferDefaultTTL unless the freelancer picks
+	// another expiry up to OfferMaxTTL
+	OfferDefaultTTL     time.Duration
+	OfferMaxTTL         time.Duration
+	OfferExpiryInterval time.Duration
+
+	// Task templates and the schedules that post them
+	TaskTemplateMax      int
+	TaskScheduleMax      int
+	TaskScheduleInterval time.Duration
+}
+
+// ticketSLADefaults holds the first response and resolution time per
+// ticket priority
+var ticketSLADefaults = map[string][2]string{
+	"low":    {"72h", "336h"},
+	"normal": {"24h", "168h"},
+	"high":   {"4h", "72h"},
+	"urgent": {"1h", "24h"},
+}
+
+// reputationWeightDefaults holds the weight of each reputation component
+var reputationWeightDefaults = map[string]float64{
+	"rating":     0.35,
+	"completion": 0.15,
+	"disputes":   0.15,
+	"on_time":    0.15,
+	"earnings":   0.1,
+	"age":        0.1,
 }
 
 var AppConfig Config
@@ -148,6 +246,94 @@ func Init() {
 	viper.SetDefault("captcha.enabled", true)
 	viper.SetDefault("captcha.rate_limit_per_minute", 10)
 	viper.SetDefault("captcha.rate_limit_per_hour", 100)
+	viper.SetDefault("captcha.pow_difficulty", 20)
+	viper.SetDefault("captcha.endpoints", map[string][]string{
+		"register": {"image", "audio", "pow"},
+		"auth":     {"image", "audio", "pow"},
+		"restore":  {"image", "audio", "pow"},
+	})
+
+	// Passwords
+	viper.SetDefault("password.min_length", 8)
+	viper.SetDefault("password.breached_list", "")
+	viper.SetDefault("password.argon2.memory_kib", 64*1024)
+	viper.SetDefault("password.argon2.time", 3)
+	viper.SetDefault("password.argon2.parallelism", 2)
+
+	// Webhooks
+	viper.SetDefault("webhooks.max_attempts", 8)
+	viper.SetDefault("webhooks.backoff_base", "30s")
+	viper.SetDefault("webhooks.backoff_max", "6h")
+	viper.SetDefault("webhooks.timeout", "10s")
+	viper.SetDefault("webhooks.dispatch_interval", "5s")
+	viper.SetDefault("webhooks.batch_size", 50)
+	viper.SetDefault("webhooks.allow_private", false)
+
+	// Email
+	viper.SetDefault("smtp.enabled", false)
+	viper.SetDefault("smtp.host", "localhost")
+	viper.SetDefault("smtp.port", 1025)
+	viper.SetDefault("smtp.username", "")
+	viper.SetDefault("smtp.password", "")
+	viper.SetDefault("smtp.from", "Symbio <no-reply@localhost>")
+	viper.SetDefault("smtp.tls", "none")
+	viper.SetDefault("smtp.max_attempts", 6)
+	viper.SetDefault("smtp.backoff_base", "1m")
+	viper.SetDefault("smtp.backoff_max", "2h")
+	viper.SetDefault("smtp.send_interval", "10s")
+	viper.SetDefault("smtp.batch_size", 20)
+	viper.SetDefault("email.verify_ttl", "24h")
+	viper.SetDefault("email.verify_url", "http://localhost:9999/verify_email?token=%s")
+	viper.SetDefault("email.default_locale", "en")
+
+	// Files
+	viper.SetDefault("files.dir", "uploads")
+	viper.SetDefault("files.max_size_mb", 10)
+	viper.SetDefault("chat.max_attachments", 5)
+
+	// Tickets
+	for p, sla := range ticketSLADefaults {
+		viper.SetDefault("tickets.sla."+p+".first_response", sla[0])
+		viper.SetDefault("tickets.sla."+p+".resolution", sla[1])
+	}
+
+	// Reviews
+	viper.SetDefault("reviews.double_blind", true)
+	viper.SetDefault("reviews.blind_window", "336h")
+	viper.SetDefault("reviews.publish_interval", "10m")
+
+	// Reputation
+	for c, w := range reputationWeightDefaults {
+		viper.SetDefault("reputation.weights."+c, w)
+	}
+	viper.SetDefault("reputation.prior_rating", 3.5)
+	viper.SetDefault("reputation.prior_weight", 5)
+	viper.SetDefault("reputation.earnings_reference.btc", 0.05)
+	viper.SetDefault("reputation.earnings_reference.xmr", 5)
+	viper.SetDefault("reputation.full_age", "8760h")
+	viper.SetDefault("reputation.refresh_interval", "24h")
+
+	// Profiles
+	viper.SetDefault("profiles.portfolio.max_items", 30)
+	viper.SetDefault("profiles.portfolio.max_images", 10)
+	viper.SetDefault("profiles.portfolio.max_links", 5)
+	viper.SetDefault("profiles.top_rated.min_score", 85)
+	viper.SetDefault("profiles.top_rated.min_reviews", 5)
+	viper.SetDefault("profiles.avatar_sizes", []int{512, 128, 48})
+
+	// Hourly contracts
+	viper.SetDefault("contracts.review_window", "120h")
+	viper.SetDefault("contracts.settle_interval", "1h")
+
+	// Offers
+	viper.SetDefault("offers.default_ttl", "336h")
+	viper.SetDefault("offers.max_ttl", "1440h")
+	viper.SetDefault("offers.expiry_interval", "10m")
+
+	// Task templates and schedules
+	viper.SetDefault("tasks.templates.max_per_client", 50)
+	viper.SetDefault("tasks.schedules.max_per_client", 10)
+	viper.SetDefault("tasks.schedules.interval", "1m")
 
 	if err := viper.ReadInConfig(); err != nil {
 		log.Println("No config file found, falling back to defaults/env vars")
@@ -202,6 +388,88 @@ func Init() {
 		CaptchaRateLimitPerMinute:  viper.GetInt("captcha.rate_limit_per_minute"),
 		CaptchaRateLimitPerHour:    viper.GetInt("captcha.rate_limit_per_hour"),
 		CaptchaFontPath:	    viper.GetString("captcha.font_path"),
+		CaptchaAudioDir:            viper.GetString("captcha.audio_dir"),
+		CaptchaPoWDifficulty:       viper.GetInt("captcha.pow_difficulty"),
+		CaptchaEndpoints:           viper.GetStringMapStringSlice("captcha.endpoints"),
+
+		PasswordMinLength:         viper.GetInt("password.min_length"),
+		PasswordBreachedListPath:  viper.GetString("password.breached_list"),
+		PasswordArgon2Memory:      viper.GetUint32("password.argon2.memory_kib"),
+		PasswordArgon2Time:        viper.GetUint32("password.argon2.time"),
+		PasswordArgon2Parallelism: uint8(viper.GetUint("password.argon2.parallelism")),
+
+		WebhookMaxAttempts:      viper.GetInt("webhooks.max_attempts"),
+		WebhookBackoffBase:      viper.GetDuration("webhooks.backoff_base"),
+		WebhookBackoffMax:       viper.GetDuration("webhooks.backoff_max"),
+		WebhookTimeout:          viper.GetDuration("webhooks.timeout"),
+		WebhookDispatchInterval: viper.GetDuration("webhooks.dispatch_interval"),
+		WebhookBatchSize:        viper.GetInt("webhooks.batch_size"),
+		WebhookAllowPrivate:     viper.GetBool("webhooks.allow_private"),
+
+		SMTPEnabled:      viper.GetBool("smtp.enabled"),
+		SMTPHost:         viper.GetString("smtp.host"),
+		SMTPPort:         viper.GetInt("smtp.port"),
+		SMTPUsername:     viper.GetString("smtp.username"),
+		SMTPPassword:     viper.GetString("smtp.password"),
+		SMTPFrom:         viper.GetString("smtp.from"),
+		SMTPTLS:          viper.GetString("smtp.tls"),
+		SMTPMaxAttempts:  viper.GetInt("smtp.max_attempts"),
+		SMTPBackoffBase:  viper.GetDuration("smtp.backoff_base"),
+		SMTPBackoffMax:   viper.GetDuration("smtp.backoff_max"),
+		SMTPSendInterval: viper.GetDuration("smtp.send_interval"),
+		SMTPBatchSize:    viper.GetInt("smtp.batch_size"),
+
+		EmailVerifyTTL:     viper.GetDuration("email.verify_ttl"),
+		EmailVerifyURL:     viper.GetString("email.verify_url"),
+		EmailDefaultLocale: viper.GetString("email.default_locale"),
+
+		FilesDir:           viper.GetString("files.dir"),
+		FilesMaxSize:       viper.GetInt64("files.max_size_mb") * 1024 * 1024,
+		ChatMaxAttachments: viper.GetInt("chat.max_attachments"),
+
+		TicketFirstResponseSLA: map[string]time.Duration{},
+		TicketResolutionSLA:    map[string]time.Duration{},
+
+		ReviewDoubleBlind:     viper.GetBool("reviews.double_blind"),
+		ReviewBlindWindow:     viper.GetDuration("reviews.blind_window"),
+		ReviewPublishInterval: viper.GetDuration("reviews.publish_interval"),
+
+		ReputationWeights:           map[string]float64{},
+		ReputationPriorRating:       viper.GetFloat64("reputation.prior_rating"),
+		ReputationPriorWeight:       viper.GetFloat64("reputation.prior_weight"),
+		ReputationEarningsReference: map[string]float64{},
+		ReputationFullAge:           viper.GetDuration("reputation.full_age"),
+		ReputationRefreshInterval:   viper.GetDuration("reputation.refresh_interval"),
+
+		PortfolioMaxItems:  viper.GetInt("profiles.portfolio.max_items"),
+		PortfolioMaxImages: viper.GetInt("profiles.portfolio.max_images"),
+		PortfolioMaxLinks:  viper.GetInt("profiles.portfolio.max_links"),
+		TopRatedScore:      viper.GetFloat64("profiles.top_rated.min_score"),
+		TopRatedMinReviews: viper.GetInt("profiles.top_rated.min_reviews"),
+
+		AvatarSizes: viper.GetIntSlice("profiles.avatar_sizes"),
+
+		ContractReviewWindow:   viper.GetDuration("contracts.review_window"),
+		ContractSettleInterval: viper.GetDuration("contracts.settle_interval"),
+
+		OfferDefaultTTL:     viper.GetDuration("offers.default_ttl"),
+		OfferMaxTTL:         viper.GetDuration("offers.max_ttl"),
+		OfferExpiryInterval: viper.GetDuration("offers.expiry_interval"),
+
+		TaskTemplateMax:      viper.GetInt("tasks.templates.max_per_client"),
+		TaskScheduleMax:      viper.GetInt("tasks.schedules.max_per_client"),
+		TaskScheduleInterval: viper.GetDuration("tasks.schedules.interval"),
+	}
+	for p := range ticketSLADefaults {
+		AppConfig.TicketFirstResponseSLA[p] = viper.GetDuration("tickets.sla." + p + ".first_response")
+		AppConfig.TicketResolutionSLA[p] = viper.GetDuration("tickets.sla." + p + ".resolution")
+	}
+	for c := range reputationWeightDefaults {
+		AppConfig.ReputationWeights[c] = viper.GetFloat64("reputation.weights." + c)
+	}
+	// viper lowercases keys, currencies are stored upper case
+	for currency := range viper.GetStringMap("reputation.earnings_reference") {
+		AppConfig.ReputationEarningsReference[strings.ToUpper(currency)] = viper.GetFloat64("reputation.earnings_reference." + currency)
 	}
 
 	log.Println("Loaded commissions:", "BTC:", AppConfig.BitcoinCommission, "XMR:", AppConfig.MoneroCommission)
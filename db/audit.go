@@ -0,0 +1,111 @@
+package db
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"mFrelance/models"
+)
+
+// Audit actions
+const (
+	AuditAdminMake         = "admin.make"
+	AuditAdminRemove       = "admin.remove"
+	AuditUserBlock         = "user.block"
+	AuditUserUnblock       = "user.unblock"
+	AuditBalanceUpdate     = "wallet.balance_update"
+	AuditDisputeAssign     = "dispute.assign"
+	AuditDisputeResolve    = "dispute.resolve"
+	AuditUserTasksDelete   = "tasks.delete_by_user"
+	AuditChatRoomDelete    = "chat.delete_room"
+	AuditChatRoomAddUser   = "chat.add_user"
+	AuditChatMessageDelete = "chat.delete_message"
+	AuditRoleUpsert        = "role.upsert"
+	AuditRoleDelete        = "role.delete"
+	AuditRoleAssign        = "role.assign"
+	AuditRoleRevoke        = "role.revoke"
+	AuditReportResolve     = "report.resolve"
+	AuditReviewHide        = "review.hide"
+	AuditReviewUnhide      = "review.unhide"
+)
+
+func jsonParam(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// pq sends []byte as bytea, jsonb needs text
+	return string(raw), nil
+}
+
+// InsertAuditLogTx appends an entry inside the caller's transaction so the
+// log and the action it describes commit or roll back together.
+// before and after are marshalled to JSON, nil values are stored as NULL.
+func InsertAuditLogTx(tx *sqlx.Tx, e *models.AuditEntry, before, after interface{}) error {
+	b, err := jsonParam(before)
+	if err != nil {
+		return err
+	}
+	a, err := jsonParam(after)
+	if err != nil {
+		return err
+	}
+	return tx.QueryRow(`
+		INSERT INTO audit_log (actor_id, action, target_type, target_id, before, after, ip, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`, e.ActorID, e.Action, e.TargetType, e.TargetID, b, a, e.IP, e.Reason).Scan(&e.ID, &e.CreatedAt)
+}
+
+// writeAudit is a no-op when e is nil, used by helpers that are also called
+// from Lua scripts and the startup migrations
+func writeAudit(tx *sqlx.Tx, e *models.AuditEntry, before, after interface{}) error {
+	if e == nil {
+		return nil
+	}
+	return InsertAuditLogTx(tx, e, before, after)
+}
+
+func ListAuditLog(db *sqlx.DB, f models.AuditFilter) ([]models.AuditEntry, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	if f.ActorID > 0 {
+		add("actor_id = ?", f.ActorID)
+	}
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type = ?", f.TargetType)
+	}
+	if f.TargetID > 0 {
+		add("target_id = ?", f.TargetID)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < ?", f.To)
+	}
+
+	query := `SELECT * FROM audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit, f.Offset)
+	query += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	entries := []models.AuditEntry{}
+	err := db.Select(&entries, query, args...)
+	return entries, err
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"mFrelance/models"
+)
+
+// LegacyAvatar is a profile avatar still stored as a base64 string
+type LegacyAvatar struct {
+	UserID int64  `db:"user_id"`
+	Avatar string `db:"avatar"`
+}
+
+// SetAvatar stores the thumbnail files of a new avatar by edge size, points
+// the profile at url and drops the legacy base64 value. Returns the storage
+// keys of the replaced thumbnails, whose content the caller removes.
+func SetAvatar(db *sqlx.DB, userID int64, files map[int]*models.File, url string) ([]string, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := deleteAvatarFilesTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for size, f := range files {
+		err := tx.QueryRow(`
+			INSERT INTO files (owner_id, name, content_type, size, sha256, storage_key)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at
+		`, f.OwnerID, f.Name, f.ContentType, f.Size, f.SHA256, f.StorageKey).Scan(&f.ID, &f.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`INSERT INTO avatar_files (user_id, size, file_id) VALUES ($1, $2, $3)`, userID, size, f.ID); err != nil {
+			return nil, err
+		}
+	}
+	_, err = tx.Exec(`
+		INSERT INTO profiles (user_id, avatar, avatar_url) VALUES ($1, '', $2)
+		ON CONFLICT (user_id) DO UPDATE SET avatar = '', avatar_url = $2
+	`, userID, url)
+	if err != nil {
+		return nil, err
+	}
+	return old, tx.Commit()
+}
+
+// ClearAvatar removes the user's avatar. Returns the storage keys of the
+// removed thumbnails.
+func ClearAvatar(db *sqlx.DB, userID int64) ([]string, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := deleteAvatarFilesTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE profiles SET avatar = '', avatar_url = '' WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	return old, tx.Commit()
+}
+
+func deleteAvatarFilesTx(tx *sqlx.Tx, userID int64) ([]string, error) {
+	var ids []int64
+	if err := tx.Select(&ids, `DELETE FROM avatar_files WHERE user_id = $1 RETURNING file_id`, userID); err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	if len(ids) == 0 {
+		return keys, nil
+	}
+	err := tx.Select(&keys, `DELETE FROM files WHERE id = ANY($1) RETURNING storage_key`, pq.Array(ids))
+	return keys, err
+}
+
+// GetAvatarFile returns the smallest thumbnail of the user's avatar that is
+// at least size pixels wide, or the largest one, and the avatar version: the
+// hash of the largest thumbnail
+func GetAvatarFile(db *sqlx.DB, userID int64, size int) (*models.File, string, error) {
+	var row struct {
+		models.File
+		Version string `db:"version"`
+	}
+	err := db.Get(&row, `
+		SELECT f.*, (
+			SELECT lf.sha256 FROM avatar_files la JOIN files lf ON lf.id = la.file_id
+			WHERE la.user_id = $1 ORDER BY la.size DESC LIMIT 1
+		) AS version
+		FROM avatar_files a
+		JOIN files f ON f.id = a.file_id
+		WHERE a.user_id = $1
+		ORDER BY a.size >= $2 DESC, CASE WHEN a.size >= $2 THEN a.size END, a.size DESC
+		LIMIT 1
+	`, userID, size)
+	if err != nil {
+		return nil, "", err
+	}
+	return &row.File, row.Version, nil
+}
+
+// ListLegacyAvatars returns up to limit profiles after afterID that still
+// carry a base64 avatar, by user ID
+func ListLegacyAvatars(db *sqlx.DB, afterID int64, limit int) ([]LegacyAvatar, error) {
+	avatars := []LegacyAvatar{}
+	err := db.Select(&avatars, `
+		SELECT user_id, avatar FROM profiles
+		WHERE user_id > $1 AND COALESCE(avatar, '') != ''
+		ORDER BY user_id
+		LIMIT $2
+	`, afterID, limit)
+	return avatars, err
+}
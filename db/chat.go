@@ -1,17 +1,22 @@
 package db
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"mFrelance/models"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 // CreateChatRoom creates a new chat room
 func CreateChatRoom(db *sqlx.DB) (*models.ChatRoom, error) {
 	room := &models.ChatRoom{}
-	err := db.Get(room, `INSERT INTO chat_rooms DEFAULT VALUES RETURNING id, created_at`)
+	err := db.Get(room, `INSERT INTO chat_rooms DEFAULT VALUES RETURNING id, task_id, offer_id, created_at`)
 	if err != nil {
 		return nil, err
 	}
@@ -25,12 +30,88 @@ func GetChatRoom(db *sqlx.DB, id int64) (*models.ChatRoom, error) {
 	return &room, err
 }
 
+// EnsureTaskChatRoomTx returns the room of a task, creating it if needed,
+// and makes sure userIDs are its participants
+func EnsureTaskChatRoomTx(tx *sqlx.Tx, taskID int64, userIDs ...int64) (*models.ChatRoom, error) {
+	if _, err := tx.Exec(`
+		INSERT INTO chat_rooms (task_id) VALUES ($1)
+		ON CONFLICT (task_id) WHERE task_id IS NOT NULL AND offer_id IS NULL DO NOTHING
+	`, taskID); err != nil {
+		return nil, err
+	}
+	var room models.ChatRoom
+	if err := tx.Get(&room, `SELECT * FROM chat_rooms WHERE task_id = $1 AND offer_id IS NULL`, taskID); err != nil {
+		return nil, err
+	}
+	return &room, addChatParticipantsTx(tx, room.ID, userIDs)
+}
+
+// EnsureOfferChatRoom returns the room for an offer, creating it with the
+// client and the freelancer if needed
+func EnsureOfferChatRoom(db *sqlx.DB, offer *models.TaskOffer, clientID int64) (*models.ChatRoom, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+		INSERT INTO chat_rooms (task_id, offer_id) VALUES ($1, $2)
+		ON CONFLICT (offer_id) WHERE offer_id IS NOT NULL DO NOTHING
+	`, offer.TaskID, offer.ID); err != nil {
+		return nil, err
+	}
+	var room models.ChatRoom
+	if err := tx.Get(&room, `SELECT * FROM chat_rooms WHERE offer_id = $1`, offer.ID); err != nil {
+		return nil, err
+	}
+	if err := addChatParticipantsTx(tx, room.ID, []int64{clientID, offer.FreelancerID}); err != nil {
+		return nil, err
+	}
+	return &room, tx.Commit()
+}
+
+func addChatParticipantsTx(tx *sqlx.Tx, chatRoomID int64, userIDs []int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO chat_participants (chat_room_id, user_id, joined_at)
+		SELECT $1, unnest($2::int[]), NOW()
+		ON CONFLICT (chat_room_id, user_id) DO NOTHING
+	`, chatRoomID, pq.Array(userIDs))
+	return err
+}
+
+// GetTaskChatRoom returns the room of a task
+func GetTaskChatRoom(db *sqlx.DB, taskID int64) (*models.ChatRoom, error) {
+	var room models.ChatRoom
+	err := db.Get(&room, `SELECT * FROM chat_rooms WHERE task_id = $1 AND offer_id IS NULL`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// GetOfferChatRoom returns the room of an offer
+func GetOfferChatRoom(db *sqlx.DB, offerID int64) (*models.ChatRoom, error) {
+	var room models.ChatRoom
+	err := db.Get(&room, `SELECT * FROM chat_rooms WHERE offer_id = $1`, offerID)
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
 // CreateChatParticipant adds a user to a chat room
 func CreateChatParticipant(db *sqlx.DB, participant *models.ChatParticipant) error {
 	_, err := db.NamedExec(`INSERT INTO chat_participants (chat_room_id, user_id, joined_at) VALUES (:chat_room_id, :user_id, :joined_at)`, participant)
 	return err
 }
 
+// GetChatParticipantIDs returns the user IDs of a room's participants
+func GetChatParticipantIDs(db *sqlx.DB, chatRoomID int64) ([]int64, error) {
+	ids := []int64{}
+	err := db.Select(&ids, `SELECT user_id FROM chat_participants WHERE chat_room_id = $1 ORDER BY user_id`, chatRoomID)
+	return ids, err
+}
+
 // GetChatParticipants retrieves participants in a chat room
 func GetChatParticipants(db *sqlx.DB, chatRoomID int64) ([]models.ChatParticipant, error) {
 	var participants []models.ChatParticipant
@@ -38,10 +119,178 @@ func GetChatParticipants(db *sqlx.DB, chatRoomID int64) ([]models.ChatParticipan
 	return participants, err
 }
 
-// CreateChatMessage adds a message to a chat room
+// chatMessageColumns selects a message with the text of deleted messages blanked
+const chatMessageColumns = `id, chat_room_id, sender_id,
+	CASE WHEN deleted_at IS NULL THEN message ELSE '' END AS message,
+	kind, CASE WHEN deleted_at IS NULL THEN encryption END AS encryption,
+	reply_to_id, edited_at, deleted_at, deleted_by, created_at`
+
+// CreateChatMessage adds a message with its attachments to a chat room and
+// sets its ID
 func CreateChatMessage(db *sqlx.DB, message *models.ChatMessage) error {
-	_, err := db.NamedExec(`INSERT INTO chat_messages (chat_room_id, sender_id, message, created_at) VALUES (:chat_room_id, :sender_id, :message, :created_at)`, message)
-	return err
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if message.Kind == "" {
+		message.Kind = models.MessageKindText
+	}
+	err = tx.QueryRow(`INSERT INTO chat_messages (chat_room_id, sender_id, message, kind, encryption, reply_to_id, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		message.ChatRoomID, message.SenderID, message.Message, message.Kind, message.Encryption, message.ReplyToID, message.CreatedAt).Scan(&message.ID)
+	if err != nil {
+		return err
+	}
+	for i, f := range message.Attachments {
+		if _, err := tx.Exec(`INSERT INTO chat_message_attachments (message_id, file_id, position) VALUES ($1, $2, $3)`, message.ID, f.ID, i); err != nil {
+			return err
+		}
+	}
+	for i := range message.Keys {
+		k := &message.Keys[i]
+		k.MessageID = message.ID
+		err := tx.QueryRow(`INSERT INTO chat_message_keys (message_id, user_id, key_id, wrapped_key) VALUES ($1, $2, $3, $4) RETURNING created_at`,
+			k.MessageID, k.UserID, k.KeyID, k.WrappedKey).Scan(&k.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetChatMessage returns a message including the text of a deleted one
+func GetChatMessage(db *sqlx.DB, id int64) (*models.ChatMessage, error) {
+	var m models.ChatMessage
+	if err := db.Get(&m, `SELECT * FROM chat_messages WHERE id = $1`, id); err != nil {
+		return nil, err
+	}
+	if err := loadChatAttachments(db, []*models.ChatMessage{&m}); err != nil {
+		return nil, err
+	}
+	if err := loadChatMessageKeys(db, []*models.ChatMessage{&m}); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// EditChatMessage replaces the text of a message and keeps the previous
+// version in the edit history
+func EditChatMessage(db *sqlx.DB, id, editorID int64, text string) (*models.ChatMessage, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+		INSERT INTO chat_message_edits (message_id, message, edited_by)
+		SELECT id, message, $2 FROM chat_messages WHERE id = $1 AND deleted_at IS NULL
+	`, id, editorID); err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec(`UPDATE chat_messages SET message = $1, edited_at = NOW() WHERE id = $2 AND deleted_at IS NULL`, text, id)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetChatMessage(db, id)
+}
+
+// GetChatMessageEdits returns the previous versions of a message, oldest first
+func GetChatMessageEdits(db *sqlx.DB, messageID int64) ([]models.ChatMessageEdit, error) {
+	edits := []models.ChatMessageEdit{}
+	err := db.Select(&edits, `SELECT * FROM chat_message_edits WHERE message_id = $1 ORDER BY id`, messageID)
+	return edits, err
+}
+
+// DeleteChatMessageTx soft deletes a message. The text and edit history are
+// kept for moderation but no longer returned to participants.
+func DeleteChatMessageTx(tx *sqlx.Tx, id, deletedBy int64) error {
+	res, err := tx.Exec(`UPDATE chat_messages SET deleted_at = NOW(), deleted_by = $1 WHERE id = $2 AND deleted_at IS NULL`, deletedBy, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func DeleteChatMessage(db *sqlx.DB, id, deletedBy int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := DeleteChatMessageTx(tx, id, deletedBy); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadChatAttachments fills Attachments of the messages that aren't deleted
+func loadChatAttachments(db *sqlx.DB, messages []*models.ChatMessage) error {
+	byID := make(map[int64]*models.ChatMessage, len(messages))
+	ids := make([]int64, 0, len(messages))
+	for _, m := range messages {
+		m.Attachments = []models.File{}
+		if m.DeletedAt == nil {
+			byID[m.ID] = m
+			ids = append(ids, m.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	rows := []struct {
+		MessageID int64 `db:"message_id"`
+		models.File
+	}{}
+	err := db.Select(&rows, `
+		SELECT a.message_id, f.* FROM chat_message_attachments a
+		JOIN files f ON f.id = a.file_id
+		WHERE a.message_id = ANY($1)
+		ORDER BY a.message_id, a.position
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		m := byID[r.MessageID]
+		m.Attachments = append(m.Attachments, r.File)
+	}
+	return nil
+}
+
+// loadChatMessageKeys fills Keys of encrypted messages that aren't deleted.
+// Wrapped keys only open for their recipient, so all of them are returned.
+func loadChatMessageKeys(db *sqlx.DB, messages []*models.ChatMessage) error {
+	byID := make(map[int64]*models.ChatMessage)
+	ids := []int64{}
+	for _, m := range messages {
+		if m.Kind == models.MessageKindEncrypted && m.DeletedAt == nil {
+			m.Keys = []models.ChatMessageKey{}
+			byID[m.ID] = m
+			ids = append(ids, m.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := []models.ChatMessageKey{}
+	err := db.Select(&keys, `SELECT * FROM chat_message_keys WHERE message_id = ANY($1) ORDER BY message_id, user_id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		m := byID[k.MessageID]
+		m.Keys = append(m.Keys, k)
+	}
+	return nil
 }
 
 // GetChatMessages retrieves messages in a chat room
@@ -52,13 +301,25 @@ func GetChatMessages(db *sqlx.DB, chatRoomID int64) ([]models.ChatMessage, error
 // GetChatMessagesPaged retrieves messages in a chat room with pagination
 func GetChatMessagesPaged(db *sqlx.DB, chatRoomID int64, limit, offset int) ([]models.ChatMessage, error) {
 	var messages []models.ChatMessage
-	query := `SELECT * FROM chat_messages WHERE chat_room_id = $1 ORDER BY created_at ASC`
+	query := `SELECT ` + chatMessageColumns + ` FROM chat_messages WHERE chat_room_id = $1 ORDER BY created_at ASC`
+	var err error
 	if limit > 0 {
 		query += ` LIMIT $2 OFFSET $3`
-		err := db.Select(&messages, query, chatRoomID, limit, offset)
+		err = db.Select(&messages, query, chatRoomID, limit, offset)
+	} else {
+		err = db.Select(&messages, query, chatRoomID)
+	}
+	if err != nil {
 		return messages, err
 	}
-	err := db.Select(&messages, query, chatRoomID)
+	ptrs := make([]*models.ChatMessage, len(messages))
+	for i := range messages {
+		ptrs[i] = &messages[i]
+	}
+	if err = loadChatAttachments(db, ptrs); err != nil {
+		return messages, err
+	}
+	err = loadChatMessageKeys(db, ptrs)
 	return messages, err
 }
 
@@ -163,6 +424,38 @@ func DeleteChatRoom(db *sqlx.DB, chatRoomID int64) error {
 	return err
 }
 
+// DeleteChatRoomTx deletes a room and returns its participants
+func DeleteChatRoomTx(tx *sqlx.Tx, chatRoomID int64) ([]int64, error) {
+	var exists bool
+	if err := tx.Get(&exists, `SELECT EXISTS(SELECT 1 FROM chat_rooms WHERE id=$1)`, chatRoomID); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("chat room with id %d does not exist", chatRoomID)
+	}
+	participants := []int64{}
+	if err := tx.Select(&participants, `SELECT user_id FROM chat_participants WHERE chat_room_id=$1 ORDER BY user_id`, chatRoomID); err != nil {
+		return nil, err
+	}
+	_, err := tx.Exec(`DELETE FROM chat_rooms WHERE id = $1`, chatRoomID)
+	return participants, err
+}
+
+func AddUserToChatRoomTx(tx *sqlx.Tx, userID int64, chatRoomID int64) error {
+	var exists bool
+	if err := tx.Get(&exists, "SELECT EXISTS(SELECT 1 FROM chat_rooms WHERE id=$1)", chatRoomID); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("chat room with id %d does not exist", chatRoomID)
+	}
+	_, err := tx.Exec(`
+        INSERT INTO chat_participants (chat_room_id, user_id, joined_at)
+        VALUES ($1, $2, NOW())
+    `, chatRoomID, userID)
+	return err
+}
+
 func DeleteChatParticipant(db *sqlx.DB, chatRoomID int64, userID int64) error {
 	log.Printf("Deleting participant: chat_room_id=%d, user_id=%d", chatRoomID, userID)
 	_, err := db.Exec(`
@@ -171,3 +464,207 @@ func DeleteChatParticipant(db *sqlx.DB, chatRoomID int64, userID int64) error {
 	`, chatRoomID, userID)
 	return err
 }
+
+// MarkChatRead moves the user's read marker in the room forward to
+// messageID, or to the latest message when messageID is 0. The marker never
+// moves backwards.
+func MarkChatRead(db *sqlx.DB, chatRoomID, userID, messageID int64) (*models.ChatRead, error) {
+	if messageID == 0 {
+		if err := db.Get(&messageID, `SELECT COALESCE(MAX(id), 0) FROM chat_messages WHERE chat_room_id = $1`, chatRoomID); err != nil {
+			return nil, err
+		}
+	} else {
+		var exists bool
+		if err := db.Get(&exists, `SELECT EXISTS(SELECT 1 FROM chat_messages WHERE id = $1 AND chat_room_id = $2)`, messageID, chatRoomID); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("message %d is not in chat room %d", messageID, chatRoomID)
+		}
+	}
+	var read models.ChatRead
+	err := db.Get(&read, `
+		INSERT INTO chat_reads (chat_room_id, user_id, last_read_message_id, read_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (chat_room_id, user_id) DO UPDATE
+		SET last_read_message_id = GREATEST(chat_reads.last_read_message_id, EXCLUDED.last_read_message_id),
+		    read_at = CASE WHEN EXCLUDED.last_read_message_id > chat_reads.last_read_message_id
+		                   THEN EXCLUDED.read_at ELSE chat_reads.read_at END
+		RETURNING chat_room_id, user_id, last_read_message_id, read_at
+	`, chatRoomID, userID, messageID)
+	return &read, err
+}
+
+// GetChatReads returns the read markers of every participant of the room
+func GetChatReads(db *sqlx.DB, chatRoomID int64) ([]models.ChatRead, error) {
+	reads := []models.ChatRead{}
+	err := db.Select(&reads, `
+		SELECT chat_room_id, user_id, last_read_message_id, read_at
+		FROM chat_reads WHERE chat_room_id = $1
+		ORDER BY user_id
+	`, chatRoomID)
+	return reads, err
+}
+
+// GetUnreadCounts returns, per room the user participates in, the number of
+// messages from others after the user's read marker. Rooms without unread
+// messages are left out.
+func GetUnreadCounts(db *sqlx.DB, userID int64) (map[int64]int, error) {
+	rows := []struct {
+		ChatRoomID int64 `db:"chat_room_id"`
+		Unread     int   `db:"unread"`
+	}{}
+	err := db.Select(&rows, `
+		SELECT cp.chat_room_id, COUNT(m.id) AS unread
+		FROM chat_participants cp
+		LEFT JOIN chat_reads r ON r.chat_room_id = cp.chat_room_id AND r.user_id = cp.user_id
+		JOIN chat_messages m ON m.chat_room_id = cp.chat_room_id
+			AND m.sender_id <> cp.user_id
+			AND m.id > COALESCE(r.last_read_message_id, 0)
+			AND m.deleted_at IS NULL
+		WHERE cp.user_id = $1
+		GROUP BY cp.chat_room_id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int64]int, len(rows))
+	for _, r := range rows {
+		counts[r.ChatRoomID] = r.Unread
+	}
+	return counts, nil
+}
+
+// GetChatRoomSummaries returns the user's rooms with their participants,
+// last message and unread count, most recently active first
+func GetChatRoomSummaries(db *sqlx.DB, userID int64) ([]models.ChatRoomSummary, error) {
+	rows := []struct {
+		models.ChatRoom
+		LastID        *int64     `db:"last_id"`
+		LastSenderID  *int64     `db:"last_sender_id"`
+		LastMessage   *string    `db:"last_message"`
+		LastKind      *string    `db:"last_kind"`
+		LastDeletedAt *time.Time `db:"last_deleted_at"`
+		LastCreatedAt *time.Time `db:"last_created_at"`
+	}{}
+	err := db.Select(&rows, `
+		SELECT cr.id, cr.task_id, cr.offer_id, cr.created_at,
+			lm.id AS last_id, lm.sender_id AS last_sender_id,
+			CASE WHEN lm.deleted_at IS NULL AND lm.kind = 'text' THEN left(lm.message, 140) ELSE '' END AS last_message,
+			lm.kind AS last_kind, lm.deleted_at AS last_deleted_at, lm.created_at AS last_created_at
+		FROM chat_rooms cr
+		JOIN chat_participants cp ON cp.chat_room_id = cr.id AND cp.user_id = $1
+		LEFT JOIN LATERAL (
+			SELECT id, sender_id, message, kind, deleted_at, created_at FROM chat_messages
+			WHERE chat_room_id = cr.id ORDER BY id DESC LIMIT 1
+		) lm ON TRUE
+		ORDER BY COALESCE(lm.created_at, cr.created_at) DESC, cr.id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []struct {
+		ChatRoomID int64 `db:"chat_room_id"`
+		models.ChatRoomMember
+	}{}
+	err = db.Select(&members, `
+		SELECT cp.chat_room_id, u.id AS user_id, u.username
+		FROM chat_participants cp
+		JOIN users u ON u.id = cp.user_id
+		WHERE cp.chat_room_id IN (SELECT chat_room_id FROM chat_participants WHERE user_id = $1)
+		ORDER BY cp.chat_room_id, cp.joined_at, cp.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	byRoom := make(map[int64][]models.ChatRoomMember)
+	for _, m := range members {
+		byRoom[m.ChatRoomID] = append(byRoom[m.ChatRoomID], m.ChatRoomMember)
+	}
+
+	unread, err := GetUnreadCounts(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make([]models.ChatRoomSummary, 0, len(rows))
+	for _, r := range rows {
+		s := models.ChatRoomSummary{
+			ChatRoom:       r.ChatRoom,
+			Participants:   byRoom[r.ID],
+			LastActivityAt: r.CreatedAt,
+			Unread:         unread[r.ID],
+		}
+		if s.Participants == nil {
+			s.Participants = []models.ChatRoomMember{}
+		}
+		if r.LastID != nil {
+			s.LastMessage = &models.ChatMessagePreview{
+				ID:        *r.LastID,
+				SenderID:  *r.LastSenderID,
+				Message:   *r.LastMessage,
+				Kind:      *r.LastKind,
+				DeletedAt: r.LastDeletedAt,
+				CreatedAt: *r.LastCreatedAt,
+			}
+			s.LastActivityAt = *r.LastCreatedAt
+		}
+		rooms = append(rooms, s)
+	}
+	return rooms, nil
+}
+
+// SearchChatMessages runs a full-text search over the plain messages of the
+// rooms the user participates in. Encrypted and deleted messages are never
+// matched.
+func SearchChatMessages(db *sqlx.DB, userID int64, f models.ChatSearchFilter) ([]models.ChatSearchResult, error) {
+	args := []interface{}{userID, f.Query}
+	where := []string{
+		"chat_room_id IN (SELECT chat_room_id FROM chat_participants WHERE user_id = $1)",
+		"deleted_at IS NULL",
+		"kind = 'text'",
+		"to_tsvector('simple', message) @@ q",
+	}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	if f.ChatRoomID > 0 {
+		add("chat_room_id = ?", f.ChatRoomID)
+	}
+	if f.SenderID > 0 {
+		add("sender_id = ?", f.SenderID)
+	}
+	if f.From != nil {
+		add("created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		add("created_at < ?", *f.To)
+	}
+
+	order := " ORDER BY created_at DESC, id DESC"
+	if f.Sort == "relevance" {
+		order = " ORDER BY rank DESC, created_at DESC, id DESC"
+	}
+	args = append(args, f.Limit, f.Offset)
+	query := `SELECT ` + chatMessageColumns + `,
+			ts_headline('simple', message, q, 'StartSel=[[, StopSel=]], MaxFragments=2, MaxWords=20, MinWords=5') AS headline,
+			ts_rank(to_tsvector('simple', message), q) AS rank
+		FROM chat_messages, websearch_to_tsquery('simple', $2) q
+		WHERE ` + strings.Join(where, " AND ") + order +
+		" LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	results := []models.ChatSearchResult{}
+	if err := db.Select(&results, query, args...); err != nil {
+		return nil, err
+	}
+	ptrs := make([]*models.ChatMessage, len(results))
+	for i := range results {
+		ptrs[i] = &results[i].ChatMessage
+	}
+	if err := loadChatAttachments(db, ptrs); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
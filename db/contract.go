@@ -0,0 +1,216 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"mFrelance/models"
+)
+
+// weekAmountSQL prices the minutes of a week at the accepted offer o,
+// billing at most its weekly cap
+func weekAmountSQL(minutes string) string {
+	return "LEAST(" + minutes + " / 60.0, o.weekly_cap) * o.hourly_rate"
+}
+
+func CreateTimeEntry(db *sqlx.DB, e *models.TimeEntry) error {
+	return db.QueryRow(`
+		INSERT INTO time_entries (task_id, freelancer_id, work_date, minutes, description)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at
+	`, e.TaskID, e.FreelancerID, e.WorkDate, e.Minutes, e.Description).Scan(&e.ID, &e.CreatedAt, &e.UpdatedAt)
+}
+
+func GetTimeEntry(db *sqlx.DB, id int64) (*models.TimeEntry, error) {
+	var e models.TimeEntry
+	err := db.Get(&e, `SELECT * FROM time_entries WHERE id = $1`, id)
+	return &e, err
+}
+
+func UpdateTimeEntry(db *sqlx.DB, e *models.TimeEntry) error {
+	return db.QueryRow(`
+		UPDATE time_entries SET work_date = $1, minutes = $2, description = $3, updated_at = NOW()
+		WHERE id = $4
+		RETURNING updated_at
+	`, e.WorkDate, e.Minutes, e.Description, e.ID).Scan(&e.UpdatedAt)
+}
+
+func DeleteTimeEntry(db *sqlx.DB, id int64) error {
+	_, err := db.Exec(`DELETE FROM time_entries WHERE id = $1`, id)
+	return err
+}
+
+// ListTimeEntries returns the time logged on a task from one date up to,
+// but not including, another, oldest first
+func ListTimeEntries(db *sqlx.DB, taskID int64, from, to time.Time) ([]models.TimeEntry, error) {
+	entries := []models.TimeEntry{}
+	err := db.Select(&entries, `
+		SELECT * FROM time_entries
+		WHERE task_id = $1 AND work_date >= $2 AND work_date < $3
+		ORDER BY work_date, id
+	`, taskID, from, to)
+	return entries, err
+}
+
+// GetContractWeek returns a closed week of an hourly contract. Weeks still
+// running have no row and give sql.ErrNoRows.
+func GetContractWeek(db *sqlx.DB, taskID int64, weekStart time.Time) (*models.ContractWeek, error) {
+	var w models.ContractWeek
+	err := db.Get(&w, `SELECT * FROM contract_weeks WHERE task_id = $1 AND week_start = $2`, taskID, weekStart)
+	return &w, err
+}
+
+// ListContractWeeks returns the closed weeks of a task, newest first
+func ListContractWeeks(db *sqlx.DB, taskID int64) ([]models.ContractWeek, error) {
+	weeks := []models.ContractWeek{}
+	err := db.Select(&weeks, `SELECT * FROM contract_weeks WHERE task_id = $1 ORDER BY week_start DESC`, taskID)
+	return weeks, err
+}
+
+// CloseContractWeeks submits the weeks of running hourly contracts that had
+// time logged and are over, or all of them once the contract was ended.
+// runningWeek is the Monday of the current week in UTC, like the weeks
+// themselves, so the database timezone doesn't matter. Returns the weeks
+// submitted for review.
+func CloseContractWeeks(db *sqlx.DB, runningWeek time.Time) ([]models.ContractWeek, error) {
+	weeks := []models.ContractWeek{}
+	err := db.Select(&weeks, `
+		INSERT INTO contract_weeks (task_id, week_start, minutes, amount)
+		SELECT e.task_id, e.work_date - (EXTRACT(ISODOW FROM e.work_date)::int - 1) AS week_start, SUM(e.minutes),
+			`+weekAmountSQL("SUM(e.minutes)")+`
+		FROM time_entries e
+		JOIN tasks t ON t.id = e.task_id
+		JOIN task_offers o ON o.task_id = t.id AND o.status = 'accepted'
+		WHERE t.contract_type = 'hourly' AND t.status = 'in_progress'
+			AND (e.work_date < $1::date OR t.ended_at IS NOT NULL)
+		GROUP BY e.task_id, week_start, o.weekly_cap, o.hourly_rate
+		ON CONFLICT (task_id, week_start) DO NOTHING
+		RETURNING *
+	`, runningWeek)
+	return weeks, err
+}
+
+// ResubmitContractWeek puts a disputed week up for review again, priced at
+// the time logged now. Returns sql.ErrNoRows when the week isn't disputed.
+func ResubmitContractWeek(db *sqlx.DB, taskID int64, weekStart time.Time) (*models.ContractWeek, error) {
+	var w models.ContractWeek
+	err := db.Get(&w, `
+		UPDATE contract_weeks w
+		SET status = 'pending', submitted_at = NOW(), reviewed_at = NULL,
+			minutes = s.minutes, amount = `+weekAmountSQL("s.minutes")+`
+		FROM (
+			SELECT COALESCE(SUM(minutes), 0) AS minutes FROM time_entries
+			WHERE task_id = $1 AND work_date >= $2 AND work_date < $2::date + 7
+		) s, task_offers o
+		WHERE w.task_id = $1 AND w.week_start = $2 AND w.status = 'disputed'
+			AND o.task_id = w.task_id AND o.status = 'accepted'
+		RETURNING w.*
+	`, taskID, weekStart)
+	return &w, err
+}
+
+// ReviewContractWeek approves or disputes a week waiting for review.
+// Returns sql.ErrNoRows when the week isn't pending.
+func ReviewContractWeek(db *sqlx.DB, taskID int64, weekStart time.Time, approve bool, reason string) (*models.ContractWeek, error) {
+	status := models.WeekDisputed
+	if approve {
+		status = models.WeekApproved
+		reason = ""
+	}
+	var w models.ContractWeek
+	err := db.Get(&w, `
+		UPDATE contract_weeks SET status = $1, dispute_reason = $2, reviewed_at = NOW()
+		WHERE task_id = $3 AND week_start = $4 AND status = 'pending'
+		RETURNING *
+	`, status, reason, taskID, weekStart)
+	return &w, err
+}
+
+// ApproveExpiredContractWeeks approves the weeks the client didn't review
+// within window of their submission and returns them
+func ApproveExpiredContractWeeks(db *sqlx.DB, window time.Duration) ([]models.ContractWeek, error) {
+	weeks := []models.ContractWeek{}
+	err := db.Select(&weeks, `
+		UPDATE contract_weeks SET status = 'approved', reviewed_at = NOW()
+		WHERE status = 'pending' AND submitted_at <= NOW() - $1 * INTERVAL '1 second'
+		RETURNING *
+	`, window.Seconds())
+	return weeks, err
+}
+
+// ListApprovedContractWeeks returns the approved weeks, oldest first. Weeks
+// of contracts no longer running are returned too, settling cancels them.
+func ListApprovedContractWeeks(db *sqlx.DB) ([]models.ContractWeek, error) {
+	weeks := []models.ContractWeek{}
+	err := db.Select(&weeks, `
+		SELECT * FROM contract_weeks
+		WHERE status = 'approved'
+		ORDER BY task_id, week_start
+	`)
+	return weeks, err
+}
+
+// CancelContractWeekTx cancels an approved week the escrow no longer pays
+func CancelContractWeekTx(tx *sqlx.Tx, taskID int64, weekStart time.Time) error {
+	_, err := tx.Exec(`
+		UPDATE contract_weeks SET status = 'cancelled'
+		WHERE task_id = $1 AND week_start = $2 AND status = 'approved'
+	`, taskID, weekStart)
+	return err
+}
+
+// CancelContractWeeksTx cancels the weeks of a task that aren't settled,
+// once a dispute resolution has released or refunded its escrow
+func CancelContractWeeksTx(tx *sqlx.Tx, taskID int64) error {
+	_, err := tx.Exec(`
+		UPDATE contract_weeks SET status = 'cancelled'
+		WHERE task_id = $1 AND status IN ('pending', 'approved', 'disputed')
+	`, taskID)
+	return err
+}
+
+// SettleContractWeekTx marks an approved week settled. Returns false when
+// the week was settled meanwhile.
+func SettleContractWeekTx(tx *sqlx.Tx, taskID int64, weekStart time.Time) (bool, error) {
+	res, err := tx.Exec(`
+		UPDATE contract_weeks SET status = 'settled', settled_at = NOW()
+		WHERE task_id = $1 AND week_start = $2 AND status = 'approved'
+	`, taskID, weekStart)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetContractPaidTx returns the total of the settled weeks of a task
+func GetContractPaidTx(tx *sqlx.Tx, taskID int64) (float64, error) {
+	var paid float64
+	err := tx.Get(&paid, `SELECT COALESCE(SUM(amount), 0) FROM contract_weeks WHERE task_id = $1 AND status = 'settled'`, taskID)
+	return paid, err
+}
+
+// EndContract stops an hourly contract from taking more time. Returns
+// sql.ErrNoRows when the task isn't a running hourly contract.
+func EndContract(db *sqlx.DB, taskID int64) (time.Time, error) {
+	var endedAt time.Time
+	err := db.Get(&endedAt, `
+		UPDATE tasks SET ended_at = NOW()
+		WHERE id = $1 AND contract_type = 'hourly' AND status = 'in_progress' AND ended_at IS NULL
+		RETURNING ended_at
+	`, taskID)
+	return endedAt, err
+}
+
+// ListFinishedContracts returns the ended hourly contracts whose weeks are
+// all settled
+func ListFinishedContracts(db *sqlx.DB) ([]*models.Task, error) {
+	tasks := []*models.Task{}
+	err := db.Select(&tasks, `
+		SELECT t.* FROM tasks t
+		WHERE t.contract_type = 'hourly' AND t.status = 'in_progress' AND t.ended_at IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM contract_weeks w WHERE w.task_id = t.id AND w.status NOT IN ('settled', 'cancelled'))
+	`)
+	return tasks, err
+}
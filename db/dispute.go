@@ -66,12 +66,35 @@ func UpdateDisputeStatusTx(tx *sqlx.Tx, id int64, status string, resolution *str
 	return err
 }
 
+// ResolveDisputeTx resolves an open dispute. Returns sql.ErrNoRows when it
+// was resolved meanwhile.
+func ResolveDisputeTx(tx *sqlx.Tx, id int64, resolution string) error {
+	res, err := tx.Exec(`UPDATE disputes SET status = 'resolved', resolution = $1, updated_at = $2 WHERE id = $3 AND status = 'open'`, resolution, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func AssignDisputeToAdmin(disputeID, adminID int64) error {
 	query := `UPDATE disputes SET assigned_admin = $1, updated_at = $2 WHERE id = $3`
 	_, err := Postgres.Exec(query, adminID, time.Now(), disputeID)
 	return err
 }
 
+// AssignDisputeToAdminTx assigns the dispute and returns the previous assignee
+func AssignDisputeToAdminTx(tx *sqlx.Tx, disputeID, adminID int64) (*int64, error) {
+	var prev *int64
+	if err := tx.Get(&prev, `SELECT assigned_admin FROM disputes WHERE id = $1 FOR UPDATE`, disputeID); err != nil {
+		return nil, err
+	}
+	_, err := tx.Exec(`UPDATE disputes SET assigned_admin = $1, updated_at = $2 WHERE id = $3`, adminID, time.Now(), disputeID)
+	return prev, err
+}
+
 func CreateDisputeMessage(message *models.DisputeMessage) error {
 	query := `
 		INSERT INTO dispute_messages (dispute_id, sender_id, message, created_at)
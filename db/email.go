@@ -0,0 +1,111 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"mFrelance/models"
+)
+
+// Outbox states
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailDead    = "dead"
+)
+
+func GetUserEmail(db *sqlx.DB, userID int64) (*models.UserEmail, error) {
+	var e models.UserEmail
+	err := db.Get(&e, `SELECT * FROM user_emails WHERE user_id = $1`, userID)
+	return &e, err
+}
+
+// SetUserEmail stores a new unverified address with a pending verification
+// token, replacing the previous address
+func SetUserEmail(db *sqlx.DB, userID int64, email, locale, tokenHash string, expires time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO user_emails (user_id, email, locale, token_hash, token_expires_at, token_sent_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET email = EXCLUDED.email, locale = EXCLUDED.locale, verified_at = NULL,
+		    token_hash = EXCLUDED.token_hash, token_expires_at = EXCLUDED.token_expires_at,
+		    token_sent_at = NOW(), updated_at = NOW()
+	`, userID, email, locale, tokenHash, expires)
+	return err
+}
+
+// SetEmailVerificationToken replaces the pending token of an unverified address
+func SetEmailVerificationToken(db *sqlx.DB, userID int64, tokenHash string, expires time.Time) error {
+	_, err := db.Exec(`
+		UPDATE user_emails
+		SET token_hash = $1, token_expires_at = $2, token_sent_at = NOW(), updated_at = NOW()
+		WHERE user_id = $3 AND verified_at IS NULL
+	`, tokenHash, expires, userID)
+	return err
+}
+
+func SetUserEmailLocale(db *sqlx.DB, userID int64, locale string) error {
+	_, err := db.Exec(`UPDATE user_emails SET locale = $1, updated_at = NOW() WHERE user_id = $2`, locale, userID)
+	return err
+}
+
+// VerifyUserEmail marks the address holding the token as verified and
+// returns its owner. It returns sql.ErrNoRows for unknown or expired tokens.
+func VerifyUserEmail(db *sqlx.DB, tokenHash string) (int64, error) {
+	var userID int64
+	err := db.Get(&userID, `
+		UPDATE user_emails
+		SET verified_at = NOW(), token_hash = NULL, token_expires_at = NULL, updated_at = NOW()
+		WHERE token_hash = $1 AND token_expires_at > NOW() AND verified_at IS NULL
+		RETURNING user_id
+	`, tokenHash)
+	return userID, err
+}
+
+func DeleteUserEmail(db *sqlx.DB, userID int64) error {
+	_, err := db.Exec(`DELETE FROM user_emails WHERE user_id = $1`, userID)
+	return err
+}
+
+// EnqueueEmail adds a rendered message to the outbox
+func EnqueueEmail(db *sqlx.DB, m *models.OutboxEmail) error {
+	return db.QueryRow(`
+		INSERT INTO email_outbox (user_id, to_address, template, subject, text_body, html_body)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, status, next_attempt_at, created_at
+	`, m.UserID, m.ToAddress, m.Template, m.Subject, m.TextBody, m.HTMLBody).Scan(&m.ID, &m.Status, &m.NextAttemptAt, &m.CreatedAt)
+}
+
+// ClaimOutboxEmails picks due messages and pushes their next attempt back
+// by lease, so other instances skip them while they are being sent
+func ClaimOutboxEmails(db *sqlx.DB, limit int, lease time.Duration) ([]models.OutboxEmail, error) {
+	list := []models.OutboxEmail{}
+	err := db.Select(&list, `
+		WITH due AS (
+			SELECT id FROM email_outbox
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE email_outbox o
+		SET next_attempt_at = NOW() + $2::float8 * INTERVAL '1 second'
+		FROM due
+		WHERE o.id = due.id
+		RETURNING o.*
+	`, limit, lease.Seconds())
+	return list, err
+}
+
+// MarkOutboxEmail records the result of a send attempt. next is only used
+// while the message stays pending.
+func MarkOutboxEmail(db *sqlx.DB, id int64, status string, attempts int, lastError string, next time.Time) error {
+	_, err := db.Exec(`
+		UPDATE email_outbox
+		SET status = $1::text, attempts = $2, last_error = $3, next_attempt_at = $4,
+		    sent_at = CASE WHEN $1::text = 'sent' THEN NOW() ELSE sent_at END
+		WHERE id = $5
+	`, status, attempts, lastError, next, id)
+	return err
+}
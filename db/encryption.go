@@ -0,0 +1,130 @@
+package db
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"mFrelance/models"
+)
+
+// PublishPublicKey revokes the user's active key and makes k the new one
+func PublishPublicKey(db *sqlx.DB, k *models.PublicKey) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE user_public_keys SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, k.UserID); err != nil {
+		return err
+	}
+	err = tx.QueryRow(`
+		INSERT INTO user_public_keys (user_id, algorithm, public_key, fingerprint)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, k.UserID, k.Algorithm, k.PublicKey, k.Fingerprint).Scan(&k.ID, &k.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokePublicKey reports whether the user had an active key to revoke
+func RevokePublicKey(db *sqlx.DB, userID int64) (bool, error) {
+	res, err := db.Exec(`UPDATE user_public_keys SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// GetActivePublicKey returns sql.ErrNoRows if the user has no active key
+func GetActivePublicKey(db *sqlx.DB, userID int64) (*models.PublicKey, error) {
+	var k models.PublicKey
+	err := db.Get(&k, `SELECT * FROM user_public_keys WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// ListPublicKeys returns every key of a user, newest first
+func ListPublicKeys(db *sqlx.DB, userID int64) ([]models.PublicKey, error) {
+	keys := []models.PublicKey{}
+	err := db.Select(&keys, `SELECT * FROM user_public_keys WHERE user_id = $1 ORDER BY id DESC`, userID)
+	return keys, err
+}
+
+// GetActivePublicKeys returns the active keys among ids by ID
+func GetActivePublicKeys(db *sqlx.DB, ids []int64) (map[int64]models.PublicKey, error) {
+	keys := []models.PublicKey{}
+	err := db.Select(&keys, `SELECT * FROM user_public_keys WHERE id = ANY($1) AND revoked_at IS NULL`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]models.PublicKey, len(keys))
+	for _, k := range keys {
+		byID[k.ID] = k
+	}
+	return byID, nil
+}
+
+// DiscloseMessageKeys stores content keys a participant re-wrapped for the
+// arbiter of a dispute. Keys the arbiter received as a regular recipient
+// are left alone.
+func DiscloseMessageKeys(db *sqlx.DB, disputeID, disclosedBy int64, keys []models.ChatMessageKey) (int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var n int64
+	for _, k := range keys {
+		res, err := tx.Exec(`
+			INSERT INTO chat_message_keys (message_id, user_id, key_id, wrapped_key, disclosed_by, dispute_id)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (message_id, user_id) DO UPDATE
+			SET key_id = EXCLUDED.key_id, wrapped_key = EXCLUDED.wrapped_key,
+			    disclosed_by = EXCLUDED.disclosed_by, dispute_id = EXCLUDED.dispute_id, created_at = NOW()
+			WHERE chat_message_keys.disclosed_by IS NOT NULL
+		`, k.MessageID, k.UserID, k.KeyID, k.WrappedKey, disclosedBy, disputeID)
+		if err != nil {
+			return 0, err
+		}
+		c, _ := res.RowsAffected()
+		n += c
+	}
+	return n, tx.Commit()
+}
+
+// GetDisclosedMessages returns the messages disclosed under a dispute,
+// oldest first, each with the keys wrapped for userID only
+func GetDisclosedMessages(db *sqlx.DB, disputeID, userID int64) ([]models.ChatMessage, error) {
+	messages := []models.ChatMessage{}
+	err := db.Select(&messages, `
+		SELECT `+chatMessageColumns+` FROM chat_messages
+		WHERE deleted_at IS NULL AND id IN (SELECT message_id FROM chat_message_keys WHERE dispute_id = $1)
+		ORDER BY chat_room_id, created_at
+	`, disputeID)
+	if err != nil || len(messages) == 0 {
+		return messages, err
+	}
+	ids := make([]int64, len(messages))
+	byID := make(map[int64]*models.ChatMessage, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+		messages[i].Attachments = []models.File{}
+		messages[i].Keys = []models.ChatMessageKey{}
+		byID[messages[i].ID] = &messages[i]
+	}
+	keys := []models.ChatMessageKey{}
+	err = db.Select(&keys, `SELECT * FROM chat_message_keys WHERE message_id = ANY($1) AND user_id = $2`, pq.Array(ids), userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		m := byID[k.MessageID]
+		m.Keys = append(m.Keys, k)
+	}
+	return messages, nil
+}
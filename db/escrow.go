@@ -68,3 +68,18 @@ func GetEscrowBalancesByUserID(userID int64) ([]*models.EscrowBalance, error) {
 	return balances, nil
 }
 
+// LockEscrowBalanceTx returns the escrow of a task, locked until the
+// transaction ends
+func LockEscrowBalanceTx(tx *sqlx.Tx, taskID int64) (*models.EscrowBalance, error) {
+	var escrow models.EscrowBalance
+	err := tx.Get(&escrow, `SELECT * FROM escrow_balances WHERE task_id = $1 FOR UPDATE`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return &escrow, nil
+}
+
+func UpdateEscrowAmountTx(tx *sqlx.Tx, taskID int64, amount float64) error {
+	_, err := tx.Exec(`UPDATE escrow_balances SET amount = $1 WHERE task_id = $2`, amount, taskID)
+	return err
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"mFrelance/models"
+)
+
+func CreateFile(db *sqlx.DB, f *models.File) error {
+	return db.QueryRow(`
+		INSERT INTO files (owner_id, name, content_type, size, sha256, storage_key)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, f.OwnerID, f.Name, f.ContentType, f.Size, f.SHA256, f.StorageKey).Scan(&f.ID, &f.CreatedAt)
+}
+
+func GetFile(db *sqlx.DB, id int64) (*models.File, error) {
+	var f models.File
+	err := db.Get(&f, `SELECT * FROM files WHERE id = $1`, id)
+	return &f, err
+}
+
+// GetOwnedFiles returns the files among ids that belong to ownerID, in the
+// order of ids
+func GetOwnedFiles(db *sqlx.DB, ownerID int64, ids []int64) ([]models.File, error) {
+	files := []models.File{}
+	err := db.Select(&files, `
+		SELECT f.* FROM files f
+		JOIN unnest($2::int[]) WITH ORDINALITY AS x(id, n) ON x.id = f.id
+		WHERE f.owner_id = $1
+		ORDER BY x.n
+	`, ownerID, pq.Array(ids))
+	return files, err
+}
+
+// CanAccessFile reports whether userID owns the file, participates in a
+// chat room where it is attached to a message that isn't deleted, or the
+// file is a portfolio image, which every user can see
+func CanAccessFile(db *sqlx.DB, userID, fileID int64) (bool, error) {
+	var ok bool
+	err := db.Get(&ok, `
+		SELECT EXISTS (SELECT 1 FROM files WHERE id = $2 AND owner_id = $1)
+		    OR EXISTS (
+			SELECT 1 FROM chat_message_attachments a
+			JOIN chat_messages m ON m.id = a.message_id AND m.deleted_at IS NULL
+			JOIN chat_participants p ON p.chat_room_id = m.chat_room_id AND p.user_id = $1
+			WHERE a.file_id = $2
+		)
+		    OR EXISTS (SELECT 1 FROM portfolio_item_files WHERE file_id = $2)
+	`, userID, fileID)
+	return ok, err
+}
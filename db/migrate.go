@@ -1,30 +1,420 @@
 package db
 
 import (
-	"io/ioutil"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
+	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the advisory lock held while migrating, so instances
+// started together don't run the same migration twice
+const migrationLockID = 7_341_209_115
+
+// noTransaction as the first line of a script runs it outside of a
+// transaction, for statements like CREATE INDEX CONCURRENTLY
+const noTransaction = "-- migrate:no-transaction"
+
+var migrationName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a numbered schema change. Down is empty for migrations that
+// can't be reverted.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Migration states reported by GetMigrationStatus
+const (
+	MigrationApplied  = "applied"
+	MigrationPending  = "pending"
+	MigrationModified = "modified"
+	MigrationMissing  = "missing"
+)
+
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	State     string
+	AppliedAt *time.Time
+}
+
+type appliedMigration struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// LoadMigrations reads the NNNN_name.up.sql and NNNN_name.down.sql files
+// at the top of fsys, ordered by version
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		m := migrationName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_name.up.sql", e.Name())
+		}
+		version, _ := strconv.ParseInt(m[1], 10, 64)
+		data, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(data)
+			sum := sha256.Sum256(data)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// EmbeddedMigrations returns the migrations compiled into the binary
+func EmbeddedMigrations() ([]Migration, error) {
+	sub, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return LoadMigrations(sub)
+}
+
+// withMigrationLock runs fn on a single connection holding the migration
+// lock and makes sure schema_migrations exists
+func withMigrationLock(db *sqlx.DB, fn func(conn *sqlx.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.GetContext(ctx, &locked, `SELECT pg_try_advisory_lock($1)`, migrationLockID); err != nil {
+		return err
+	}
+	if !locked {
+		log.Println("Waiting for another instance to finish migrating")
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+			return err
+		}
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum VARCHAR(64) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func loadAppliedMigrations(conn *sqlx.Conn) (map[int64]appliedMigration, error) {
+	var rows []appliedMigration
+	err := conn.SelectContext(context.Background(), &rows, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]appliedMigration, len(rows))
+	for _, r := range rows {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// checkMigrationChecksums fails when an applied migration was edited
+// afterwards. Schema changes go into a new migration instead.
+func checkMigrationChecksums(migrations []Migration, applied map[int64]appliedMigration) error {
+	for _, m := range migrations {
+		if a, ok := applied[m.Version]; ok && a.Checksum != m.Checksum {
+			return fmt.Errorf("migration %04d_%s was changed after it was applied", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// runMigrationScript runs a script and records the result with record in
+// the same transaction
+func runMigrationScript(conn *sqlx.Conn, script string, record func(ex sqlx.ExecerContext) error) error {
+	ctx := context.Background()
+	if strings.HasPrefix(script, noTransaction) {
+		if _, err := conn.ExecContext(ctx, script); err != nil {
+			return err
+		}
+		return record(conn)
+	}
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateUp applies the pending migrations in order and returns how many
+// were applied
+func MigrateUp(db *sqlx.DB, migrations []Migration) (int, error) {
+	n := 0
+	err := withMigrationLock(db, func(conn *sqlx.Conn) error {
+		applied, err := loadAppliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		if err := checkMigrationChecksums(migrations, applied); err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			start := time.Now()
+			err := runMigrationScript(conn, m.Up, func(ex sqlx.ExecerContext) error {
+				_, err := ex.ExecContext(context.Background(),
+					`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+					m.Version, m.Name, m.Checksum)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+			}
+			log.Printf("Applied migration %04d_%s in %s", m.Version, m.Name, time.Since(start).Round(time.Millisecond))
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first
+func MigrateDown(db *sqlx.DB, migrations []Migration, steps int) (int, error) {
+	byVersion := make(map[int64]Migration, len(migrations))
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+	n := 0
+	err := withMigrationLock(db, func(conn *sqlx.Conn) error {
+		applied, err := loadAppliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		if err := checkMigrationChecksums(migrations, applied); err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, v := range versions {
+			if n == steps {
+				break
+			}
+			m, ok := byVersion[v]
+			if !ok {
+				return fmt.Errorf("migration %04d_%s is applied but its files are missing", v, applied[v].Name)
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %04d_%s can't be reverted, it has no down script", m.Version, m.Name)
+			}
+			err := runMigrationScript(conn, m.Down, func(ex sqlx.ExecerContext) error {
+				_, err := ex.ExecContext(context.Background(), `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("reverting migration %04d_%s failed: %w", m.Version, m.Name, err)
+			}
+			log.Printf("Reverted migration %04d_%s", m.Version, m.Name)
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// GetMigrationStatus lists the known and applied migrations by version
+func GetMigrationStatus(db *sqlx.DB, migrations []Migration) ([]MigrationStatus, error) {
+	var list []MigrationStatus
+	err := withMigrationLock(db, func(conn *sqlx.Conn) error {
+		applied, err := loadAppliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			s := MigrationStatus{Version: m.Version, Name: m.Name, State: MigrationPending}
+			if a, ok := applied[m.Version]; ok {
+				s.State = MigrationApplied
+				if a.Checksum != m.Checksum {
+					s.State = MigrationModified
+				}
+				s.AppliedAt = &a.AppliedAt
+				delete(applied, m.Version)
+			}
+			list = append(list, s)
+		}
+		for _, a := range applied {
+			at := a.AppliedAt
+			list = append(list, MigrationStatus{Version: a.Version, Name: a.Name, State: MigrationMissing, AppliedAt: &at})
+		}
+		return nil
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, err
+}
+
+// CreateMigration writes empty up and down scripts numbered after the
+// newest migration in dir and returns their paths
+func CreateMigration(dir, name string) (string, string, error) {
+	name = strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return "", "", errors.New("migration name is required")
+	}
+	existing, err := LoadMigrations(os.DirFS(dir))
+	if err != nil {
+		return "", "", err
+	}
+	var version int64 = 1
+	if len(existing) > 0 {
+		version = existing[len(existing)-1].Version + 1
+	}
+	base := filepath.Join(dir, fmt.Sprintf("%04d_%s", version, name))
+	up, down := base+".up.sql", base+".down.sql"
+	if err := os.WriteFile(up, []byte("-- "+strings.ReplaceAll(name, "_", " ")+"\n"), 0644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(down, []byte(""), 0644); err != nil {
+		return "", "", err
+	}
+	return up, down, nil
+}
+
+// Migrate applies the embedded migrations and stops the program if that
+// fails
 func Migrate(db *sqlx.DB) {
-	files, err := filepath.Glob("db/migrations/*.sql")
+	migrations, err := EmbeddedMigrations()
 	if err != nil {
+		log.Fatal("Failed to load migrations: ", err)
+	}
+	if _, err := MigrateUp(db, migrations); err != nil {
 		log.Fatal(err)
 	}
+}
 
-	for _, file := range files {
-		data, err := ioutil.ReadFile(file)
+const migrateUsage = `usage: mFrelance migrate up|down [steps]|status|create <name>`
+
+// RunMigrateCommand runs the migrate subcommand. args start after
+// "migrate". down reverts one migration unless steps is given, create
+// writes new scripts to db/migrations of the source tree.
+func RunMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		up, down, err := CreateMigration(filepath.Join("db", "migrations"), args[1])
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
+		fmt.Println("Created", up)
+		fmt.Println("Created", down)
+		return nil
+	}
 
-		_, err = db.Exec(string(data))
+	migrations, err := EmbeddedMigrations()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "up":
+		Connect()
+		n, err := MigrateUp(Postgres, migrations)
+		fmt.Printf("Applied %d migrations\n", n)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return errors.New("steps must be a positive number")
+			}
+		}
+		Connect()
+		n, err := MigrateDown(Postgres, migrations, steps)
+		fmt.Printf("Reverted %d migrations\n", n)
+		return err
+	case "status":
+		Connect()
+		list, err := GetMigrationStatus(Postgres, migrations)
 		if err != nil {
-			log.Fatalf("Migration %s failed: %v", file, err)
+			return err
 		}
-
-		log.Println("Applied migration:", file)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, s := range list {
+			at := ""
+			if s.AppliedAt != nil {
+				at = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, s.State, at)
+		}
+		return w.Flush()
 	}
+	return errors.New(migrateUsage)
 }
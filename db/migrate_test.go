@@ -0,0 +1,79 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_b.up.sql":   {Data: []byte("ALTER TABLE a ADD COLUMN b INT;")},
+		"0002_add_b.down.sql": {Data: []byte("ALTER TABLE a DROP COLUMN b;")},
+		"0001_init.up.sql":    {Data: []byte("CREATE TABLE a (id INT);")},
+		"README.md":           {Data: []byte("ignored")},
+	}
+	list, err := LoadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Version != 1 || list[1].Version != 2 {
+		t.Fatalf("unexpected migrations: %+v", list)
+	}
+	if list[0].Down != "" || list[1].Down == "" {
+		t.Errorf("down scripts not matched: %+v", list)
+	}
+	if len(list[0].Checksum) != 64 || list[0].Checksum == list[1].Checksum {
+		t.Errorf("bad checksums %q %q", list[0].Checksum, list[1].Checksum)
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"bad name":  {"init.sql": {Data: []byte("SELECT 1;")}},
+		"no up":     {"0001_init.down.sql": {Data: []byte("SELECT 1;")}},
+		"two names": {"0001_a.up.sql": {Data: []byte("SELECT 1;")}, "0001_b.up.sql": {Data: []byte("SELECT 2;")}},
+	}
+	for name, fsys := range cases {
+		if _, err := LoadMigrations(fsys); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	list, err := EmbeddedMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 || list[0].Version != 1 {
+		t.Fatalf("embedded migrations must start at 1, got %d migrations", len(list))
+	}
+	for i, m := range list {
+		if m.Version != int64(i+1) {
+			t.Errorf("gap before migration %04d_%s", m.Version, m.Name)
+		}
+		if m.Version > 1 && m.Down == "" {
+			t.Errorf("migration %04d_%s has no down script", m.Version, m.Name)
+		}
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "0007_old.up.sql"), []byte("SELECT 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	up, down, err := CreateMigration(dir, "Add user Bio!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(up) != "0008_add_user_bio.up.sql" || !strings.HasSuffix(down, "0008_add_user_bio.down.sql") {
+		t.Errorf("unexpected files %s, %s", up, down)
+	}
+	if _, _, err := CreateMigration(dir, "!!"); err == nil {
+		t.Error("expected an error for an empty name")
+	}
+}
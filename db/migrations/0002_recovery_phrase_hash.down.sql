@@ -0,0 +1,3 @@
+-- The hashes can't be turned back into phrases, users have to restore
+-- their accounts another way after this
+ALTER TABLE users DROP COLUMN IF EXISTS mnemonic_hash;
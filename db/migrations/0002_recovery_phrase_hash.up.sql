@@ -0,0 +1,2 @@
+-- Recovery phrases are stored as argon2id hashes only
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mnemonic_hash TEXT;
@@ -0,0 +1,7 @@
+-- users.permissions is left untouched by the roles migration, so the
+-- bitmask still works after this
+ALTER TABLE users DROP COLUMN IF EXISTS perm_version;
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS permissions;
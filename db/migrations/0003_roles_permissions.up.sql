@@ -0,0 +1,76 @@
+-- Role based access control, replaces the users.permissions bitmask
+CREATE TABLE IF NOT EXISTS permissions (
+    name VARCHAR(64) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(64) NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    builtin BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INT REFERENCES roles(id) ON DELETE CASCADE,
+    permission VARCHAR(64) REFERENCES permissions(name) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    role_id INT REFERENCES roles(id) ON DELETE CASCADE,
+    granted_by INT REFERENCES users(id) ON DELETE SET NULL,
+    granted_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (user_id, role_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles (role_id);
+
+-- Bumped on every role change so cached permissions and JWTs can be invalidated
+ALTER TABLE users ADD COLUMN IF NOT EXISTS perm_version BIGINT NOT NULL DEFAULT 0;
+
+INSERT INTO permissions (name, description) VALUES
+    ('balance.change', 'Change user wallet balances'),
+    ('user.block', 'Block and unblock users'),
+    ('transaction.view', 'View all transactions'),
+    ('wallet.view', 'View all wallets'),
+    ('ticket.manage', 'Work on support tickets'),
+    ('dispute.manage', 'Assign and resolve disputes'),
+    ('chat.manage', 'Manage chat rooms'),
+    ('task.moderate', 'Delete tasks and offers of other users'),
+    ('role.manage', 'Manage roles, permissions and admins')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles (name, description, builtin) VALUES
+    ('support', 'Support staff', TRUE),
+    ('arbiter', 'Dispute arbiter', TRUE),
+    ('finance', 'Finance operator', TRUE),
+    ('superadmin', 'Full access', TRUE)
+ON CONFLICT (name) DO NOTHING;
+
+-- Default permissions are only seeded into roles that have none yet, so
+-- edits made through the admin API survive restarts
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, v.permission
+FROM roles r
+JOIN (VALUES
+    ('support', 'ticket.manage'),
+    ('support', 'chat.manage'),
+    ('support', 'user.block'),
+    ('support', 'task.moderate'),
+    ('arbiter', 'dispute.manage'),
+    ('arbiter', 'chat.manage'),
+    ('finance', 'balance.change'),
+    ('finance', 'transaction.view'),
+    ('finance', 'wallet.view')
+) AS v(role, permission) ON v.role = r.name
+WHERE NOT EXISTS (SELECT 1 FROM role_permissions rp WHERE rp.role_id = r.id)
+ON CONFLICT DO NOTHING;
+
+-- superadmin always holds every permission
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'superadmin'
+ON CONFLICT DO NOTHING;
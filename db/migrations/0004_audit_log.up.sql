@@ -0,0 +1,37 @@
+-- Append-only audit trail of privileged actions. No foreign keys on purpose:
+-- entries must survive the deletion of the users and objects they mention.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INT,
+    action VARCHAR(64) NOT NULL,
+    target_type VARCHAR(32) NOT NULL DEFAULT '',
+    target_id BIGINT,
+    before JSONB,
+    after JSONB,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor_id ON audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log (target_type, target_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_no_modify ON audit_log;
+CREATE TRIGGER audit_log_no_modify BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+INSERT INTO permissions (name, description) VALUES
+    ('audit.view', 'Read the admin audit log')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'superadmin'
+ON CONFLICT DO NOTHING;
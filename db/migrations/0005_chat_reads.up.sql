@@ -0,0 +1,9 @@
+-- Read state per participant, drives unread counters and read receipts
+CREATE TABLE IF NOT EXISTS chat_reads (
+    chat_room_id INT NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    last_read_message_id INT NOT NULL DEFAULT 0,
+    read_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (chat_room_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_chat_reads_user_id ON chat_reads (user_id);
@@ -0,0 +1,3 @@
+ALTER TABLE notification_preferences DROP COLUMN IF EXISTS email;
+DROP TABLE IF EXISTS email_outbox;
+DROP TABLE IF EXISTS user_emails;
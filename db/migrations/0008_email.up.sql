@@ -0,0 +1,35 @@
+-- Optional email address per user. token_hash is the SHA-256 of the pending
+-- verification token, cleared once the address is verified.
+CREATE TABLE IF NOT EXISTS user_emails (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(254) NOT NULL,
+    locale VARCHAR(8) NOT NULL DEFAULT 'en',
+    verified_at TIMESTAMP,
+    token_hash VARCHAR(64),
+    token_expires_at TIMESTAMP,
+    token_sent_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_emails_verified ON user_emails (LOWER(email)) WHERE verified_at IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_emails_token_hash ON user_emails (token_hash);
+
+-- status: pending, sent, dead
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    to_address VARCHAR(254) NOT NULL,
+    template VARCHAR(64) NOT NULL,
+    subject TEXT NOT NULL,
+    text_body TEXT NOT NULL,
+    html_body TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_error TEXT NOT NULL DEFAULT '',
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_email_outbox_due ON email_outbox (next_attempt_at) WHERE status = 'pending';
+
+ALTER TABLE notification_preferences ADD COLUMN IF NOT EXISTS email BOOLEAN NOT NULL DEFAULT TRUE;
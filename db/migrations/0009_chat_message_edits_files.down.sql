@@ -0,0 +1,8 @@
+-- Deleted messages come back with their last text
+DROP TABLE IF EXISTS chat_message_attachments;
+DROP TABLE IF EXISTS chat_message_edits;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS deleted_by;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS edited_at;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS reply_to_id;
+DROP TABLE IF EXISTS files;
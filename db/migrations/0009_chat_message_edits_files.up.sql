@@ -0,0 +1,36 @@
+-- Uploaded files. The content is stored under files.dir by storage_key.
+CREATE TABLE IF NOT EXISTS files (
+    id SERIAL PRIMARY KEY,
+    owner_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    content_type VARCHAR(127) NOT NULL,
+    size BIGINT NOT NULL,
+    sha256 VARCHAR(64) NOT NULL,
+    storage_key VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_files_owner_id ON files (owner_id);
+
+-- Chat message editing, deletion, replies and attachments
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS reply_to_id INT REFERENCES chat_messages(id) ON DELETE SET NULL;
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP;
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS deleted_by INT REFERENCES users(id) ON DELETE SET NULL;
+
+-- previous versions of edited messages, newest last
+CREATE TABLE IF NOT EXISTS chat_message_edits (
+    id SERIAL PRIMARY KEY,
+    message_id INT NOT NULL REFERENCES chat_messages(id) ON DELETE CASCADE,
+    message TEXT NOT NULL,
+    edited_by INT REFERENCES users(id) ON DELETE SET NULL,
+    edited_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_chat_message_edits_message_id ON chat_message_edits (message_id);
+
+CREATE TABLE IF NOT EXISTS chat_message_attachments (
+    message_id INT NOT NULL REFERENCES chat_messages(id) ON DELETE CASCADE,
+    file_id INT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    position INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (message_id, file_id)
+);
+CREATE INDEX IF NOT EXISTS idx_chat_message_attachments_file_id ON chat_message_attachments (file_id);
@@ -0,0 +1,5 @@
+-- Task and offer rooms become plain rooms between their participants
+DROP INDEX IF EXISTS idx_chat_rooms_offer_id;
+DROP INDEX IF EXISTS idx_chat_rooms_task_id;
+ALTER TABLE chat_rooms DROP COLUMN IF EXISTS offer_id;
+ALTER TABLE chat_rooms DROP COLUMN IF EXISTS task_id;
@@ -0,0 +1,6 @@
+-- Chat rooms bound to a task (after the offer is accepted) or to an offer
+-- (messaging before the contract)
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS task_id INT REFERENCES tasks(id) ON DELETE CASCADE;
+ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS offer_id INT REFERENCES task_offers(id) ON DELETE CASCADE;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chat_rooms_task_id ON chat_rooms (task_id) WHERE task_id IS NOT NULL AND offer_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chat_rooms_offer_id ON chat_rooms (offer_id) WHERE offer_id IS NOT NULL;
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE name = 'report.manage';
+DROP TABLE IF EXISTS reports;
+DROP TABLE IF EXISTS user_blocks;
@@ -0,0 +1,49 @@
+-- Users a user doesn't want to hear from. Blocks work both ways: neither
+-- side can send chat requests, messages or offers to the other.
+CREATE TABLE IF NOT EXISTS user_blocks (
+    blocker_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id <> blocked_id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_blocks_blocked_id ON user_blocks (blocked_id);
+
+-- Abuse reports on messages, users and tasks. snapshot keeps the reported
+-- content so it survives deletion.
+CREATE TABLE IF NOT EXISTS reports (
+    id SERIAL PRIMARY KEY,
+    reporter_id INT REFERENCES users(id) ON DELETE SET NULL,
+    target_type VARCHAR(16) NOT NULL,
+    target_id INT NOT NULL,
+    target_user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    category VARCHAR(32) NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    snapshot TEXT NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL DEFAULT 'open',
+    action VARCHAR(32),
+    resolution_note TEXT NOT NULL DEFAULT '',
+    resolved_by INT REFERENCES users(id) ON DELETE SET NULL,
+    resolved_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status, created_at);
+CREATE INDEX IF NOT EXISTS idx_reports_target ON reports (target_type, target_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_open_per_reporter ON reports (reporter_id, target_type, target_id) WHERE status = 'open';
+
+-- support gets the new permission once, when it is created
+WITH p AS (
+    INSERT INTO permissions (name, description) VALUES
+        ('report.manage', 'Work on the moderation queue of user reports')
+    ON CONFLICT (name) DO NOTHING
+    RETURNING name
+)
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name FROM roles r CROSS JOIN p
+WHERE r.name = 'support'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'superadmin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,5 @@
+-- Encrypted messages stay as base64 ciphertext in plain messages
+DROP TABLE IF EXISTS chat_message_keys;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS encryption;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS kind;
+DROP TABLE IF EXISTS user_public_keys;
@@ -0,0 +1,34 @@
+-- End-to-end encryption key directory. A user has at most one active key,
+-- publishing a new one revokes the previous; revoked keys stay so old
+-- messages can still be decrypted.
+CREATE TABLE IF NOT EXISTS user_public_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    algorithm VARCHAR(64) NOT NULL,
+    public_key TEXT NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_user_public_keys_user_id ON user_public_keys (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_public_keys_active ON user_public_keys (user_id) WHERE revoked_at IS NULL;
+
+-- Encrypted messages keep the ciphertext in message and the cipher
+-- parameters in encryption
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS kind VARCHAR(16) NOT NULL DEFAULT 'text';
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS encryption JSONB;
+
+-- The content key of an encrypted message wrapped for each recipient.
+-- disclosed_by and dispute_id are set on keys a participant re-wrapped for
+-- the arbiter of a dispute.
+CREATE TABLE IF NOT EXISTS chat_message_keys (
+    message_id INT NOT NULL REFERENCES chat_messages(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key_id INT NOT NULL REFERENCES user_public_keys(id) ON DELETE CASCADE,
+    wrapped_key TEXT NOT NULL,
+    disclosed_by INT REFERENCES users(id) ON DELETE SET NULL,
+    dispute_id INT REFERENCES disputes(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_chat_message_keys_dispute_id ON chat_message_keys (dispute_id) WHERE dispute_id IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_chat_messages_room_last;
+DROP INDEX IF EXISTS idx_chat_messages_search;
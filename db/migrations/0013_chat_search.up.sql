@@ -0,0 +1,5 @@
+-- Full-text search over plain chat messages. The 'simple' configuration
+-- doesn't stem, so it works the same for every language.
+CREATE INDEX IF NOT EXISTS idx_chat_messages_search ON chat_messages USING GIN (to_tsvector('simple', message)) WHERE deleted_at IS NULL AND kind = 'text';
+-- Last message of a room for the room list
+CREATE INDEX IF NOT EXISTS idx_chat_messages_room_last ON chat_messages (chat_room_id, id DESC);
@@ -0,0 +1,11 @@
+DELETE FROM permissions WHERE name = 'canned_response.manage';
+DROP TABLE IF EXISTS ticket_canned_responses;
+DROP TABLE IF EXISTS ticket_links;
+DROP TABLE IF EXISTS ticket_notes;
+DROP INDEX IF EXISTS idx_tickets_queue;
+ALTER TABLE tickets DROP COLUMN IF EXISTS resolved_at;
+ALTER TABLE tickets DROP COLUMN IF EXISTS resolution_due_at;
+ALTER TABLE tickets DROP COLUMN IF EXISTS first_response_at;
+ALTER TABLE tickets DROP COLUMN IF EXISTS first_response_due_at;
+ALTER TABLE tickets DROP COLUMN IF EXISTS category;
+ALTER TABLE tickets DROP COLUMN IF EXISTS priority;
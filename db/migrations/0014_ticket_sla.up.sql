@@ -0,0 +1,51 @@
+-- Ticket triage: priority, category and SLA timers. The due dates follow
+-- the priority, first_response_at is set by the first reply of the
+-- assigned admin and resolved_at when the ticket is closed.
+ALTER TABLE tickets ADD COLUMN IF NOT EXISTS priority VARCHAR(10) NOT NULL DEFAULT 'normal';
+ALTER TABLE tickets ADD COLUMN IF NOT EXISTS category VARCHAR(20) NOT NULL DEFAULT 'other';
+ALTER TABLE tickets ADD COLUMN IF NOT EXISTS first_response_due_at TIMESTAMP;
+ALTER TABLE tickets ADD COLUMN IF NOT EXISTS first_response_at TIMESTAMP;
+ALTER TABLE tickets ADD COLUMN IF NOT EXISTS resolution_due_at TIMESTAMP;
+ALTER TABLE tickets ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_tickets_queue ON tickets (created_at) WHERE admin_id IS NULL AND status <> 'closed';
+
+-- Admin-only notes, never shown to the ticket's users
+CREATE TABLE IF NOT EXISTS ticket_notes (
+    id SERIAL PRIMARY KEY,
+    ticket_id INT NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
+    author_id INT REFERENCES users(id) ON DELETE SET NULL,
+    note TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_ticket_notes_ticket_id ON ticket_notes (ticket_id);
+
+-- Tasks, disputes and transactions a ticket is about
+CREATE TABLE IF NOT EXISTS ticket_links (
+    ticket_id INT NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
+    target_type VARCHAR(20) NOT NULL,
+    target_id INT NOT NULL,
+    created_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (ticket_id, target_type, target_id)
+);
+CREATE INDEX IF NOT EXISTS idx_ticket_links_target ON ticket_links (target_type, target_id);
+
+-- Canned responses for support replies
+CREATE TABLE IF NOT EXISTS ticket_canned_responses (
+    id SERIAL PRIMARY KEY,
+    title VARCHAR(100) NOT NULL,
+    body TEXT NOT NULL,
+    category VARCHAR(20),
+    created_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO permissions (name, description) VALUES
+    ('canned_response.manage', 'Manage canned responses for support tickets')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'superadmin'
+ON CONFLICT DO NOTHING;
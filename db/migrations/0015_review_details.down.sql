@@ -0,0 +1,13 @@
+DELETE FROM permissions WHERE name = 'review.moderate';
+DROP INDEX IF EXISTS idx_reviews_unpublished;
+DROP INDEX IF EXISTS idx_reviews_reviewed_id;
+DROP INDEX IF EXISTS idx_reviews_task_reviewer;
+ALTER TABLE reviews DROP COLUMN IF EXISTS hidden_reason;
+ALTER TABLE reviews DROP COLUMN IF EXISTS hidden_by;
+ALTER TABLE reviews DROP COLUMN IF EXISTS hidden_at;
+ALTER TABLE reviews DROP COLUMN IF EXISTS replied_at;
+ALTER TABLE reviews DROP COLUMN IF EXISTS reply;
+ALTER TABLE reviews DROP COLUMN IF EXISTS published_at;
+ALTER TABLE reviews DROP COLUMN IF EXISTS timeliness_rating;
+ALTER TABLE reviews DROP COLUMN IF EXISTS quality_rating;
+ALTER TABLE reviews DROP COLUMN IF EXISTS communication_rating;
@@ -0,0 +1,45 @@
+-- Category sub-ratings. Reviews written before they existed keep NULL and
+-- only count towards the overall rating.
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS communication_rating INT CHECK (communication_rating >= 1 AND communication_rating <= 5);
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS quality_rating INT CHECK (quality_rating >= 1 AND quality_rating <= 5);
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS timeliness_rating INT CHECK (timeliness_rating >= 1 AND timeliness_rating <= 5);
+
+-- Double-blind: a review stays unpublished until the other party reviews
+-- too or the blind window runs out
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS published_at TIMESTAMP;
+UPDATE reviews SET published_at = created_at WHERE published_at IS NULL;
+
+-- One public reply from the reviewed user
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS reply TEXT;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS replied_at TIMESTAMP;
+
+-- Reviews hidden by an arbiter, e.g. after a dispute was resolved against
+-- the reviewer
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS hidden_at TIMESTAMP;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS hidden_by INT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS hidden_reason TEXT NOT NULL DEFAULT '';
+
+-- Only the first review per task and reviewer survives, later ones came
+-- from double submits racing the existence check
+DELETE FROM reviews a USING reviews b
+WHERE a.task_id = b.task_id AND a.reviewer_id = b.reviewer_id AND a.id > b.id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reviews_task_reviewer ON reviews (task_id, reviewer_id);
+CREATE INDEX IF NOT EXISTS idx_reviews_reviewed_id ON reviews (reviewed_id) WHERE published_at IS NOT NULL AND hidden_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_reviews_unpublished ON reviews (created_at) WHERE published_at IS NULL;
+
+-- arbiter gets the new permission once, when it is created
+WITH p AS (
+    INSERT INTO permissions (name, description) VALUES
+        ('review.moderate', 'Hide reviews tied to lost disputes')
+    ON CONFLICT (name) DO NOTHING
+    RETURNING name
+)
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name FROM roles r CROSS JOIN p
+WHERE r.name = 'arbiter'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'superadmin'
+ON CONFLICT DO NOTHING;
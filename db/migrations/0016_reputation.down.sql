@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS reputation_history;
+DROP TABLE IF EXISTS reputation_scores;
+ALTER TABLE tasks DROP COLUMN IF EXISTS completed_at;
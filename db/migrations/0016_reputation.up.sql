@@ -0,0 +1,33 @@
+-- When a task was completed, for on-time delivery against the deadline.
+-- Tasks completed before this column existed stay NULL.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP;
+
+-- Current reputation score per user and the components it was built from.
+-- Rates are smoothed towards a prior so a handful of contracts can't max
+-- them out.
+CREATE TABLE IF NOT EXISTS reputation_scores (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    score NUMERIC(6,2) NOT NULL DEFAULT 0,
+    rating NUMERIC(4,3) NOT NULL DEFAULT 0,
+    review_count INT NOT NULL DEFAULT 0,
+    contracts INT NOT NULL DEFAULT 0,
+    completion_rate NUMERIC(5,4) NOT NULL DEFAULT 0,
+    dispute_loss_rate NUMERIC(5,4) NOT NULL DEFAULT 0,
+    on_time_rate NUMERIC(5,4) NOT NULL DEFAULT 0,
+    earnings_score NUMERIC(5,4) NOT NULL DEFAULT 0,
+    account_age_days INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_reputation_scores_score ON reputation_scores (score DESC);
+CREATE INDEX IF NOT EXISTS idx_reputation_scores_updated_at ON reputation_scores (updated_at);
+
+-- Every change of a score with the event that caused it
+CREATE TABLE IF NOT EXISTS reputation_history (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    score NUMERIC(6,2) NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    components JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_reputation_history_user_id ON reputation_history (user_id, created_at);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS task_history_consents;
+DROP TABLE IF EXISTS portfolio_item_files;
+DROP TABLE IF EXISTS portfolio_items;
+ALTER TABLE profiles DROP COLUMN IF EXISTS hourly_currency;
+ALTER TABLE profiles DROP COLUMN IF EXISTS hourly_rate;
@@ -0,0 +1,31 @@
+-- Hourly rate freelancers advertise on their profile
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS hourly_rate NUMERIC(20,8);
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS hourly_currency VARCHAR(10) NOT NULL DEFAULT 'BTC';
+
+-- Portfolio entries shown on public profiles. Images are stored files.
+CREATE TABLE IF NOT EXISTS portfolio_items (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    title VARCHAR(200) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    links JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_portfolio_items_user_id ON portfolio_items (user_id);
+
+CREATE TABLE IF NOT EXISTS portfolio_item_files (
+    item_id INT NOT NULL REFERENCES portfolio_items(id) ON DELETE CASCADE,
+    file_id INT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    position INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (item_id, file_id)
+);
+CREATE INDEX IF NOT EXISTS idx_portfolio_item_files_file_id ON portfolio_item_files (file_id);
+
+-- Completed tasks the client agreed to show in the freelancer's public work
+-- history
+CREATE TABLE IF NOT EXISTS task_history_consents (
+    task_id INT PRIMARY KEY REFERENCES tasks(id) ON DELETE CASCADE,
+    client_id INT REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS avatar_files;
+ALTER TABLE profiles DROP COLUMN IF EXISTS avatar_url;
@@ -0,0 +1,11 @@
+-- Avatars are processed into square thumbnails stored as files and served
+-- from avatar_url. profiles.avatar only keeps legacy base64 values until
+-- they are converted at startup.
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS avatar_url TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS avatar_files (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    size INT NOT NULL,
+    file_id INT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, size)
+);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS contract_weeks;
+DROP TABLE IF EXISTS time_entries;
+ALTER TABLE task_offers DROP COLUMN IF EXISTS weekly_cap;
+ALTER TABLE task_offers DROP COLUMN IF EXISTS hourly_rate;
+ALTER TABLE tasks DROP COLUMN IF EXISTS ended_at;
+ALTER TABLE tasks DROP COLUMN IF EXISTS contract_type;
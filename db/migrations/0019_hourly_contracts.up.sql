@@ -0,0 +1,34 @@
+-- Hourly contracts. The accepted offer carries the rate and a weekly cap in
+-- hours; its price is one week at the cap, which the client prefunds into
+-- escrow on acceptance and tops up after every settled week.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS contract_type VARCHAR(10) NOT NULL DEFAULT 'fixed';
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS ended_at TIMESTAMP;
+ALTER TABLE task_offers ADD COLUMN IF NOT EXISTS hourly_rate NUMERIC(30,12);
+ALTER TABLE task_offers ADD COLUMN IF NOT EXISTS weekly_cap NUMERIC(6,2);
+
+CREATE TABLE IF NOT EXISTS time_entries (
+    id SERIAL PRIMARY KEY,
+    task_id INT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    freelancer_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    work_date DATE NOT NULL,
+    minutes INT NOT NULL CHECK (minutes > 0 AND minutes <= 1440),
+    description TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_time_entries_task_date ON time_entries (task_id, work_date);
+
+-- Weeks (Monday to Sunday) of hourly contracts, created once a week is over
+CREATE TABLE IF NOT EXISTS contract_weeks (
+    task_id INT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    week_start DATE NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending', -- pending, approved, disputed, settled
+    minutes INT NOT NULL DEFAULT 0,
+    amount NUMERIC(30,12) NOT NULL DEFAULT 0,
+    submitted_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    reviewed_at TIMESTAMP,
+    dispute_reason TEXT NOT NULL DEFAULT '',
+    settled_at TIMESTAMP,
+    PRIMARY KEY (task_id, week_start)
+);
+CREATE INDEX IF NOT EXISTS idx_contract_weeks_status ON contract_weeks (status);
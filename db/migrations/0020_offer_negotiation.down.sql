@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS offer_revisions;
+ALTER TABLE task_offers ADD COLUMN IF NOT EXISTS accepted BOOLEAN DEFAULT FALSE;
+UPDATE task_offers SET accepted = (status = 'accepted');
+DROP INDEX IF EXISTS idx_task_offers_expires_at;
+DROP INDEX IF EXISTS idx_task_offers_accepted;
+ALTER TABLE task_offers DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE task_offers DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE task_offers DROP COLUMN IF EXISTS status;
@@ -0,0 +1,41 @@
+-- Offers get a status instead of the accepted flag: pending (the
+-- freelancer's terms wait for the client), countered (the client proposed
+-- other terms), accepted, rejected, withdrawn or expired
+ALTER TABLE task_offers ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'pending';
+ALTER TABLE task_offers ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+ALTER TABLE task_offers ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+-- The flag didn't tell rejected offers from pending ones; offers on tasks
+-- that are no longer open weren't taken
+UPDATE task_offers o SET status = CASE
+    WHEN o.accepted THEN 'accepted'
+    WHEN t.status != 'open' THEN 'rejected'
+    ELSE 'pending'
+END, updated_at = COALESCE(o.created_at, NOW())
+FROM tasks t WHERE t.id = o.task_id;
+
+ALTER TABLE task_offers DROP COLUMN IF EXISTS accepted;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_task_offers_accepted ON task_offers (task_id) WHERE status = 'accepted';
+CREATE INDEX IF NOT EXISTS idx_task_offers_expires_at ON task_offers (expires_at) WHERE status IN ('pending', 'countered');
+
+-- Every set of terms put on the table, by the freelancer (offer) or the
+-- client (counter)
+CREATE TABLE IF NOT EXISTS offer_revisions (
+    id SERIAL PRIMARY KEY,
+    offer_id INT NOT NULL REFERENCES task_offers(id) ON DELETE CASCADE,
+    author_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(10) NOT NULL, -- offer, counter
+    price NUMERIC(20,8) NOT NULL,
+    hourly_rate NUMERIC(30,12),
+    weekly_cap NUMERIC(6,2),
+    message TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_offer_revisions_offer_id ON offer_revisions (offer_id, created_at);
+
+-- Existing offers start their history with their current terms
+INSERT INTO offer_revisions (offer_id, author_id, kind, price, hourly_rate, weekly_cap, message, created_at)
+SELECT id, freelancer_id, 'offer', COALESCE(price, 0), hourly_rate, weekly_cap, COALESCE(message, ''), COALESCE(created_at, NOW())
+FROM task_offers o
+WHERE freelancer_id IS NOT NULL
+    AND NOT EXISTS (SELECT 1 FROM offer_revisions r WHERE r.offer_id = o.id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS task_invites;
+DROP INDEX IF EXISTS idx_tasks_open_public;
+ALTER TABLE tasks DROP COLUMN IF EXISTS visibility;
@@ -0,0 +1,14 @@
+-- Task visibility. Public tasks are listed, unlisted tasks are only reachable
+-- by their link and invite-only tasks can only be seen and bid on by the
+-- freelancers the client invited.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS visibility VARCHAR(10) NOT NULL DEFAULT 'public';
+CREATE INDEX IF NOT EXISTS idx_tasks_open_public ON tasks (created_at DESC) WHERE status = 'open' AND visibility = 'public';
+
+CREATE TABLE IF NOT EXISTS task_invites (
+    task_id INT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    freelancer_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (task_id, freelancer_id)
+);
+CREATE INDEX IF NOT EXISTS idx_task_invites_freelancer ON task_invites (freelancer_id, created_at DESC);
@@ -0,0 +1,4 @@
+ALTER TABLE tasks DROP COLUMN IF EXISTS schedule_id;
+ALTER TABLE tasks DROP COLUMN IF EXISTS template_id;
+DROP TABLE IF EXISTS task_schedules;
+DROP TABLE IF EXISTS task_templates;
@@ -0,0 +1,41 @@
+-- Task templates let clients post the same job again without re-entering
+-- it; schedules post a template on a cron expression.
+CREATE TABLE IF NOT EXISTS task_templates (
+    id SERIAL PRIMARY KEY,
+    client_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    category VARCHAR(100) NOT NULL DEFAULT '',
+    budget NUMERIC(20,8) NOT NULL DEFAULT 0,
+    currency VARCHAR(10) NOT NULL,
+    contract_type VARCHAR(10) NOT NULL DEFAULT 'fixed',
+    visibility VARCHAR(10) NOT NULL DEFAULT 'public',
+    -- deadline of created tasks in days after creation, 0 for none
+    deadline_days INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_task_templates_client ON task_templates (client_id);
+
+CREATE TABLE IF NOT EXISTS task_schedules (
+    id SERIAL PRIMARY KEY,
+    template_id INT NOT NULL REFERENCES task_templates(id) ON DELETE CASCADE,
+    client_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    cron VARCHAR(100) NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    -- offer the task to the freelancer of the previous one
+    rehire BOOLEAN NOT NULL DEFAULT FALSE,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    next_run_at TIMESTAMP,
+    last_run_at TIMESTAMP,
+    last_task_id INT REFERENCES tasks(id) ON DELETE SET NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_task_schedules_client ON task_schedules (client_id);
+CREATE INDEX IF NOT EXISTS idx_task_schedules_due ON task_schedules (next_run_at) WHERE active;
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS template_id INT REFERENCES task_templates(id) ON DELETE SET NULL;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS schedule_id INT REFERENCES task_schedules(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_tasks_schedule ON tasks (schedule_id, created_at DESC) WHERE schedule_id IS NOT NULL;
@@ -0,0 +1,7 @@
+ALTER TABLE task_offers DROP COLUMN IF EXISTS org_id;
+ALTER TABLE tasks DROP COLUMN IF EXISTS org_id;
+DROP TABLE IF EXISTS organization_wallet_entries;
+DROP TABLE IF EXISTS organization_wallets;
+DROP TABLE IF EXISTS organization_spending_limits;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,56 @@
+-- Organizations let agencies and companies share a wallet. Tasks posted on
+-- behalf of an organization are paid from its wallet, offers an agency
+-- makes for one of its members are paid out to it.
+CREATE TABLE IF NOT EXISTS organizations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    org_id INT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(10) NOT NULL DEFAULT 'member', -- owner, manager, member
+    invited_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    -- NULL until the user accepts the invitation
+    joined_at TIMESTAMP,
+    PRIMARY KEY (org_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_organization_members_user ON organization_members (user_id);
+
+-- Most a member can move into escrow from the organization wallet per
+-- calendar month; members without a limit are not limited
+CREATE TABLE IF NOT EXISTS organization_spending_limits (
+    org_id INT NOT NULL,
+    user_id INT NOT NULL,
+    currency VARCHAR(10) NOT NULL,
+    monthly_limit NUMERIC(30,12) NOT NULL CHECK (monthly_limit >= 0),
+    PRIMARY KEY (org_id, user_id, currency),
+    FOREIGN KEY (org_id, user_id) REFERENCES organization_members(org_id, user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS organization_wallets (
+    org_id INT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    currency VARCHAR(10) NOT NULL,
+    balance NUMERIC(30,12) NOT NULL DEFAULT 0 CHECK (balance >= 0),
+    PRIMARY KEY (org_id, currency)
+);
+
+-- Every change of an organization wallet balance
+CREATE TABLE IF NOT EXISTS organization_wallet_entries (
+    id SERIAL PRIMARY KEY,
+    org_id INT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    currency VARCHAR(10) NOT NULL,
+    kind VARCHAR(16) NOT NULL, -- deposit, withdrawal, escrow, refund, payout
+    amount NUMERIC(30,12) NOT NULL, -- negative when leaving the wallet
+    user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    task_id INT REFERENCES tasks(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_organization_wallet_entries_org ON organization_wallet_entries (org_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_organization_wallet_entries_spending ON organization_wallet_entries (org_id, user_id, currency, created_at) WHERE kind = 'escrow';
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS org_id INT REFERENCES organizations(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_tasks_org ON tasks (org_id, created_at DESC) WHERE org_id IS NOT NULL;
+ALTER TABLE task_offers ADD COLUMN IF NOT EXISTS org_id INT REFERENCES organizations(id) ON DELETE SET NULL;
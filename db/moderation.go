@@ -0,0 +1,154 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"mFrelance/models"
+)
+
+// Report targets, categories and states
+const (
+	ReportTargetMessage = "message"
+	ReportTargetUser    = "user"
+	ReportTargetTask    = "task"
+
+	ReportOpen      = "open"
+	ReportDismissed = "dismissed"
+	ReportActioned  = "actioned"
+)
+
+// ReportCategories lists the categories a report can have
+var ReportCategories = []string{"spam", "scam", "harassment", "inappropriate", "other"}
+
+// AddUserBlock adds blockedID to the block list of blockerID
+func AddUserBlock(db *sqlx.DB, blockerID, blockedID int64) error {
+	_, err := db.Exec(`
+		INSERT INTO user_blocks (blocker_id, blocked_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, blockerID, blockedID)
+	return err
+}
+
+// RemoveUserBlock reports whether there was a block to remove
+func RemoveUserBlock(db *sqlx.DB, blockerID, blockedID int64) (bool, error) {
+	res, err := db.Exec(`DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2`, blockerID, blockedID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ListUserBlocks returns the block list of a user, newest first
+func ListUserBlocks(db *sqlx.DB, blockerID int64) ([]models.UserBlock, error) {
+	list := []models.UserBlock{}
+	err := db.Select(&list, `
+		SELECT b.blocker_id, b.blocked_id, u.username, b.created_at
+		FROM user_blocks b JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = $1
+		ORDER BY b.created_at DESC
+	`, blockerID)
+	return list, err
+}
+
+// IsBlockedBetween reports whether userID and any of others blocked one
+// another, in either direction
+func IsBlockedBetween(db *sqlx.DB, userID int64, others ...int64) (bool, error) {
+	var blocked bool
+	err := db.Get(&blocked, `
+		SELECT EXISTS (
+			SELECT 1 FROM user_blocks
+			WHERE (blocker_id = $1 AND blocked_id = ANY($2::int[]))
+			   OR (blocked_id = $1 AND blocker_id = ANY($2::int[]))
+		)
+	`, userID, pq.Array(others))
+	return blocked, err
+}
+
+const reportColumns = `r.*, (
+	SELECT COUNT(*) FROM reports o
+	WHERE o.target_type = r.target_type AND o.target_id = r.target_id AND o.status = 'open'
+) AS target_reports`
+
+// CreateReport stores a new open report and sets its ID
+func CreateReport(db *sqlx.DB, r *models.Report) error {
+	return db.QueryRow(`
+		INSERT INTO reports (reporter_id, target_type, target_id, target_user_id, category, details, snapshot)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, status, created_at
+	`, r.ReporterID, r.TargetType, r.TargetID, r.TargetUserID, r.Category, r.Details, r.Snapshot).
+		Scan(&r.ID, &r.Status, &r.CreatedAt)
+}
+
+func GetReport(db *sqlx.DB, id int64) (*models.Report, error) {
+	var r models.Report
+	if err := db.Get(&r, `SELECT `+reportColumns+` FROM reports r WHERE r.id = $1`, id); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListReportsByReporter returns the reports a user filed, newest first.
+// Snapshots are left out.
+func ListReportsByReporter(db *sqlx.DB, reporterID int64, limit, offset int) ([]models.Report, error) {
+	list := []models.Report{}
+	err := db.Select(&list, `
+		SELECT r.*, 0 AS target_reports FROM reports r
+		WHERE r.reporter_id = $1
+		ORDER BY r.id DESC LIMIT $2 OFFSET $3
+	`, reporterID, limit, offset)
+	for i := range list {
+		list[i].Snapshot = ""
+	}
+	return list, err
+}
+
+// ListReports is the moderation queue. Open reports come oldest first,
+// everything else newest first.
+func ListReports(db *sqlx.DB, f models.ReportFilter) ([]models.Report, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	if f.Status != "" {
+		add("r.status = ?", f.Status)
+	}
+	if f.TargetType != "" {
+		add("r.target_type = ?", f.TargetType)
+	}
+
+	query := `SELECT ` + reportColumns + ` FROM reports r`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	order := " ORDER BY r.id DESC"
+	if f.Status == ReportOpen {
+		order = " ORDER BY r.id"
+	}
+	args = append(args, f.Limit, f.Offset)
+	query += order + " LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	list := []models.Report{}
+	err := db.Select(&list, query, args...)
+	return list, err
+}
+
+// ResolveReportsTx closes every open report on the target of report id
+// with the same outcome and returns the IDs of the closed reports
+func ResolveReportsTx(tx *sqlx.Tx, id int64, status, action, note string, resolvedBy int64) ([]int64, error) {
+	ids := []int64{}
+	err := tx.Select(&ids, `
+		UPDATE reports o
+		SET status = $2, action = $3, resolution_note = $4, resolved_by = $5, resolved_at = NOW()
+		FROM reports r
+		WHERE r.id = $1 AND o.target_type = r.target_type AND o.target_id = r.target_id AND o.status = 'open'
+		RETURNING o.id
+	`, id, status, action, note, resolvedBy)
+	return ids, err
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"mFrelance/models"
+)
+
+// CreateNotification stores n in the inbox. data is marshalled to JSON.
+func CreateNotification(db *sqlx.DB, n *models.Notification, data interface{}) error {
+	d, err := jsonParam(data)
+	if err != nil {
+		return err
+	}
+	return db.QueryRow(`
+		INSERT INTO notifications (user_id, type, title, body, data)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, data, created_at
+	`, n.UserID, n.Type, n.Title, n.Body, d).Scan(&n.ID, &n.Data, &n.CreatedAt)
+}
+
+// ListNotifications returns the user's notifications, newest first
+func ListNotifications(db *sqlx.DB, userID int64, unreadOnly bool, limit, offset int) ([]models.Notification, error) {
+	query := `SELECT * FROM notifications WHERE user_id = $1`
+	if unreadOnly {
+		query += ` AND read_at IS NULL`
+	}
+	query += ` ORDER BY id DESC LIMIT $2 OFFSET $3`
+	list := []models.Notification{}
+	err := db.Select(&list, query, userID, limit, offset)
+	return list, err
+}
+
+func CountUnreadNotifications(db *sqlx.DB, userID int64) (int, error) {
+	var n int
+	err := db.Get(&n, `SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`, userID)
+	return n, err
+}
+
+// MarkNotificationsRead marks the given notifications of the user as read,
+// or all of them when ids is empty. It returns how many were updated.
+func MarkNotificationsRead(db *sqlx.DB, userID int64, ids []int64) (int64, error) {
+	var res sql.Result
+	var err error
+	if len(ids) == 0 {
+		res, err = db.Exec(`UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL`, userID)
+	} else {
+		res, err = db.Exec(`UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL AND id = ANY($2)`, userID, pq.Array(ids))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetNotificationPreferences returns the stored preferences by event type.
+// Types without a row are enabled.
+func GetNotificationPreferences(db *sqlx.DB, userID int64) (map[string]models.NotificationPreference, error) {
+	var rows []models.NotificationPreference
+	if err := db.Select(&rows, `SELECT type, in_app, email FROM notification_preferences WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	prefs := make(map[string]models.NotificationPreference, len(rows))
+	for _, p := range rows {
+		prefs[p.Type] = p
+	}
+	return prefs, nil
+}
+
+// SetNotificationPreference changes the channels of one event type. A nil
+// channel keeps its current setting.
+func SetNotificationPreference(db *sqlx.DB, userID int64, typ string, inApp, email *bool) error {
+	_, err := db.Exec(`
+		INSERT INTO notification_preferences AS p (user_id, type, in_app, email)
+		VALUES ($1, $2, COALESCE($3, TRUE), COALESCE($4, TRUE))
+		ON CONFLICT (user_id, type) DO UPDATE
+		SET in_app = COALESCE($3, p.in_app), email = COALESCE($4, p.email)
+	`, userID, typ, inApp, email)
+	return err
+}
+
+// GetNotificationPreference returns the preference for one event type,
+// enabled when nothing is stored
+func GetNotificationPreference(db *sqlx.DB, userID int64, typ string) (models.NotificationPreference, error) {
+	p := models.NotificationPreference{Type: typ, InApp: true, Email: true}
+	err := db.Get(&p, `SELECT type, in_app, email FROM notification_preferences WHERE user_id = $1 AND type = $2`, userID, typ)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, nil
+	}
+	return p, err
+}
@@ -0,0 +1,331 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"math/big"
+
+	"github.com/jmoiron/sqlx"
+
+	"mFrelance/models"
+)
+
+// ErrInsufficientBalance is returned by TransferOrgFunds when the source
+// wallet doesn't cover the amount
+var ErrInsufficientBalance = models.ErrInsufficientBalance
+
+var (
+	// ErrNotOrgMember is returned by CheckOrgSpendingTx when the user is not
+	// an active member of the organization
+	ErrNotOrgMember = errors.New("not an organization member")
+	// ErrSpendingLimit is returned by CheckOrgSpendingTx when the amount
+	// doesn't fit in the member's monthly limit
+	ErrSpendingLimit = errors.New("spending limit exceeded")
+)
+
+// CreateOrganization creates an organization owned by ownerID with an empty
+// wallet in each of currencies
+func CreateOrganization(db *sqlx.DB, org *models.Organization, ownerID int64, currencies []string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`INSERT INTO organizations (name) VALUES ($1) RETURNING id, created_at`, org.Name).Scan(&org.ID, &org.CreatedAt)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO organization_members (org_id, user_id, role, joined_at)
+		VALUES ($1, $2, 'owner', NOW())
+	`, org.ID, ownerID)
+	if err != nil {
+		return err
+	}
+	for _, currency := range currencies {
+		if _, err := tx.Exec(`INSERT INTO organization_wallets (org_id, currency) VALUES ($1, $2)`, org.ID, currency); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func GetOrganization(db *sqlx.DB, id int64) (*models.Organization, error) {
+	var org models.Organization
+	err := db.Get(&org, `SELECT * FROM organizations WHERE id = $1`, id)
+	return &org, err
+}
+
+func OrganizationNameExists(db *sqlx.DB, name string) (bool, error) {
+	var exists bool
+	err := db.Get(&exists, `SELECT EXISTS (SELECT 1 FROM organizations WHERE LOWER(name) = LOWER($1))`, name)
+	return exists, err
+}
+
+// ListUserOrganizations returns the organizations a user belongs to or is
+// invited to
+func ListUserOrganizations(db *sqlx.DB, userID int64) ([]models.UserOrganization, error) {
+	orgs := []models.UserOrganization{}
+	err := db.Select(&orgs, `
+		SELECT o.*, m.role, m.joined_at FROM organization_members m
+		JOIN organizations o ON o.id = m.org_id
+		WHERE m.user_id = $1
+		ORDER BY o.name
+	`, userID)
+	return orgs, err
+}
+
+// GetOrgMember returns sql.ErrNoRows when the user is neither a member nor
+// invited
+func GetOrgMember(db *sqlx.DB, orgID, userID int64) (*models.OrgMember, error) {
+	var m models.OrgMember
+	err := db.Get(&m, `
+		SELECT m.*, u.username FROM organization_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = $1 AND m.user_id = $2
+	`, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ListOrgMembers returns the members of an organization, invited ones
+// included, owners first
+func ListOrgMembers(db *sqlx.DB, orgID int64) ([]models.OrgMember, error) {
+	members := []models.OrgMember{}
+	err := db.Select(&members, `
+		SELECT m.*, u.username FROM organization_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = $1
+		ORDER BY CASE m.role WHEN 'owner' THEN 0 WHEN 'manager' THEN 1 ELSE 2 END, m.created_at
+	`, orgID)
+	return members, err
+}
+
+// InviteOrgMember invites a user to an organization. Returns false when the
+// user is a member or invited already.
+func InviteOrgMember(db *sqlx.DB, m *models.OrgMember) (bool, error) {
+	err := db.QueryRow(`
+		INSERT INTO organization_members (org_id, user_id, role, invited_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (org_id, user_id) DO NOTHING
+		RETURNING created_at
+	`, m.OrgID, m.UserID, m.Role, m.InvitedBy).Scan(&m.CreatedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// JoinOrganization accepts an invitation. Returns sql.ErrNoRows when the
+// user has none pending.
+func JoinOrganization(db *sqlx.DB, orgID, userID int64) error {
+	res, err := db.Exec(`
+		UPDATE organization_members SET joined_at = NOW()
+		WHERE org_id = $1 AND user_id = $2 AND joined_at IS NULL
+	`, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RemoveOrgMember removes a member or declines an invitation, along with
+// the member's spending limits. Returns sql.ErrNoRows when there was none.
+func RemoveOrgMember(db *sqlx.DB, orgID, userID int64) error {
+	res, err := db.Exec(`DELETE FROM organization_members WHERE org_id = $1 AND user_id = $2`, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetOrgMemberRole returns sql.ErrNoRows when the user is not a member
+func SetOrgMemberRole(db *sqlx.DB, orgID, userID int64, role string) error {
+	res, err := db.Exec(`UPDATE organization_members SET role = $1 WHERE org_id = $2 AND user_id = $3`, role, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func CountOrgOwners(db *sqlx.DB, orgID int64) (int, error) {
+	var n int
+	err := db.Get(&n, `SELECT COUNT(*) FROM organization_members WHERE org_id = $1 AND role = 'owner' AND joined_at IS NOT NULL`, orgID)
+	return n, err
+}
+
+// SetOrgSpendingLimit sets a member's monthly limit in a currency, or lifts
+// it when limit is nil
+func SetOrgSpendingLimit(db *sqlx.DB, orgID, userID int64, currency string, limit *float64) error {
+	if limit == nil {
+		_, err := db.Exec(`
+			DELETE FROM organization_spending_limits WHERE org_id = $1 AND user_id = $2 AND currency = $3
+		`, orgID, userID, currency)
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO organization_spending_limits (org_id, user_id, currency, monthly_limit)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (org_id, user_id, currency) DO UPDATE SET monthly_limit = EXCLUDED.monthly_limit
+	`, orgID, userID, currency, *limit)
+	return err
+}
+
+// orgSpentThisMonth is what a member moved into escrow from the
+// organization wallet this calendar month
+const orgSpentThisMonth = `
+	COALESCE((
+		SELECT -SUM(e.amount) FROM organization_wallet_entries e
+		WHERE e.org_id = l.org_id AND e.user_id = l.user_id AND e.currency = l.currency
+			AND e.kind = 'escrow' AND e.created_at >= DATE_TRUNC('month', NOW())
+	), 0)`
+
+// CheckOrgSpendingTx checks that userID may move amount into escrow from the
+// organization wallet. The membership and the limit stay locked until tx
+// ends, so debit in the same tx. Returns ErrNotOrgMember, or
+// ErrSpendingLimit along with the limit.
+func CheckOrgSpendingTx(tx *sqlx.Tx, orgID, userID int64, currency string, amount float64) (*models.OrgSpendingLimit, error) {
+	var active bool
+	err := tx.Get(&active, `
+		SELECT joined_at IS NOT NULL FROM organization_members
+		WHERE org_id = $1 AND user_id = $2
+		FOR UPDATE
+	`, orgID, userID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !active {
+		return nil, ErrNotOrgMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l models.OrgSpendingLimit
+	err = tx.Get(&l, `
+		SELECT * FROM organization_spending_limits
+		WHERE org_id = $1 AND user_id = $2 AND currency = $3
+		FOR UPDATE
+	`, orgID, userID, currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// spent is read after the lock is taken, so it includes what the
+	// transaction that held it debited
+	err = tx.Get(&l.Spent, `SELECT `+orgSpentThisMonth+` FROM organization_spending_limits l WHERE l.org_id = $1 AND l.user_id = $2 AND l.currency = $3`, orgID, userID, currency)
+	if err != nil {
+		return nil, err
+	}
+	if l.Spent+amount > l.MonthlyLimit {
+		return &l, ErrSpendingLimit
+	}
+	return &l, nil
+}
+
+// ListOrgSpendingLimits returns the limits of an organization's members
+// with what they spent this month
+func ListOrgSpendingLimits(db *sqlx.DB, orgID int64) ([]models.OrgSpendingLimit, error) {
+	limits := []models.OrgSpendingLimit{}
+	err := db.Select(&limits, `
+		SELECT l.*, `+orgSpentThisMonth+` AS spent
+		FROM organization_spending_limits l
+		WHERE l.org_id = $1
+		ORDER BY l.user_id, l.currency
+	`, orgID)
+	return limits, err
+}
+
+func ListOrgWallets(db *sqlx.DB, orgID int64) ([]models.OrgWallet, error) {
+	wallets := []models.OrgWallet{}
+	err := db.Select(&wallets, `SELECT org_id, currency, balance FROM organization_wallets WHERE org_id = $1 ORDER BY currency`, orgID)
+	return wallets, err
+}
+
+// ListOrgWalletEntries returns a page of an organization's wallet entries,
+// newest first
+func ListOrgWalletEntries(db *sqlx.DB, orgID int64, limit, offset int) ([]models.OrgWalletEntry, error) {
+	entries := []models.OrgWalletEntry{}
+	err := db.Select(&entries, `
+		SELECT * FROM organization_wallet_entries
+		WHERE org_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`, orgID, limit, offset)
+	return entries, err
+}
+
+// TransferOrgFunds moves amount between a member's wallet and the
+// organization wallet: into it when deposit is set, out of it otherwise.
+// Returns ErrInsufficientBalance when the source wallet doesn't cover it.
+func TransferOrgFunds(db *sqlx.DB, orgID, userID int64, currency string, amount *big.Float, deposit bool) error {
+	wallet, err := models.GetWalletByUserAndCurrency(db, userID, currency)
+	if err != nil {
+		return err
+	}
+	orgWallet, err := models.GetOrgWallet(db, orgID, currency)
+	if err != nil {
+		return err
+	}
+	orgWallet.UserID = &userID
+	orgWallet.CreditKind = models.OrgEntryDeposit
+	orgWallet.DebitKind = models.OrgEntryWithdrawal
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if deposit {
+		if err := wallet.SubBalance(tx, amount); err != nil {
+			return err
+		}
+		if err := orgWallet.AddBalance(tx, amount); err != nil {
+			return err
+		}
+	} else {
+		if err := orgWallet.SubBalance(tx, amount); err != nil {
+			return err
+		}
+		if err := wallet.AddBalance(tx, amount); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListOrgTasks returns a page of the tasks posted for an organization,
+// newest first
+func ListOrgTasks(db *sqlx.DB, orgID int64, limit, offset int) ([]models.Task, error) {
+	tasks := []models.Task{}
+	err := db.Select(&tasks, `
+		SELECT * FROM tasks
+		WHERE org_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`, orgID, limit, offset)
+	return tasks, err
+}
+
+// ListOrgOffers returns a page of the offers an agency made, newest first
+func ListOrgOffers(db *sqlx.DB, orgID int64, limit, offset int) ([]models.TaskOffer, error) {
+	offers := []models.TaskOffer{}
+	err := db.Select(&offers, `
+		SELECT * FROM task_offers
+		WHERE org_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`, orgID, limit, offset)
+	return offers, err
+}
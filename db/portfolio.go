@@ -0,0 +1,272 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"mFrelance/models"
+)
+
+// ListPortfolioItems returns a user's portfolio with images, newest first
+func ListPortfolioItems(db *sqlx.DB, userID int64) ([]*models.PortfolioItem, error) {
+	items := []*models.PortfolioItem{}
+	err := db.Select(&items, `SELECT * FROM portfolio_items WHERE user_id = $1 ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return items, loadPortfolioImages(db, items)
+}
+
+func GetPortfolioItem(db *sqlx.DB, id int64) (*models.PortfolioItem, error) {
+	item := &models.PortfolioItem{}
+	if err := db.Get(item, `SELECT * FROM portfolio_items WHERE id = $1`, id); err != nil {
+		return nil, err
+	}
+	return item, loadPortfolioImages(db, []*models.PortfolioItem{item})
+}
+
+func CountPortfolioItems(db *sqlx.DB, userID int64) (int, error) {
+	var n int
+	err := db.Get(&n, `SELECT COUNT(*) FROM portfolio_items WHERE user_id = $1`, userID)
+	return n, err
+}
+
+// SavePortfolioItem creates the item, or updates it when ID is set, and
+// replaces its images with files in the given order. Returns sql.ErrNoRows
+// when the item doesn't belong to item.UserID.
+func SavePortfolioItem(db *sqlx.DB, item *models.PortfolioItem, fileIDs []int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if item.ID == 0 {
+		err = tx.QueryRow(`
+			INSERT INTO portfolio_items (user_id, title, description, links)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at, updated_at
+		`, item.UserID, item.Title, item.Description, item.Links).Scan(&item.ID, &item.CreatedAt, &item.UpdatedAt)
+	} else {
+		err = tx.QueryRow(`
+			UPDATE portfolio_items SET title = $3, description = $4, links = $5, updated_at = NOW()
+			WHERE id = $1 AND user_id = $2
+			RETURNING created_at, updated_at
+		`, item.ID, item.UserID, item.Title, item.Description, item.Links).Scan(&item.CreatedAt, &item.UpdatedAt)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM portfolio_item_files WHERE item_id = $1`, item.ID); err != nil {
+		return err
+	}
+	for i, id := range fileIDs {
+		if _, err := tx.Exec(`INSERT INTO portfolio_item_files (item_id, file_id, position) VALUES ($1, $2, $3)`, item.ID, id, i); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeletePortfolioItem removes one of the user's portfolio items. The image
+// files stay with their owner.
+func DeletePortfolioItem(db *sqlx.DB, id, userID int64) (bool, error) {
+	res, err := db.Exec(`DELETE FROM portfolio_items WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// loadPortfolioImages fills Images of the items
+func loadPortfolioImages(db *sqlx.DB, items []*models.PortfolioItem) error {
+	byID := make(map[int64]*models.PortfolioItem, len(items))
+	ids := make([]int64, 0, len(items))
+	for _, item := range items {
+		item.Images = []models.File{}
+		byID[item.ID] = item
+		ids = append(ids, item.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	rows := []struct {
+		ItemID int64 `db:"item_id"`
+		models.File
+	}{}
+	err := db.Select(&rows, `
+		SELECT pf.item_id, f.* FROM portfolio_item_files pf
+		JOIN files f ON f.id = pf.file_id
+		WHERE pf.item_id = ANY($1)
+		ORDER BY pf.item_id, pf.position
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		item := byID[r.ItemID]
+		item.Images = append(item.Images, r.File)
+	}
+	return nil
+}
+
+// SetTaskHistoryConsent records whether the client of a completed task
+// allows it to appear in the freelancer's public work history
+func SetTaskHistoryConsent(db *sqlx.DB, taskID, clientID int64, allow bool) error {
+	if !allow {
+		_, err := db.Exec(`DELETE FROM task_history_consents WHERE task_id = $1`, taskID)
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO task_history_consents (task_id, client_id) VALUES ($1, $2)
+		ON CONFLICT (task_id) DO NOTHING
+	`, taskID, clientID)
+	return err
+}
+
+func HasTaskHistoryConsent(db *sqlx.DB, taskID int64) (bool, error) {
+	var ok bool
+	err := db.Get(&ok, `SELECT EXISTS (SELECT 1 FROM task_history_consents WHERE task_id = $1)`, taskID)
+	return ok, err
+}
+
+// ListWorkHistory returns the completed contracts of a freelancer whose
+// clients agreed to show them, most recent first
+func ListWorkHistory(db *sqlx.DB, freelancerID int64, limit, offset int) ([]models.WorkHistoryItem, error) {
+	items := []models.WorkHistoryItem{}
+	err := db.Select(&items, `
+		SELECT t.id AS task_id, t.title, COALESCE(t.category, '') AS category, t.completed_at,
+		       r.rating, r.comment
+		FROM escrow_balances e
+		JOIN tasks t ON t.id = e.task_id AND t.status = 'completed'
+		JOIN task_history_consents c ON c.task_id = t.id
+		LEFT JOIN reviews r ON r.task_id = t.id AND r.reviewer_id = e.client_id
+		     AND r.reviewed_id = e.freelancer_id AND r.published_at IS NOT NULL AND r.hidden_at IS NULL
+		WHERE e.freelancer_id = $1 AND e.status = 'released'
+		ORDER BY t.completed_at DESC NULLS LAST, t.id DESC
+		LIMIT $2 OFFSET $3
+	`, freelancerID, limit, offset)
+	return items, err
+}
+
+// IsEmailVerified reports whether the user has a verified email address
+func IsEmailVerified(db *sqlx.DB, userID int64) (bool, error) {
+	var ok bool
+	err := db.Get(&ok, `SELECT EXISTS (SELECT 1 FROM user_emails WHERE user_id = $1 AND verified_at IS NOT NULL)`, userID)
+	return ok, err
+}
+
+// GetReputationReviewCount returns the number of reviews the user's current
+// reputation score was computed from
+func GetReputationReviewCount(db *sqlx.DB, userID int64) (int, error) {
+	var n int
+	err := db.Get(&n, `SELECT COALESCE((SELECT review_count FROM reputation_scores WHERE user_id = $1), 0)`, userID)
+	return n, err
+}
+
+// profileSortOrders maps directory sort names to ORDER BY clauses
+var profileSortOrders = map[string]string{
+	"reputation":      "reputation DESC, completed_tasks DESC, p.user_id",
+	"rating":          "rating DESC, review_count DESC, p.user_id",
+	"completed_tasks": "completed_tasks DESC, reputation DESC, p.user_id",
+	"hourly_rate":     "p.hourly_rate ASC NULLS LAST, reputation DESC, p.user_id",
+}
+
+// IsProfileSort reports whether sort is a known directory order
+func IsProfileSort(sort string) bool {
+	_, ok := profileSortOrders[sort]
+	return ok
+}
+
+// SearchProfiles lists the non-empty profiles of users that aren't blocked
+// matching the filter, and the total number of matches
+func SearchProfiles(db *sqlx.DB, f models.ProfileSearchFilter) ([]models.DirectoryProfile, int, error) {
+	args := []interface{}{}
+	where := []string{
+		"u.blocked = false",
+		"(COALESCE(p.full_name, '') != '' OR COALESCE(p.bio, '') != '')",
+	}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if f.Query != "" {
+		add("(p.full_name ILIKE ? OR p.bio ILIKE ? OR u.username ILIKE ?)", "%"+escapeLike(f.Query)+"%")
+	}
+	if len(f.Skills) > 0 {
+		// every requested skill, case-insensitively
+		add(`NOT EXISTS (
+			SELECT 1 FROM unnest(?::text[]) s
+			WHERE NOT EXISTS (
+				SELECT 1 FROM jsonb_array_elements_text(CASE WHEN jsonb_typeof(p.skills) = 'array' THEN p.skills ELSE '[]' END) ps
+				WHERE LOWER(ps) = LOWER(s)
+			)
+		)`, pq.Array(f.Skills))
+	}
+	if f.MinRating > 0 {
+		add("COALESCE(rv.rating, 0) >= ?", f.MinRating)
+	}
+	if f.MinCompleted > 0 {
+		add("COALESCE(p.completed_tasks, 0) >= ?", f.MinCompleted)
+	}
+	if f.HourlyCurrency != "" {
+		add("p.hourly_currency = ?", f.HourlyCurrency)
+	}
+	if f.MinHourlyRate > 0 {
+		add("p.hourly_rate >= ?", f.MinHourlyRate)
+	}
+	if f.MaxHourlyRate > 0 {
+		add("p.hourly_rate <= ?", f.MaxHourlyRate)
+	}
+
+	from := `
+		FROM profiles p
+		JOIN users u ON u.id = p.user_id
+		LEFT JOIN (
+			SELECT reviewed_id, AVG(rating) AS rating, COUNT(*) AS review_count
+			FROM reviews WHERE ` + reviewVisible + `
+			GROUP BY reviewed_id
+		) rv ON rv.reviewed_id = p.user_id
+		LEFT JOIN reputation_scores rs ON rs.user_id = p.user_id
+		WHERE ` + strings.Join(where, " AND ")
+
+	var total int
+	if err := db.Get(&total, `SELECT COUNT(*)`+from, args...); err != nil {
+		return nil, 0, err
+	}
+
+	order, ok := profileSortOrders[f.Sort]
+	if !ok {
+		order = profileSortOrders["reputation"]
+	}
+	args = append(args, f.Limit, f.Offset)
+	query := `
+		SELECT p.user_id, COALESCE(p.full_name, '') AS full_name, COALESCE(p.bio, '') AS bio,
+		       COALESCE(p.skills, '[]') AS skills, COALESCE(p.avatar, '') AS avatar, p.avatar_url,
+		       COALESCE(p.completed_tasks, 0) AS completed_tasks, p.hourly_rate, p.hourly_currency,
+		       u.username, u.is_admin, COALESCE(u.admin_title, '') AS admin_title,
+		       COALESCE((SELECT json_agg(ro.name ORDER BY ro.name) FROM user_roles ur JOIN roles ro ON ro.id = ur.role_id WHERE ur.user_id = u.id), '[]') AS roles,
+		       COALESCE(rv.rating, 0) AS rating,
+		       COALESCE(rs.score, 0) AS reputation,
+		       COALESCE(rs.review_count, 0) AS review_count,
+		       EXISTS (SELECT 1 FROM user_emails ue WHERE ue.user_id = p.user_id AND ue.verified_at IS NOT NULL) AS email_verified` +
+		from + `
+		ORDER BY ` + order +
+		" LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	profiles := []models.DirectoryProfile{}
+	if err := db.Select(&profiles, query, args...); err != nil {
+		return nil, 0, err
+	}
+	return profiles, total, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
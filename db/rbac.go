@@ -0,0 +1,427 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"mFrelance/models"
+)
+
+const RoleSuperadmin = "superadmin"
+
+// RoleAdmin is the role users.is_admin stands for. Making a user admin
+// grants it, removing admin revokes it.
+const RoleAdmin = "support"
+
+var (
+	ErrRoleNotFound    = errors.New("role not found")
+	ErrBuiltinRole     = errors.New("builtin role cannot be changed")
+	ErrUnknownPermName = errors.New("unknown permission")
+)
+
+func ListPermissions(db *sqlx.DB) ([]models.Permission, error) {
+	var perms []models.Permission
+	err := db.Select(&perms, `SELECT name, description FROM permissions ORDER BY name`)
+	return perms, err
+}
+
+func loadRolePermissions(db sqlx.Queryer, roles []models.Role) error {
+	for i := range roles {
+		roles[i].Permissions = []string{}
+		if err := sqlx.Select(db, &roles[i].Permissions,
+			`SELECT permission FROM role_permissions WHERE role_id=$1 ORDER BY permission`, roles[i].ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ListRoles(db *sqlx.DB) ([]models.Role, error) {
+	var roles []models.Role
+	if err := db.Select(&roles, `SELECT id, name, description, builtin, created_at FROM roles ORDER BY id`); err != nil {
+		return nil, err
+	}
+	if err := loadRolePermissions(db, roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func GetRoleByName(db *sqlx.DB, name string) (*models.Role, error) {
+	var role models.Role
+	err := db.Get(&role, `SELECT id, name, description, builtin, created_at FROM roles WHERE name=$1`, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	roles := []models.Role{role}
+	if err := loadRolePermissions(db, roles); err != nil {
+		return nil, err
+	}
+	return &roles[0], nil
+}
+
+// bumpPermVersionTx marks the cached permissions of the given users as stale
+func bumpPermVersionTx(tx *sqlx.Tx, userIDs []int64) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`UPDATE users SET perm_version = perm_version + 1 WHERE id = ANY($1)`, pq.Array(userIDs))
+	return err
+}
+
+// UpsertRole creates the role or replaces its description and permissions.
+// It returns the users holding the role so their cached permissions can be
+// dropped. audit may be nil.
+func UpsertRole(db *sqlx.DB, name, description string, permissions []string, audit *models.AuditEntry) ([]int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var roleID int64
+	var builtin bool
+	var before interface{}
+	err = tx.QueryRow(`SELECT id, builtin FROM roles WHERE name=$1 FOR UPDATE`, name).Scan(&roleID, &builtin)
+	if err == nil {
+		before, err = roleSnapshotTx(tx, roleID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		err = tx.QueryRow(`INSERT INTO roles (name, description) VALUES ($1, $2) RETURNING id`, name, description).Scan(&roleID)
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case name == RoleSuperadmin:
+		return nil, ErrBuiltinRole
+	default:
+		if _, err := tx.Exec(`UPDATE roles SET description=$1 WHERE id=$2`, description, roleID); err != nil {
+			return nil, err
+		}
+	}
+
+	var known int
+	if err := tx.Get(&known, `SELECT COUNT(*) FROM permissions WHERE name = ANY($1)`, pq.Array(permissions)); err != nil {
+		return nil, err
+	}
+	if known != len(uniqueStrings(permissions)) {
+		return nil, ErrUnknownPermName
+	}
+
+	if _, err := tx.Exec(`DELETE FROM role_permissions WHERE role_id=$1`, roleID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO role_permissions (role_id, permission)
+		SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING
+	`, roleID, pq.Array(permissions)); err != nil {
+		return nil, err
+	}
+
+	var userIDs []int64
+	if err := tx.Select(&userIDs, `SELECT user_id FROM user_roles WHERE role_id=$1`, roleID); err != nil {
+		return nil, err
+	}
+	if err := bumpPermVersionTx(tx, userIDs); err != nil {
+		return nil, err
+	}
+	after, err := roleSnapshotTx(tx, roleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeAudit(tx, audit, before, after); err != nil {
+		return nil, err
+	}
+	return userIDs, tx.Commit()
+}
+
+type roleSnapshot struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func roleSnapshotTx(tx *sqlx.Tx, roleID int64) (*roleSnapshot, error) {
+	var snap roleSnapshot
+	if err := tx.QueryRow(`SELECT name, description FROM roles WHERE id=$1`, roleID).Scan(&snap.Name, &snap.Description); err != nil {
+		return nil, err
+	}
+	snap.Permissions = []string{}
+	err := tx.Select(&snap.Permissions, `SELECT permission FROM role_permissions WHERE role_id=$1 ORDER BY permission`, roleID)
+	return &snap, err
+}
+
+func userRolesTx(tx *sqlx.Tx, userID int64) ([]string, error) {
+	roles := []string{}
+	err := tx.Select(&roles, `
+		SELECT r.name FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id=$1
+		ORDER BY r.name
+	`, userID)
+	return roles, err
+}
+
+// DeleteRole removes a custom role. Builtin roles are kept. audit may be nil.
+func DeleteRole(db *sqlx.DB, name string, audit *models.AuditEntry) ([]int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var roleID int64
+	var builtin bool
+	err = tx.QueryRow(`SELECT id, builtin FROM roles WHERE name=$1 FOR UPDATE`, name).Scan(&roleID, &builtin)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if builtin {
+		return nil, ErrBuiltinRole
+	}
+
+	var userIDs []int64
+	if err := tx.Select(&userIDs, `SELECT user_id FROM user_roles WHERE role_id=$1`, roleID); err != nil {
+		return nil, err
+	}
+	before, err := roleSnapshotTx(tx, roleID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM roles WHERE id=$1`, roleID); err != nil {
+		return nil, err
+	}
+	if err := bumpPermVersionTx(tx, userIDs); err != nil {
+		return nil, err
+	}
+	if err := writeAudit(tx, audit, map[string]interface{}{"role": before, "holders": userIDs}, nil); err != nil {
+		return nil, err
+	}
+	return userIDs, tx.Commit()
+}
+
+// AssignRole grants a role to the user. grantedBy is 0 for system grants,
+// audit may be nil.
+func AssignRole(db *sqlx.DB, userID int64, roleName string, grantedBy int64, audit *models.AuditEntry) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := userRolesTx(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	var granter sql.NullInt64
+	if grantedBy > 0 {
+		granter = sql.NullInt64{Int64: grantedBy, Valid: true}
+	}
+	res, err := tx.Exec(`
+		INSERT INTO user_roles (user_id, role_id, granted_by)
+		SELECT $1, id, $3 FROM roles WHERE name=$2
+		ON CONFLICT DO NOTHING
+	`, userID, roleName, granter)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists bool
+		if err := tx.Get(&exists, `SELECT EXISTS(SELECT 1 FROM roles WHERE name=$1)`, roleName); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrRoleNotFound
+		}
+		return nil
+	}
+	if err := bumpPermVersionTx(tx, []int64{userID}); err != nil {
+		return err
+	}
+	after, err := userRolesTx(tx, userID)
+	if err != nil {
+		return err
+	}
+	if err := writeAudit(tx, audit, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeRole removes a role from the user, audit may be nil
+func RevokeRole(db *sqlx.DB, userID int64, roleName string, audit *models.AuditEntry) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := userRolesTx(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`
+		DELETE FROM user_roles
+		WHERE user_id=$1 AND role_id = (SELECT id FROM roles WHERE name=$2)
+	`, userID, roleName)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	if err := bumpPermVersionTx(tx, []int64{userID}); err != nil {
+		return err
+	}
+	after, err := userRolesTx(tx, userID)
+	if err != nil {
+		return err
+	}
+	if err := writeAudit(tx, audit, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func GetUserRoles(db *sqlx.DB, userID int64) ([]string, error) {
+	roles := []string{}
+	err := db.Select(&roles, `
+		SELECT r.name FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id=$1
+		ORDER BY r.name
+	`, userID)
+	return roles, err
+}
+
+// GetUserPermissions returns the permission names granted to the user by
+// all of their roles, together with the user's current perm_version
+func GetUserPermissions(db *sqlx.DB, userID int64) ([]string, int64, error) {
+	var version int64
+	if err := db.Get(&version, `SELECT perm_version FROM users WHERE id=$1`, userID); err != nil {
+		return nil, 0, err
+	}
+	perms := []string{}
+	err := db.Select(&perms, `
+		SELECT DISTINCT rp.permission FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		WHERE ur.user_id=$1
+		ORDER BY rp.permission
+	`, userID)
+	return perms, version, err
+}
+
+func GetPermVersion(db *sqlx.DB, userID int64) (int64, error) {
+	var version int64
+	err := db.Get(&version, `SELECT perm_version FROM users WHERE id=$1`, userID)
+	return version, err
+}
+
+// Legacy permission bits, kept so existing Lua scripts using
+// add_permission/remove_permission/set_permissions keep working.
+// Each bit maps onto the role that now carries that permission.
+const (
+	LegacyPermBalanceChange   = 1 << iota // finance
+	LegacyPermUserBlock                   // support
+	LegacyPermTransactionView             // finance
+	LegacyPermTicketManage                // support
+	LegacyPermDisputeManage               // arbiter
+	LegacyPermAll             = 31
+)
+
+var legacyBitRoles = []struct {
+	bit  int
+	role string
+}{
+	{LegacyPermBalanceChange, "finance"},
+	{LegacyPermUserBlock, "support"},
+	{LegacyPermTransactionView, "finance"},
+	{LegacyPermTicketManage, "support"},
+	{LegacyPermDisputeManage, "arbiter"},
+}
+
+// LegacyRoles returns the roles equivalent to a legacy permissions bitmask
+func LegacyRoles(perm int) []string {
+	var roles []string
+	if perm&LegacyPermAll == LegacyPermAll {
+		return []string{RoleSuperadmin}
+	}
+	for _, b := range legacyBitRoles {
+		if perm&b.bit != 0 {
+			roles = appendUnique(roles, b.role)
+		}
+	}
+	return roles
+}
+
+// MigrateLegacyPermissions converts users.permissions and is_admin into role
+// grants. It only runs while no role has been assigned yet.
+func MigrateLegacyPermissions(db *sqlx.DB) (int, error) {
+	var assigned bool
+	if err := db.Get(&assigned, `SELECT EXISTS(SELECT 1 FROM user_roles)`); err != nil {
+		return 0, err
+	}
+	if assigned {
+		return 0, nil
+	}
+
+	var rows []struct {
+		ID          int64 `db:"id"`
+		IsAdmin     bool  `db:"is_admin"`
+		Permissions int   `db:"permissions"`
+	}
+	if err := db.Select(&rows, `
+		SELECT id, is_admin, COALESCE(permissions, 0) AS permissions
+		FROM users WHERE is_admin OR COALESCE(permissions, 0) <> 0
+	`); err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, u := range rows {
+		roles := LegacyRoles(u.Permissions)
+		if u.IsAdmin {
+			roles = appendUnique(roles, RoleAdmin)
+		}
+		for _, role := range roles {
+			if err := AssignRole(db, u.ID, role, 0, nil); err != nil {
+				return migrated, err
+			}
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func uniqueStrings(list []string) []string {
+	var out []string
+	for _, s := range list {
+		out = appendUnique(out, s)
+	}
+	return out
+}
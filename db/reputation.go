@@ -0,0 +1,160 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+
+	"github.com/jmoiron/sqlx"
+
+	"mFrelance/models"
+)
+
+// GetReputationStats collects the track record of a user from reviews,
+// escrows and disputes
+func GetReputationStats(db *sqlx.DB, userID int64) (*models.ReputationStats, error) {
+	var s models.ReputationStats
+	err := db.Get(&s, `
+		SELECT u.id AS user_id, u.created_at AS account_created,
+		       COALESCE(rv.rating_sum, 0) AS rating_sum, rv.rating_count,
+		       es.contracts, es.released, es.refunded, es.with_deadline, es.on_time,
+		       dl.disputes_lost
+		FROM users u
+		CROSS JOIN LATERAL (
+			SELECT SUM(rating) AS rating_sum, COUNT(*) AS rating_count
+			FROM reviews WHERE reviewed_id = u.id AND `+reviewVisible+`
+		) rv
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) FILTER (WHERE e.status IN ('released', 'refunded')) AS contracts,
+			       COUNT(*) FILTER (WHERE e.freelancer_id = u.id AND e.status = 'released') AS released,
+			       COUNT(*) FILTER (WHERE e.freelancer_id = u.id AND e.status = 'refunded') AS refunded,
+			       COUNT(*) FILTER (WHERE e.freelancer_id = u.id AND e.status = 'released'
+			                        AND t.deadline IS NOT NULL AND t.completed_at IS NOT NULL) AS with_deadline,
+			       COUNT(*) FILTER (WHERE e.freelancer_id = u.id AND e.status = 'released'
+			                        AND t.completed_at <= t.deadline) AS on_time
+			FROM escrow_balances e JOIN tasks t ON t.id = e.task_id
+			WHERE e.client_id = u.id OR e.freelancer_id = u.id
+		) es
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) AS disputes_lost
+			FROM disputes d JOIN escrow_balances e ON e.task_id = d.task_id
+			WHERE d.status = 'resolved' AND (
+				(e.client_id = u.id AND d.resolution = 'freelancer_won') OR
+				(e.freelancer_id = u.id AND d.resolution = 'client_won'))
+		) dl
+		WHERE u.id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT currency, SUM(amount) FROM escrow_balances
+		WHERE freelancer_id = $1 AND status = 'released'
+		GROUP BY currency
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	s.Earnings = map[string]float64{}
+	for rows.Next() {
+		var currency string
+		var amount float64
+		if err := rows.Scan(&currency, &amount); err != nil {
+			return nil, err
+		}
+		s.Earnings[currency] = amount
+	}
+	return &s, rows.Err()
+}
+
+// reputationChangeEpsilon is the smallest score change written to the
+// history
+const reputationChangeEpsilon = 0.005
+
+// SaveReputation stores the current score of a user and, when it moved,
+// appends it to the history with reason. Reports whether the score changed.
+func SaveReputation(db *sqlx.DB, rep *models.Reputation, reason string) (bool, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var previous float64
+	err = tx.Get(&previous, `SELECT score FROM reputation_scores WHERE user_id = $1 FOR UPDATE`, rep.UserID)
+	isNew := errors.Is(err, sql.ErrNoRows)
+	if err != nil && !isNew {
+		return false, err
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO reputation_scores (user_id, score, rating, review_count, contracts, completion_rate,
+			dispute_loss_rate, on_time_rate, earnings_score, account_age_days, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET
+			score = EXCLUDED.score, rating = EXCLUDED.rating, review_count = EXCLUDED.review_count,
+			contracts = EXCLUDED.contracts, completion_rate = EXCLUDED.completion_rate,
+			dispute_loss_rate = EXCLUDED.dispute_loss_rate, on_time_rate = EXCLUDED.on_time_rate,
+			earnings_score = EXCLUDED.earnings_score, account_age_days = EXCLUDED.account_age_days,
+			updated_at = EXCLUDED.updated_at
+		RETURNING updated_at
+	`, rep.UserID, rep.Score, rep.Rating, rep.ReviewCount, rep.Contracts, rep.CompletionRate,
+		rep.DisputeLossRate, rep.OnTimeRate, rep.EarningsScore, rep.AccountAgeDays,
+	).Scan(&rep.UpdatedAt)
+	if err != nil {
+		return false, err
+	}
+
+	changed := isNew || math.Abs(previous-rep.Score) >= reputationChangeEpsilon
+	if changed {
+		components, err := jsonParam(rep)
+		if err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO reputation_history (user_id, score, reason, components) VALUES ($1, $2, $3, $4)
+		`, rep.UserID, rep.Score, reason, components); err != nil {
+			return false, err
+		}
+	}
+	return changed, tx.Commit()
+}
+
+// GetReputation returns the stored score of a user, sql.ErrNoRows if it
+// wasn't computed yet
+func GetReputation(db *sqlx.DB, userID int64) (*models.Reputation, error) {
+	var rep models.Reputation
+	if err := db.Get(&rep, `SELECT * FROM reputation_scores WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	return &rep, nil
+}
+
+// ListReputationHistory returns the latest score changes of a user, newest
+// first
+func ListReputationHistory(db *sqlx.DB, userID int64, limit, offset int) ([]models.ReputationHistoryEntry, error) {
+	list := []models.ReputationHistoryEntry{}
+	err := db.Select(&list, `
+		SELECT id, user_id, score, reason, created_at FROM reputation_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+	return list, err
+}
+
+// ListStaleReputationUsers returns users without a score or whose score is
+// older than maxAgeSeconds, oldest first
+func ListStaleReputationUsers(db *sqlx.DB, maxAgeSeconds float64, limit int) ([]int64, error) {
+	var ids []int64
+	err := db.Select(&ids, `
+		SELECT u.id FROM users u
+		LEFT JOIN reputation_scores rs ON rs.user_id = u.id
+		WHERE rs.user_id IS NULL OR rs.updated_at < NOW() - $1 * INTERVAL '1 second'
+		ORDER BY rs.updated_at NULLS FIRST, u.id
+		LIMIT $2
+	`, maxAgeSeconds, limit)
+	return ids, err
+}
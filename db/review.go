@@ -2,70 +2,151 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"mFrelance/models"
-	// "time"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
-func CreateReview(review *models.Review) error {
-	query := `
-		INSERT INTO reviews (task_id, reviewer_id, reviewed_id, rating, comment, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id`
+// ErrReviewExists is returned when the reviewer already reviewed the task
+var ErrReviewExists = errors.New("review already exists for this task")
+
+const reviewColumns = `id, task_id, reviewer_id, reviewed_id, rating,
+	communication_rating, quality_rating, timeliness_rating, COALESCE(comment, '') AS comment,
+	reply, replied_at, published_at, hidden_at, hidden_by, hidden_reason, created_at`
 
-	return Postgres.QueryRow(query, review.TaskID, review.ReviewerID, review.ReviewedID, review.Rating, review.Comment, review.CreatedAt).Scan(&review.ID)
+// reviewVisible matches reviews everyone can see: published and not hidden
+const reviewVisible = `published_at IS NOT NULL AND hidden_at IS NULL`
+
+// CreateReview stores a review that is visible right away
+func CreateReview(review *models.Review) error {
+	_, err := SubmitReview(review, false)
+	return err
 }
 
-func GetReviewsByUserID(userID int64) ([]*models.Review, error) {
-	query := `SELECT id, task_id, reviewer_id, reviewed_id, rating, comment, created_at FROM reviews WHERE reviewed_id = $1 ORDER BY created_at DESC`
-	rows, err := Postgres.Query(query, userID)
+// SubmitReview stores a review. With blind set the review stays unpublished
+// until the other party reviews the task as well; their submission
+// publishes both. Returns the reviews that became visible.
+func SubmitReview(review *models.Review, blind bool) ([]*models.Review, error) {
+	tx, err := Postgres.Beginx()
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var reviews []*models.Review
-	for rows.Next() {
-		review := &models.Review{}
-		err := rows.Scan(&review.ID, &review.TaskID, &review.ReviewerID, &review.ReviewedID, &review.Rating, &review.Comment, &review.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		reviews = append(reviews, review)
+	// Serializes both parties of a task so neither submission misses the other
+	if _, err := tx.Exec(`SELECT id FROM tasks WHERE id = $1 FOR UPDATE`, review.TaskID); err != nil {
+		return nil, err
 	}
-	return reviews, nil
-}
 
-func GetReviewsByTaskID(taskID int64) ([]*models.Review, error) {
-	query := `SELECT id, task_id, reviewer_id, reviewed_id, rating, comment, created_at FROM reviews WHERE task_id = $1 ORDER BY created_at DESC`
-	rows, err := Postgres.Query(query, taskID)
+	var counterpart sql.NullTime
+	var hasCounterpart bool
+	err = tx.QueryRow(`SELECT published_at FROM reviews WHERE task_id = $1 AND reviewer_id = $2`,
+		review.TaskID, review.ReviewedID).Scan(&counterpart)
+	switch {
+	case err == nil:
+		hasCounterpart = true
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	if review.CreatedAt.IsZero() {
+		review.CreatedAt = time.Now()
+	}
+	review.PublishedAt = nil
+	if !blind || hasCounterpart {
+		now := time.Now()
+		review.PublishedAt = &now
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO reviews (task_id, reviewer_id, reviewed_id, rating, communication_rating, quality_rating, timeliness_rating, comment, published_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id`,
+		review.TaskID, review.ReviewerID, review.ReviewedID, review.Rating,
+		review.CommunicationRating, review.QualityRating, review.TimelinessRating,
+		review.Comment, review.PublishedAt, review.CreatedAt,
+	).Scan(&review.ID)
 	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrReviewExists
+		}
 		return nil, err
 	}
-	defer rows.Close()
 
-	var reviews []*models.Review
-	for rows.Next() {
-		review := &models.Review{}
-		err := rows.Scan(&review.ID, &review.TaskID, &review.ReviewerID, &review.ReviewedID, &review.Rating, &review.Comment, &review.CreatedAt)
+	var published []*models.Review
+	if review.PublishedAt != nil {
+		published = append(published, review)
+	}
+	if hasCounterpart && !counterpart.Valid {
+		other := &models.Review{}
+		err := tx.QueryRowx(`
+			UPDATE reviews SET published_at = $3
+			WHERE task_id = $1 AND reviewer_id = $2
+			RETURNING `+reviewColumns,
+			review.TaskID, review.ReviewedID, review.PublishedAt).StructScan(other)
 		if err != nil {
 			return nil, err
 		}
-		reviews = append(reviews, review)
+		published = append(published, other)
 	}
-	return reviews, nil
+	return published, tx.Commit()
+}
+
+// PublishExpiredReviews publishes double-blind reviews whose counterpart
+// didn't arrive within window and returns them
+func PublishExpiredReviews(window time.Duration) ([]*models.Review, error) {
+	reviews := []*models.Review{}
+	err := Postgres.Select(&reviews, `
+		UPDATE reviews SET published_at = NOW()
+		WHERE published_at IS NULL AND created_at <= NOW() - $1 * INTERVAL '1 second'
+		RETURNING `+reviewColumns, window.Seconds())
+	return reviews, err
 }
 
-func GetUserRating(userID int64) (float64, error) {
-	query := `SELECT AVG(rating) FROM reviews WHERE reviewed_id = $1`
-	var rating sql.NullFloat64
-	err := Postgres.QueryRow(query, userID).Scan(&rating)
+func GetReviewByID(id int64) (*models.Review, error) {
+	review := &models.Review{}
+	err := Postgres.Get(review, `SELECT `+reviewColumns+` FROM reviews WHERE id = $1`, id)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	if rating.Valid {
-		return rating.Float64, nil
+	return review, nil
+}
+
+// GetReviewsByUserID returns the visible reviews a user received
+func GetReviewsByUserID(userID int64) ([]*models.Review, error) {
+	reviews := []*models.Review{}
+	err := Postgres.Select(&reviews, `SELECT `+reviewColumns+` FROM reviews WHERE reviewed_id = $1 AND `+reviewVisible+` ORDER BY created_at DESC`, userID)
+	return reviews, err
+}
+
+// GetReviewsByTaskID returns the visible reviews of a task and, when
+// viewerID wrote one that is still unpublished, the viewer's own review
+func GetReviewsByTaskID(taskID, viewerID int64) ([]*models.Review, error) {
+	reviews := []*models.Review{}
+	err := Postgres.Select(&reviews, `
+		SELECT `+reviewColumns+` FROM reviews
+		WHERE task_id = $1 AND ((`+reviewVisible+`) OR (reviewer_id = $2 AND hidden_at IS NULL))
+		ORDER BY created_at DESC`, taskID, viewerID)
+	return reviews, err
+}
+
+// GetUserRating aggregates the visible reviews a user received
+func GetUserRating(userID int64) (*models.UserRating, error) {
+	rating := &models.UserRating{}
+	err := Postgres.Get(rating, `
+		SELECT COALESCE(AVG(rating), 0) AS rating,
+		       COALESCE(AVG(communication_rating), 0) AS communication,
+		       COALESCE(AVG(quality_rating), 0) AS quality,
+		       COALESCE(AVG(timeliness_rating), 0) AS timeliness,
+		       COUNT(*) AS count
+		FROM reviews WHERE reviewed_id = $1 AND `+reviewVisible, userID)
+	if err != nil {
+		return nil, err
 	}
-	return 0, nil
+	return rating, nil
 }
 
 func HasUserReviewedTask(userID, taskID int64) (bool, error) {
@@ -74,3 +155,36 @@ func HasUserReviewedTask(userID, taskID int64) (bool, error) {
 	err := Postgres.QueryRow(query, userID, taskID).Scan(&count)
 	return count > 0, err
 }
+
+// ReplyToReview stores the one reply the reviewed user can give to a
+// visible review. Returns sql.ErrNoRows when the review isn't theirs, isn't
+// visible or already has a reply.
+func ReplyToReview(reviewID, userID int64, reply string) (*models.Review, error) {
+	review := &models.Review{}
+	err := Postgres.Get(review, `
+		UPDATE reviews SET reply = $3, replied_at = NOW()
+		WHERE id = $1 AND reviewed_id = $2 AND reply IS NULL AND `+reviewVisible+`
+		RETURNING `+reviewColumns, reviewID, userID, reply)
+	if err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// SetReviewHiddenTx hides a review from everyone or makes it visible again
+func SetReviewHiddenTx(tx *sqlx.Tx, reviewID int64, hidden bool, adminID int64, reason string) error {
+	var res sql.Result
+	var err error
+	if hidden {
+		res, err = tx.Exec(`UPDATE reviews SET hidden_at = NOW(), hidden_by = $2, hidden_reason = $3 WHERE id = $1`, reviewID, adminID, reason)
+	} else {
+		res, err = tx.Exec(`UPDATE reviews SET hidden_at = NULL, hidden_by = NULL, hidden_reason = '' WHERE id = $1`, reviewID)
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"database/sql"
 	"log"
 	"mFrelance/models"
 
@@ -22,44 +23,63 @@ func UpdateEscrowBalanceStatusTx(tx *sqlx.Tx, taskID int64, status string) error
 }
 
 func UpdateTaskStatusTx(tx *sqlx.Tx, taskID int64, status string) error {
-	_, err := tx.Exec(`UPDATE tasks SET status=$1 WHERE id=$2`, status, taskID)
+	_, err := tx.Exec(`UPDATE tasks SET status=$1, completed_at = CASE WHEN $1 = 'completed' THEN COALESCE(completed_at, NOW()) END WHERE id=$2`, status, taskID)
 	return err
 }
 
-func CreateTask(db *sqlx.DB, task *models.Task) error {
-	log.Printf("[CreateTask] Creating task: %+v", task)
-
-	deadlineTime := task.Deadline.Time
-
-	query := `
-		INSERT INTO tasks (
-			client_id, title, description, category, budget, currency, status, created_at, deadline
-		) VALUES (
-			:client_id, :title, :description, :category, :budget, :currency, :status, :created_at, :deadline
-		)
-		RETURNING id
-	`
+// namedPreparer is a *sqlx.DB or *sqlx.Tx
+type namedPreparer interface {
+	PrepareNamed(query string) (*sqlx.NamedStmt, error)
+}
 
-	params := map[string]interface{}{
-		"client_id":   task.ClientID,
-		"title":       task.Title,
-		"description": task.Description,
-		"category":    task.Category,
-		"budget":      task.Budget,
-		"currency":    task.Currency,
-		"status":      task.Status,
-		"created_at":  task.CreatedAt,
-		"deadline":    deadlineTime,
+func CreateTask(db *sqlx.DB, task *models.Task) error {
+	return createTask(db, task)
+}
+
+func CreateTaskTx(tx *sqlx.Tx, task *models.Task) error {
+	return createTask(tx, task)
+}
+
+const createTaskQuery = `
+	INSERT INTO tasks (
+		client_id, title, description, category, budget, currency, status, created_at, deadline, contract_type, visibility, template_id, schedule_id, org_id
+	) VALUES (
+		:client_id, :title, :description, :category, :budget, :currency, :status, :created_at, :deadline, :contract_type, :visibility, :template_id, :schedule_id, :org_id
+	)
+	RETURNING id
+`
+
+// createTaskParams binds createTaskQuery, every name in it must have a key
+func createTaskParams(task *models.Task) map[string]interface{} {
+	return map[string]interface{}{
+		"client_id":     task.ClientID,
+		"title":         task.Title,
+		"description":   task.Description,
+		"category":      task.Category,
+		"budget":        task.Budget,
+		"currency":      task.Currency,
+		"status":        task.Status,
+		"created_at":    task.CreatedAt,
+		"deadline":      task.Deadline.Time,
+		"contract_type": task.ContractType,
+		"visibility":    task.Visibility,
+		"template_id":   task.TemplateID,
+		"schedule_id":   task.ScheduleID,
+		"org_id":        task.OrgID,
 	}
+}
+
+func createTask(db namedPreparer, task *models.Task) error {
+	log.Printf("[CreateTask] Creating task: %+v", task)
 
-	stmt, err := db.PrepareNamed(query)
+	stmt, err := db.PrepareNamed(createTaskQuery)
 	if err != nil {
 		log.Printf("[CreateTask] PrepareNamed error: %v", err)
 		return err
 	}
 	defer stmt.Close()
 
-	err = stmt.Get(&task.ID, params)
+	err = stmt.Get(&task.ID, createTaskParams(task))
 	if err != nil {
 		log.Printf("[CreateTask] Database error: %v", err)
 		return err
@@ -89,7 +109,7 @@ func GetTasksByClientID(db *sqlx.DB, clientID int64) ([]*models.Task, error) {
 
 func GetOpenTasks(db *sqlx.DB) ([]*models.Task, error) {
 	var tasks []*models.Task
-	err := db.Select(&tasks, `SELECT * FROM tasks WHERE status = 'open' ORDER BY created_at DESC`)
+	err := db.Select(&tasks, `SELECT * FROM tasks WHERE status = 'open' AND visibility = 'public' ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -98,7 +118,7 @@ func GetOpenTasks(db *sqlx.DB) ([]*models.Task, error) {
 
 func CountOpenTasks(db *sqlx.DB) (int64, error) {
     var n int64
-    err := db.Get(&n, `SELECT COUNT(*) FROM tasks WHERE status='open'`)
+    err := db.Get(&n, `SELECT COUNT(*) FROM tasks WHERE status='open' AND visibility='public'`)
     return n, err
 }
 
@@ -120,7 +140,7 @@ func GetTasksByClientIDPaged(db *sqlx.DB, clientID int64, limit, offset int) ([]
 
 func GetOpenTasksPaged(db *sqlx.DB, limit, offset int) ([]*models.Task, error) {
     var tasks []*models.Task
-    err := db.Select(&tasks, `SELECT * FROM tasks WHERE status = 'open' ORDER BY created_at DESC LIMIT $1 OFFSET $2`, limit, offset)
+    err := db.Select(&tasks, `SELECT * FROM tasks WHERE status = 'open' AND visibility = 'public' ORDER BY created_at DESC LIMIT $1 OFFSET $2`, limit, offset)
     if err != nil {
         return nil, err
     }
@@ -147,7 +167,8 @@ func UpdateTask(db *sqlx.DB, task *models.Task) error {
 			budget = :budget,
 			currency = :currency,
 			status = :status,
-			deadline = :deadline
+			deadline = :deadline,
+			visibility = :visibility
 		WHERE id = :id
 	`
 	_, err := db.NamedExec(query, task)
@@ -155,7 +176,7 @@ func UpdateTask(db *sqlx.DB, task *models.Task) error {
 }
 
 func UpdateTaskStatus(db *sqlx.DB, taskID int64, status string) error {
-	_, err := db.Exec(`UPDATE tasks SET status = $1 WHERE id = $2`, status, taskID)
+	_, err := db.Exec(`UPDATE tasks SET status = $1, completed_at = CASE WHEN $1 = 'completed' THEN COALESCE(completed_at, NOW()) END WHERE id = $2`, status, taskID)
 	return err
 }
 
@@ -164,6 +185,26 @@ func DeleteTask(db *sqlx.DB, id int64) error {
 	return err
 }
 
+// DeleteOpenTaskTx deletes a task that has no contract yet. It fails with
+// sql.ErrNoRows if the task is gone or no longer open.
+func DeleteOpenTaskTx(tx *sqlx.Tx, id int64) error {
+	res, err := tx.Exec(`DELETE FROM tasks WHERE id = $1 AND status = 'open'`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteTasksByUserIDTx deletes all tasks of a client and returns their ids
+func DeleteTasksByUserIDTx(tx *sqlx.Tx, userID int64) ([]int64, error) {
+	ids := []int64{}
+	err := tx.Select(&ids, `DELETE FROM tasks WHERE client_id = $1 RETURNING id`, userID)
+	return ids, err
+}
+
 func DeleteTasksByUserID(db *sqlx.DB, userID int64) (int64, error) {
     res, err := db.Exec(`DELETE FROM tasks WHERE client_id = $1`, userID)
     if err != nil {
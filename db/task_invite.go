@@ -0,0 +1,107 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+
+	"mFrelance/models"
+)
+
+// CreateTaskInvite invites a freelancer to a task, or replaces the message
+// of an existing invite. Returns false when the freelancer was invited
+// already.
+func CreateTaskInvite(db *sqlx.DB, invite *models.TaskInvite) (bool, error) {
+	var created bool
+	err := db.QueryRow(`
+		INSERT INTO task_invites (task_id, freelancer_id, message)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (task_id, freelancer_id) DO UPDATE SET message = EXCLUDED.message
+		RETURNING created_at, xmax = 0
+	`, invite.TaskID, invite.FreelancerID, invite.Message).Scan(&invite.CreatedAt, &created)
+	return created, err
+}
+
+// CreateTaskInviteTx invites a freelancer to a task unless they are invited
+// already
+func CreateTaskInviteTx(tx *sqlx.Tx, invite *models.TaskInvite) error {
+	_, err := tx.Exec(`
+		INSERT INTO task_invites (task_id, freelancer_id, message) VALUES ($1, $2, $3)
+		ON CONFLICT (task_id, freelancer_id) DO NOTHING
+	`, invite.TaskID, invite.FreelancerID, invite.Message)
+	return err
+}
+
+// DeleteTaskInvite revokes an invite and rejects the freelancer's open
+// offer on the task. Returns the rejected offer, or nil if there was none,
+// and sql.ErrNoRows when the freelancer wasn't invited.
+func DeleteTaskInvite(db *sqlx.DB, taskID, freelancerID int64) (*models.TaskOffer, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM task_invites WHERE task_id = $1 AND freelancer_id = $2`, taskID, freelancerID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	offer := &models.TaskOffer{}
+	err = tx.Get(offer, `
+		UPDATE task_offers SET status = 'rejected', updated_at = NOW()
+		WHERE task_id = $1 AND freelancer_id = $2 AND status IN ('pending', 'countered')
+		RETURNING *
+	`, taskID, freelancerID)
+	if err == sql.ErrNoRows {
+		offer = nil
+	} else if err != nil {
+		return nil, err
+	}
+	return offer, tx.Commit()
+}
+
+func IsTaskInvited(db *sqlx.DB, taskID, freelancerID int64) (bool, error) {
+	var invited bool
+	err := db.Get(&invited, `SELECT EXISTS (SELECT 1 FROM task_invites WHERE task_id = $1 AND freelancer_id = $2)`, taskID, freelancerID)
+	return invited, err
+}
+
+// CanSeeTask reports whether a user other than the client was invited to
+// the task or made an offer on it
+func CanSeeTask(db *sqlx.DB, taskID, userID int64) (bool, error) {
+	var ok bool
+	err := db.Get(&ok, `
+		SELECT EXISTS (SELECT 1 FROM task_invites WHERE task_id = $1 AND freelancer_id = $2)
+			OR EXISTS (SELECT 1 FROM task_offers WHERE task_id = $1 AND freelancer_id = $2)
+	`, taskID, userID)
+	return ok, err
+}
+
+// ListTaskInvites returns the freelancers invited to a task, newest first
+func ListTaskInvites(db *sqlx.DB, taskID int64) ([]models.TaskInvite, error) {
+	invites := []models.TaskInvite{}
+	err := db.Select(&invites, `
+		SELECT i.*, u.username FROM task_invites i
+		JOIN users u ON u.id = i.freelancer_id
+		WHERE i.task_id = $1
+		ORDER BY i.created_at DESC
+	`, taskID)
+	return invites, err
+}
+
+// ListInvitedTasks returns the open tasks a freelancer was invited to,
+// newest invite first
+func ListInvitedTasks(db *sqlx.DB, freelancerID int64, limit, offset int) ([]*models.Task, error) {
+	tasks := []*models.Task{}
+	err := db.Select(&tasks, `
+		SELECT t.* FROM tasks t
+		JOIN task_invites i ON i.task_id = t.id
+		WHERE i.freelancer_id = $1 AND t.status = 'open'
+		ORDER BY i.created_at DESC
+		LIMIT $2 OFFSET $3
+	`, freelancerID, limit, offset)
+	return tasks, err
+}
@@ -1,12 +1,40 @@
 package db
 
 import (
+	"database/sql"
+
 	"github.com/jmoiron/sqlx"
 	"mFrelance/models"
 )
 
+// CreateTaskOffer stores a new offer and its first revision
 func CreateTaskOffer(db *sqlx.DB, offer *models.TaskOffer) error {
-	_, err := db.NamedExec(`INSERT INTO task_offers (task_id, freelancer_id, price, message, accepted, created_at) VALUES (:task_id, :freelancer_id, :price, :message, :accepted, :created_at)`, offer)
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := CreateTaskOfferTx(tx, offer); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func CreateTaskOfferTx(tx *sqlx.Tx, offer *models.TaskOffer) error {
+	err := tx.QueryRow(`INSERT INTO task_offers (task_id, freelancer_id, price, message, status, expires_at, created_at, updated_at, hourly_rate, weekly_cap, org_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8, $9, $10) RETURNING id, updated_at`,
+		offer.TaskID, offer.FreelancerID, offer.Price, offer.Message, offer.Status, offer.ExpiresAt, offer.CreatedAt, offer.HourlyRate, offer.WeeklyCap, offer.OrgID).Scan(&offer.ID, &offer.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return insertOfferRevisionTx(tx, offer, offer.FreelancerID, models.RevisionOffer, offer.Message)
+}
+
+func insertOfferRevisionTx(tx *sqlx.Tx, offer *models.TaskOffer, authorID int64, kind, message string) error {
+	_, err := tx.Exec(`
+		INSERT INTO offer_revisions (offer_id, author_id, kind, price, hourly_rate, weekly_cap, message)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, offer.ID, authorID, kind, offer.Price, offer.HourlyRate, offer.WeeklyCap, message)
 	return err
 }
 
@@ -22,38 +50,168 @@ func GetTaskOffersByTaskID(db *sqlx.DB, taskID int64) ([]*models.TaskOffer, erro
 	return offers, err
 }
 
+// GetAcceptedTaskOffer returns the accepted offer of a task
+func GetAcceptedTaskOffer(db *sqlx.DB, taskID int64) (*models.TaskOffer, error) {
+	var offer models.TaskOffer
+	err := db.Get(&offer, `SELECT * FROM task_offers WHERE task_id = $1 AND status = 'accepted'`, taskID)
+	return &offer, err
+}
+
 func GetTaskOffersByFreelancerID(db *sqlx.DB, freelancerID int64) ([]*models.TaskOffer, error) {
 	var offers []*models.TaskOffer
 	err := db.Select(&offers, `SELECT * FROM task_offers WHERE freelancer_id = $1 ORDER BY created_at DESC`, freelancerID)
 	return offers, err
 }
 
+// acceptableOffer matches offers with the freelancer's terms on the table
+const acceptableOffer = `status = 'pending' AND (expires_at IS NULL OR expires_at > NOW())`
+
 func AcceptTaskOffer(db *sqlx.DB, offerID int64) error {
-	_, err := db.Exec(`UPDATE task_offers SET accepted = true WHERE id = $1`, offerID)
-	return err
+	res, err := db.Exec(`UPDATE task_offers SET status = 'accepted', updated_at = NOW() WHERE id = $1 AND `+acceptableOffer, offerID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-func AcceptTaskOfferTx(tx *sqlx.Tx, offerID int64) error {
-	_, err := tx.Exec(`UPDATE task_offers SET accepted = true WHERE id = $1`, offerID)
-	return err
+// AcceptTaskOfferTx accepts a pending offer that hasn't expired and returns
+// it with the terms it was accepted on, which a revision committed in the
+// meantime may have changed. Returns sql.ErrNoRows otherwise.
+func AcceptTaskOfferTx(tx *sqlx.Tx, offerID int64) (*models.TaskOffer, error) {
+	var offer models.TaskOffer
+	err := tx.Get(&offer, `UPDATE task_offers SET status = 'accepted', updated_at = NOW() WHERE id = $1 AND `+acceptableOffer+` RETURNING *`, offerID)
+	if err != nil {
+		return nil, err
+	}
+	return &offer, nil
 }
 
-func RejectOtherOffersForTask(db *sqlx.DB, taskID, acceptedOfferID int64) error {
-	_, err := db.Exec(`UPDATE task_offers SET accepted = false WHERE task_id = $1 AND id != $2`, taskID, acceptedOfferID)
-	return err
+// RejectOtherOffersForTask rejects the offers of a task still being
+// negotiated, except the accepted one, and returns their freelancers
+func RejectOtherOffersForTask(db *sqlx.DB, taskID, acceptedOfferID int64) ([]int64, error) {
+	ids := []int64{}
+	err := db.Select(&ids, `UPDATE task_offers SET status = 'rejected', updated_at = NOW() WHERE task_id = $1 AND id != $2 AND status IN ('pending', 'countered') RETURNING freelancer_id`, taskID, acceptedOfferID)
+	return ids, err
 }
 
-func RejectOtherOffersForTaskTx(tx *sqlx.Tx, taskID, acceptedOfferID int64) error {
-	_, err := tx.Exec(`UPDATE task_offers SET accepted = false WHERE task_id = $1 AND id != $2`, taskID, acceptedOfferID)
-	return err
+func RejectOtherOffersForTaskTx(tx *sqlx.Tx, taskID, acceptedOfferID int64) ([]int64, error) {
+	ids := []int64{}
+	err := tx.Select(&ids, `UPDATE task_offers SET status = 'rejected', updated_at = NOW() WHERE task_id = $1 AND id != $2 AND status IN ('pending', 'countered') RETURNING freelancer_id`, taskID, acceptedOfferID)
+	return ids, err
 }
 
-func UpdateTaskOffer(db *sqlx.DB, offer *models.TaskOffer) error {
-	_, err := db.NamedExec(`UPDATE task_offers SET task_id=:task_id, freelancer_id=:freelancer_id, price=:price, message=:message, accepted=:accepted WHERE id=:id`, offer)
-	return err
+// ReviseTaskOffer puts new terms on the table of an offer still being
+// negotiated and records them as a revision by authorID. Offer revisions
+// come from the freelancer and also replace the offer's message; counters
+// come from the client and leave the offer countered. Returns sql.ErrNoRows
+// when the offer is no longer open.
+func ReviseTaskOffer(db *sqlx.DB, offer *models.TaskOffer, authorID int64, kind, message string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	offer.Status = models.OfferPending
+	if kind == models.RevisionCounter {
+		offer.Status = models.OfferCountered
+	} else {
+		offer.Message = message
+	}
+	err = tx.QueryRow(`
+		UPDATE task_offers
+		SET price = $1, hourly_rate = $2, weekly_cap = $3, message = $4, status = $5, expires_at = $6, updated_at = NOW()
+		WHERE id = $7 AND status IN ('pending', 'countered')
+		RETURNING updated_at
+	`, offer.Price, offer.HourlyRate, offer.WeeklyCap, offer.Message, offer.Status, offer.ExpiresAt, offer.ID).Scan(&offer.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if err := insertOfferRevisionTx(tx, offer, authorID, kind, message); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
+// CloseTaskOffer ends the negotiation of an open offer with status
+// (rejected or withdrawn). Returns sql.ErrNoRows when it isn't open.
+func CloseTaskOffer(db *sqlx.DB, offerID int64, status string) error {
+	res, err := db.Exec(`UPDATE task_offers SET status = $1, updated_at = NOW() WHERE id = $2 AND status IN ('pending', 'countered')`, status, offerID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ExpireTaskOffers expires the open offers past their expiry and returns them
+func ExpireTaskOffers(db *sqlx.DB) ([]*models.TaskOffer, error) {
+	offers := []*models.TaskOffer{}
+	err := db.Select(&offers, `
+		UPDATE task_offers SET status = 'expired', updated_at = NOW()
+		WHERE status IN ('pending', 'countered') AND expires_at <= NOW()
+		RETURNING *
+	`)
+	return offers, err
+}
+
+// ListOfferRevisions returns the negotiation history of an offer, oldest first
+func ListOfferRevisions(db *sqlx.DB, offerID int64) ([]models.OfferRevision, error) {
+	revisions := []models.OfferRevision{}
+	err := db.Select(&revisions, `SELECT * FROM offer_revisions WHERE offer_id = $1 ORDER BY created_at, id`, offerID)
+	return revisions, err
+}
+
+// DeleteTaskOffer deletes an offer unless it was accepted. Returns
+// sql.ErrNoRows when there is no such offer or it was accepted.
 func DeleteTaskOffer(db *sqlx.DB, id int64) error {
-	_, err := db.Exec(`DELETE FROM task_offers WHERE id = $1`, id)
-	return err
+	res, err := db.Exec(`DELETE FROM task_offers WHERE id = $1 AND status <> 'accepted'`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Orders for ListRankedTaskOffers
+const (
+	OfferSortCreated    = "created"
+	OfferSortReputation = "reputation"
+	OfferSortPrice      = "price"
+)
+
+var offerSortOrders = map[string]string{
+	OfferSortCreated:    "o.created_at DESC",
+	OfferSortReputation: "freelancer_reputation DESC, o.created_at DESC",
+	OfferSortPrice:      "o.price ASC, freelancer_reputation DESC",
+}
+
+// IsOfferSort reports whether sort is an order ListRankedTaskOffers knows
+func IsOfferSort(sort string) bool {
+	_, ok := offerSortOrders[sort]
+	return ok
+}
+
+// ListRankedTaskOffers returns the offers of a task with their freelancer's
+// reputation, leaving out freelancers scoring below minReputation
+func ListRankedTaskOffers(db *sqlx.DB, taskID int64, sort string, minReputation float64) ([]*models.RankedTaskOffer, error) {
+	order, ok := offerSortOrders[sort]
+	if !ok {
+		order = offerSortOrders[OfferSortCreated]
+	}
+	offers := []*models.RankedTaskOffer{}
+	err := db.Select(&offers, `
+		SELECT o.*, COALESCE(rs.score, 0) AS freelancer_reputation
+		FROM task_offers o
+		LEFT JOIN reputation_scores rs ON rs.user_id = o.freelancer_id
+		WHERE o.task_id = $1 AND COALESCE(rs.score, 0) >= $2
+		ORDER BY `+order, taskID, minReputation)
+	return offers, err
 }
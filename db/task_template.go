@@ -0,0 +1,161 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"mFrelance/models"
+)
+
+// SaveTaskTemplate creates the template, or updates it when ID is set.
+// Returns sql.ErrNoRows when the template doesn't belong to t.ClientID.
+func SaveTaskTemplate(db *sqlx.DB, t *models.TaskTemplate) error {
+	if t.ID == 0 {
+		return db.QueryRow(`
+			INSERT INTO task_templates (client_id, name, title, description, category, budget, currency, contract_type, visibility, deadline_days)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING id, created_at, updated_at
+		`, t.ClientID, t.Name, t.Title, t.Description, t.Category, t.Budget, t.Currency, t.ContractType, t.Visibility, t.DeadlineDays).Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt)
+	}
+	return db.QueryRow(`
+		UPDATE task_templates
+		SET name = $1, title = $2, description = $3, category = $4, budget = $5, currency = $6,
+			contract_type = $7, visibility = $8, deadline_days = $9, updated_at = NOW()
+		WHERE id = $10 AND client_id = $11
+		RETURNING created_at, updated_at
+	`, t.Name, t.Title, t.Description, t.Category, t.Budget, t.Currency, t.ContractType, t.Visibility, t.DeadlineDays, t.ID, t.ClientID).Scan(&t.CreatedAt, &t.UpdatedAt)
+}
+
+func GetTaskTemplate(db *sqlx.DB, id int64) (*models.TaskTemplate, error) {
+	var t models.TaskTemplate
+	err := db.Get(&t, `SELECT * FROM task_templates WHERE id = $1`, id)
+	return &t, err
+}
+
+// ListTaskTemplates returns a client's templates by name
+func ListTaskTemplates(db *sqlx.DB, clientID int64) ([]models.TaskTemplate, error) {
+	templates := []models.TaskTemplate{}
+	err := db.Select(&templates, `SELECT * FROM task_templates WHERE client_id = $1 ORDER BY name, id`, clientID)
+	return templates, err
+}
+
+func CountTaskTemplates(db *sqlx.DB, clientID int64) (int, error) {
+	var n int
+	err := db.Get(&n, `SELECT COUNT(*) FROM task_templates WHERE client_id = $1`, clientID)
+	return n, err
+}
+
+// DeleteTaskTemplate deletes a template and its schedules. Returns
+// sql.ErrNoRows when the template doesn't belong to clientID.
+func DeleteTaskTemplate(db *sqlx.DB, id, clientID int64) error {
+	res, err := db.Exec(`DELETE FROM task_templates WHERE id = $1 AND client_id = $2`, id, clientID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SaveTaskSchedule creates the schedule, or updates it when ID is set.
+// Returns sql.ErrNoRows when the schedule doesn't belong to s.ClientID.
+func SaveTaskSchedule(db *sqlx.DB, s *models.TaskSchedule) error {
+	if s.ID == 0 {
+		return db.QueryRow(`
+			INSERT INTO task_schedules (template_id, client_id, cron, timezone, rehire, active, next_run_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id, created_at
+		`, s.TemplateID, s.ClientID, s.Cron, s.Timezone, s.Rehire, s.Active, s.NextRunAt).Scan(&s.ID, &s.CreatedAt)
+	}
+	return db.Get(s, `
+		UPDATE task_schedules
+		SET template_id = $1, cron = $2, timezone = $3, rehire = $4, active = $5, next_run_at = $6, last_error = ''
+		WHERE id = $7 AND client_id = $8
+		RETURNING *
+	`, s.TemplateID, s.Cron, s.Timezone, s.Rehire, s.Active, s.NextRunAt, s.ID, s.ClientID)
+}
+
+func GetTaskSchedule(db *sqlx.DB, id int64) (*models.TaskSchedule, error) {
+	var s models.TaskSchedule
+	err := db.Get(&s, `SELECT * FROM task_schedules WHERE id = $1`, id)
+	return &s, err
+}
+
+// ListTaskSchedules returns a client's schedules, oldest first
+func ListTaskSchedules(db *sqlx.DB, clientID int64) ([]models.TaskSchedule, error) {
+	schedules := []models.TaskSchedule{}
+	err := db.Select(&schedules, `SELECT * FROM task_schedules WHERE client_id = $1 ORDER BY id`, clientID)
+	return schedules, err
+}
+
+func CountActiveTaskSchedules(db *sqlx.DB, clientID int64) (int, error) {
+	var n int
+	err := db.Get(&n, `SELECT COUNT(*) FROM task_schedules WHERE client_id = $1 AND active`, clientID)
+	return n, err
+}
+
+// DeleteTaskSchedule returns sql.ErrNoRows when the schedule doesn't belong
+// to clientID
+func DeleteTaskSchedule(db *sqlx.DB, id, clientID int64) error {
+	res, err := db.Exec(`DELETE FROM task_schedules WHERE id = $1 AND client_id = $2`, id, clientID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListDueTaskSchedules returns the active schedules whose next run is at or
+// before now
+func ListDueTaskSchedules(db *sqlx.DB, now time.Time) ([]models.TaskSchedule, error) {
+	schedules := []models.TaskSchedule{}
+	err := db.Select(&schedules, `
+		SELECT * FROM task_schedules
+		WHERE active AND next_run_at <= $1
+		ORDER BY next_run_at
+	`, now)
+	return schedules, err
+}
+
+// ClaimTaskSchedule moves a due schedule on to its next run. Returns false
+// when another run claimed it first or it was changed meanwhile.
+func ClaimTaskSchedule(db *sqlx.DB, id int64, due time.Time, next *time.Time) (bool, error) {
+	res, err := db.Exec(`
+		UPDATE task_schedules SET next_run_at = $1, active = $1 IS NOT NULL, last_run_at = NOW()
+		WHERE id = $2 AND active AND next_run_at = $3
+	`, next, id, due)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetTaskScheduleResult records the task a run posted, or why it posted
+// none
+func SetTaskScheduleResult(db *sqlx.DB, id int64, taskID *int64, runErr string) error {
+	_, err := db.Exec(`
+		UPDATE task_schedules SET last_task_id = COALESCE($1, last_task_id), last_error = $2
+		WHERE id = $3
+	`, taskID, runErr, id)
+	return err
+}
+
+// GetScheduleRehireOffer returns the accepted offer of the newest task
+// posted by a schedule or from its template that a freelancer took
+func GetScheduleRehireOffer(db *sqlx.DB, s *models.TaskSchedule) (*models.TaskOffer, error) {
+	var offer models.TaskOffer
+	err := db.Get(&offer, `
+		SELECT o.* FROM task_offers o
+		JOIN tasks t ON t.id = o.task_id
+		WHERE (t.schedule_id = $1 OR t.template_id = $2) AND t.client_id = $3 AND o.status = 'accepted'
+		ORDER BY t.created_at DESC
+		LIMIT 1
+	`, s.ID, s.TemplateID, s.ClientID)
+	return &offer, err
+}
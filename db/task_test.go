@@ -0,0 +1,20 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"mFrelance/models"
+)
+
+func TestCreateTaskParamsBindQuery(t *testing.T) {
+	orgID := int64(3)
+	query, args, err := sqlx.Named(createTaskQuery, createTaskParams(&models.Task{OrgID: &orgID}))
+	if err != nil {
+		t.Fatalf("query doesn't bind: %v", err)
+	}
+	if query == "" || len(args) != 14 {
+		t.Fatalf("query=%q args=%d", query, len(args))
+	}
+}
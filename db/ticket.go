@@ -1,7 +1,11 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
 	"mFrelance/models"
+	"strconv"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -11,9 +15,9 @@ func GetTicketByID(db *sqlx.DB, id int64) (*models.Ticket, error) {
 	return models.GetTicketByID(db, id)
 }
 
-// CreateTicket creates a new ticket
-func CreateTicket(db *sqlx.DB, subject string, userID int64) (int64, error) {
-	return models.CreateTicket(db, subject, userID)
+// CreateTicket creates a new ticket with its SLA timers running
+func CreateTicket(db *sqlx.DB, subject string, userID int64, category, priority string, sla models.TicketSLA) (int64, error) {
+	return models.CreateTicket(db, subject, userID, category, priority, sla)
 }
 
 // GetRandomPendingTicket retrieves a random pending ticket
@@ -75,3 +79,203 @@ func GetTicketMessages(db *sqlx.DB, ticketID int64) ([]models.TicketMessage, err
 func MarkTicketMessagesRead(db *sqlx.DB, ticketID int64, userID int64) error {
 	return models.MarkTicketMessagesRead(db, ticketID, userID)
 }
+
+// ErrTicketLinkTarget is returned when a ticket is linked to something that
+// doesn't exist
+var ErrTicketLinkTarget = errors.New("link target not found")
+
+// ticketQueueOrder puts urgent tickets first and the oldest first within a
+// priority
+const ticketQueueOrder = ` ORDER BY array_position(ARRAY['urgent','high','normal','low']::VARCHAR[], priority), created_at, id`
+
+// RecordTicketFirstResponse stops the first response timer of a ticket if
+// it is still running
+func RecordTicketFirstResponse(db *sqlx.DB, ticketID int64) error {
+	_, err := db.Exec(`UPDATE tickets SET first_response_at = NOW() WHERE id = $1 AND first_response_at IS NULL`, ticketID)
+	return err
+}
+
+// TriageTicket sets the priority and category of a ticket. The SLA due
+// dates are recalculated from the creation of the ticket.
+func TriageTicket(db *sqlx.DB, ticketID int64, priority, category string, sla models.TicketSLA) (*models.Ticket, error) {
+	res, err := db.Exec(`
+		UPDATE tickets
+		SET priority = $2, category = $3,
+			first_response_due_at = created_at + $4 * INTERVAL '1 second',
+			resolution_due_at = created_at + $5 * INTERVAL '1 second',
+			updated_at = NOW()
+		WHERE id = $1
+	`, ticketID, priority, category, sla.FirstResponse.Seconds(), sla.Resolution.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return models.GetTicketByID(db, ticketID)
+}
+
+// ListTicketQueue returns the tickets waiting for an admin, most urgent
+// first
+func ListTicketQueue(db *sqlx.DB, f models.TicketQueueFilter) ([]models.Ticket, error) {
+	where := []string{"admin_id IS NULL", "status <> 'closed'"}
+	var args []interface{}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	if f.Priority != "" {
+		add("priority = ?", f.Priority)
+	}
+	if f.Category != "" {
+		add("category = ?", f.Category)
+	}
+	if f.Breached {
+		where = append(where, "(COALESCE(first_response_at, NOW()) > first_response_due_at OR NOW() > resolution_due_at)")
+	}
+	args = append(args, f.Limit, f.Offset)
+	query := `SELECT ` + models.TicketColumns + ` FROM tickets WHERE ` + strings.Join(where, " AND ") + ticketQueueOrder +
+		" LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	tickets := []models.Ticket{}
+	err := db.Select(&tickets, query, args...)
+	return tickets, err
+}
+
+// TakeTicket assigns a waiting ticket to the admin, the given one or with
+// ticketID 0 the head of the queue. It returns sql.ErrNoRows when there is
+// nothing to take.
+func TakeTicket(db *sqlx.DB, adminID, ticketID int64) (*models.Ticket, error) {
+	var id int64
+	err := db.Get(&id, `
+		UPDATE tickets SET admin_id = $1, status = 'pending', updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM tickets
+			WHERE admin_id IS NULL AND status <> 'closed' AND ($2 = 0 OR id = $2)
+			`+ticketQueueOrder+`
+			LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id
+	`, adminID, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	return models.GetTicketByID(db, id)
+}
+
+// AddTicketNote adds an internal note to a ticket
+func AddTicketNote(db *sqlx.DB, ticketID, authorID int64, note string) (*models.TicketNote, error) {
+	n := models.TicketNote{TicketID: ticketID, AuthorID: &authorID, Note: note}
+	err := db.QueryRow(`
+		INSERT INTO ticket_notes (ticket_id, author_id, note) VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`, ticketID, authorID, note).Scan(&n.ID, &n.CreatedAt)
+	return &n, err
+}
+
+// ListTicketNotes returns the notes of a ticket, oldest first
+func ListTicketNotes(db *sqlx.DB, ticketID int64) ([]models.TicketNote, error) {
+	notes := []models.TicketNote{}
+	err := db.Select(&notes, `
+		SELECT n.id, n.ticket_id, n.author_id, u.username AS author_username, n.note, n.created_at
+		FROM ticket_notes n
+		LEFT JOIN users u ON u.id = n.author_id
+		WHERE n.ticket_id = $1
+		ORDER BY n.id
+	`, ticketID)
+	return notes, err
+}
+
+// AddTicketLink links a ticket to a task, dispute or transaction. Linking
+// twice is not an error.
+func AddTicketLink(db *sqlx.DB, ticketID int64, targetType string, targetID, createdBy int64) (*models.TicketLink, error) {
+	table := map[string]string{
+		models.TicketLinkTask:        "tasks",
+		models.TicketLinkDispute:     "disputes",
+		models.TicketLinkTransaction: "transactions",
+	}[targetType]
+	if table == "" {
+		return nil, ErrTicketLinkTarget
+	}
+	var exists bool
+	if err := db.Get(&exists, `SELECT EXISTS (SELECT 1 FROM `+table+` WHERE id = $1)`, targetID); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrTicketLinkTarget
+	}
+	var l models.TicketLink
+	err := db.Get(&l, `
+		INSERT INTO ticket_links (ticket_id, target_type, target_id, created_by) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (ticket_id, target_type, target_id) DO UPDATE SET ticket_id = EXCLUDED.ticket_id
+		RETURNING ticket_id, target_type, target_id, created_by, created_at
+	`, ticketID, targetType, targetID, createdBy)
+	return &l, err
+}
+
+// ListTicketLinks returns what a ticket is linked to
+func ListTicketLinks(db *sqlx.DB, ticketID int64) ([]models.TicketLink, error) {
+	links := []models.TicketLink{}
+	err := db.Select(&links, `SELECT * FROM ticket_links WHERE ticket_id = $1 ORDER BY created_at`, ticketID)
+	return links, err
+}
+
+// DeleteTicketLink removes a link, sql.ErrNoRows if there is none
+func DeleteTicketLink(db *sqlx.DB, ticketID int64, targetType string, targetID int64) error {
+	res, err := db.Exec(`DELETE FROM ticket_links WHERE ticket_id = $1 AND target_type = $2 AND target_id = $3`, ticketID, targetType, targetID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListCannedResponses returns the canned responses by title. With a
+// category only the ones for that category and the general ones are
+// returned.
+func ListCannedResponses(db *sqlx.DB, category string) ([]models.CannedResponse, error) {
+	list := []models.CannedResponse{}
+	err := db.Select(&list, `
+		SELECT * FROM ticket_canned_responses
+		WHERE $1 = '' OR category IS NULL OR category = $1
+		ORDER BY title, id
+	`, category)
+	return list, err
+}
+
+func GetCannedResponse(db *sqlx.DB, id int64) (*models.CannedResponse, error) {
+	var c models.CannedResponse
+	err := db.Get(&c, `SELECT * FROM ticket_canned_responses WHERE id = $1`, id)
+	return &c, err
+}
+
+// SaveCannedResponse creates the response when its ID is 0 and updates it
+// otherwise
+func SaveCannedResponse(db *sqlx.DB, c *models.CannedResponse) error {
+	if c.ID == 0 {
+		return db.QueryRow(`
+			INSERT INTO ticket_canned_responses (title, body, category, created_by) VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at, updated_at
+		`, c.Title, c.Body, c.Category, c.CreatedBy).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt)
+	}
+	return db.QueryRow(`
+		UPDATE ticket_canned_responses SET title = $2, body = $3, category = $4, updated_at = NOW()
+		WHERE id = $1
+		RETURNING created_by, created_at, updated_at
+	`, c.ID, c.Title, c.Body, c.Category).Scan(&c.CreatedBy, &c.CreatedAt, &c.UpdatedAt)
+}
+
+// DeleteCannedResponse removes a canned response, sql.ErrNoRows if there
+// is none
+func DeleteCannedResponse(db *sqlx.DB, id int64) error {
+	res, err := db.Exec(`DELETE FROM ticket_canned_responses WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	_ "github.com/lib/pq"
+	"mFrelance/auth"
 	"mFrelance/models"
 )
 
@@ -54,6 +55,20 @@ func UnblockUser(db *sqlx.DB, userID int64) error {
 	return nil
 }
 
+// SetUserBlockedTx sets users.blocked and returns the previous value
+func SetUserBlockedTx(tx *sqlx.Tx, userID int64, blocked bool) (bool, error) {
+	var prev bool
+	err := tx.Get(&prev, `SELECT blocked FROM users WHERE id = $1 FOR UPDATE`, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, errors.New("user not found")
+	}
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(`UPDATE users SET blocked = $1 WHERE id = $2`, blocked, userID)
+	return prev, err
+}
+
 func IsUserBlocked(db *sqlx.DB, userID int64) (bool, error) {
 	var blocked bool
 	err := db.QueryRow(`
@@ -83,16 +98,18 @@ func CheckUser(db *sqlx.DB, username, passwordHash string) (int64, error) {
 	return userID, nil
 }
 
-// err = db.RestoreUser(db.Postgres, req.Username, req.Mnemonic)
+// RestoreUser looks the user up by name and checks the recovery phrase
+// against the stored argon2id hash
 func RestoreUser(db *sqlx.DB, wantusername, mnemonic string) (int64, string, error) {
 	var (
-		userID   int64
-		username string
+		userID       int64
+		username     string
+		mnemonicHash sql.NullString
 	)
 	err := db.QueryRow(`
-        SELECT id, username FROM users
-        WHERE mnemonic = $1
-    `, mnemonic).Scan(&userID, &username)
+        SELECT id, username, mnemonic_hash FROM users
+        WHERE username = $1
+    `, wantusername).Scan(&userID, &username, &mnemonicHash)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return 0, "", nil
@@ -100,12 +117,63 @@ func RestoreUser(db *sqlx.DB, wantusername, mnemonic string) (int64, string, err
 		return 0, "", err
 	}
 
-	if wantusername != username {
+	if !mnemonicHash.Valid || !auth.VerifyMnemonic(mnemonic, mnemonicHash.String) {
 		return 0, "", errors.New("username does not match mnemonic")
 	}
 	return userID, username, nil
 }
 
+// SetMnemonicHash replaces the recovery phrase hash of a user
+func SetMnemonicHash(db *sqlx.DB, userID int64, mnemonicHash string) error {
+	res, err := db.Exec(`
+        UPDATE users
+        SET mnemonic_hash = $1, mnemonic = NULL
+        WHERE id = $2
+    `, mnemonicHash, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errors.New("user not found")
+	}
+
+	return nil
+}
+
+// MigrateMnemonicHashes hashes recovery phrases that are still stored in
+// plaintext and wipes the plaintext column. Safe to run on every boot.
+func MigrateMnemonicHashes(db *sqlx.DB) (int, error) {
+	var rows []struct {
+		ID       int64  `db:"id"`
+		Mnemonic string `db:"mnemonic"`
+	}
+	err := db.Select(&rows, `
+        SELECT id, mnemonic FROM users
+        WHERE mnemonic IS NOT NULL AND mnemonic_hash IS NULL
+    `)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, row := range rows {
+		hash, err := auth.HashMnemonic(row.Mnemonic)
+		if err != nil {
+			return migrated, err
+		}
+		if err := SetMnemonicHash(db, row.ID, hash); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
 func ChangeUserPassword(db *sqlx.DB, username, passwordHash string) error {
 	res, err := db.Exec(`
         UPDATE users
@@ -127,14 +195,16 @@ func ChangeUserPassword(db *sqlx.DB, username, passwordHash string) error {
 	return nil
 }
 
-func CreateUser(db *sqlx.DB, username, passwordHash, mnemonic string) error {
+// CreateUser stores a new user. mnemonicHash must already be hashed with
+// auth.HashMnemonic, the plaintext phrase is never persisted.
+func CreateUser(db *sqlx.DB, username, passwordHash, mnemonicHash string) error {
 	//if userID, _, errFoundUser := GetUserByUsername(db, username); errFoundUser == nil && userID != 0 {
 	//	return errors.New("User exists")
 	//}
 	_, err := db.Exec(`
-        INSERT INTO users (username, password_hash, mnemonic, created_at)
+        INSERT INTO users (username, password_hash, mnemonic_hash, created_at)
         VALUES ($1, $2, $3, $4)
-    `, username, passwordHash, mnemonic, time.Now())
+    `, username, passwordHash, mnemonicHash, time.Now())
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok {
 			if pqErr.Code == "23505" {
@@ -187,9 +257,11 @@ func GetAllUserIDs(db *sqlx.DB) ([]int64, error) {
 	return userIDs, nil
 }
 
+// IsAdmin reports whether the user holds any role: rights come from roles,
+// users.is_admin is only the badge shown on profiles
 func IsAdmin(db *sqlx.DB, userID int64) (bool, error) {
 	var isAdmin bool
-	query := `SELECT is_admin FROM users WHERE id = $1`
+	query := `SELECT EXISTS (SELECT 1 FROM user_roles WHERE user_id = $1) FROM users WHERE id = $1`
 	log.Printf("Executing SQL: %s with userID=%d", query, userID)
 	err := db.QueryRow(query, userID).Scan(&isAdmin)
 	if err != nil {
@@ -202,6 +274,40 @@ func IsAdmin(db *sqlx.DB, userID int64) (bool, error) {
 	log.Printf("User %d is_admin=%v", userID, isAdmin)
 	return isAdmin, nil
 }
+
+// SetUserAdminTx sets users.is_admin, grants or revokes RoleAdmin to match
+// and returns the previous value. The caller invalidates the cached
+// permissions after commit.
+func SetUserAdminTx(tx *sqlx.Tx, userID int64, isAdmin bool) (bool, error) {
+	var prev bool
+	err := tx.Get(&prev, `SELECT is_admin FROM users WHERE id = $1 FOR UPDATE`, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, errors.New("user not found")
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err = tx.Exec(`UPDATE users SET is_admin = $1 WHERE id = $2`, isAdmin, userID); err != nil {
+		return false, err
+	}
+	if isAdmin {
+		_, err = tx.Exec(`
+			INSERT INTO user_roles (user_id, role_id)
+			SELECT $1, id FROM roles WHERE name = $2
+			ON CONFLICT DO NOTHING
+		`, userID, RoleAdmin)
+	} else {
+		_, err = tx.Exec(`
+			DELETE FROM user_roles
+			WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)
+		`, userID, RoleAdmin)
+	}
+	if err != nil {
+		return false, err
+	}
+	return prev, bumpPermVersionTx(tx, []int64{userID})
+}
+
 func MakeAdmin(db *sqlx.DB, userID int64) error {
 	res, err := db.Exec(`
         UPDATE users SET is_admin = TRUE WHERE id = $1
@@ -242,17 +348,42 @@ func GetUserByID(id int64) (*models.User, error) {
 	return &user, err
 }
 
+// AddPermission grants the roles matching a legacy permission bit
 func AddPermission(db *sqlx.DB, userID int64, perm int) error {
-	_, err := db.Exec("UPDATE users SET permissions = permissions | $1 WHERE id = $2", perm, userID)
-	return err
+	for _, role := range LegacyRoles(perm) {
+		if err := AssignRole(db, userID, role, 0, nil); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// RemovePermission revokes the roles matching a legacy permission bit
 func RemovePermission(db *sqlx.DB, userID int64, perm int) error {
-	_, err := db.Exec("UPDATE users SET permissions = permissions & ~$1 WHERE id = $2", perm, userID)
-	return err
+	for _, role := range LegacyRoles(perm) {
+		if err := RevokeRole(db, userID, role, nil); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// SetPermissions replaces the user's legacy-mapped roles with the ones
+// matching the bitmask
 func SetPermissions(db *sqlx.DB, userID int64, permissions int) error {
-	_, err := db.Exec("UPDATE users SET permissions = $1 WHERE id = $2", permissions, userID)
-	return err
+	want := LegacyRoles(permissions)
+	for _, role := range []string{"finance", "support", "arbiter", RoleSuperadmin} {
+		keep := false
+		for _, w := range want {
+			keep = keep || w == role
+		}
+		if keep {
+			if err := AssignRole(db, userID, role, 0, nil); err != nil {
+				return err
+			}
+		} else if err := RevokeRole(db, userID, role, nil); err != nil {
+			return err
+		}
+	}
+	return nil
 }
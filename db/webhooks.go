@@ -0,0 +1,172 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"mFrelance/models"
+)
+
+// Webhook delivery states
+const (
+	WebhookPending   = "pending"
+	WebhookSucceeded = "succeeded"
+	WebhookDead      = "dead"
+)
+
+func CreateWebhookEndpoint(db *sqlx.DB, e *models.WebhookEndpoint) error {
+	return db.QueryRow(`
+		INSERT INTO webhook_endpoints (owner_id, url, secret, events, global, active, description)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at
+	`, e.OwnerID, e.URL, e.Secret, e.Events, e.Global, e.Active, e.Description).Scan(&e.ID, &e.CreatedAt, &e.UpdatedAt)
+}
+
+func GetWebhookEndpoint(db *sqlx.DB, id int64) (*models.WebhookEndpoint, error) {
+	var e models.WebhookEndpoint
+	err := db.Get(&e, `SELECT * FROM webhook_endpoints WHERE id = $1`, id)
+	return &e, err
+}
+
+// ListWebhookEndpoints returns the endpoints of ownerID, or all endpoints
+// when ownerID is 0
+func ListWebhookEndpoints(db *sqlx.DB, ownerID int64) ([]models.WebhookEndpoint, error) {
+	list := []models.WebhookEndpoint{}
+	if ownerID == 0 {
+		err := db.Select(&list, `SELECT * FROM webhook_endpoints ORDER BY id`)
+		return list, err
+	}
+	err := db.Select(&list, `SELECT * FROM webhook_endpoints WHERE owner_id = $1 ORDER BY id`, ownerID)
+	return list, err
+}
+
+func UpdateWebhookEndpoint(db *sqlx.DB, e *models.WebhookEndpoint) error {
+	return db.QueryRow(`
+		UPDATE webhook_endpoints
+		SET url = $1, events = $2, global = $3, active = $4, description = $5, updated_at = NOW()
+		WHERE id = $6
+		RETURNING updated_at
+	`, e.URL, e.Events, e.Global, e.Active, e.Description, e.ID).Scan(&e.UpdatedAt)
+}
+
+func SetWebhookSecret(db *sqlx.DB, id int64, secret string) error {
+	_, err := db.Exec(`UPDATE webhook_endpoints SET secret = $1, updated_at = NOW() WHERE id = $2`, secret, id)
+	return err
+}
+
+func DeleteWebhookEndpoint(db *sqlx.DB, id int64) error {
+	_, err := db.Exec(`DELETE FROM webhook_endpoints WHERE id = $1`, id)
+	return err
+}
+
+// EnqueueWebhookDeliveries queues the payload for every active endpoint
+// subscribed to event that is either global or owned by one of userIDs
+func EnqueueWebhookDeliveries(db *sqlx.DB, event, eventID, payload string, userIDs []int64) (int64, error) {
+	res, err := db.Exec(`
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, event, payload)
+		SELECT id, $1::text, $2::text, $3::jsonb FROM webhook_endpoints
+		WHERE active AND $2::text = ANY(events) AND (global OR owner_id = ANY($4))
+	`, eventID, event, payload, pq.Array(userIDs))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// EnqueueWebhookDelivery queues the payload for one endpoint regardless of
+// its subscriptions, used for test pings
+func EnqueueWebhookDelivery(db *sqlx.DB, endpointID int64, event, eventID, payload string) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	err := db.Get(&d, `
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, event, payload)
+		VALUES ($1, $2, $3, $4)
+		RETURNING *
+	`, endpointID, eventID, event, payload)
+	return &d, err
+}
+
+// ClaimWebhookDeliveries picks due deliveries and pushes their next attempt
+// back by lease, so other instances skip them while they are being sent
+func ClaimWebhookDeliveries(db *sqlx.DB, limit int, lease time.Duration) ([]models.WebhookJob, error) {
+	jobs := []models.WebhookJob{}
+	err := db.Select(&jobs, `
+		WITH due AS (
+			SELECT d.id FROM webhook_deliveries d
+			WHERE d.status = 'pending' AND d.next_attempt_at <= NOW()
+			ORDER BY d.next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + $2::float8 * INTERVAL '1 second'
+		FROM due, webhook_endpoints e
+		WHERE d.id = due.id AND e.id = d.endpoint_id
+		RETURNING d.*, e.url, e.secret
+	`, limit, lease.Seconds())
+	return jobs, err
+}
+
+// RecordWebhookAttempt logs an attempt and moves the delivery to status.
+// next is only used while the delivery stays pending.
+func RecordWebhookAttempt(db *sqlx.DB, a *models.WebhookAttempt, status string, next time.Time) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(`
+		INSERT INTO webhook_attempts (delivery_id, attempt, status_code, error, response, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, a.DeliveryID, a.Attempt, a.StatusCode, a.Error, a.Response, a.DurationMS).Scan(&a.ID, &a.CreatedAt); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $1::text, attempts = $2, last_status_code = $3, last_error = $4, next_attempt_at = $5,
+		    delivered_at = CASE WHEN $1::text = 'succeeded' THEN NOW() ELSE delivered_at END
+		WHERE id = $6
+	`, status, a.Attempt, a.StatusCode, a.Error, next, a.DeliveryID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func GetWebhookDelivery(db *sqlx.DB, id int64) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	err := db.Get(&d, `SELECT * FROM webhook_deliveries WHERE id = $1`, id)
+	return &d, err
+}
+
+// ListWebhookDeliveries returns the deliveries of an endpoint, newest first,
+// optionally filtered by status
+func ListWebhookDeliveries(db *sqlx.DB, endpointID int64, status string, limit, offset int) ([]models.WebhookDelivery, error) {
+	list := []models.WebhookDelivery{}
+	err := db.Select(&list, `
+		SELECT * FROM webhook_deliveries
+		WHERE endpoint_id = $1 AND ($2::text = '' OR status = $2::text)
+		ORDER BY id DESC
+		LIMIT $3 OFFSET $4
+	`, endpointID, status, limit, offset)
+	return list, err
+}
+
+func ListWebhookAttempts(db *sqlx.DB, deliveryID int64) ([]models.WebhookAttempt, error) {
+	list := []models.WebhookAttempt{}
+	err := db.Select(&list, `SELECT * FROM webhook_attempts WHERE delivery_id = $1 ORDER BY attempt`, deliveryID)
+	return list, err
+}
+
+// RedeliverWebhook puts a delivery back in the queue with a fresh set of
+// attempts. The attempt log is kept.
+func RedeliverWebhook(db *sqlx.DB, id int64) error {
+	_, err := db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+		WHERE id = $1
+	`, id)
+	return err
+}
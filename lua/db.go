@@ -113,6 +113,9 @@ func RegisterLuaPostgres(L *lua.LState, dbPg *sqlx.DB) {
 			Budget:      float64(tbl.RawGetInt(5).(lua.LNumber)),
 			Currency:    tbl.RawGetInt(6).String(),
 			Status:      tbl.RawGetInt(7).String(),
+
+			ContractType: models.ContractFixed,
+			Visibility:   models.VisibilityPublic,
 		}
 
 		err := db.CreateTask(dbPg, task)
@@ -251,14 +251,21 @@ func RegisterLuaReviews(L *lua.LState) {
 		reviewedID := L.CheckInt64(3)
 		rating := L.CheckInt(4)
 		comment := L.CheckString(5)
+		// sub-ratings are optional and default to the overall rating
+		communication := L.OptInt(6, rating)
+		quality := L.OptInt(7, rating)
+		timeliness := L.OptInt(8, rating)
 
 		review := &models.Review{
-			TaskID:     taskID,
-			ReviewerID: reviewerID,
-			ReviewedID: reviewedID,
-			Rating:     rating,
-			Comment:    comment,
-			CreatedAt:  time.Now(),
+			TaskID:              taskID,
+			ReviewerID:          reviewerID,
+			ReviewedID:          reviewedID,
+			Rating:              rating,
+			CommunicationRating: &communication,
+			QualityRating:       &quality,
+			TimelinessRating:    &timeliness,
+			Comment:             comment,
+			CreatedAt:           time.Now(),
 		}
 		if err := db.CreateReview(review); err != nil {
 			L.Push(lua.LNil)
@@ -285,7 +292,15 @@ func RegisterLuaReviews(L *lua.LState) {
 			rTbl.RawSetString("reviewer_id", lua.LNumber(r.ReviewerID))
 			rTbl.RawSetString("reviewed_id", lua.LNumber(r.ReviewedID))
 			rTbl.RawSetString("rating", lua.LNumber(r.Rating))
+			if r.CommunicationRating != nil {
+				rTbl.RawSetString("communication_rating", lua.LNumber(*r.CommunicationRating))
+				rTbl.RawSetString("quality_rating", lua.LNumber(*r.QualityRating))
+				rTbl.RawSetString("timeliness_rating", lua.LNumber(*r.TimelinessRating))
+			}
 			rTbl.RawSetString("comment", lua.LString(r.Comment))
+			if r.Reply != nil {
+				rTbl.RawSetString("reply", lua.LString(*r.Reply))
+			}
 			rTbl.RawSetString("created_at", lua.LString(r.CreatedAt.Format(time.RFC3339)))
 			tbl.Append(rTbl)
 		}
@@ -608,6 +623,7 @@ func RegisterLuaHelpers(L *lua.LState, rdb *redis.Client, psql *sqlx.DB) {
 			L.Push(lua.LString(err.Error()))
 			return 2
 		}
+		server.InvalidatePermissions(userID)
 		L.Push(lua.LBool(true))
 		return 1
 	}))
@@ -621,6 +637,7 @@ func RegisterLuaHelpers(L *lua.LState, rdb *redis.Client, psql *sqlx.DB) {
 			L.Push(lua.LString(err.Error()))
 			return 2
 		}
+		server.InvalidatePermissions(userID)
 		L.Push(lua.LBool(true))
 		return 1
 	}))
@@ -634,6 +651,35 @@ func RegisterLuaHelpers(L *lua.LState, rdb *redis.Client, psql *sqlx.DB) {
 			L.Push(lua.LString(err.Error()))
 			return 2
 		}
+		server.InvalidatePermissions(userID)
+		L.Push(lua.LBool(true))
+		return 1
+	}))
+
+	L.SetGlobal("assign_role", L.NewFunction(func(L *lua.LState) int {
+		userID := int64(L.ToInt(1))
+		role := L.ToString(2)
+		err := db.AssignRole(psql, userID, role, 0, nil)
+		if err != nil {
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		server.InvalidatePermissions(userID)
+		L.Push(lua.LBool(true))
+		return 1
+	}))
+
+	L.SetGlobal("revoke_role", L.NewFunction(func(L *lua.LState) int {
+		userID := int64(L.ToInt(1))
+		role := L.ToString(2)
+		err := db.RevokeRole(psql, userID, role, nil)
+		if err != nil {
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		server.InvalidatePermissions(userID)
 		L.Push(lua.LBool(true))
 		return 1
 	}))
@@ -682,8 +728,10 @@ func RegisterLuaHelpers(L *lua.LState, rdb *redis.Client, psql *sqlx.DB) {
 		tbl.RawSetString("skills", skillsTbl)
 
 		tbl.RawSetString("avatar", lua.LString(profile.Avatar))
+		tbl.RawSetString("avatar_url", lua.LString(profile.AvatarURL))
 		tbl.RawSetString("rating", lua.LNumber(profile.Rating))
 		tbl.RawSetString("completed_tasks", lua.LNumber(profile.CompletedTasks))
+		tbl.RawSetString("reputation", lua.LNumber(profile.Reputation))
 
 		L.Push(tbl)
 		return 1
@@ -703,12 +751,20 @@ func RegisterLuaHelpers(L *lua.LState, rdb *redis.Client, psql *sqlx.DB) {
 			}
 		})
 
+		// keeps the hourly rate, which scripts don't manage
+		current, err := models.GetProfile(psql, userID)
+		if err != nil {
+			L.RaiseError("GetProfile error: %v", err)
+			return 0
+		}
 		profile := &models.Profile{
-			UserID:   userID,
-			FullName: fullName,
-			Bio:      bio,
-			Skills:   skills,
-			Avatar:   avatar,
+			UserID:         userID,
+			FullName:       fullName,
+			Bio:            bio,
+			Skills:         skills,
+			Avatar:         avatar,
+			HourlyRate:     current.HourlyRate,
+			HourlyCurrency: current.HourlyCurrency,
 		}
 
 		if err := models.UpsertProfile(psql, profile); err != nil {
@@ -744,8 +800,10 @@ func RegisterLuaHelpers(L *lua.LState, rdb *redis.Client, psql *sqlx.DB) {
 			pTbl.RawSetString("skills", skillsTbl)
 
 			pTbl.RawSetString("avatar", lua.LString(p.Avatar))
+			pTbl.RawSetString("avatar_url", lua.LString(p.AvatarURL))
 			pTbl.RawSetString("rating", lua.LNumber(p.Rating))
 			pTbl.RawSetString("completed_tasks", lua.LNumber(p.CompletedTasks))
+			pTbl.RawSetString("reputation", lua.LNumber(p.Reputation))
 
 			tbl.Append(pTbl)
 		}
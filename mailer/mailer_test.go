@@ -0,0 +1,132 @@
+package mailer_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"mFrelance/mailer"
+)
+
+// smtpStandIn accepts one message and hands its envelope and data to got
+func smtpStandIn(t *testing.T) (host string, port int, got chan []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got = make(chan []string, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		var lines []string
+		reply("220 localhost ESMTP stand-in")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM"), strings.HasPrefix(cmd, "RCPT TO"):
+				lines = append(lines, line)
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					l = strings.TrimRight(l, "\r\n")
+					if l == "." {
+						break
+					}
+					lines = append(lines, l)
+				}
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				got <- lines
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, got
+}
+
+func TestSMTPSender_Send(t *testing.T) {
+	host, port, got := smtpStandIn(t)
+	s := &mailer.SMTPSender{Host: host, Port: port, From: "Symbio <no-reply@example.com>", TLS: mailer.TLSNone}
+
+	msg, err := mailer.Render("ru", "en", "deposit_credited", map[string]interface{}{
+		"Data": map[string]interface{}{"amount": "0.5", "currency": "XMR", "txid": "abc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.To = "user@example.com"
+	if err := s.Send(msg); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	lines := <-got
+	all := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"MAIL FROM:<no-reply@example.com>",
+		"RCPT TO:<user@example.com>",
+		"To: user@example.com",
+		"Subject: =?utf-8?q?",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Type: text/html; charset=utf-8",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("message is missing %q:\n%s", want, all)
+		}
+	}
+}
+
+func TestRender_FallsBackAndEscapes(t *testing.T) {
+	data := map[string]interface{}{
+		"Data": map[string]interface{}{"username": "<b>bob</b>"},
+	}
+	msg, err := mailer.Render("xx", "yy", "password_restored", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Your password was changed" {
+		t.Fatalf("expected the English fallback, got %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "<b>bob</b>") {
+		t.Errorf("text part must not be escaped: %q", msg.Text)
+	}
+	if strings.Contains(msg.HTML, "<b>bob</b>") || !strings.Contains(msg.HTML, "&lt;b&gt;bob&lt;/b&gt;") {
+		t.Errorf("html part must be escaped: %q", msg.HTML)
+	}
+
+	if _, err := mailer.Render("en", "en", "no_such_template", nil); err == nil {
+		t.Fatalf("unknown template rendered")
+	}
+}
+
+func TestBuild_RejectsHeaderInjection(t *testing.T) {
+	_, err := mailer.Build("a@example.com", mailer.Message{To: "b@example.com\r\nBcc: c@example.com", Subject: "hi", Text: "x"})
+	if err == nil {
+		t.Fatalf("line break in To accepted")
+	}
+}
@@ -0,0 +1,126 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"mFrelance/config"
+	"mFrelance/db"
+	"mFrelance/models"
+)
+
+// Enqueue renders template name for locale and adds it to the outbox.
+// userID may be 0 for messages that don't belong to an account.
+func Enqueue(userID int64, to, locale, name string, data interface{}) error {
+	msg, err := Render(locale, config.AppConfig.EmailDefaultLocale, name, data)
+	if err != nil {
+		return err
+	}
+	m := &models.OutboxEmail{
+		ToAddress: to,
+		Template:  name,
+		Subject:   msg.Subject,
+		TextBody:  msg.Text,
+		HTMLBody:  msg.HTML,
+	}
+	if userID > 0 {
+		m.UserID = &userID
+	}
+	return db.EnqueueEmail(db.Postgres, m)
+}
+
+// Worker sends the outbox
+type Worker struct {
+	Sender      Sender
+	MaxAttempts int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	BatchSize   int
+	Interval    time.Duration
+}
+
+// NewWorker builds a worker sending through the SMTP server from the config
+func NewWorker() *Worker {
+	c := config.AppConfig
+	return &Worker{
+		Sender: &SMTPSender{
+			Host:     c.SMTPHost,
+			Port:     c.SMTPPort,
+			Username: c.SMTPUsername,
+			Password: c.SMTPPassword,
+			From:     c.SMTPFrom,
+			TLS:      c.SMTPTLS,
+		},
+		MaxAttempts: c.SMTPMaxAttempts,
+		BackoffBase: c.SMTPBackoffBase,
+		BackoffMax:  c.SMTPBackoffMax,
+		BatchSize:   c.SMTPBatchSize,
+		Interval:    c.SMTPSendInterval,
+	}
+}
+
+// Start sends the outbox until ctx is done
+func (wk *Worker) Start(ctx context.Context) {
+	ticker := time.NewTicker(wk.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			wk.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce claims a batch of due messages and sends them
+func (wk *Worker) RunOnce(ctx context.Context) {
+	lease := smtpTimeout*time.Duration(wk.BatchSize) + time.Minute
+	list, err := db.ClaimOutboxEmails(db.Postgres, wk.BatchSize, lease)
+	if err != nil {
+		log.Printf("[mailer] failed to claim outbox: %v", err)
+		return
+	}
+	for _, m := range list {
+		if ctx.Err() != nil {
+			return
+		}
+		wk.send(m)
+	}
+}
+
+func (wk *Worker) send(m models.OutboxEmail) {
+	attempts := m.Attempts + 1
+	err := wk.Sender.Send(Message{
+		To:      m.ToAddress,
+		Subject: m.Subject,
+		Text:    m.TextBody,
+		HTML:    m.HTMLBody,
+	})
+
+	status, lastError, next := db.EmailSent, "", time.Now()
+	if err != nil {
+		status, lastError = db.EmailPending, err.Error()
+		next = next.Add(backoff(attempts, wk.BackoffBase, wk.BackoffMax))
+		if attempts >= wk.MaxAttempts {
+			status = db.EmailDead
+			log.Printf("[mailer] giving up on email %d to %s after %d attempts: %v", m.ID, m.ToAddress, attempts, err)
+		}
+	}
+	if err := db.MarkOutboxEmail(db.Postgres, m.ID, status, attempts, lastError, next); err != nil {
+		log.Printf("[mailer] failed to update email %d: %v", m.ID, err)
+	}
+}
+
+// backoff returns base * 2^(attempt-1), capped at max
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
@@ -0,0 +1,96 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Templates live in templates/<locale>/<name>.tmpl and define three blocks:
+// "subject" and "text" are rendered as plain text, "html" with HTML escaping.
+//
+//go:embed templates
+var templateFS embed.FS
+
+// FallbackLocale is used when neither the requested nor the configured
+// default locale has the template
+const FallbackLocale = "en"
+
+type template struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// templates by locale and name
+var templates = mustLoadTemplates()
+
+func mustLoadTemplates() map[string]map[string]*template {
+	all := make(map[string]map[string]*template)
+	files, err := fs.Glob(templateFS, "templates/*/*.tmpl")
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range files {
+		locale := path.Base(path.Dir(f))
+		name := strings.TrimSuffix(path.Base(f), ".tmpl")
+		t := &template{
+			text: texttemplate.Must(texttemplate.ParseFS(templateFS, f)),
+			html: htmltemplate.Must(htmltemplate.ParseFS(templateFS, f)),
+		}
+		if all[locale] == nil {
+			all[locale] = make(map[string]*template)
+		}
+		all[locale][name] = t
+	}
+	return all
+}
+
+// Locales returns the locales that have templates
+func Locales() []string {
+	list := make([]string, 0, len(templates))
+	for l := range templates {
+		list = append(list, l)
+	}
+	return list
+}
+
+// HasLocale reports whether there are templates for locale
+func HasLocale(locale string) bool {
+	_, ok := templates[locale]
+	return ok
+}
+
+// Render renders template name in locale, falling back to defaultLocale
+// and then FallbackLocale. The returned message has no recipient.
+func Render(locale, defaultLocale, name string, data interface{}) (Message, error) {
+	var t *template
+	for _, l := range []string{locale, defaultLocale, FallbackLocale} {
+		if t = templates[l][name]; t != nil {
+			break
+		}
+	}
+	if t == nil {
+		return Message{}, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := t.text.ExecuteTemplate(&text, "text", data); err != nil {
+		return Message{}, err
+	}
+	if err := t.html.ExecuteTemplate(&html, "html", data); err != nil {
+		return Message{}, err
+	}
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    strings.TrimSpace(html.String()) + "\n",
+	}, nil
+}
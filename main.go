@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"github.com/gabstv/httpdigest"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	httpSwagger "github.com/swaggo/http-swagger"
 	"github.com/tetratelabs/wazero"
@@ -14,8 +15,13 @@ import (
 	_ "mFrelance/docs"
 	"mFrelance/electrum"
 	"mFrelance/lua"
+	"mFrelance/mailer"
+	"mFrelance/notifications"
+	"mFrelance/realtime"
+	"mFrelance/reputation"
 	"mFrelance/server"
 	serverhandlers "mFrelance/server/handlers"
+	"mFrelance/webhooks"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -35,6 +41,13 @@ func main() {
 	ctxWasm := context.Background()
 	config.Init()
 
+	if args := pflag.Args(); len(args) > 0 && args[0] == "migrate" {
+		if err := db.RunMigrateCommand(args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	electrumClient := electrum.NewClient(
 		config.AppConfig.ElectrumUser,
 		config.AppConfig.ElectrumPassword,
@@ -65,6 +78,21 @@ func main() {
 
 	db.Connect()
 	db.Migrate(db.Postgres)
+	if n, err := db.MigrateMnemonicHashes(db.Postgres); err != nil {
+		log.Fatal("Failed to hash stored recovery phrases:", err)
+	} else if n > 0 {
+		log.Printf("Hashed %d plaintext recovery phrases", n)
+	}
+	if n, err := db.MigrateLegacyPermissions(db.Postgres); err != nil {
+		log.Fatal("Failed to convert legacy permissions to roles:", err)
+	} else if n > 0 {
+		log.Printf("Converted legacy permissions of %d users to roles", n)
+	}
+	if n, err := serverhandlers.MigrateLegacyAvatars(); err != nil {
+		log.Fatal("Failed to convert base64 avatars:", err)
+	} else if n > 0 {
+		log.Printf("Converted %d base64 avatars to thumbnails", n)
+	}
 	db.ConnectRedis()
 
 	L := lua.NewState(db.RedisClient, db.Postgres, electrumClient, moneroClient)
@@ -135,10 +163,12 @@ func main() {
 	s.Handle("/restoreuser", func(w http.ResponseWriter, r *http.Request) {
 		serverhandlers.RestoreHandler(w, r, db.RedisClient)
 	})
+	s.Handle("/verify_email", serverhandlers.VerifyEmailHandler)
 
 	apiMux := http.NewServeMux()
 	apiMux.Handle("/test", server.AuthMiddleware(http.HandlerFunc(serverhandlers.TestHandler)))
 	apiMux.Handle("/ownID", server.AuthMiddleware(http.HandlerFunc(serverhandlers.OwnIdHandler())))
+	apiMux.Handle("/recovery/rotate", server.AuthMiddleware(http.HandlerFunc(serverhandlers.RotateRecoveryHandler)))
 
 	apiMux.Handle("/wallet", server.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		serverhandlers.WalletHandler(w, r, moneroClient, electrumClient)
@@ -154,24 +184,42 @@ func main() {
 		serverhandlers.SendElectrumHandler(w, r, electrumClient)
 	})))
 
-	apiMux.Handle("/admin/make", server.AuthMiddleware(serverhandlers.RequireAdmin(serverhandlers.MakeAdminHandler)))
-	apiMux.Handle("/admin/remove", server.AuthMiddleware(serverhandlers.RequireAdmin(serverhandlers.RemoveAdminHandler)))
+	apiMux.Handle("/admin/make", server.AuthMiddleware(server.RequirePermission(server.PermRoleManage)(serverhandlers.MakeAdminHandler)))
+	apiMux.Handle("/admin/remove", server.AuthMiddleware(server.RequirePermission(server.PermRoleManage)(serverhandlers.RemoveAdminHandler)))
 	apiMux.Handle("/admin/check", server.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		serverhandlers.IsAdminHandler(w, r)
 	})))
 	apiMux.Handle("/admin/IIsAdmin", server.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		serverhandlers.IsIAdminHandler(w, r)
 	})))
+	apiMux.Handle("/admin/permissions", server.AuthMiddleware(server.RequirePermission(server.PermRoleManage)(serverhandlers.ListPermissionsHandler)))
+	apiMux.Handle("/admin/roles", server.AuthMiddleware(server.RequirePermission(server.PermRoleManage)(serverhandlers.RolesHandler)))
+	apiMux.Handle("/admin/roles/delete", server.AuthMiddleware(server.RequirePermission(server.PermRoleManage)(serverhandlers.DeleteRoleHandler)))
+	apiMux.Handle("/admin/roles/assign", server.AuthMiddleware(server.RequirePermission(server.PermRoleManage)(serverhandlers.AssignRoleHandler)))
+	apiMux.Handle("/admin/roles/revoke", server.AuthMiddleware(server.RequirePermission(server.PermRoleManage)(serverhandlers.RevokeRoleHandler)))
+	apiMux.Handle("/admin/users/roles", server.AuthMiddleware(server.RequirePermission(server.PermRoleManage)(serverhandlers.UserRolesHandler)))
+	apiMux.Handle("/admin/audit", server.AuthMiddleware(server.RequirePermission(server.PermAuditView)(serverhandlers.AdminAuditLogHandler)))
 	apiMux.Handle("/admin/block", server.AuthMiddleware(server.RequirePermission(server.PermUserBlock)(serverhandlers.BlockUserHandler)))
 	apiMux.Handle("/admin/unblock", server.AuthMiddleware(server.RequirePermission(server.PermUserBlock)(serverhandlers.UnblockUserHandler)))
-	apiMux.Handle("/admin/transactions", server.AuthMiddleware(serverhandlers.RequireAdmin(serverhandlers.AdminTransactionsHandler)))
-	apiMux.Handle("/admin/wallets", server.AuthMiddleware(serverhandlers.RequireAdmin(serverhandlers.AdminWalletsHandler)))
+	apiMux.Handle("/admin/transactions", server.AuthMiddleware(server.RequirePermission(server.PermTransactionView)(serverhandlers.AdminTransactionsHandler)))
+	apiMux.Handle("/admin/wallets", server.AuthMiddleware(server.RequirePermission(server.PermWalletView)(serverhandlers.AdminWalletsHandler)))
 	apiMux.Handle("/admin/update_balance", server.AuthMiddleware(server.RequirePermission(server.PermBalanceChange)(serverhandlers.AdminUpdateBalanceHandler)))
-	apiMux.Handle("/admin/delete_user_tasks", server.AuthMiddleware(serverhandlers.RequireAdmin(serverhandlers.AdminDeleteUserTasksHandler)))
-	apiMux.Handle("/admin/getRandomTicket", server.AuthMiddleware(serverhandlers.RequireAdmin(serverhandlers.AdminGetRandomTicketHandler)))
-	apiMux.Handle("/admin/tickets", server.AuthMiddleware(serverhandlers.RequireAdmin(serverhandlers.GetAllTicketsHandler)))
-	apiMux.Handle("/admin/addUserToChatRoom", server.AuthMiddleware(serverhandlers.RequireAdmin(serverhandlers.AdminAddUserToChatRoom)))
-	apiMux.Handle("/admin/deleteChatRoom", server.AuthMiddleware(serverhandlers.RequireAdmin(serverhandlers.DeleteChatRoom)))
+	apiMux.Handle("/admin/delete_user_tasks", server.AuthMiddleware(server.RequirePermission(server.PermTaskModerate)(serverhandlers.AdminDeleteUserTasksHandler)))
+	apiMux.Handle("/admin/getRandomTicket", server.AuthMiddleware(server.RequirePermission(server.PermTicketManage)(serverhandlers.AdminGetRandomTicketHandler)))
+	apiMux.Handle("/admin/tickets", server.AuthMiddleware(server.RequirePermission(server.PermTicketManage)(serverhandlers.GetAllTicketsHandler)))
+	apiMux.Handle("/admin/tickets/queue", server.AuthMiddleware(server.RequirePermission(server.PermTicketManage)(serverhandlers.AdminTicketQueueHandler)))
+	apiMux.Handle("/admin/tickets/take", server.AuthMiddleware(server.RequirePermission(server.PermTicketManage)(serverhandlers.TakeTicketHandler)))
+	apiMux.Handle("/admin/tickets/triage", server.AuthMiddleware(server.RequirePermission(server.PermTicketManage)(serverhandlers.TriageTicketHandler)))
+	apiMux.Handle("/admin/tickets/details", server.AuthMiddleware(server.RequirePermission(server.PermTicketManage)(serverhandlers.AdminTicketDetailsHandler)))
+	apiMux.Handle("/admin/tickets/notes", server.AuthMiddleware(server.RequirePermission(server.PermTicketManage)(serverhandlers.TicketNotesHandler)))
+	apiMux.Handle("/admin/tickets/links", server.AuthMiddleware(server.RequirePermission(server.PermTicketManage)(serverhandlers.TicketLinksHandler)))
+	apiMux.Handle("/admin/tickets/links/delete", server.AuthMiddleware(server.RequirePermission(server.PermTicketManage)(serverhandlers.DeleteTicketLinkHandler)))
+	apiMux.Handle("/admin/tickets/canned", server.AuthMiddleware(server.RequirePermission(server.PermTicketManage)(serverhandlers.CannedResponsesHandler)))
+	apiMux.Handle("/admin/tickets/canned/save", server.AuthMiddleware(server.RequirePermission(server.PermCannedResponseManage)(serverhandlers.SaveCannedResponseHandler)))
+	apiMux.Handle("/admin/tickets/canned/delete", server.AuthMiddleware(server.RequirePermission(server.PermCannedResponseManage)(serverhandlers.DeleteCannedResponseHandler)))
+	apiMux.Handle("/admin/addUserToChatRoom", server.AuthMiddleware(server.RequirePermission(server.PermChatManage)(serverhandlers.AdminAddUserToChatRoom)))
+	apiMux.Handle("/admin/deleteChatRoom", server.AuthMiddleware(server.RequirePermission(server.PermChatManage)(serverhandlers.DeleteChatRoom)))
+	apiMux.Handle("/admin/chat/deleteMessage", server.AuthMiddleware(server.RequirePermission(server.PermChatManage)(serverhandlers.AdminDeleteChatMessageHandler)))
 
 	// Task management routes
 	apiMux.Handle("/tasks/create", server.AuthMiddleware(serverhandlers.CreateTaskHandler()))
@@ -179,6 +227,25 @@ func main() {
 	apiMux.Handle("/tasks/get", server.AuthMiddleware(http.HandlerFunc(serverhandlers.GetTaskHandler())))
 	apiMux.Handle("/tasks/update", server.AuthMiddleware(http.HandlerFunc(serverhandlers.UpdateTaskHandler())))
 	apiMux.Handle("/tasks/delete", server.AuthMiddleware(http.HandlerFunc(serverhandlers.DeleteTaskHandler())))
+	apiMux.Handle("/tasks/invites", server.AuthMiddleware(http.HandlerFunc(serverhandlers.TaskInvitesHandler)))
+	apiMux.Handle("/tasks/invites/delete", server.AuthMiddleware(http.HandlerFunc(serverhandlers.RevokeTaskInviteHandler)))
+	apiMux.Handle("/invites", server.AuthMiddleware(http.HandlerFunc(serverhandlers.MyTaskInvitesHandler)))
+	apiMux.Handle("/task_templates", server.AuthMiddleware(http.HandlerFunc(serverhandlers.TaskTemplatesHandler)))
+	apiMux.Handle("/task_templates/delete", server.AuthMiddleware(http.HandlerFunc(serverhandlers.DeleteTaskTemplateHandler)))
+	apiMux.Handle("/task_schedules", server.AuthMiddleware(http.HandlerFunc(serverhandlers.TaskSchedulesHandler)))
+	apiMux.Handle("/task_schedules/delete", server.AuthMiddleware(http.HandlerFunc(serverhandlers.DeleteTaskScheduleHandler)))
+
+	// Organization routes
+	apiMux.Handle("/orgs", server.AuthMiddleware(http.HandlerFunc(serverhandlers.OrganizationsHandler)))
+	apiMux.Handle("/orgs/join", server.AuthMiddleware(http.HandlerFunc(serverhandlers.JoinOrganizationHandler)))
+	apiMux.Handle("/orgs/members", server.AuthMiddleware(http.HandlerFunc(serverhandlers.OrgMembersHandler)))
+	apiMux.Handle("/orgs/members/delete", server.AuthMiddleware(http.HandlerFunc(serverhandlers.RemoveOrgMemberHandler)))
+	apiMux.Handle("/orgs/members/role", server.AuthMiddleware(http.HandlerFunc(serverhandlers.OrgMemberRoleHandler)))
+	apiMux.Handle("/orgs/limits", server.AuthMiddleware(http.HandlerFunc(serverhandlers.OrgSpendingLimitsHandler)))
+	apiMux.Handle("/orgs/wallet", server.AuthMiddleware(http.HandlerFunc(serverhandlers.OrgWalletHandler)))
+	apiMux.Handle("/orgs/wallet/deposit", server.AuthMiddleware(http.HandlerFunc(serverhandlers.OrgWalletDepositHandler)))
+	apiMux.Handle("/orgs/wallet/withdraw", server.AuthMiddleware(http.HandlerFunc(serverhandlers.OrgWalletWithdrawHandler)))
+	apiMux.Handle("/orgs/tasks", server.AuthMiddleware(http.HandlerFunc(serverhandlers.OrgTasksHandler)))
 
 	// Task offers routes
 	apiMux.Handle("/offers/create", server.AuthMiddleware(http.HandlerFunc(serverhandlers.CreateTaskOfferHandler())))
@@ -186,6 +253,10 @@ func main() {
 	apiMux.Handle("/offers/accept", server.AuthMiddleware(http.HandlerFunc(serverhandlers.AcceptTaskOfferHandler())))
 	apiMux.Handle("/offers/update", server.AuthMiddleware(http.HandlerFunc(serverhandlers.UpdateTaskOfferHandler())))
 	apiMux.Handle("/offers/delete", server.AuthMiddleware(http.HandlerFunc(serverhandlers.DeleteTaskOfferHandler())))
+	apiMux.Handle("/offers/counter", server.AuthMiddleware(http.HandlerFunc(serverhandlers.CounterTaskOfferHandler())))
+	apiMux.Handle("/offers/reject", server.AuthMiddleware(http.HandlerFunc(serverhandlers.RejectTaskOfferHandler())))
+	apiMux.Handle("/offers/withdraw", server.AuthMiddleware(http.HandlerFunc(serverhandlers.WithdrawTaskOfferHandler())))
+	apiMux.Handle("/offers/revisions", server.AuthMiddleware(http.HandlerFunc(serverhandlers.GetOfferRevisionsHandler())))
 	apiMux.Handle("/tasks/complete", server.AuthMiddleware(http.HandlerFunc(serverhandlers.CompleteTaskHandler())))
 
 	// Dispute routes
@@ -193,12 +264,17 @@ func main() {
 	apiMux.Handle("/disputes/get", server.AuthMiddleware(http.HandlerFunc(serverhandlers.GetDisputeHandler())))
 	apiMux.Handle("/disputes/message", server.AuthMiddleware(http.HandlerFunc(serverhandlers.SendDisputeMessageHandler())))
 	apiMux.Handle("/disputes/my", server.AuthMiddleware(http.HandlerFunc(serverhandlers.GetUserDisputesHandler())))
+	apiMux.Handle("/disputes/disclose", server.AuthMiddleware(http.HandlerFunc(serverhandlers.DiscloseMessagesHandler)))
+	apiMux.Handle("/disputes/disclosures", server.AuthMiddleware(http.HandlerFunc(serverhandlers.DisclosedMessagesHandler)))
 
 	// Review routes
 	apiMux.Handle("/reviews/create", server.AuthMiddleware(http.HandlerFunc(serverhandlers.CreateReviewHandler())))
 	apiMux.Handle("/reviews/user", server.AuthMiddleware(http.HandlerFunc(serverhandlers.GetReviewsByUserHandler())))
 	apiMux.Handle("/reviews/task", server.AuthMiddleware(http.HandlerFunc(serverhandlers.GetReviewsByTaskHandler())))
 	apiMux.Handle("/reviews/rating", server.AuthMiddleware(http.HandlerFunc(serverhandlers.GetUserRatingHandler())))
+	apiMux.Handle("/reputation", server.AuthMiddleware(http.HandlerFunc(serverhandlers.ReputationHandler)))
+	apiMux.Handle("/reviews/reply", server.AuthMiddleware(http.HandlerFunc(serverhandlers.ReplyReviewHandler())))
+	apiMux.Handle("/admin/reviews/hide", server.AuthMiddleware(server.RequirePermission(server.PermReviewModerate)(http.HandlerFunc(serverhandlers.HideReviewHandler()))))
 
 	// Admin dispute routes
 	apiMux.Handle("/admin/disputes", server.AuthMiddleware(server.RequirePermission(server.PermDisputeManage)(http.HandlerFunc(serverhandlers.GetOpenDisputesHandler()))))
@@ -213,6 +289,26 @@ func main() {
 	apiMux.Handle("/ticket/exit", server.AuthMiddleware(http.HandlerFunc(serverhandlers.ExitFromTicketHandler)))
 	apiMux.Handle("/ticket/close", server.AuthMiddleware(http.HandlerFunc(serverhandlers.CloseTicketHandler)))
 
+	apiMux.Handle("/notifications", server.AuthMiddleware(http.HandlerFunc(serverhandlers.NotificationsHandler)))
+	apiMux.Handle("/notifications/read", server.AuthMiddleware(http.HandlerFunc(serverhandlers.MarkNotificationsReadHandler)))
+	apiMux.Handle("/notifications/unread_count", server.AuthMiddleware(http.HandlerFunc(serverhandlers.NotificationsUnreadCountHandler)))
+	apiMux.Handle("/notifications/preferences", server.AuthMiddleware(http.HandlerFunc(serverhandlers.NotificationPreferencesHandler)))
+
+	// Email
+	apiMux.Handle("/email", server.AuthMiddleware(http.HandlerFunc(serverhandlers.EmailHandler)))
+	apiMux.Handle("/email/resend", server.AuthMiddleware(http.HandlerFunc(serverhandlers.ResendEmailVerificationHandler)))
+	apiMux.Handle("/email/delete", server.AuthMiddleware(http.HandlerFunc(serverhandlers.DeleteEmailHandler)))
+
+	// Webhooks
+	apiMux.Handle("/webhooks", server.AuthMiddleware(http.HandlerFunc(serverhandlers.WebhooksHandler)))
+	apiMux.Handle("/webhooks/update", server.AuthMiddleware(http.HandlerFunc(serverhandlers.UpdateWebhookHandler)))
+	apiMux.Handle("/webhooks/delete", server.AuthMiddleware(http.HandlerFunc(serverhandlers.DeleteWebhookHandler)))
+	apiMux.Handle("/webhooks/rotate_secret", server.AuthMiddleware(http.HandlerFunc(serverhandlers.RotateWebhookSecretHandler)))
+	apiMux.Handle("/webhooks/test", server.AuthMiddleware(http.HandlerFunc(serverhandlers.TestWebhookHandler)))
+	apiMux.Handle("/webhooks/deliveries", server.AuthMiddleware(http.HandlerFunc(serverhandlers.WebhookDeliveriesHandler)))
+	apiMux.Handle("/webhooks/attempts", server.AuthMiddleware(http.HandlerFunc(serverhandlers.WebhookAttemptsHandler)))
+	apiMux.Handle("/webhooks/redeliver", server.AuthMiddleware(http.HandlerFunc(serverhandlers.RedeliverWebhookHandler)))
+
 	apiMux.Handle("/ticket/createTicket", server.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		serverhandlers.CreateTicket(w, r)
 	})))
@@ -244,6 +340,39 @@ func main() {
 	apiMux.Handle("/chat/cancelChatRequest", server.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		serverhandlers.CancelChatRequestHandler().ServeHTTP(w, r)
 	})))
+	apiMux.Handle("/chat/ws", server.StreamAuthMiddleware(serverhandlers.ChatWSHandler()))
+	apiMux.Handle("/chat/events", server.StreamAuthMiddleware(serverhandlers.ChatEventsHandler()))
+	apiMux.Handle("/chat/typing", server.AuthMiddleware(serverhandlers.ChatTypingHandler()))
+	apiMux.Handle("/chat/read", server.AuthMiddleware(serverhandlers.ChatReadHandler()))
+	apiMux.Handle("/chat/reads", server.AuthMiddleware(serverhandlers.ChatReadsHandler()))
+	apiMux.Handle("/chat/unread", server.AuthMiddleware(serverhandlers.ChatUnreadHandler()))
+	apiMux.Handle("/keys", server.AuthMiddleware(http.HandlerFunc(serverhandlers.PublicKeysHandler)))
+	apiMux.Handle("/keys/revoke", server.AuthMiddleware(http.HandlerFunc(serverhandlers.RevokePublicKeyHandler)))
+	apiMux.Handle("/keys/user", server.AuthMiddleware(http.HandlerFunc(serverhandlers.UserPublicKeyHandler)))
+	apiMux.Handle("/blocks", server.AuthMiddleware(http.HandlerFunc(serverhandlers.UserBlocksHandler)))
+	apiMux.Handle("/blocks/delete", server.AuthMiddleware(http.HandlerFunc(serverhandlers.RemoveUserBlockHandler)))
+	apiMux.Handle("/reports", server.AuthMiddleware(http.HandlerFunc(serverhandlers.ReportsHandler)))
+	apiMux.Handle("/admin/reports", server.AuthMiddleware(server.RequirePermission(server.PermReportManage)(serverhandlers.AdminReportsHandler)))
+	apiMux.Handle("/admin/reports/resolve", server.AuthMiddleware(server.RequirePermission(server.PermReportManage)(serverhandlers.ResolveReportHandler)))
+	apiMux.Handle("/chat/taskRoom", server.AuthMiddleware(serverhandlers.TaskChatRoomHandler()))
+	apiMux.Handle("/chat/offerRoom", server.AuthMiddleware(serverhandlers.OfferChatRoomHandler()))
+	apiMux.Handle("/chat/editMessage", server.AuthMiddleware(serverhandlers.EditChatMessageHandler()))
+	apiMux.Handle("/chat/deleteMessage", server.AuthMiddleware(serverhandlers.DeleteChatMessageHandler()))
+	apiMux.Handle("/chat/messageHistory", server.AuthMiddleware(serverhandlers.ChatMessageHistoryHandler()))
+	apiMux.Handle("/chat/search", server.AuthMiddleware(serverhandlers.SearchChatMessagesHandler()))
+	apiMux.Handle("/files/upload", server.AuthMiddleware(http.HandlerFunc(serverhandlers.UploadFileHandler)))
+	apiMux.Handle("/files/download", server.AuthMiddleware(http.HandlerFunc(serverhandlers.DownloadFileHandler)))
+	apiMux.Handle("/avatar", server.AuthMiddleware(http.HandlerFunc(serverhandlers.UploadAvatarHandler)))
+	apiMux.Handle("/avatar/delete", server.AuthMiddleware(http.HandlerFunc(serverhandlers.DeleteAvatarHandler)))
+	apiMux.Handle("/portfolio", server.AuthMiddleware(http.HandlerFunc(serverhandlers.PortfolioHandler)))
+	apiMux.Handle("/portfolio/delete", server.AuthMiddleware(http.HandlerFunc(serverhandlers.DeletePortfolioItemHandler)))
+	apiMux.Handle("/tasks/history_consent", server.AuthMiddleware(http.HandlerFunc(serverhandlers.TaskHistoryConsentHandler)))
+	apiMux.Handle("/contracts", server.AuthMiddleware(http.HandlerFunc(serverhandlers.ContractHandler)))
+	apiMux.Handle("/contracts/time", server.AuthMiddleware(http.HandlerFunc(serverhandlers.TimeEntryHandler)))
+	apiMux.Handle("/contracts/time/delete", server.AuthMiddleware(http.HandlerFunc(serverhandlers.DeleteTimeEntryHandler)))
+	apiMux.Handle("/contracts/review", server.AuthMiddleware(http.HandlerFunc(serverhandlers.ReviewContractWeekHandler)))
+	apiMux.Handle("/contracts/resubmit", server.AuthMiddleware(http.HandlerFunc(serverhandlers.ResubmitContractWeekHandler)))
+	apiMux.Handle("/contracts/end", server.AuthMiddleware(http.HandlerFunc(serverhandlers.EndContractHandler)))
 
 	s.HandleHandler("/api/", http.StripPrefix("/api", apiMux))
 	s.Handle("/profile", func(w http.ResponseWriter, r *http.Request) {
@@ -255,13 +384,32 @@ func main() {
 	s.Handle("/profile/by_id", func(w http.ResponseWriter, r *http.Request) {
 		server.AuthMiddleware(serverhandlers.ProfileByIDHandler()).ServeHTTP(w, r)
 	})
+	s.Handle("/profiles/search", func(w http.ResponseWriter, r *http.Request) {
+		server.AuthMiddleware(http.HandlerFunc(serverhandlers.ProfileSearchHandler)).ServeHTTP(w, r)
+	})
+
+	s.Handle("/avatar", serverhandlers.AvatarHandler)
 
 	s.Handle("/swagger/", httpSwagger.WrapHandler)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	realtime.Default = realtime.NewHub(db.RedisClient)
+	go realtime.Default.Run(ctx)
+	notifications.Start(ctx)
+	go webhooks.NewDispatcher().Start(ctx)
+	if config.AppConfig.SMTPEnabled {
+		go mailer.NewWorker().Start(ctx)
+	} else {
+		log.Println("SMTP disabled, emails stay in the outbox")
+	}
 	go server.StartWalletSync(ctx, electrumClient, moneroClient, config.AppConfig.WalletSyncInterval)
 	go server.StartTxBlockTransactions(ctx, electrumClient, config.AppConfig.TxBlockInterval)
+	go reputation.Start(ctx)
+	go server.StartReviewPublisher(ctx, config.AppConfig.ReviewBlindWindow, config.AppConfig.ReviewPublishInterval)
+	go server.StartContractSettlement(ctx, config.AppConfig.ContractReviewWindow, config.AppConfig.ContractSettleInterval)
+	go server.StartOfferExpiry(ctx, config.AppConfig.OfferExpiryInterval)
+	go server.StartTaskSchedules(ctx, config.AppConfig.TaskScheduleInterval)
 
 	server.StartTxPoolFlusher(electrumClient, moneroClient, config.AppConfig.TxPoolFlushInterval, int(config.AppConfig.MaxAddrPerBlock))
 	server.SetTxPoolBlocked(false)
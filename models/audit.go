@@ -0,0 +1,32 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEntry is one row of the append-only audit_log
+type AuditEntry struct {
+	ID         int64           `db:"id" json:"id"`
+	ActorID    *int64          `db:"actor_id" json:"actor_id"`
+	Action     string          `db:"action" json:"action"`
+	TargetType string          `db:"target_type" json:"target_type"`
+	TargetID   *int64          `db:"target_id" json:"target_id"`
+	Before     json.RawMessage `db:"before" json:"before"`
+	After      json.RawMessage `db:"after" json:"after"`
+	IP         string          `db:"ip" json:"ip"`
+	Reason     string          `db:"reason" json:"reason"`
+	CreatedAt  time.Time       `db:"created_at" json:"created_at"`
+}
+
+// AuditFilter narrows down ListAuditLog, zero values are ignored
+type AuditFilter struct {
+	ActorID    int64
+	Action     string
+	TargetType string
+	TargetID   int64
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
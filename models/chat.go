@@ -2,8 +2,13 @@ package models
 
 import "time"
 
+// ChatRoom is a conversation between its participants. Task rooms have
+// TaskID set, rooms for talking about an offer before it is accepted also
+// have OfferID.
 type ChatRoom struct {
 	ID        int64     `db:"id" json:"id"`
+	TaskID    *int64    `db:"task_id" json:"task_id"`
+	OfferID   *int64    `db:"offer_id" json:"offer_id"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
 }
 
@@ -15,11 +20,28 @@ type ChatParticipant struct {
 }
 
 type ChatMessage struct {
-	ID         int64     `db:"id" json:"id"`
-	ChatRoomID int64     `db:"chat_room_id" json:"chat_room_id"`
-	SenderID   int64     `db:"sender_id" json:"sender_id"`
-	Message    string    `db:"message" json:"message"`
-	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	ID          int64              `db:"id" json:"id"`
+	ChatRoomID  int64              `db:"chat_room_id" json:"chat_room_id"`
+	SenderID    int64              `db:"sender_id" json:"sender_id"`
+	Message     string             `db:"message" json:"message"`
+	Kind        string             `db:"kind" json:"kind"`
+	Encryption  *MessageEncryption `db:"encryption" json:"encryption,omitempty"`
+	ReplyToID   *int64             `db:"reply_to_id" json:"reply_to_id"`
+	EditedAt    *time.Time         `db:"edited_at" json:"edited_at"`
+	DeletedAt   *time.Time         `db:"deleted_at" json:"deleted_at"`
+	DeletedBy   *int64             `db:"deleted_by" json:"deleted_by,omitempty"`
+	CreatedAt   time.Time          `db:"created_at" json:"created_at"`
+	Attachments []File             `db:"-" json:"attachments"`
+	Keys        []ChatMessageKey   `db:"-" json:"keys,omitempty"`
+}
+
+// ChatMessageEdit is a previous version of an edited message
+type ChatMessageEdit struct {
+	ID        int64     `db:"id" json:"id"`
+	MessageID int64     `db:"message_id" json:"message_id"`
+	Message   string    `db:"message" json:"message"`
+	EditedBy  *int64    `db:"edited_by" json:"edited_by"`
+	EditedAt  time.Time `db:"edited_at" json:"edited_at"`
 }
 
 type ChatRequest struct {
@@ -29,3 +51,57 @@ type ChatRequest struct {
 	Status      string    `db:"status" json:"status"` // pending, accepted, rejected
 	CreatedAt   time.Time `db:"created_at" json:"created_at"`
 }
+
+type ChatRead struct {
+	ChatRoomID        int64     `db:"chat_room_id" json:"chat_room_id"`
+	UserID            int64     `db:"user_id" json:"user_id"`
+	LastReadMessageID int64     `db:"last_read_message_id" json:"last_read_message_id"`
+	ReadAt            time.Time `db:"read_at" json:"read_at"`
+}
+
+// ChatRoomMember is a participant as shown in the room list
+type ChatRoomMember struct {
+	UserID   int64  `db:"user_id" json:"user_id"`
+	Username string `db:"username" json:"username"`
+}
+
+// ChatMessagePreview is the start of the last message of a room. Message
+// is empty for deleted and encrypted messages.
+type ChatMessagePreview struct {
+	ID        int64      `json:"id"`
+	SenderID  int64      `json:"sender_id"`
+	Message   string     `json:"message"`
+	Kind      string     `json:"kind"`
+	DeletedAt *time.Time `json:"deleted_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ChatRoomSummary is a room in the user's room list
+type ChatRoomSummary struct {
+	ChatRoom
+	Participants   []ChatRoomMember    `json:"participants"`
+	LastMessage    *ChatMessagePreview `json:"last_message"`
+	LastActivityAt time.Time           `json:"last_activity_at"`
+	Unread         int                 `json:"unread_count"`
+}
+
+// ChatSearchResult is a message matching a search. Headline is an excerpt
+// with the matches wrapped in [[ and ]].
+type ChatSearchResult struct {
+	ChatMessage
+	Headline string  `db:"headline" json:"headline"`
+	Rank     float64 `db:"rank" json:"rank"`
+}
+
+// ChatSearchFilter narrows a message search. Sort is "recent" (default) or
+// "relevance".
+type ChatSearchFilter struct {
+	Query      string
+	ChatRoomID int64
+	SenderID   int64
+	From       *time.Time
+	To         *time.Time
+	Sort       string
+	Limit      int
+	Offset     int
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// Task contract types
+const (
+	ContractFixed  = "fixed"
+	ContractHourly = "hourly"
+)
+
+// Contract week statuses
+const (
+	WeekPending  = "pending"
+	WeekApproved = "approved"
+	WeekDisputed = "disputed"
+	WeekSettled  = "settled"
+	// WeekCancelled is a week left unpaid because the escrow was released
+	// or refunded by a dispute resolution
+	WeekCancelled = "cancelled"
+)
+
+// TimeEntry is time a freelancer logged on an hourly contract
+type TimeEntry struct {
+	ID           int64     `db:"id" json:"id"`
+	TaskID       int64     `db:"task_id" json:"task_id"`
+	FreelancerID int64     `db:"freelancer_id" json:"freelancer_id"`
+	WorkDate     time.Time `db:"work_date" json:"work_date"`
+	Minutes      int       `db:"minutes" json:"minutes"`
+	Description  string    `db:"description" json:"description"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// ContractWeek is a finished week of an hourly contract. Minutes and Amount
+// are filled in when the week is settled.
+type ContractWeek struct {
+	TaskID        int64      `db:"task_id" json:"task_id"`
+	WeekStart     time.Time  `db:"week_start" json:"week_start"`
+	Status        string     `db:"status" json:"status"`
+	Minutes       int        `db:"minutes" json:"minutes"`
+	Amount        float64    `db:"amount" json:"amount"`
+	SubmittedAt   time.Time  `db:"submitted_at" json:"submitted_at"`
+	ReviewedAt    *time.Time `db:"reviewed_at" json:"reviewed_at"`
+	DisputeReason string     `db:"dispute_reason" json:"dispute_reason"`
+	SettledAt     *time.Time `db:"settled_at" json:"settled_at"`
+}
+
+// WeekStart returns the Monday starting the week of t, as a date
+func WeekStart(t time.Time) time.Time {
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+}
+
+// WeekAmount is what a week of minutes costs at rate, with the billed hours
+// capped at weeklyCap
+func WeekAmount(minutes int, rate, weeklyCap float64) float64 {
+	hours := math.Min(float64(minutes)/60, weeklyCap)
+	return hours * rate
+}
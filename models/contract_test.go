@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWeekStart(t *testing.T) {
+	monday := time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)
+	for _, day := range []time.Time{
+		monday,
+		time.Date(2025, 3, 5, 13, 30, 0, 0, time.UTC),
+		time.Date(2025, 3, 9, 23, 59, 0, 0, time.UTC), // Sunday
+	} {
+		if got := WeekStart(day); !got.Equal(monday) {
+			t.Errorf("WeekStart(%s) = %s, want %s", day, got, monday)
+		}
+	}
+	if got := WeekStart(time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)); got.Equal(monday) {
+		t.Error("the next Monday starts a new week")
+	}
+}
+
+func TestWeekAmountCapsHours(t *testing.T) {
+	if got := WeekAmount(90, 10, 40); got != 15 {
+		t.Errorf("90 minutes at 10/h: got %v, want 15", got)
+	}
+	if got := WeekAmount(50*60, 10, 40); got != 400 {
+		t.Errorf("50 hours with a 40 hour cap: got %v, want 400", got)
+	}
+}
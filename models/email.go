@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+type UserEmail struct {
+	UserID         int64      `db:"user_id" json:"-"`
+	Email          string     `db:"email" json:"email"`
+	Locale         string     `db:"locale" json:"locale"`
+	VerifiedAt     *time.Time `db:"verified_at" json:"verified_at"`
+	TokenHash      *string    `db:"token_hash" json:"-"`
+	TokenExpiresAt *time.Time `db:"token_expires_at" json:"-"`
+	TokenSentAt    *time.Time `db:"token_sent_at" json:"-"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+func (e *UserEmail) Verified() bool {
+	return e.VerifiedAt != nil
+}
+
+// OutboxEmail is a rendered message waiting in or sent from the outbox
+type OutboxEmail struct {
+	ID            int64      `db:"id" json:"id"`
+	UserID        *int64     `db:"user_id" json:"user_id"`
+	ToAddress     string     `db:"to_address" json:"to_address"`
+	Template      string     `db:"template" json:"template"`
+	Subject       string     `db:"subject" json:"subject"`
+	TextBody      string     `db:"text_body" json:"text_body"`
+	HTMLBody      string     `db:"html_body" json:"html_body"`
+	Status        string     `db:"status" json:"status"`
+	Attempts      int        `db:"attempts" json:"attempts"`
+	NextAttemptAt time.Time  `db:"next_attempt_at" json:"next_attempt_at"`
+	LastError     string     `db:"last_error" json:"last_error"`
+	SentAt        *time.Time `db:"sent_at" json:"sent_at"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Chat message kinds
+const (
+	MessageKindText      = "text"
+	MessageKindEncrypted = "encrypted"
+)
+
+// PublicKey is a key a user published for end-to-end encrypted messages.
+// The server never sees private keys.
+type PublicKey struct {
+	ID          int64      `db:"id" json:"id"`
+	UserID      int64      `db:"user_id" json:"user_id"`
+	Algorithm   string     `db:"algorithm" json:"algorithm"`
+	PublicKey   string     `db:"public_key" json:"public_key"`
+	Fingerprint string     `db:"fingerprint" json:"fingerprint"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	RevokedAt   *time.Time `db:"revoked_at" json:"revoked_at"`
+}
+
+// MessageEncryption holds the cipher parameters of an encrypted message
+type MessageEncryption struct {
+	Algorithm   string `json:"algorithm"`
+	Nonce       string `json:"nonce"`
+	SenderKeyID int64  `json:"sender_key_id"`
+}
+
+func (e *MessageEncryption) Scan(value interface{}) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("expected []byte, got %T", value)
+	}
+	return json.Unmarshal(b, e)
+}
+
+func (e MessageEncryption) Value() (driver.Value, error) {
+	b, err := json.Marshal(e)
+	return string(b), err
+}
+
+// ChatMessageKey is the content key of an encrypted message wrapped with
+// the public key KeyID of UserID
+type ChatMessageKey struct {
+	MessageID   int64     `db:"message_id" json:"message_id"`
+	UserID      int64     `db:"user_id" json:"user_id"`
+	KeyID       int64     `db:"key_id" json:"key_id"`
+	WrappedKey  string    `db:"wrapped_key" json:"wrapped_key"`
+	DisclosedBy *int64    `db:"disclosed_by" json:"disclosed_by,omitempty"`
+	DisputeID   *int64    `db:"dispute_id" json:"dispute_id,omitempty"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
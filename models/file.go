@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+type File struct {
+	ID          int64     `db:"id" json:"id"`
+	OwnerID     int64     `db:"owner_id" json:"owner_id"`
+	Name        string    `db:"name" json:"name"`
+	ContentType string    `db:"content_type" json:"content_type"`
+	Size        int64     `db:"size" json:"size"`
+	SHA256      string    `db:"sha256" json:"sha256"`
+	StorageKey  string    `db:"storage_key" json:"-"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// UserBlock is an entry of a user's block list
+type UserBlock struct {
+	BlockerID int64     `db:"blocker_id" json:"blocker_id"`
+	BlockedID int64     `db:"blocked_id" json:"blocked_id"`
+	Username  string    `db:"username" json:"username"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Report is a user's complaint about a message, a user or a task.
+// TargetReports is the number of open reports on the same target.
+type Report struct {
+	ID             int64      `db:"id" json:"id"`
+	ReporterID     *int64     `db:"reporter_id" json:"reporter_id"`
+	TargetType     string     `db:"target_type" json:"target_type"`
+	TargetID       int64      `db:"target_id" json:"target_id"`
+	TargetUserID   *int64     `db:"target_user_id" json:"target_user_id"`
+	Category       string     `db:"category" json:"category"`
+	Details        string     `db:"details" json:"details"`
+	Snapshot       string     `db:"snapshot" json:"snapshot,omitempty"`
+	Status         string     `db:"status" json:"status"`
+	Action         *string    `db:"action" json:"action"`
+	ResolutionNote string     `db:"resolution_note" json:"resolution_note,omitempty"`
+	ResolvedBy     *int64     `db:"resolved_by" json:"resolved_by,omitempty"`
+	ResolvedAt     *time.Time `db:"resolved_at" json:"resolved_at"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+	TargetReports  int        `db:"target_reports" json:"target_reports,omitempty"`
+}
+
+type ReportFilter struct {
+	Status     string
+	TargetType string
+	Limit      int
+	Offset     int
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Notification struct {
+	ID        int64           `db:"id" json:"id"`
+	UserID    int64           `db:"user_id" json:"user_id"`
+	Type      string          `db:"type" json:"type"`
+	Title     string          `db:"title" json:"title"`
+	Body      string          `db:"body" json:"body"`
+	Data      json.RawMessage `db:"data" json:"data,omitempty"`
+	ReadAt    *time.Time      `db:"read_at" json:"read_at"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+}
+
+type NotificationPreference struct {
+	Type  string `db:"type" json:"type"`
+	InApp bool   `db:"in_app" json:"in_app"`
+	Email bool   `db:"email" json:"email"`
+}
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Organization member roles. Owners manage everything, managers invite
+// members, set their spending limits and make offers for them, members post
+// tasks and make offers for themselves.
+const (
+	OrgRoleOwner   = "owner"
+	OrgRoleManager = "manager"
+	OrgRoleMember  = "member"
+)
+
+// Organization wallet entry kinds
+const (
+	OrgEntryDeposit    = "deposit"
+	OrgEntryWithdrawal = "withdrawal"
+	OrgEntryEscrow     = "escrow"
+	OrgEntryRefund     = "refund"
+	OrgEntryPayout     = "payout"
+)
+
+// IsOrgRole reports whether role is a known member role
+func IsOrgRole(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleManager || role == OrgRoleMember
+}
+
+// OrgRoleRank orders roles from member (1) to owner (3)
+func OrgRoleRank(role string) int {
+	switch role {
+	case OrgRoleOwner:
+		return 3
+	case OrgRoleManager:
+		return 2
+	case OrgRoleMember:
+		return 1
+	}
+	return 0
+}
+
+type Organization struct {
+	ID        int64     `db:"id" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// UserOrganization is an organization a user belongs to or is invited to
+type UserOrganization struct {
+	Organization
+	Role     string     `db:"role" json:"role"`
+	JoinedAt *time.Time `db:"joined_at" json:"joined_at"`
+}
+
+// OrgMember is a user of an organization. JoinedAt is nil while the
+// invitation is pending.
+type OrgMember struct {
+	OrgID     int64      `db:"org_id" json:"org_id"`
+	UserID    int64      `db:"user_id" json:"user_id"`
+	Username  string     `db:"username" json:"username"`
+	Role      string     `db:"role" json:"role"`
+	InvitedBy *int64     `db:"invited_by" json:"invited_by"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	JoinedAt  *time.Time `db:"joined_at" json:"joined_at"`
+}
+
+// Active reports whether the member accepted the invitation
+func (m *OrgMember) Active() bool {
+	return m.JoinedAt != nil
+}
+
+// OrgSpendingLimit caps what a member moves into escrow from the
+// organization wallet per calendar month. Spent is the current month's
+// total.
+type OrgSpendingLimit struct {
+	OrgID        int64   `db:"org_id" json:"org_id"`
+	UserID       int64   `db:"user_id" json:"user_id"`
+	Currency     string  `db:"currency" json:"currency"`
+	MonthlyLimit float64 `db:"monthly_limit" json:"monthly_limit"`
+	Spent        float64 `db:"spent" json:"spent"`
+}
+
+// OrgWalletEntry is a change of an organization wallet balance
+type OrgWalletEntry struct {
+	ID        int64     `db:"id" json:"id"`
+	OrgID     int64     `db:"org_id" json:"org_id"`
+	Currency  string    `db:"currency" json:"currency"`
+	Kind      string    `db:"kind" json:"kind"`
+	Amount    float64   `db:"amount" json:"amount"`
+	UserID    *int64    `db:"user_id" json:"user_id"`
+	TaskID    *int64    `db:"task_id" json:"task_id"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// OrgWallet is an organization's balance in one currency. Every change is
+// recorded as an entry of CreditKind or DebitKind on behalf of UserID and
+// TaskID.
+type OrgWallet struct {
+	OrgID    int64  `db:"org_id" json:"org_id"`
+	Currency string `db:"currency" json:"currency"`
+	Balance  string `db:"balance" json:"balance"`
+
+	UserID     *int64 `db:"-" json:"-"`
+	TaskID     *int64 `db:"-" json:"-"`
+	CreditKind string `db:"-" json:"-"`
+	DebitKind  string `db:"-" json:"-"`
+}
+
+func GetOrgWallet(db *sqlx.DB, orgID int64, currency string) (*OrgWallet, error) {
+	var w OrgWallet
+	err := db.Get(&w, `SELECT org_id, currency, balance FROM organization_wallets WHERE org_id = $1 AND currency = $2`, orgID, currency)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (w *OrgWallet) BigBalance() *big.Float {
+	b, ok := new(big.Float).SetString(w.Balance)
+	if !ok {
+		log.Printf("invalid balance format for organization %d wallet %s: %s", w.OrgID, w.Currency, w.Balance)
+		return big.NewFloat(0)
+	}
+	return b
+}
+
+func (w *OrgWallet) AddBalance(db interface{}, delta *big.Float) error {
+	return w.change(db, w.CreditKind, delta)
+}
+
+func (w *OrgWallet) SubBalance(db interface{}, delta *big.Float) error {
+	return w.change(db, w.DebitKind, new(big.Float).Neg(delta))
+}
+
+// change moves the balance by delta unless it would go negative and records
+// the entry
+func (w *OrgWallet) change(db interface{}, kind string, delta *big.Float) error {
+	q, ok := db.(sqlx.Ext)
+	if !ok {
+		return fmt.Errorf("unsupported database interface")
+	}
+	amount := fmt.Sprintf("%.8f", delta)
+	var balance string
+	err := sqlx.Get(q, &balance, `
+		UPDATE organization_wallets SET balance = balance + $1
+		WHERE org_id = $2 AND currency = $3 AND balance + $1 >= 0
+		RETURNING balance
+	`, amount, w.OrgID, w.Currency)
+	if err == sql.ErrNoRows {
+		return ErrInsufficientBalance
+	}
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(`
+		INSERT INTO organization_wallet_entries (org_id, currency, kind, amount, user_id, task_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, w.OrgID, w.Currency, kind, amount, w.UserID, w.TaskID)
+	if err == nil {
+		w.Balance = balance
+	}
+	return err
+}
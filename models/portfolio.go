@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// PortfolioItem is a showcase entry on a freelancer's profile. Images are
+// stored files owned by the freelancer.
+type PortfolioItem struct {
+	ID          int64       `db:"id" json:"id"`
+	UserID      int64       `db:"user_id" json:"user_id"`
+	Title       string      `db:"title" json:"title"`
+	Description string      `db:"description" json:"description"`
+	Links       JSONStrings `db:"links" json:"links"`
+	Images      []File      `db:"-" json:"images"`
+	CreatedAt   time.Time   `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time   `db:"updated_at" json:"updated_at"`
+}
+
+// WorkHistoryItem is a completed contract in a freelancer's public work
+// history with the client's review, when it is visible
+type WorkHistoryItem struct {
+	TaskID      int64      `db:"task_id" json:"task_id"`
+	Title       string     `db:"title" json:"title"`
+	Category    string     `db:"category" json:"category"`
+	CompletedAt *time.Time `db:"completed_at" json:"completed_at"`
+	Rating      *int       `db:"rating" json:"rating"`
+	Comment     *string    `db:"comment" json:"comment"`
+}
@@ -36,11 +36,15 @@ type Profile struct {
 	Bio            string      `db:"bio" json:"bio"`
 	Skills         JSONStrings `db:"skills" json:"skills"`
 	Avatar         string      `db:"avatar" json:"avatar"`
+	AvatarURL      string      `db:"avatar_url" json:"avatar_url"`
 	Rating         float64     `db:"rating" json:"rating"`
 	CompletedTasks int         `db:"completed_tasks" json:"completed_tasks"`
+	Reputation     float64     `db:"reputation" json:"reputation"`
+	HourlyRate     *float64    `db:"hourly_rate" json:"hourly_rate"`
+	HourlyCurrency string      `db:"hourly_currency" json:"hourly_currency"`
 	IsAdmin        bool        `db:"is_admin" json:"is_admin"`
 	AdminTitle     string      `db:"admin_title" json:"admin_title"`
-	Permissions    int         `db:"permissions" json:"permissions"`
+	Roles          JSONStrings `db:"roles" json:"roles"`
 }
 
 func (j *JSONStrings) UnmarshalJSON(data []byte) error {
@@ -55,33 +59,38 @@ func (j *JSONStrings) UnmarshalJSON(data []byte) error {
 func GetProfile(db *sqlx.DB, userID int64) (*Profile, error) {
 	var profile Profile
 	err := db.Get(&profile, `
-		SELECT p.*, u.is_admin, COALESCE(u.admin_title, '') as admin_title, u.permissions,
-		       COALESCE(AVG(r.rating), 0) as rating
+		SELECT p.*, u.is_admin, COALESCE(u.admin_title, '') as admin_title,
+		       COALESCE((SELECT json_agg(ro.name ORDER BY ro.name) FROM user_roles ur JOIN roles ro ON ro.id = ur.role_id WHERE ur.user_id = u.id), '[]') AS roles,
+		       COALESCE(AVG(r.rating), 0) as rating,
+		       COALESCE(rs.score, 0) as reputation
 		FROM profiles p
 		LEFT JOIN users u ON p.user_id = u.id
-		LEFT JOIN reviews r ON r.reviewed_id = p.user_id
+		LEFT JOIN reviews r ON r.reviewed_id = p.user_id AND r.published_at IS NOT NULL AND r.hidden_at IS NULL
+		LEFT JOIN reputation_scores rs ON rs.user_id = p.user_id
 		WHERE p.user_id=$1
-		GROUP BY p.user_id, p.full_name, p.bio, p.skills, p.avatar, p.rating, p.completed_tasks, u.is_admin, u.admin_title, u.permissions
+		GROUP BY p.user_id, p.full_name, p.bio, p.skills, p.avatar, p.rating, p.completed_tasks, u.id, u.is_admin, u.admin_title, rs.score
 	`, userID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			// Get user data even if profile doesn't exist
 			var isAdmin bool
 			var adminTitle string
-			var permissions int
+			var roles JSONStrings
 			err := db.QueryRow(`
-				SELECT is_admin, COALESCE(admin_title, ''), permissions
+				SELECT is_admin, COALESCE(admin_title, ''),
+				       COALESCE((SELECT json_agg(ro.name ORDER BY ro.name) FROM user_roles ur JOIN roles ro ON ro.id = ur.role_id WHERE ur.user_id = users.id), '[]')
 				FROM users WHERE id=$1
-			`, userID).Scan(&isAdmin, &adminTitle, &permissions)
+			`, userID).Scan(&isAdmin, &adminTitle, &roles)
 			if err != nil {
 				return nil, err
 			}
 			return &Profile{
 				UserID: userID,
 				Skills: JSONStrings{},
+				HourlyCurrency: "BTC",
 				IsAdmin: isAdmin,
 				AdminTitle: adminTitle,
-				Permissions: permissions,
+				Roles: roles,
 			}, nil
 		}
 		return nil, err
@@ -91,11 +100,11 @@ func GetProfile(db *sqlx.DB, userID int64) (*Profile, error) {
 
 func UpsertProfile(db *sqlx.DB, p *Profile) error {
 	_, err := db.Exec(`
-        INSERT INTO profiles(user_id, full_name, bio, skills, avatar)
-        VALUES($1, $2, $3, $4, $5)
+        INSERT INTO profiles(user_id, full_name, bio, skills, avatar, hourly_rate, hourly_currency)
+        VALUES($1, $2, $3, $4, $5, $6, $7)
         ON CONFLICT (user_id)
-        DO UPDATE SET full_name=$2, bio=$3, skills=$4, avatar=$5
-    `, p.UserID, p.FullName, p.Bio, p.Skills, p.Avatar)
+        DO UPDATE SET full_name=$2, bio=$3, skills=$4, avatar=$5, hourly_rate=$6, hourly_currency=$7
+    `, p.UserID, p.FullName, p.Bio, p.Skills, p.Avatar, p.HourlyRate, p.HourlyCurrency)
 	return err
 }
 
@@ -116,14 +125,17 @@ func GetProfilesCount(db *sqlx.DB) (int, error) {
 func GetProfilesWithLimitOffset(db *sqlx.DB, limit, offset int) ([]Profile, error) {
 	var profiles []Profile
 	err := db.Select(&profiles, `
-		SELECT p.*, u.is_admin, COALESCE(u.admin_title, '') as admin_title, u.permissions,
-		       COALESCE(AVG(r.rating), 0) as rating
+		SELECT p.*, u.is_admin, COALESCE(u.admin_title, '') as admin_title,
+		       COALESCE((SELECT json_agg(ro.name ORDER BY ro.name) FROM user_roles ur JOIN roles ro ON ro.id = ur.role_id WHERE ur.user_id = u.id), '[]') AS roles,
+		       COALESCE(AVG(r.rating), 0) as rating,
+		       COALESCE(rs.score, 0) as reputation
 		FROM profiles p
 		LEFT JOIN users u ON p.user_id = u.id
-		LEFT JOIN reviews r ON r.reviewed_id = p.user_id
+		LEFT JOIN reviews r ON r.reviewed_id = p.user_id AND r.published_at IS NOT NULL AND r.hidden_at IS NULL
+		LEFT JOIN reputation_scores rs ON rs.user_id = p.user_id
 		WHERE u.blocked = false
-		GROUP BY p.user_id, p.full_name, p.bio, p.skills, p.avatar, p.rating, p.completed_tasks, u.is_admin, u.admin_title, u.permissions
-		ORDER BY p.completed_tasks DESC, AVG(r.rating) DESC, u.is_admin DESC
+		GROUP BY p.user_id, p.full_name, p.bio, p.skills, p.avatar, p.rating, p.completed_tasks, u.id, u.is_admin, u.admin_title, rs.score
+		ORDER BY COALESCE(rs.score, 0) DESC, p.completed_tasks DESC, u.is_admin DESC
 		LIMIT $1 OFFSET $2
 	`, limit, offset)
 	if err != nil {
@@ -131,3 +143,34 @@ func GetProfilesWithLimitOffset(db *sqlx.DB, limit, offset int) ([]Profile, erro
 	}
 	return profiles, nil
 }
+
+// Profile badges
+const (
+	BadgeVerified = "verified"
+	BadgeTopRated = "top_rated"
+)
+
+// DirectoryProfile is a profile in the freelancer directory
+type DirectoryProfile struct {
+	Profile
+	Username      string   `db:"username" json:"username"`
+	ReviewCount   int      `db:"review_count" json:"review_count"`
+	EmailVerified bool     `db:"email_verified" json:"-"`
+	Badges        []string `db:"-" json:"badges"`
+}
+
+// ProfileSearchFilter narrows the freelancer directory. Zero values don't
+// filter. Sort is "reputation" (default), "rating", "completed_tasks" or
+// "hourly_rate".
+type ProfileSearchFilter struct {
+	Query          string
+	Skills         []string
+	MinRating      float64
+	MinCompleted   int
+	MinHourlyRate  float64
+	MaxHourlyRate  float64
+	HourlyCurrency string
+	Sort           string
+	Limit          int
+	Offset         int
+}
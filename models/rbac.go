@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type Permission struct {
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+}
+
+type Role struct {
+	ID          int64     `db:"id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	Builtin     bool      `db:"builtin" json:"builtin"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	Permissions []string  `db:"-" json:"permissions"`
+}
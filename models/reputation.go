@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+)
+
+// ReputationStats is the raw track record a reputation score is computed
+// from. Contracts are escrows the user took part in as client or
+// freelancer; Released, Refunded, WithDeadline and OnTime only count the
+// ones where the user was the freelancer.
+type ReputationStats struct {
+	UserID         int64              `db:"user_id"`
+	RatingSum      float64            `db:"rating_sum"`
+	RatingCount    int                `db:"rating_count"`
+	Contracts      int                `db:"contracts"`
+	Released       int                `db:"released"`
+	Refunded       int                `db:"refunded"`
+	DisputesLost   int                `db:"disputes_lost"`
+	WithDeadline   int                `db:"with_deadline"`
+	OnTime         int                `db:"on_time"`
+	Earnings       map[string]float64 `db:"-"`
+	AccountCreated time.Time          `db:"account_created"`
+}
+
+// Reputation is a user's score from 0 to 100 with the components it was
+// built from. Rating is the smoothed star rating, the rates are smoothed
+// fractions from 0 to 1.
+type Reputation struct {
+	UserID          int64     `db:"user_id" json:"user_id"`
+	Score           float64   `db:"score" json:"score"`
+	Rating          float64   `db:"rating" json:"rating"`
+	ReviewCount     int       `db:"review_count" json:"review_count"`
+	Contracts       int       `db:"contracts" json:"contracts"`
+	CompletionRate  float64   `db:"completion_rate" json:"completion_rate"`
+	DisputeLossRate float64   `db:"dispute_loss_rate" json:"dispute_loss_rate"`
+	OnTimeRate      float64   `db:"on_time_rate" json:"on_time_rate"`
+	EarningsScore   float64   `db:"earnings_score" json:"earnings_score"`
+	AccountAgeDays  int       `db:"account_age_days" json:"account_age_days"`
+	UpdatedAt       time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type ReputationHistoryEntry struct {
+	ID        int64     `db:"id" json:"id"`
+	UserID    int64     `db:"user_id" json:"user_id"`
+	Score     float64   `db:"score" json:"score"`
+	Reason    string    `db:"reason" json:"reason"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
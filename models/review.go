@@ -4,21 +4,34 @@ import (
 	"time"
 )
 
+// Review is one party's feedback on the other after a task. Sub-ratings are
+// nil on reviews written before they were introduced. PublishedAt is nil
+// while a double-blind review waits for the other side.
 type Review struct {
-	ID         int64     `db:"id" json:"id"`
-	TaskID     int64     `db:"task_id" json:"task_id"`
-	ReviewerID int64     `db:"reviewer_id" json:"reviewer_id"`
-	ReviewedID int64     `db:"reviewed_id" json:"reviewed_id"`
-	Rating     int       `db:"rating" json:"rating"`
-	Comment    string    `db:"comment" json:"comment"`
-	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	ID                  int64      `db:"id" json:"id"`
+	TaskID              int64      `db:"task_id" json:"task_id"`
+	ReviewerID          int64      `db:"reviewer_id" json:"reviewer_id"`
+	ReviewedID          int64      `db:"reviewed_id" json:"reviewed_id"`
+	Rating              int        `db:"rating" json:"rating"`
+	CommunicationRating *int       `db:"communication_rating" json:"communication_rating"`
+	QualityRating       *int       `db:"quality_rating" json:"quality_rating"`
+	TimelinessRating    *int       `db:"timeliness_rating" json:"timeliness_rating"`
+	Comment             string     `db:"comment" json:"comment"`
+	Reply               *string    `db:"reply" json:"reply"`
+	RepliedAt           *time.Time `db:"replied_at" json:"replied_at"`
+	PublishedAt         *time.Time `db:"published_at" json:"published_at"`
+	HiddenAt            *time.Time `db:"hidden_at" json:"hidden_at,omitempty"`
+	HiddenBy            *int64     `db:"hidden_by" json:"hidden_by,omitempty"`
+	HiddenReason        string     `db:"hidden_reason" json:"hidden_reason,omitempty"`
+	CreatedAt           time.Time  `db:"created_at" json:"created_at"`
 }
 
-type ReviewResponse struct {
-	ID        int64     `db:"id" json:"id"`
-	ReviewID  int64     `db:"review_id" json:"review_id"`
-	UserID    int64     `db:"user_id" json:"user_id"`
-	Response  string    `db:"response" json:"response"`
-	CreatedAt time.Time `db:"created_at" json:"created_at"`
+// UserRating aggregates the visible reviews a user received. Category
+// averages only cover reviews that have sub-ratings.
+type UserRating struct {
+	Rating        float64 `db:"rating" json:"rating"`
+	Communication float64 `db:"communication" json:"communication"`
+	Quality       float64 `db:"quality" json:"quality"`
+	Timeliness    float64 `db:"timeliness" json:"timeliness"`
+	Count         int     `db:"count" json:"count"`
 }
-
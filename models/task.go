@@ -92,4 +92,18 @@ type Task struct {
 	Status      string       `db:"status" json:"status"` // open, in_progress, completed, cancelled, disputed
 	CreatedAt   time.Time    `db:"created_at" json:"created_at"`
 	Deadline    FlexibleTime `db:"deadline" json:"deadline"`
+	CompletedAt *time.Time   `db:"completed_at" json:"completed_at"`
+	// ContractType is "fixed" or "hourly". EndedAt is set when either party
+	// ends an hourly contract; it completes once the last week is settled.
+	ContractType string     `db:"contract_type" json:"contract_type"`
+	EndedAt      *time.Time `db:"ended_at" json:"ended_at"`
+	// Visibility is "public", "unlisted" or "invite"
+	Visibility string `db:"visibility" json:"visibility"`
+	// TemplateID is the template the task was filled from, ScheduleID the
+	// schedule that posted it
+	TemplateID *int64 `db:"template_id" json:"template_id"`
+	ScheduleID *int64 `db:"schedule_id" json:"schedule_id"`
+	// OrgID is the organization the task was posted for. Its escrow is paid
+	// from and refunded to the organization wallet.
+	OrgID *int64 `db:"org_id" json:"org_id"`
 }
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Task visibilities. Public tasks are listed with the open tasks, unlisted
+// ones can be opened by anyone who has their link and invite-only ones only
+// by the client and the freelancers they invited.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityInvite   = "invite"
+)
+
+// IsVisibility reports whether v is a known task visibility
+func IsVisibility(v string) bool {
+	return v == VisibilityPublic || v == VisibilityUnlisted || v == VisibilityInvite
+}
+
+// TaskInvite lets a freelancer see an invite-only task and make an offer
+type TaskInvite struct {
+	TaskID       int64     `db:"task_id" json:"task_id"`
+	FreelancerID int64     `db:"freelancer_id" json:"freelancer_id"`
+	Username     string    `db:"username" json:"username"`
+	Message      string    `db:"message" json:"message"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
@@ -4,12 +4,61 @@ import (
 	"time"
 )
 
+// Offer statuses. Pending offers carry the freelancer's terms, countered
+// ones the client's; either side answers the other's terms.
+const (
+	OfferPending   = "pending"
+	OfferCountered = "countered"
+	OfferAccepted  = "accepted"
+	OfferRejected  = "rejected"
+	OfferWithdrawn = "withdrawn"
+	OfferExpired   = "expired"
+)
+
+// Offer revision kinds
+const (
+	RevisionOffer   = "offer"
+	RevisionCounter = "counter"
+)
+
 type TaskOffer struct {
 	ID           int64     `db:"id" json:"id"`
 	TaskID       int64     `db:"task_id" json:"task_id"`
 	FreelancerID int64     `db:"freelancer_id" json:"freelancer_id"`
 	Price        float64   `db:"price" json:"price"`
 	Message      string    `db:"message" json:"message"`
-	Accepted     bool      `db:"accepted" json:"accepted"`
 	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	// Hourly offers only. Price is then one week at the cap.
+	HourlyRate *float64   `db:"hourly_rate" json:"hourly_rate"`
+	WeeklyCap  *float64   `db:"weekly_cap" json:"weekly_cap"`
+	Status     string     `db:"status" json:"status"`
+	ExpiresAt  *time.Time `db:"expires_at" json:"expires_at"`
+	UpdatedAt  time.Time  `db:"updated_at" json:"updated_at"`
+	// OrgID is the agency that made the offer for FreelancerID, its member.
+	// The agency's wallet is paid out.
+	OrgID *int64 `db:"org_id" json:"org_id"`
+}
+
+// Open reports whether the offer is still being negotiated
+func (o *TaskOffer) Open() bool {
+	return o.Status == OfferPending || o.Status == OfferCountered
+}
+
+// OfferRevision is one set of terms put on the table during a negotiation
+type OfferRevision struct {
+	ID         int64     `db:"id" json:"id"`
+	OfferID    int64     `db:"offer_id" json:"offer_id"`
+	AuthorID   int64     `db:"author_id" json:"author_id"`
+	Kind       string    `db:"kind" json:"kind"`
+	Price      float64   `db:"price" json:"price"`
+	HourlyRate *float64  `db:"hourly_rate" json:"hourly_rate"`
+	WeeklyCap  *float64  `db:"weekly_cap" json:"weekly_cap"`
+	Message    string    `db:"message" json:"message"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+}
+
+// RankedTaskOffer is an offer with the reputation score of its freelancer
+type RankedTaskOffer struct {
+	TaskOffer
+	FreelancerReputation float64 `db:"freelancer_reputation" json:"freelancer_reputation"`
 }
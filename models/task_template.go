@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// TaskTemplate is a task a client saved to post again
+type TaskTemplate struct {
+	ID           int64     `db:"id" json:"id"`
+	ClientID     int64     `db:"client_id" json:"client_id"`
+	Name         string    `db:"name" json:"name"`
+	Title        string    `db:"title" json:"title"`
+	Description  string    `db:"description" json:"description"`
+	Category     string    `db:"category" json:"category"`
+	Budget       float64   `db:"budget" json:"budget"`
+	Currency     string    `db:"currency" json:"currency"`
+	ContractType string    `db:"contract_type" json:"contract_type"`
+	Visibility   string    `db:"visibility" json:"visibility"`
+	DeadlineDays int       `db:"deadline_days" json:"deadline_days"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// Task returns a new open task of the client filled from the template
+func (t *TaskTemplate) Task(now time.Time) *Task {
+	task := &Task{
+		ClientID:     t.ClientID,
+		Title:        t.Title,
+		Description:  t.Description,
+		Category:     t.Category,
+		Budget:       t.Budget,
+		Currency:     t.Currency,
+		Status:       "open",
+		CreatedAt:    now,
+		ContractType: t.ContractType,
+		Visibility:   t.Visibility,
+		TemplateID:   &t.ID,
+	}
+	if t.DeadlineDays > 0 {
+		task.Deadline = FlexibleTime{now.AddDate(0, 0, t.DeadlineDays)}
+	}
+	return task
+}
+
+// TaskSchedule posts a template whenever its cron expression matches, in
+// its time zone. With Rehire the task is offered to the freelancer of the
+// schedule's previous contract on the same terms.
+type TaskSchedule struct {
+	ID         int64      `db:"id" json:"id"`
+	TemplateID int64      `db:"template_id" json:"template_id"`
+	ClientID   int64      `db:"client_id" json:"client_id"`
+	Cron       string     `db:"cron" json:"cron"`
+	Timezone   string     `db:"timezone" json:"timezone"`
+	Rehire     bool       `db:"rehire" json:"rehire"`
+	Active     bool       `db:"active" json:"active"`
+	NextRunAt  *time.Time `db:"next_run_at" json:"next_run_at"`
+	LastRunAt  *time.Time `db:"last_run_at" json:"last_run_at"`
+	LastTaskID *int64     `db:"last_task_id" json:"last_task_id"`
+	// LastError tells the client why the last run didn't post a task
+	LastError string    `db:"last_error" json:"last_error"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
@@ -9,26 +9,116 @@ import (
 	"github.com/lib/pq"
 )
 
+// Ticket priorities, from the lowest
+const (
+	TicketPriorityLow    = "low"
+	TicketPriorityNormal = "normal"
+	TicketPriorityHigh   = "high"
+	TicketPriorityUrgent = "urgent"
+)
+
+var TicketPriorities = []string{TicketPriorityLow, TicketPriorityNormal, TicketPriorityHigh, TicketPriorityUrgent}
+
+var TicketCategories = []string{"account", "payment", "task", "dispute", "technical", "other"}
+
+// Targets a ticket can be linked to
+const (
+	TicketLinkTask        = "task"
+	TicketLinkDispute     = "dispute"
+	TicketLinkTransaction = "transaction"
+)
+
 type Ticket struct {
-	ID              int64         `db:"id" json:"id"`
-	UserID          *int64        `db:"user_id" json:"user_id"`
-	AdminID         *int64        `db:"admin_id" json:"admin_id"`
-	Status          string        `db:"status" json:"status"`
-	Subject         string        `db:"subject" json:"subject"`
-	CreatedAt       string        `db:"created_at" json:"created_at"`
-	UpdatedAt       string        `db:"updated_at" json:"updated_at"`
-	AdditionalUsers pq.Int64Array `db:"additional_users_have_access" json:"additional_users_have_access"`
+	ID                    int64         `db:"id" json:"id"`
+	UserID                *int64        `db:"user_id" json:"user_id"`
+	AdminID               *int64        `db:"admin_id" json:"admin_id"`
+	Status                string        `db:"status" json:"status"`
+	Subject               string        `db:"subject" json:"subject"`
+	Priority              string        `db:"priority" json:"priority"`
+	Category              string        `db:"category" json:"category"`
+	CreatedAt             string        `db:"created_at" json:"created_at"`
+	UpdatedAt             string        `db:"updated_at" json:"updated_at"`
+	AdditionalUsers       pq.Int64Array `db:"additional_users_have_access" json:"additional_users_have_access"`
+	FirstResponseDueAt    *time.Time    `db:"first_response_due_at" json:"first_response_due_at"`
+	FirstResponseAt       *time.Time    `db:"first_response_at" json:"first_response_at"`
+	ResolutionDueAt       *time.Time    `db:"resolution_due_at" json:"resolution_due_at"`
+	ResolvedAt            *time.Time    `db:"resolved_at" json:"resolved_at"`
+	FirstResponseBreached bool          `db:"first_response_breached" json:"first_response_breached"`
+	ResolutionBreached    bool          `db:"resolution_breached" json:"resolution_breached"`
 }
 
 type TicketDoc struct {
-	ID              int64   `json:"id"`
-	UserID          *int64  `json:"user_id"`
-	AdminID         *int64  `json:"admin_id"`
-	AdditionalUsers []int64 `json:"additional_users_have_access"`
-	Status          string  `json:"status"`
-	Subject         string  `json:"subject"`
-	CreatedAt       string  `json:"created_at"`
-	UpdatedAt       string  `json:"updated_at"`
+	ID                    int64      `json:"id"`
+	UserID                *int64     `json:"user_id"`
+	AdminID               *int64     `json:"admin_id"`
+	AdditionalUsers       []int64    `json:"additional_users_have_access"`
+	Status                string     `json:"status"`
+	Subject               string     `json:"subject"`
+	Priority              string     `json:"priority"`
+	Category              string     `json:"category"`
+	CreatedAt             string     `json:"created_at"`
+	UpdatedAt             string     `json:"updated_at"`
+	FirstResponseDueAt    *time.Time `json:"first_response_due_at"`
+	FirstResponseAt       *time.Time `json:"first_response_at"`
+	ResolutionDueAt       *time.Time `json:"resolution_due_at"`
+	ResolvedAt            *time.Time `json:"resolved_at"`
+	FirstResponseBreached bool       `json:"first_response_breached"`
+	ResolutionBreached    bool       `json:"resolution_breached"`
+}
+
+// TicketColumns selects a ticket with its SLA breach flags. A timer is
+// breached when it was stopped late or is still running past its due date.
+const TicketColumns = `id, user_id, admin_id, status, subject, priority, category,
+	created_at, updated_at, additional_users_have_access,
+	first_response_due_at, first_response_at, resolution_due_at, resolved_at,
+	(COALESCE(first_response_at, NOW()) > first_response_due_at) IS TRUE AS first_response_breached,
+	(COALESCE(resolved_at, NOW()) > resolution_due_at) IS TRUE AS resolution_breached`
+
+// TicketSLA is the time allowed for the first response and the resolution
+// of a ticket
+type TicketSLA struct {
+	FirstResponse time.Duration
+	Resolution    time.Duration
+}
+
+// TicketNote is an internal note of the support team on a ticket
+type TicketNote struct {
+	ID             int64     `db:"id" json:"id"`
+	TicketID       int64     `db:"ticket_id" json:"ticket_id"`
+	AuthorID       *int64    `db:"author_id" json:"author_id"`
+	AuthorUsername *string   `db:"author_username" json:"author_username"`
+	Note           string    `db:"note" json:"note"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+// TicketLink connects a ticket to the task, dispute or transaction it is about
+type TicketLink struct {
+	TicketID   int64     `db:"ticket_id" json:"ticket_id"`
+	TargetType string    `db:"target_type" json:"target_type"`
+	TargetID   int64     `db:"target_id" json:"target_id"`
+	CreatedBy  *int64    `db:"created_by" json:"created_by"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+}
+
+// CannedResponse is a reply template for support. Category limits it to
+// tickets of one category, nil fits all.
+type CannedResponse struct {
+	ID        int64     `db:"id" json:"id"`
+	Title     string    `db:"title" json:"title"`
+	Body      string    `db:"body" json:"body"`
+	Category  *string   `db:"category" json:"category"`
+	CreatedBy *int64    `db:"created_by" json:"created_by"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// TicketQueueFilter narrows the queue of unassigned tickets
+type TicketQueueFilter struct {
+	Priority string
+	Category string
+	Breached bool
+	Limit    int
+	Offset   int
 }
 
 type TicketMessage struct {
@@ -46,24 +136,20 @@ type TicketMessage struct {
 
 func GetTicketByID(db *sqlx.DB, id int64) (*Ticket, error) {
 	var t Ticket
-	err := db.QueryRow(`
-		SELECT id, user_id, admin_id, status, subject, created_at, updated_at
-		FROM tickets
-		WHERE id=$1
-	`, id).Scan(&t.ID, &t.UserID, &t.AdminID, &t.Status, &t.Subject, &t.CreatedAt, &t.UpdatedAt)
+	err := db.Get(&t, `SELECT `+TicketColumns+` FROM tickets WHERE id=$1`, id)
 	if err != nil {
 		return nil, err
 	}
 	return &t, nil
 }
 
-func CreateTicket(db *sqlx.DB, subject string, userID int64) (int64, error) {
+func CreateTicket(db *sqlx.DB, subject string, userID int64, category, priority string, sla TicketSLA) (int64, error) {
 	var id int64
 	err := db.QueryRow(`
-		INSERT INTO tickets(subject, user_id) 
-		VALUES($1, $2) 
+		INSERT INTO tickets(subject, user_id, category, priority, first_response_due_at, resolution_due_at)
+		VALUES($1, $2, $3, $4, NOW() + $5 * INTERVAL '1 second', NOW() + $6 * INTERVAL '1 second')
 		RETURNING id
-	`, subject, userID).Scan(&id)
+	`, subject, userID, category, priority, sla.FirstResponse.Seconds(), sla.Resolution.Seconds()).Scan(&id)
 	if err != nil {
 		return 0, err
 	}
@@ -71,13 +157,13 @@ func CreateTicket(db *sqlx.DB, subject string, userID int64) (int64, error) {
 }
 func GetRandomPendingTicket(db *sqlx.DB) (*Ticket, error) {
 	var t Ticket
-	err := db.QueryRow(`
-		SELECT id, user_id, admin_id, status, subject, created_at, updated_at
+	err := db.Get(&t, `
+		SELECT `+TicketColumns+`
 		FROM tickets
 		WHERE status='open'
 		ORDER BY RANDOM()
 		LIMIT 1
-	`).Scan(&t.ID, &t.UserID, &t.AdminID, &t.Status, &t.Subject, &t.CreatedAt, &t.UpdatedAt)
+	`)
 	if err != nil {
 		return nil, err
 	}
@@ -166,7 +252,7 @@ func GetMessagesForTicket(db *sqlx.DB, ticketID, userID int64, limit, offset int
 func GetTicketsForUser(db *sqlx.DB, userID int64) ([]Ticket, error) {
 	var tickets []Ticket
 	query := `
-        SELECT *
+        SELECT ` + TicketColumns + `
         FROM tickets
         WHERE user_id = $1
            OR admin_id = $1
@@ -244,7 +330,7 @@ func ExitFromTicket(db *sqlx.DB, ticketID, userID int64) error {
 func CloseTicket(db *sqlx.DB, ticketID int64) error {
 	_, err := db.Exec(`
 		UPDATE tickets
-		SET status='closed', updated_at=NOW()
+		SET status='closed', resolved_at=COALESCE(resolved_at, NOW()), updated_at=NOW()
 		WHERE id=$1
 	`, ticketID)
 	return err
@@ -253,7 +339,7 @@ func CloseTicket(db *sqlx.DB, ticketID int64) error {
 func OpenTicket(db *sqlx.DB, ticketID int64) error {
 	_, err := db.Exec(`
 		UPDATE tickets
-		SET status='open', updated_at=NOW()
+		SET status='open', resolved_at=NULL, updated_at=NOW()
 		WHERE id=$1
 	`, ticketID)
 	return err
@@ -314,7 +400,7 @@ func MarkTicketMessagesRead(db *sqlx.DB, ticketID int64, userID int64) error {
 func GetAllTickets(db *sqlx.DB, adminID int64) ([]Ticket, error) {
 	var tickets []Ticket
 	query := `
-        SELECT id, user_id, admin_id, status, subject, created_at, updated_at, additional_users_have_access
+        SELECT ` + TicketColumns + `
         FROM tickets
         WHERE status = 'pending' OR admin_id = $1
         ORDER BY created_at DESC
@@ -8,11 +8,13 @@ import (
 type User struct {
 	ID           int64          `json:"id" db:"id"`
 	Username     string         `json:"username" db:"username"`
-	Mnemonic     string         `json:"-" db:"mnemonic"`
+	Mnemonic     sql.NullString `json:"-" db:"mnemonic"`
+	MnemonicHash sql.NullString `json:"-" db:"mnemonic_hash"`
 	PasswordHash string         `json:"-" db:"password_hash"`
 	CreatedAt    time.Time      `db:"created_at" json:"created_at"`
 	Blocked      bool           `db:"blocked" json:"blocked"`
 	IsAdmin      bool           `db:"is_admin" json:"is_admin"`
 	AdminTitle   sql.NullString `db:"admin_title" json:"admin_title"`
-	Permissions  int            `db:"permissions" json:"permissions"`
+	Permissions  int            `db:"permissions" json:"permissions"` // legacy bitmask, see roles
+	PermVersion  int64          `db:"perm_version" json:"-"`
 }
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
@@ -8,6 +10,18 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
+// ErrInsufficientBalance is returned by SubBalance when the balance doesn't
+// cover the amount
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+// Funds is a balance tasks are paid from and to: a user's wallet or an
+// organization's
+type Funds interface {
+	BigBalance() *big.Float
+	AddBalance(db interface{}, delta *big.Float) error
+	SubBalance(db interface{}, delta *big.Float) error
+}
+
 type Wallet struct {
 	ID       int64  `db:"id"`
 	UserID   int64  `db:"user_id"`
@@ -111,18 +125,35 @@ func (w *Wallet) SetBalance(db interface{}, newBal *big.Float) error {
 }
 
 func (w *Wallet) AddBalance(db interface{}, delta *big.Float) error {
-	current := w.BigBalance()
-	newBal := new(big.Float).Add(current, delta)
-	return w.SetBalance(db, newBal)
+	return w.change(db, delta)
 }
 
 func (w *Wallet) SubBalance(db interface{}, delta *big.Float) error {
-	current := w.BigBalance()
-	newBal := new(big.Float).Sub(current, delta)
-	if newBal.Cmp(big.NewFloat(0)) < 0 {
-		return fmt.Errorf("insufficient balance")
+	return w.change(db, new(big.Float).Neg(delta))
+}
+
+// change moves the stored balance by delta unless it would go negative. The
+// update is relative, so concurrent changes to the same wallet don't
+// overwrite each other whatever w.Balance was loaded as.
+func (w *Wallet) change(db interface{}, delta *big.Float) error {
+	q, ok := db.(sqlx.Ext)
+	if !ok {
+		return fmt.Errorf("unsupported database interface")
+	}
+	var balance string
+	err := sqlx.Get(q, &balance, `
+		UPDATE wallets SET balance = balance + $1
+		WHERE id = $2 AND balance + $1 >= 0
+		RETURNING balance
+	`, fmt.Sprintf("%.8f", delta), w.ID)
+	if err == sql.ErrNoRows {
+		return ErrInsufficientBalance
+	}
+	if err != nil {
+		return err
 	}
-	return w.SetBalance(db, newBal)
+	w.Balance = balance
+	return nil
 }
 
 func AddToWalletBalance(db *sqlx.DB, address, currency string, delta *big.Float) error {
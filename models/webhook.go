@@ -0,0 +1,54 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type WebhookEndpoint struct {
+	ID          int64          `db:"id" json:"id"`
+	OwnerID     int64          `db:"owner_id" json:"owner_id"`
+	URL         string         `db:"url" json:"url"`
+	Secret      string         `db:"secret" json:"-"`
+	Events      pq.StringArray `db:"events" json:"events"`
+	Global      bool           `db:"global" json:"global"`
+	Active      bool           `db:"active" json:"active"`
+	Description string         `db:"description" json:"description"`
+	CreatedAt   time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time      `db:"updated_at" json:"updated_at"`
+}
+
+type WebhookDelivery struct {
+	ID             int64           `db:"id" json:"id"`
+	EndpointID     int64           `db:"endpoint_id" json:"endpoint_id"`
+	EventID        string          `db:"event_id" json:"event_id"`
+	Event          string          `db:"event" json:"event"`
+	Payload        json.RawMessage `db:"payload" json:"payload"`
+	Status         string          `db:"status" json:"status"`
+	Attempts       int             `db:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time       `db:"next_attempt_at" json:"next_attempt_at"`
+	LastStatusCode *int            `db:"last_status_code" json:"last_status_code"`
+	LastError      string          `db:"last_error" json:"last_error"`
+	DeliveredAt    *time.Time      `db:"delivered_at" json:"delivered_at"`
+	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+}
+
+// WebhookJob is a claimed delivery together with where to send it
+type WebhookJob struct {
+	WebhookDelivery
+	URL    string `db:"url"`
+	Secret string `db:"secret"`
+}
+
+type WebhookAttempt struct {
+	ID         int64     `db:"id" json:"id"`
+	DeliveryID int64     `db:"delivery_id" json:"delivery_id"`
+	Attempt    int       `db:"attempt" json:"attempt"`
+	StatusCode *int      `db:"status_code" json:"status_code"`
+	Error      string    `db:"error" json:"error"`
+	Response   string    `db:"response" json:"response"`
+	DurationMS int64     `db:"duration_ms" json:"duration_ms"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+}
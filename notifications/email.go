@@ -0,0 +1,62 @@
+package notifications
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"mFrelance/db"
+	"mFrelance/mailer"
+)
+
+// emailTemplates maps the event types that are also sent by email to their
+// mailer template
+var emailTemplates = map[string]string{
+	TypeDepositCredited:  "deposit_credited",
+	TypeWithdrawalSent:   "withdrawal_sent",
+	TypeDisputeMessage:   "dispute_update",
+	TypeDisputeResolved:  "dispute_update",
+	TypePasswordRestored: "password_restored",
+}
+
+// HasEmail reports whether events of typ can be delivered by email
+func HasEmail(typ string) bool {
+	_, ok := emailTemplates[typ]
+	return ok
+}
+
+// Email queues the event in the outbox when the user has a verified address
+// and didn't turn email off for the type
+func Email(ev Event) {
+	name, ok := emailTemplates[ev.Type]
+	if !ok {
+		return
+	}
+	addr, err := db.GetUserEmail(db.Postgres, ev.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		log.Printf("[notifications] failed to load email of user %d: %v", ev.UserID, err)
+		return
+	}
+	if !addr.Verified() {
+		return
+	}
+	pref, err := db.GetNotificationPreference(db.Postgres, ev.UserID, ev.Type)
+	if err != nil {
+		log.Printf("[notifications] failed to load preference of user %d: %v", ev.UserID, err)
+		return
+	}
+	if !pref.Email {
+		return
+	}
+	data := map[string]interface{}{
+		"Title": ev.Title,
+		"Body":  ev.Body,
+		"Data":  ev.Data,
+	}
+	if err := mailer.Enqueue(ev.UserID, addr.Email, addr.Locale, name, data); err != nil {
+		log.Printf("[notifications] failed to queue %s email for user %d: %v", ev.Type, ev.UserID, err)
+	}
+}
@@ -0,0 +1,46 @@
+package notifications
+
+import (
+	"context"
+	"log"
+
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/realtime"
+)
+
+// Inbox stores the event unless the user turned the type off, and pushes
+// the stored notification to the user's open connections
+func Inbox(ev Event) {
+	pref, err := db.GetNotificationPreference(db.Postgres, ev.UserID, ev.Type)
+	if err != nil {
+		log.Printf("[notifications] failed to load preference of user %d: %v", ev.UserID, err)
+		return
+	}
+	if !pref.InApp {
+		return
+	}
+	n := &models.Notification{
+		UserID: ev.UserID,
+		Type:   ev.Type,
+		Title:  ev.Title,
+		Body:   ev.Body,
+	}
+	var data interface{}
+	if len(ev.Data) > 0 {
+		data = ev.Data
+	}
+	if err := db.CreateNotification(db.Postgres, n, data); err != nil {
+		log.Printf("[notifications] failed to store %s for user %d: %v", ev.Type, ev.UserID, err)
+		return
+	}
+	realtime.Publish(realtime.Event{Type: realtime.EventNotification, Data: n}, ev.UserID)
+}
+
+// Start subscribes the inbox and the email channel and runs Default until
+// ctx is done
+func Start(ctx context.Context) {
+	Default.Subscribe(Inbox)
+	Default.Subscribe(Email)
+	go Default.Run(ctx)
+}
@@ -0,0 +1,164 @@
+// Package notifications turns domain events into inbox entries and emails.
+// Handlers and background jobs publish events on the bus, subscribers decide
+// what to do with them: store them in the inbox, push them to connected
+// clients, queue an email, and so on.
+package notifications
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Event types users can receive and configure
+const (
+	TypeOfferCreated    = "offer.created"
+	TypeOfferAccepted   = "offer.accepted"
+	TypeEscrowReleased  = "escrow.released"
+	TypeDisputeMessage  = "dispute.message"
+	TypeTicketReply     = "ticket.reply"
+	TypeDepositCredited = "wallet.deposit"
+
+	TypeWithdrawalSent    = "wallet.withdrawal"
+	TypeDisputeResolved   = "dispute.resolved"
+	TypePasswordRestored  = "account.password_restored"
+	TypeModerationWarning = "moderation.warning"
+	TypeReviewReceived    = "review.received"
+
+	TypeContractWeekSubmitted = "contract.week_submitted"
+	TypeContractWeekDisputed  = "contract.week_disputed"
+	TypeContractFundingLow    = "contract.funding_low"
+
+	TypeOfferCountered = "offer.countered"
+	TypeOfferRevised   = "offer.revised"
+	TypeOfferRejected  = "offer.rejected"
+	TypeOfferExpired   = "offer.expired"
+
+	TypeTaskInvited   = "task.invited"
+	TypeTaskScheduled = "task.scheduled"
+
+	TypeOrgInvited = "org.invited"
+)
+
+// Types lists every event type in the order shown to users
+var Types = []string{
+	TypeTaskInvited,
+	TypeTaskScheduled,
+	TypeOfferCreated,
+	TypeOfferAccepted,
+	TypeOfferCountered,
+	TypeOfferRevised,
+	TypeOfferRejected,
+	TypeOfferExpired,
+	TypeEscrowReleased,
+	TypeDisputeMessage,
+	TypeTicketReply,
+	TypeDepositCredited,
+	TypeWithdrawalSent,
+	TypeDisputeResolved,
+	TypePasswordRestored,
+	TypeModerationWarning,
+	TypeReviewReceived,
+	TypeContractWeekSubmitted,
+	TypeContractWeekDisputed,
+	TypeContractFundingLow,
+	TypeOrgInvited,
+}
+
+// IsKnownType reports whether typ is one of Types
+func IsKnownType(typ string) bool {
+	for _, t := range Types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is a notification for one user
+type Event struct {
+	Type   string
+	UserID int64
+	Title  string
+	Body   string
+	Data   map[string]interface{}
+}
+
+// Handler consumes events from the bus
+type Handler func(Event)
+
+// Bus queues events and hands them to the subscribers on a background
+// goroutine, so publishing never blocks a request
+type Bus struct {
+	ch       chan Event
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+const busBuffer = 1024
+
+// Default is the bus the handlers publish to, started in main
+var Default = NewBus(busBuffer)
+
+func NewBus(size int) *Bus {
+	return &Bus{ch: make(chan Event, size)}
+}
+
+// Subscribe adds h. Subscribers run one after another for every event.
+func (b *Bus) Subscribe(h Handler) {
+	b.mu.Lock()
+	b.handlers = append(b.handlers, h)
+	b.mu.Unlock()
+}
+
+// Publish queues ev. When the queue is full the event is dropped and logged.
+func (b *Bus) Publish(ev Event) {
+	if ev.UserID <= 0 {
+		return
+	}
+	select {
+	case b.ch <- ev:
+	default:
+		log.Printf("[notifications] queue full, dropping %s for user %d", ev.Type, ev.UserID)
+	}
+}
+
+// Run dispatches queued events until ctx is done
+func (b *Bus) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-b.ch:
+			b.mu.RLock()
+			handlers := b.handlers
+			b.mu.RUnlock()
+			for _, h := range handlers {
+				b.dispatch(h, ev)
+			}
+		}
+	}
+}
+
+func (b *Bus) dispatch(h Handler, ev Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[notifications] subscriber panic on %s: %v", ev.Type, r)
+		}
+	}()
+	h(ev)
+}
+
+// Publish queues ev on Default for every user in userIDs, skipping
+// duplicates and zero IDs
+func Publish(ev Event, userIDs ...int64) {
+	seen := make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		if id <= 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ev.UserID = id
+		Default.Publish(ev)
+	}
+}
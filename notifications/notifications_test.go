@@ -0,0 +1,60 @@
+package notifications_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"mFrelance/notifications"
+)
+
+func TestBus_DeliversToSubscribers(t *testing.T) {
+	bus := notifications.NewBus(4)
+	got := make(chan notifications.Event, 4)
+	bus.Subscribe(func(ev notifications.Event) { got <- ev })
+	bus.Subscribe(func(notifications.Event) { panic("broken subscriber") })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bus.Run(ctx)
+
+	bus.Publish(notifications.Event{Type: notifications.TypeOfferCreated, UserID: 0})
+	bus.Publish(notifications.Event{Type: notifications.TypeOfferCreated, UserID: 7})
+	bus.Publish(notifications.Event{Type: notifications.TypeTicketReply, UserID: 8})
+
+	for _, want := range []int64{7, 8} {
+		select {
+		case ev := <-got:
+			if ev.UserID != want {
+				t.Fatalf("got event for user %d, want %d", ev.UserID, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("event for user %d not delivered", want)
+		}
+	}
+}
+
+func TestBus_DropsWhenFull(t *testing.T) {
+	bus := notifications.NewBus(1)
+	bus.Publish(notifications.Event{Type: notifications.TypeOfferCreated, UserID: 1})
+	// not running, the second event must not block
+	done := make(chan struct{})
+	go func() {
+		bus.Publish(notifications.Event{Type: notifications.TypeOfferCreated, UserID: 2})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Publish blocked on a full queue")
+	}
+}
+
+func TestIsKnownType(t *testing.T) {
+	if !notifications.IsKnownType(notifications.TypeDepositCredited) {
+		t.Fatalf("deposit type should be known")
+	}
+	if notifications.IsKnownType("offer.deleted") {
+		t.Fatalf("unknown type accepted")
+	}
+}
@@ -0,0 +1,206 @@
+// Package realtime pushes chat events and notifications to connected
+// WebSocket and SSE clients. Events are published to a Redis channel so every server instance delivers
+// them to the clients connected to it.
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Event types
+const (
+	EventMessage        = "message"
+	EventMessageEdited  = "message_edited"
+	EventMessageDeleted = "message_deleted"
+	EventChatRequest    = "chat_request"
+	EventTyping         = "typing"
+	EventRead           = "read"
+	EventNotification   = "notification"
+	EventPing           = "ping"
+	EventError          = "error"
+)
+
+// Channel is the Redis pub/sub channel shared by all instances
+const Channel = "chat:events"
+
+// clientBuffer is how many events may wait for a slow client before new
+// ones are dropped
+const clientBuffer = 64
+
+// Event is what clients receive, encoded as JSON
+type Event struct {
+	Type       string      `json:"type"`
+	ChatRoomID int64       `json:"chat_room_id,omitempty"`
+	UserID     int64       `json:"user_id,omitempty"`
+	Data       interface{} `json:"data,omitempty"`
+	At         time.Time   `json:"at"`
+}
+
+type envelope struct {
+	To    []int64         `json:"to"`
+	Event json.RawMessage `json:"event"`
+}
+
+// Client is one open connection of a user
+type Client struct {
+	UserID int64
+	Send   chan []byte
+}
+
+// Hub keeps the connections of this instance by user
+type Hub struct {
+	rdb     *redis.Client
+	mu      sync.RWMutex
+	clients map[int64]map[*Client]struct{}
+}
+
+// Default is the hub used by the handlers, set up in main
+var Default *Hub
+
+// NewHub creates a hub. With a nil client events are only delivered locally.
+func NewHub(rdb *redis.Client) *Hub {
+	return &Hub{
+		rdb:     rdb,
+		clients: make(map[int64]map[*Client]struct{}),
+	}
+}
+
+// Register adds a connection for userID
+func (h *Hub) Register(userID int64) *Client {
+	c := &Client{UserID: userID, Send: make(chan []byte, clientBuffer)}
+	h.mu.Lock()
+	if h.clients[userID] == nil {
+		h.clients[userID] = make(map[*Client]struct{})
+	}
+	h.clients[userID][c] = struct{}{}
+	h.mu.Unlock()
+	return c
+}
+
+// Unregister removes the connection
+func (h *Hub) Unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if set, ok := h.clients[c.UserID]; ok {
+		delete(set, c)
+		if len(set) == 0 {
+			delete(h.clients, c.UserID)
+		}
+	}
+}
+
+// Online reports whether the user has a connection on this instance
+func (h *Hub) Online(userID int64) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients[userID]) > 0
+}
+
+// Publish sends ev to the given users on every instance
+func (h *Hub) Publish(ev Event, to ...int64) error {
+	if len(to) == 0 {
+		return nil
+	}
+	if ev.At.IsZero() {
+		ev.At = time.Now().UTC()
+	}
+	raw, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	env := envelope{To: unique(to), Event: raw}
+	if h.rdb == nil {
+		h.deliver(env)
+		return nil
+	}
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return h.rdb.Publish(context.Background(), Channel, payload).Err()
+}
+
+// Run consumes the Redis channel until ctx is done. It is a no-op without
+// Redis.
+func (h *Hub) Run(ctx context.Context) {
+	if h.rdb == nil {
+		return
+	}
+	sub := h.rdb.Subscribe(ctx, Channel)
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		log.Println("[realtime] subscribe failed:", err)
+		return
+	}
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var env envelope
+			if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+				log.Println("[realtime] bad payload:", err)
+				continue
+			}
+			h.deliver(env)
+		}
+	}
+}
+
+func (h *Hub) deliver(env envelope) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, userID := range env.To {
+		for c := range h.clients[userID] {
+			select {
+			case c.Send <- env.Event:
+			default:
+				log.Printf("[realtime] dropping event for slow client of user %d", userID)
+			}
+		}
+	}
+}
+
+// Publish sends ev through Default. Errors are logged, a failed push never
+// fails the request that caused it.
+func Publish(ev Event, to ...int64) {
+	if Default == nil {
+		return
+	}
+	if err := Default.Publish(ev, to...); err != nil {
+		log.Println("[realtime] publish failed:", err)
+	}
+}
+
+// Except returns ids without skip
+func Except(ids []int64, skip int64) []int64 {
+	out := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if id != skip {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func unique(ids []int64) []int64 {
+	seen := make(map[int64]struct{}, len(ids))
+	out := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,82 @@
+package realtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"mFrelance/realtime"
+	"mFrelance/server/testutil"
+)
+
+func receive(t *testing.T, c *realtime.Client) realtime.Event {
+	t.Helper()
+	select {
+	case raw := <-c.Send:
+		var ev realtime.Event
+		if err := json.Unmarshal(raw, &ev); err != nil {
+			t.Fatalf("bad event %s: %v", raw, err)
+		}
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no event received")
+	}
+	return realtime.Event{}
+}
+
+func TestHub_FanOutAcrossInstances(t *testing.T) {
+	mr, rdb := testutil.NewMiniRedis(t)
+	defer mr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b := realtime.NewHub(rdb), realtime.NewHub(rdb)
+	go a.Run(ctx)
+	go b.Run(ctx)
+
+	alice := b.Register(1)
+	bob := b.Register(2)
+	defer b.Unregister(alice)
+	defer b.Unregister(bob)
+
+	// wait until both hubs are subscribed
+	deadline := time.Now().Add(2 * time.Second)
+	for mr.PubSubNumSub(realtime.Channel)[realtime.Channel] < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("hubs did not subscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := a.Publish(realtime.Event{Type: realtime.EventTyping, ChatRoomID: 7, UserID: 2}, 1); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	ev := receive(t, alice)
+	if ev.Type != realtime.EventTyping || ev.ChatRoomID != 7 || ev.UserID != 2 {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	select {
+	case raw := <-bob.Send:
+		t.Fatalf("event leaked to another user: %s", raw)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHub_LocalWithoutRedis(t *testing.T) {
+	h := realtime.NewHub(nil)
+	c := h.Register(5)
+	if !h.Online(5) {
+		t.Fatalf("user should be online")
+	}
+	if err := h.Publish(realtime.Event{Type: realtime.EventRead, ChatRoomID: 3}, 5); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if ev := receive(t, c); ev.Type != realtime.EventRead {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	h.Unregister(c)
+	if h.Online(5) {
+		t.Fatalf("user should be offline")
+	}
+}
@@ -0,0 +1,95 @@
+package reputation
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"mFrelance/config"
+	"mFrelance/db"
+	"mFrelance/models"
+)
+
+// Reasons a score is recalculated, stored in the history
+const (
+	ReasonOffer           = "offer"
+	ReasonTaskCompleted   = "task_completed"
+	ReasonDisputeResolved = "dispute_resolved"
+	ReasonReview          = "review"
+	ReasonRefresh         = "refresh"
+)
+
+type job struct {
+	userID int64
+	reason string
+}
+
+const queueSize = 1024
+
+var queue = make(chan job, queueSize)
+
+// Touch queues a recalculation for every user in userIDs. It never blocks,
+// when the queue is full the periodic refresh catches up later.
+func Touch(reason string, userIDs ...int64) {
+	for _, id := range userIDs {
+		if id <= 0 {
+			continue
+		}
+		select {
+		case queue <- job{userID: id, reason: reason}:
+		default:
+			log.Printf("[reputation] queue full, dropping %s for user %d", reason, id)
+		}
+	}
+}
+
+// Recalculate computes and stores the score of a user right away
+func Recalculate(userID int64, reason string) (*models.Reputation, error) {
+	stats, err := db.GetReputationStats(db.Postgres, userID)
+	if err != nil {
+		return nil, err
+	}
+	rep := Compute(stats, ParamsFromConfig(), time.Now())
+	if _, err := db.SaveReputation(db.Postgres, rep, reason); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// refreshBatch caps the users refreshed per tick
+const refreshBatch = 200
+
+// refresh recalculates scores older than the refresh interval, which picks
+// up account age and users that never had an event
+func refresh() {
+	ids, err := db.ListStaleReputationUsers(db.Postgres, config.AppConfig.ReputationRefreshInterval.Seconds(), refreshBatch)
+	if err != nil {
+		log.Println("[reputation] refresh:", err)
+		return
+	}
+	for _, id := range ids {
+		if _, err := Recalculate(id, ReasonRefresh); err != nil {
+			log.Printf("[reputation] refresh user %d: %v", id, err)
+		}
+	}
+}
+
+// Start recalculates touched users and refreshes stale scores until ctx is
+// done
+func Start(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	refresh()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-queue:
+			if _, err := Recalculate(j.userID, j.reason); err != nil {
+				log.Printf("[reputation] user %d: %v", j.userID, err)
+			}
+		case <-ticker.C:
+			refresh()
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Package reputation turns a user's track record into a score from 0 to
+// 100. The star rating and the delivery rates are smoothed towards a prior,
+// earnings and account age saturate, so a new account with a single
+// 5-star review can't outrank users with a long history.
+package reputation
+
+import (
+	"math"
+	"time"
+
+	"mFrelance/config"
+	"mFrelance/models"
+)
+
+// Weights of the components in the score. They don't have to add up to 1.
+type Weights struct {
+	Rating     float64
+	Completion float64
+	Disputes   float64
+	OnTime     float64
+	Earnings   float64
+	Age        float64
+}
+
+type Params struct {
+	Weights Weights
+	// PriorRating and PriorWeight act as PriorWeight reviews of
+	// PriorRating stars every user starts with
+	PriorRating float64
+	PriorWeight float64
+	// EarningsReference is the amount per currency that earns about two
+	// thirds of the earnings component
+	EarningsReference map[string]float64
+	// FullAge is the account age that earns the whole age component
+	FullAge time.Duration
+}
+
+// Priors of the delivery rates and how many contracts they weigh
+const (
+	priorCompletion  = 0.75
+	priorOnTime      = 0.75
+	priorDisputeLoss = 0.1
+	priorRateWeight  = 3
+)
+
+// ParamsFromConfig reads the parameters from config.AppConfig
+func ParamsFromConfig() Params {
+	c := config.AppConfig
+	return Params{
+		Weights: Weights{
+			Rating:     c.ReputationWeights["rating"],
+			Completion: c.ReputationWeights["completion"],
+			Disputes:   c.ReputationWeights["disputes"],
+			OnTime:     c.ReputationWeights["on_time"],
+			Earnings:   c.ReputationWeights["earnings"],
+			Age:        c.ReputationWeights["age"],
+		},
+		PriorRating:       c.ReputationPriorRating,
+		PriorWeight:       c.ReputationPriorWeight,
+		EarningsReference: c.ReputationEarningsReference,
+		FullAge:           c.ReputationFullAge,
+	}
+}
+
+// smooth is the Bayesian average of hits out of n with prior weighing as
+// much as weight observations
+func smooth(hits, n int, prior, weight float64) float64 {
+	return (float64(hits) + prior*weight) / (float64(n) + weight)
+}
+
+// Compute scores s as of now
+func Compute(s *models.ReputationStats, p Params, now time.Time) *models.Reputation {
+	rep := &models.Reputation{
+		UserID:      s.UserID,
+		ReviewCount: s.RatingCount,
+		Contracts:   s.Contracts,
+	}
+
+	rep.Rating = (p.PriorRating*p.PriorWeight + s.RatingSum) / (p.PriorWeight + float64(s.RatingCount))
+	rep.CompletionRate = smooth(s.Released, s.Released+s.Refunded, priorCompletion, priorRateWeight)
+	rep.DisputeLossRate = smooth(s.DisputesLost, s.Contracts, priorDisputeLoss, priorRateWeight)
+	rep.OnTimeRate = smooth(s.OnTime, s.WithDeadline, priorOnTime, priorRateWeight)
+
+	var volume float64
+	for currency, amount := range s.Earnings {
+		if ref := p.EarningsReference[currency]; ref > 0 && amount > 0 {
+			volume += amount / ref
+		}
+	}
+	rep.EarningsScore = 1 - math.Exp(-volume)
+
+	age := now.Sub(s.AccountCreated)
+	if age < 0 {
+		age = 0
+	}
+	rep.AccountAgeDays = int(age.Hours() / 24)
+	ageScore := 1.0
+	if p.FullAge > 0 {
+		ageScore = math.Min(1, age.Seconds()/p.FullAge.Seconds())
+	}
+
+	w := p.Weights
+	total := w.Rating + w.Completion + w.Disputes + w.OnTime + w.Earnings + w.Age
+	if total <= 0 {
+		return rep
+	}
+	score := w.Rating*clamp((rep.Rating-1)/4) +
+		w.Completion*rep.CompletionRate +
+		w.Disputes*(1-rep.DisputeLossRate) +
+		w.OnTime*rep.OnTimeRate +
+		w.Earnings*rep.EarningsScore +
+		w.Age*ageScore
+	rep.Score = math.Round(10000*score/total) / 100
+	return rep
+}
+
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
@@ -0,0 +1,107 @@
+package reputation_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"mFrelance/models"
+	"mFrelance/reputation"
+)
+
+var params = reputation.Params{
+	Weights: reputation.Weights{
+		Rating:     0.35,
+		Completion: 0.15,
+		Disputes:   0.15,
+		OnTime:     0.15,
+		Earnings:   0.1,
+		Age:        0.1,
+	},
+	PriorRating:       3.5,
+	PriorWeight:       5,
+	EarningsReference: map[string]float64{"BTC": 0.05, "XMR": 5},
+	FullAge:           365 * 24 * time.Hour,
+}
+
+var now = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestCompute_NewcomerBelowVeteran(t *testing.T) {
+	newcomer := &models.ReputationStats{
+		UserID:         1,
+		RatingSum:      5,
+		RatingCount:    1,
+		Contracts:      1,
+		Released:       1,
+		WithDeadline:   1,
+		OnTime:         1,
+		Earnings:       map[string]float64{"BTC": 0.001},
+		AccountCreated: now.Add(-7 * 24 * time.Hour),
+	}
+	veteran := &models.ReputationStats{
+		UserID:         2,
+		RatingSum:      4.6 * 50,
+		RatingCount:    50,
+		Contracts:      55,
+		Released:       50,
+		Refunded:       2,
+		DisputesLost:   2,
+		WithDeadline:   48,
+		OnTime:         43,
+		Earnings:       map[string]float64{"BTC": 0.4, "XMR": 20},
+		AccountCreated: now.Add(-3 * 365 * 24 * time.Hour),
+	}
+	n := reputation.Compute(newcomer, params, now)
+	v := reputation.Compute(veteran, params, now)
+	if n.Score >= v.Score {
+		t.Fatalf("newcomer scored %.2f, veteran %.2f", n.Score, v.Score)
+	}
+	if n.Rating >= 4 {
+		t.Fatalf("one 5-star review smoothed to %.2f, want it pulled towards the prior", n.Rating)
+	}
+	if v.AccountAgeDays != 3*365 {
+		t.Fatalf("account age %d days", v.AccountAgeDays)
+	}
+}
+
+func TestCompute_EmptyRecordUsesPriors(t *testing.T) {
+	rep := reputation.Compute(&models.ReputationStats{AccountCreated: now}, params, now)
+	if rep.Rating != params.PriorRating {
+		t.Fatalf("rating %.2f, want the prior %.2f", rep.Rating, params.PriorRating)
+	}
+	if !near(rep.CompletionRate, 0.75) || !near(rep.OnTimeRate, 0.75) || !near(rep.DisputeLossRate, 0.1) {
+		t.Fatalf("rates not at their priors: %+v", rep)
+	}
+	if rep.EarningsScore != 0 {
+		t.Fatalf("earnings score %.2f without earnings", rep.EarningsScore)
+	}
+	if rep.Score <= 0 || rep.Score >= 100 {
+		t.Fatalf("score %.2f out of range", rep.Score)
+	}
+}
+
+func TestCompute_DisputeLossesLowerScore(t *testing.T) {
+	clean := &models.ReputationStats{Contracts: 10, Released: 10, AccountCreated: now}
+	lost := &models.ReputationStats{Contracts: 10, Released: 7, Refunded: 3, DisputesLost: 3, AccountCreated: now}
+	if c, l := reputation.Compute(clean, params, now).Score, reputation.Compute(lost, params, now).Score; l >= c {
+		t.Fatalf("lost disputes scored %.2f, clean record %.2f", l, c)
+	}
+}
+
+func TestCompute_UnknownCurrencyIgnored(t *testing.T) {
+	rep := reputation.Compute(&models.ReputationStats{Earnings: map[string]float64{"DOGE": 1e6}, AccountCreated: now}, params, now)
+	if rep.EarningsScore != 0 {
+		t.Fatalf("earnings score %.2f for a currency without reference", rep.EarningsScore)
+	}
+}
+
+func TestCompute_NoWeights(t *testing.T) {
+	rep := reputation.Compute(&models.ReputationStats{RatingSum: 5, RatingCount: 1, AccountCreated: now}, reputation.Params{PriorWeight: 5}, now)
+	if rep.Score != 0 {
+		t.Fatalf("score %.2f without weights", rep.Score)
+	}
+}
@@ -0,0 +1,212 @@
+// Package schedule parses the cron expressions of recurring tasks and works
+// out when they run next.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchYears bounds the search for the next run of expressions that
+// rarely or never match, such as February 30th
+const searchYears = 5
+
+// Cron is a parsed five field cron expression: minute, hour, day of month,
+// month and day of week. Fields take numbers, names (JAN, MON), ranges,
+// lists and steps. As in cron, a day matches either day field when both are
+// restricted.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+type field struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{min: 0, max: 59}
+	hourField   = field{min: 0, max: 23}
+	domField    = field{min: 1, max: 31}
+	monthField  = field{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is Sunday too
+	dowField = field{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a five field expression or one of @yearly, @monthly,
+// @weekly, @daily and @hourly
+func Parse(expr string) (*Cron, error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := descriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("cron expression needs 5 fields: minute hour day-of-month month day-of-week")
+	}
+
+	c := &Cron{}
+	var err error
+	if c.minute, _, err = minuteField.parse(fields[0]); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, _, err = hourField.parse(fields[1]); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, c.domStar, err = domField.parse(fields[2]); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, _, err = monthField.parse(fields[3]); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, c.dowStar, err = dowField.parse(fields[4]); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parse returns the bits of the values a field matches and whether it is
+// an unrestricted *
+func (f field) parse(s string) (uint64, bool, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, false, fmt.Errorf("bad step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			i := strings.Index(rng, "-")
+			var err error
+			if lo, err = f.value(rng[:i]); err != nil {
+				return 0, false, err
+			}
+			if hi, err = f.value(rng[i+1:]); err != nil {
+				return 0, false, err
+			}
+			if lo > hi {
+				return 0, false, fmt.Errorf("bad range %q", rng)
+			}
+		default:
+			v, err := f.value(rng)
+			if err != nil {
+				return 0, false, err
+			}
+			lo = v
+			if step == 1 {
+				hi = v
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, s == "*", nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%q is not between %d and %d", s, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time after t the expression matches, in t's
+// location, or the zero time if it doesn't match within five years
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.Year() + searchYears
+
+wrap:
+	for t.Year() <= limit {
+		for c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			if t.Month() == time.January {
+				continue wrap
+			}
+		}
+		for !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			if t.Day() == 1 {
+				continue wrap
+			}
+		}
+		for c.hour&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			if next.Day() != t.Day() {
+				t = next
+				continue wrap
+			}
+			t = next
+		}
+		for c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			if t.Minute() == 0 {
+				continue wrap
+			}
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// MinGap returns the shortest time between two of the next n runs after t,
+// or zero if the expression runs less than twice
+func (c *Cron) MinGap(t time.Time, n int) time.Duration {
+	var gap time.Duration
+	prev := c.Next(t)
+	for i := 1; i < n && !prev.IsZero(); i++ {
+		next := c.Next(prev)
+		if next.IsZero() {
+			break
+		}
+		if d := next.Sub(prev); gap == 0 || d < gap {
+			gap = d
+		}
+		prev = next
+	}
+	return gap
+}
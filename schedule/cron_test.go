@@ -0,0 +1,85 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2025, 3, 5, 10, 30, 0, 0, time.UTC)
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"0 9 * * MON", time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2025, 3, 9, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, 3, 5, 10, 45, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2025, 3, 6, 10, 30, 0, 0, time.UTC)},
+		{"0 8 1 * *", time.Date(2025, 4, 1, 8, 0, 0, 0, time.UTC)},
+		{"0 12 * jun-aug 1-5", time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// both day fields restricted: either one matches
+		{"0 0 15 * 5", time.Date(2025, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, 3, 9, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		cron, err := Parse(c.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.expr, err)
+		}
+		if got := cron.Next(from); !got.Equal(c.want) {
+			t.Errorf("%q: next run %s, want %s", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestNextKeepsLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	cron, err := Parse("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := cron.Next(time.Date(2025, 3, 5, 7, 0, 0, 0, time.UTC).In(loc))
+	if want := time.Date(2025, 3, 6, 6, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("next run %s, want %s", got, want)
+	}
+}
+
+func TestNextNeverMatches(t *testing.T) {
+	cron, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cron.Next(time.Now()); !got.IsZero() {
+		t.Errorf("February 30th ran at %s", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestMinGap(t *testing.T) {
+	from := time.Date(2025, 3, 5, 10, 30, 0, 0, time.UTC)
+	cases := map[string]time.Duration{
+		"@weekly":      7 * 24 * time.Hour,
+		"0 9 * * 1,2":  24 * time.Hour,
+		"*/10 * * * *": 10 * time.Minute,
+		"0 9,17 * * *": 8 * time.Hour,
+		"0 0 30 2 *":   0,
+	}
+	for expr, want := range cases {
+		cron, err := Parse(expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", expr, err)
+		}
+		if got := cron.MinGap(from, 50); got != want {
+			t.Errorf("%q: gap %s, want %s", expr, got, want)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/notifications"
+	"mFrelance/reputation"
+	"mFrelance/webhooks"
+)
+
+// StartContractSettlement runs SettleContracts every interval
+func StartContractSettlement(ctx context.Context, reviewWindow, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			SettleContracts(reviewWindow)
+		}
+	}
+}
+
+// SettleContracts moves hourly contracts along: finished weeks are submitted
+// to the client, weeks left unreviewed for reviewWindow are approved,
+// approved weeks are paid from escrow and ended contracts whose weeks are
+// all paid are closed
+func SettleContracts(reviewWindow time.Duration) {
+	submitted, err := db.CloseContractWeeks(db.Postgres, models.WeekStart(time.Now().UTC()))
+	if err != nil {
+		log.Println("[SettleContracts] close weeks:", err)
+	}
+	for _, w := range submitted {
+		NotifyContractWeekSubmitted(w)
+	}
+
+	if _, err := db.ApproveExpiredContractWeeks(db.Postgres, reviewWindow); err != nil {
+		log.Println("[SettleContracts] approve weeks:", err)
+	}
+
+	approved, err := db.ListApprovedContractWeeks(db.Postgres)
+	if err != nil {
+		log.Println("[SettleContracts] list weeks:", err)
+	}
+	for _, w := range approved {
+		if err := settleContractWeek(w); err != nil {
+			log.Printf("[SettleContracts] task %d week %s: %v", w.TaskID, w.WeekStart.Format(time.DateOnly), err)
+		}
+	}
+
+	finished, err := db.ListFinishedContracts(db.Postgres)
+	if err != nil {
+		log.Println("[SettleContracts] list contracts:", err)
+	}
+	for _, task := range finished {
+		if err := closeContract(task); err != nil {
+			log.Printf("[SettleContracts] close task %d: %v", task.ID, err)
+		}
+	}
+}
+
+// NotifyContractWeekSubmitted asks the client to review a week of time
+func NotifyContractWeekSubmitted(w models.ContractWeek) {
+	task, err := db.GetTask(db.Postgres, w.TaskID)
+	if err != nil {
+		log.Printf("[NotifyContractWeekSubmitted] task %d: %v", w.TaskID, err)
+		return
+	}
+	notifications.Publish(notifications.Event{
+		Type:  notifications.TypeContractWeekSubmitted,
+		Title: "Timesheet ready for review",
+		Body:  fmt.Sprintf("%s: %.2f hours in the week of %s", task.Title, float64(w.Minutes)/60, w.WeekStart.Format(time.DateOnly)),
+		Data: map[string]interface{}{
+			"task_id":    task.ID,
+			"week_start": w.WeekStart.Format(time.DateOnly),
+			"minutes":    w.Minutes,
+			"amount":     w.Amount,
+			"currency":   task.Currency,
+		},
+	}, task.ClientID)
+}
+
+// settleContractWeek pays an approved week from the escrow and refills the
+// escrow to one week at the cap from the client's wallet. A week the escrow
+// can't cover is left approved until the client has the funds, and on
+// organization tasks the spending limit allows it.
+func settleContractWeek(w models.ContractWeek) error {
+	task, err := db.GetTask(db.Postgres, w.TaskID)
+	if err != nil {
+		return err
+	}
+	offer, err := db.GetAcceptedTaskOffer(db.Postgres, w.TaskID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Postgres.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	escrow, err := db.LockEscrowBalanceTx(tx, w.TaskID)
+	if err != nil {
+		return err
+	}
+	if escrow.Status != "pending" {
+		// a dispute resolution paid the escrow out, nothing is left to pay
+		// the week from
+		log.Printf("[settleContractWeek] task %d: escrow is %s, cancelling the week", task.ID, escrow.Status)
+		if err := db.CancelContractWeekTx(tx, w.TaskID, w.WeekStart); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	clientWallet, err := ClientFunds(task)
+	if err != nil {
+		return err
+	}
+	freelancerWallet, err := PayoutFunds(task, offer)
+	if err != nil {
+		return err
+	}
+
+	held := escrow.Amount
+	if held < w.Amount {
+		err := debitEscrow(tx, task, clientWallet, w.Amount-held)
+		if cantFund(err) {
+			log.Printf("[settleContractWeek] task %d: escrow can't cover the week yet: %v", task.ID, err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		held = w.Amount
+	}
+
+	ok, err := db.SettleContractWeekTx(tx, w.TaskID, w.WeekStart)
+	if err != nil || !ok {
+		return err
+	}
+	if err := freelancerWallet.AddBalance(tx, big.NewFloat(w.Amount)); err != nil {
+		return err
+	}
+	held = math.Max(held-w.Amount, 0)
+
+	funded := true
+	if task.EndedAt == nil && held < offer.Price {
+		err := debitEscrow(tx, task, clientWallet, offer.Price-held)
+		switch {
+		case cantFund(err):
+			funded = false
+		case err != nil:
+			return err
+		default:
+			held = offer.Price
+		}
+	}
+	if err := db.UpdateEscrowAmountTx(tx, task.ID, held); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	week := w.WeekStart.Format(time.DateOnly)
+	notifications.Publish(notifications.Event{
+		Type:  notifications.TypeEscrowReleased,
+		Title: "Weekly payment released",
+		Body:  fmt.Sprintf("%s: %v %s for the week of %s was credited to your wallet", task.Title, w.Amount, task.Currency, week),
+		Data: map[string]interface{}{
+			"task_id":    task.ID,
+			"week_start": week,
+			"amount":     w.Amount,
+			"currency":   task.Currency,
+		},
+	}, escrow.FreelancerID)
+	webhooks.Emit(webhooks.EventEscrowReleased, map[string]interface{}{
+		"task_id":       task.ID,
+		"client_id":     task.ClientID,
+		"freelancer_id": escrow.FreelancerID,
+		"amount":        w.Amount,
+		"currency":      task.Currency,
+		"week_start":    week,
+		"reason":        "hourly_week",
+	}, task.ClientID, escrow.FreelancerID)
+	if !funded {
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeContractFundingLow,
+			Title: "Hourly contract escrow is low",
+			Body:  fmt.Sprintf("%s: %v %s of %v %s is held for next week", task.Title, held, task.Currency, offer.Price, task.Currency),
+			Data: map[string]interface{}{
+				"task_id":  task.ID,
+				"held":     held,
+				"needed":   offer.Price,
+				"currency": task.Currency,
+			},
+		}, task.ClientID, escrow.FreelancerID)
+	}
+	return nil
+}
+
+// debitEscrow moves amount from the client's funds into the escrow of task.
+// On a task posted for an organization the client must still be a member and
+// the amount must fit in their spending limit.
+func debitEscrow(tx *sqlx.Tx, task *models.Task, funds models.Funds, amount float64) error {
+	if task.OrgID != nil {
+		if _, err := db.CheckOrgSpendingTx(tx, *task.OrgID, task.ClientID, task.Currency, amount); err != nil {
+			return err
+		}
+	}
+	return funds.SubBalance(tx, big.NewFloat(amount))
+}
+
+// cantFund reports whether debitEscrow failed because the client can't pay
+// right now rather than on an error
+func cantFund(err error) bool {
+	return errors.Is(err, models.ErrInsufficientBalance) || errors.Is(err, db.ErrNotOrgMember) || errors.Is(err, db.ErrSpendingLimit)
+}
+
+// closeContract completes an ended hourly contract: whatever is left in
+// escrow goes back to the client, and the escrow records the total paid
+func closeContract(task *models.Task) error {
+	tx, err := db.Postgres.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	escrow, err := db.LockEscrowBalanceTx(tx, task.ID)
+	if err != nil {
+		return err
+	}
+	if escrow.Status != "pending" {
+		return nil
+	}
+	paid, err := db.GetContractPaidTx(tx, task.ID)
+	if err != nil {
+		return err
+	}
+	refund := escrow.Amount
+	if refund > 0 {
+		clientWallet, err := ClientFunds(task)
+		if err != nil {
+			return err
+		}
+		if err := clientWallet.AddBalance(tx, big.NewFloat(refund)); err != nil {
+			return err
+		}
+	}
+
+	status := "released"
+	if paid == 0 {
+		status = "refunded"
+	}
+	if err := db.UpdateEscrowAmountTx(tx, task.ID, paid); err != nil {
+		return err
+	}
+	if err := db.UpdateEscrowBalanceStatusTx(tx, task.ID, status); err != nil {
+		return err
+	}
+	if err := db.UpdateTaskStatusTx(tx, task.ID, "completed"); err != nil {
+		return err
+	}
+	if paid > 0 {
+		_, err = tx.Exec(`
+			UPDATE profiles
+			SET completed_tasks = completed_tasks + 1
+			WHERE user_id = $1
+		`, escrow.FreelancerID)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if refund > 0 {
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeEscrowReleased,
+			Title: "Escrow refunded",
+			Body:  fmt.Sprintf("%s: %v %s left in escrow was returned to your wallet", task.Title, refund, task.Currency),
+			Data: map[string]interface{}{
+				"task_id":  task.ID,
+				"amount":   refund,
+				"currency": task.Currency,
+			},
+		}, task.ClientID)
+		webhooks.Emit(webhooks.EventEscrowReleased, map[string]interface{}{
+			"task_id":       task.ID,
+			"client_id":     task.ClientID,
+			"freelancer_id": escrow.FreelancerID,
+			"credited_user": task.ClientID,
+			"amount":        refund,
+			"currency":      task.Currency,
+			"reason":        "hourly_ended",
+		}, task.ClientID, escrow.FreelancerID)
+	}
+	reputation.Touch(reputation.ReasonTaskCompleted, task.ClientID, escrow.FreelancerID)
+	return nil
+}
@@ -15,6 +15,8 @@ import (
 	"mFrelance/config"
 	"mFrelance/db"
 	"mFrelance/electrum"
+	"mFrelance/notifications"
+	"mFrelance/webhooks"
 	"math/big"
 	"os"
 	"sync"
@@ -24,6 +26,7 @@ import (
 type TxPoolItem struct {
     Amount   *big.Float
     Currency string
+    UserID   int64 // 0 for outputs that aren't user withdrawals
 }
 
 var txPool = struct {
@@ -99,6 +102,7 @@ func StartWalletSync(ctx context.Context, eClient *electrum.Client, mClient *wal
 
 type MoneroWalletSubAddr struct {
     WalletID   int
+    UserID     int64
     Currency   string
     Address    string
     BalanceStr string
@@ -108,9 +112,9 @@ func getMoneroWalletSubAddr(address, currency string) (*MoneroWalletSubAddr, err
     var w MoneroWalletSubAddr
 
     err := db.Postgres.QueryRow(
-        `SELECT id, currency, address, balance FROM wallets WHERE address=$1 AND currency=$2`,
+        `SELECT id, COALESCE(user_id, 0), currency, address, balance FROM wallets WHERE address=$1 AND currency=$2`,
         address, currency,
-    ).Scan(&w.WalletID, &w.Currency, &w.Address, &w.BalanceStr)
+    ).Scan(&w.WalletID, &w.UserID, &w.Currency, &w.Address, &w.BalanceStr)
 
     if err != nil {
      //   log.Printf("Wallet not found for address %s: %v", address, err)
@@ -179,11 +183,12 @@ func syncMoneroWallets(mClient *walletrpc.Client) {
 		}
 
 		log.Printf("Updated XMR wallet %d (%s): +%s XMR", w.WalletID, w.Address, amt.Text('f', 12))
+		notifyDeposit(w.UserID, w.WalletID, tx.Txid, amt, "XMR")
 	}
 }
 func syncAllWallets(eClient *electrum.Client, mClient *walletrpc.Client) {
 	//log.Println("SyncAllWallets")
-	rows, err := db.Postgres.Query(`SELECT id, currency, address, balance FROM wallets`)
+	rows, err := db.Postgres.Query(`SELECT id, COALESCE(user_id, 0), currency, address, balance FROM wallets`)
 	if err != nil {
 		log.Println("Failed to fetch wallets:", err)
 		return
@@ -192,16 +197,18 @@ func syncAllWallets(eClient *electrum.Client, mClient *walletrpc.Client) {
 
 	for rows.Next() {
 		var walletID int
+		var userID int64
 		var currency, address string
 		var balanceStr string
 
-		if err := rows.Scan(&walletID, &currency, &address, &balanceStr); err != nil {
+		if err := rows.Scan(&walletID, &userID, &currency, &address, &balanceStr); err != nil {
 			log.Println("Failed to scan wallet:", err)
 			continue
 		}
 
 		currentBalance, _ := new(big.Float).SetString(balanceStr)
 		newBalance := new(big.Float).Set(currentBalance)
+		var credited []deposit
 
 		switch currency {
 		case "BTC":
@@ -231,6 +238,7 @@ func syncAllWallets(eClient *electrum.Client, mClient *walletrpc.Client) {
 					newBalance.Add(newBalance, amt)
 					//func SaveTransaction(txid string, walletID int, amount decimal.Decimal, currency string, confirmed bool) error {
 					SaveTransaction(tx.Txid, walletID, amt, currency, true)
+					credited = append(credited, deposit{txid: tx.Txid, amount: amt})
 				}
 			}
 
@@ -246,11 +254,69 @@ func syncAllWallets(eClient *electrum.Client, mClient *walletrpc.Client) {
 			log.Println("Failed to update wallet balance:", err)
 		} else {
 			log.Printf("Wallet %d (%s) balance updated: %s", walletID, currency, newBalanceStr)
+			for _, d := range credited {
+				notifyDeposit(userID, walletID, d.txid, d.amount, currency)
+			}
 		}
 	}
 	syncMoneroWallets(mClient);
 }
 
+type deposit struct {
+	txid   string
+	amount *big.Float
+}
+
+func notifyDeposit(userID int64, walletID int, txid string, amount *big.Float, currency string) {
+	notifications.Publish(notifications.Event{
+		Type:  notifications.TypeDepositCredited,
+		Title: "Deposit credited",
+		Body:  amount.Text('f', 8) + " " + currency,
+		Data: map[string]interface{}{
+			"wallet_id": walletID,
+			"txid":      txid,
+			"amount":    amount.Text('f', 12),
+			"currency":  currency,
+		},
+	}, userID)
+	webhooks.Emit(webhooks.EventDepositConfirmed, map[string]interface{}{
+		"user_id":   userID,
+		"wallet_id": walletID,
+		"txid":      txid,
+		"amount":    amount.Text('f', 12),
+		"currency":  currency,
+	}, userID)
+}
+
+type withdrawal struct {
+	userID  int64
+	address string
+	amount  string
+}
+
+func notifyWithdrawals(currency, txid string, list []withdrawal) {
+	for _, wd := range list {
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeWithdrawalSent,
+			Title: "Withdrawal sent",
+			Body:  wd.amount + " " + currency,
+			Data: map[string]interface{}{
+				"address":  wd.address,
+				"amount":   wd.amount,
+				"currency": currency,
+				"txid":     txid,
+			},
+		}, wd.userID)
+		webhooks.Emit(webhooks.EventWithdrawalBroadcast, map[string]interface{}{
+			"user_id":  wd.userID,
+			"address":  wd.address,
+			"amount":   wd.amount,
+			"currency": currency,
+			"txid":     txid,
+		}, wd.userID)
+	}
+}
+
 func IsTxProcessed(txid string) bool {
 	var exists bool
 	err := db.Postgres.QueryRow(`
@@ -334,10 +400,12 @@ func StartTxBlockTransactions(ctx context.Context, client *electrum.Client, inte
 	}()
 }
 
+// flushMoneroTxPool sends outs in one transfer and returns its txid, or ""
+// when nothing was sent
 func flushMoneroTxPool(mClient *walletrpc.Client, outs []struct {
     Address string
     Amount  *big.Float
-}) {
+}) string {
     ctx := context.Background()
 
     var dests []walletrpc.Destination
@@ -352,7 +420,7 @@ func flushMoneroTxPool(mClient *walletrpc.Client, outs []struct {
         })
     }
     if len(dests) == 0 {
-        return
+        return ""
     }
 
     resp, err := mClient.Transfer(ctx, &walletrpc.TransferRequest{
@@ -362,11 +430,12 @@ func flushMoneroTxPool(mClient *walletrpc.Client, outs []struct {
     })
     if err != nil {
         log.Printf("Monero PayToMany failed: %v", err)
-        return
+        return ""
     }
 
     log.Printf("Monero transaction successfully sent. TXID: %s, Fee: %.12f XMR",
         resp.TxHash, float64(resp.Fee)/1e12)
+    return resp.TxHash
 }
 
 func StartTxPoolFlusher(client *electrum.Client, mClient *walletrpc.Client, interval time.Duration, maxBatchSize int) {
@@ -380,10 +449,14 @@ func StartTxPoolFlusher(client *electrum.Client, mClient *walletrpc.Client, inte
         }
 
         payments := make(map[string][][2]string) // currency -> list of [address, amount]
+        withdrawals := make(map[string][]withdrawal)
         for addr, items := range txPool.outputs {
             for _, item := range items {
                 amtStr := item.Amount.Text('f', 8)
                 payments[item.Currency] = append(payments[item.Currency], [2]string{addr, amtStr})
+                if item.UserID > 0 {
+                    withdrawals[item.Currency] = append(withdrawals[item.Currency], withdrawal{item.UserID, addr, amtStr})
+                }
             }
         }
 
@@ -409,7 +482,7 @@ func StartTxPoolFlusher(client *electrum.Client, mClient *walletrpc.Client, inte
                     }{Address: o[0], Amount: amt})
                 }
 
-                flushMoneroTxPool(mClient, moneroOuts)
+                txid = flushMoneroTxPool(mClient, moneroOuts)
             default:
                 log.Println("Unknown currency in txPool:", currency)
                 continue
@@ -423,6 +496,16 @@ func StartTxPoolFlusher(client *electrum.Client, mClient *walletrpc.Client, inte
                     val, _ := new(big.Float).SetString(o[1])
                     txPool.outputs[o[0]] = append(txPool.outputs[o[0]], TxPoolItem{Amount: val, Currency: currency})
                 }
+                // keep the owners so the webhook still fires after the retry
+                for _, wd := range withdrawals[currency] {
+                    val, _ := new(big.Float).SetString(wd.amount)
+                    for i, item := range txPool.outputs[wd.address] {
+                        if item.UserID == 0 && item.Amount.Cmp(val) == 0 {
+                            txPool.outputs[wd.address][i].UserID = wd.userID
+                            break
+                        }
+                    }
+                }
                 txPool.Unlock()
 
                 f, _ := os.OpenFile("FailedPayments.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -433,6 +516,9 @@ func StartTxPoolFlusher(client *electrum.Client, mClient *walletrpc.Client, inte
                 f, _ := os.OpenFile("SuccessfulPayments.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
                 defer f.Close()
                 fmt.Fprintf(f, "%s - %s txid: %s\nOutputs: %+v\n", time.Now().Format(time.RFC3339), currency, txid, outs)
+                if txid != "" {
+                    notifyWithdrawals(currency, txid, withdrawals[currency])
+                }
             }
         }
     }
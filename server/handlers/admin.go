@@ -5,6 +5,9 @@ import (
 	"math/big"
 	"net/http"
 	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
 
 	"mFrelance/db"
 	"mFrelance/models"
@@ -13,29 +16,26 @@ import (
 
 // AdminRequest payload
 type AdminRequest struct {
-	UserID int64 `json:"user_id"`
+	UserID int64  `json:"user_id"`
+	Reason string `json:"reason,omitempty"`
 }
 
-func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		claims := server.GetUserFromContext(r)
-		if claims == nil {
-			http.Error(w, "user not found (RequireAdmin)", http.StatusUnauthorized)
-			return
-		}
-		isAdmin, err := db.IsAdmin(db.Postgres, claims.UserID)
-		if err != nil || !isAdmin {
-			http.Error(w, "admin rights required", http.StatusForbidden)
-			return
+// setUserFlag flips users.is_admin or users.blocked and audits the change
+func setUserFlag(r *http.Request, req AdminRequest, action, field string, value bool,
+	set func(tx *sqlx.Tx, userID int64, value bool) (bool, error)) error {
+	e := newAuditEntry(r, action, "user", req.UserID, req.Reason)
+	return runAudited(e, func(tx *sqlx.Tx) (interface{}, interface{}, error) {
+		prev, err := set(tx, req.UserID, value)
+		if err != nil {
+			return nil, nil, err
 		}
-		next(w, r)
-	}
+		return map[string]bool{field: prev}, map[string]bool{field: value}, nil
+	})
 }
 
-
 // MakeAdminHandler godoc
 // @Summary Grant Administrative Privileges
-// @Description Elevates a regular user to administrator status. Requires existing admin privileges to execute.
+// @Description Elevates a regular user to administrator status: sets the admin badge and grants the support role. Requires the role.manage permission; use /api/admin/roles/assign for other roles.
 // @Tags administration
 // @Accept json
 // @Produce json
@@ -43,7 +43,7 @@ func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
 // @Success 200 {string} string "Example: \"user is now admin\""
 // @Failure 400 {string} string "Example: \"invalid request body\""
 // @Failure 401 {string} string "Example: \"unauthorized\""
-// @Failure 403 {string} string "Example: \"admin rights required\""
+// @Failure 403 {string} string "Example: \"insufficient permissions\""
 // @Failure 500 {string} string "Example: \"internal server error\""
 // @Security BearerAuth
 // @Router /api/admin/make [post]
@@ -53,17 +53,18 @@ func MakeAdminHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-	if err := db.MakeAdmin(db.Postgres, req.UserID); err != nil {
+	if err := setUserFlag(r, req, db.AuditAdminMake, "is_admin", true, db.SetUserAdminTx); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	server.InvalidatePermissions(req.UserID)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("user is now admin"))
 }
 
 // RemoveAdminHandler godoc
 // @Summary Revoke Administrative Privileges
-// @Description Removes administrator status from a user, reverting them to regular user privileges.
+// @Description Removes administrator status from a user: clears the admin badge and revokes the support role. Other roles the user holds are kept.
 // @Tags administration
 // @Accept json
 // @Produce json
@@ -71,7 +72,7 @@ func MakeAdminHandler(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {string} string "Example: \"user admin removed\""
 // @Failure 400 {string} string "Example: \"invalid request body\""
 // @Failure 401 {string} string "Example: \"unauthorized\""
-// @Failure 403 {string} string "Example: \"admin rights required\""
+// @Failure 403 {string} string "Example: \"insufficient permissions\""
 // @Failure 500 {string} string "Example: \"internal server error\""
 // @Security BearerAuth
 // @Router /api/admin/remove [post]
@@ -81,17 +82,18 @@ func RemoveAdminHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-	if err := db.RemoveAdmin(db.Postgres, req.UserID); err != nil {
+	if err := setUserFlag(r, req, db.AuditAdminRemove, "is_admin", false, db.SetUserAdminTx); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	server.InvalidatePermissions(req.UserID)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("user admin removed"))
 }
 
 // IsAdminHandler godoc
 // @Summary Check if user is admin
-// @Description Returns true/false if current user has admin privileges
+// @Description Returns true/false if the user holds any role
 // @Tags administration
 // @Produce json
 // @Success 200 {object} map[string]interface{} "Example: {\"user_id\": 123, \"is_admin\": true}"
@@ -118,7 +120,7 @@ func IsAdminHandler(w http.ResponseWriter, r *http.Request) {
 
 // IsIAdminHandler godoc
 // @Summary Check if current user is admin
-// @Description Returns true/false if the authenticated user has admin privileges
+// @Description Returns true/false if the authenticated user holds any role
 // @Tags administration
 // @Produce json
 // @Success 200 {object} map[string]interface{} "Example: {\"user_id\": 123, \"is_admin\": true}"
@@ -163,7 +165,7 @@ func BlockUserHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "invalid request body", http.StatusBadRequest)
 			return
 		}
-		if err := db.BlockUser(db.Postgres, req.UserID); err != nil {
+		if err := setUserFlag(r, req, db.AuditUserBlock, "blocked", true, db.SetUserBlockedTx); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -239,7 +241,7 @@ func UnblockUserHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "invalid request body", http.StatusBadRequest)
 			return
 		}
-		if err := db.UnblockUser(db.Postgres, req.UserID); err != nil {
+		if err := setUserFlag(r, req, db.AuditUserUnblock, "blocked", false, db.SetUserBlockedTx); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -282,17 +284,24 @@ func AdminWalletsHandler(w http.ResponseWriter, r *http.Request) {
 type AdminUpdateBalanceRequest struct {
 	UserID  int64  `json:"user_id"`
 	Balance string `json:"balance"`
+	Reason  string `json:"reason"`
+}
+
+type walletBalance struct {
+	ID       int64  `db:"id" json:"wallet_id"`
+	Currency string `db:"currency" json:"currency"`
+	Balance  string `db:"balance" json:"balance"`
 }
 
 // AdminUpdateBalanceHandler godoc
 // @Summary Update Wallet Balance
-// @Description Allows admin with balance change permission to manually set a new balance for a user's wallet
+// @Description Allows admin with balance change permission to manually set a new balance for a user's wallet. A reason is mandatory and is stored in the audit log together with the old and new balances.
 // @Tags administration
 // @Accept json
 // @Produce json
-// @Param request body AdminUpdateBalanceRequest true "Wallet balance payload with user_id and balance"
+// @Param request body AdminUpdateBalanceRequest true "Wallet balance payload with user_id, balance and reason"
 // @Success 200 {string} string "Example: \"balance updated\""
-// @Failure 400 {string} string "Example: \"invalid balance format\""
+// @Failure 400 {string} string "Example: \"reason is required\""
 // @Failure 403 {string} string "Example: \"insufficient permissions\""
 // @Failure 500 {string} string "Example: \"DB error\""
 // @Security BearerAuth
@@ -304,12 +313,29 @@ func AdminUpdateBalanceHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "invalid request body", http.StatusBadRequest)
 			return
 		}
+		if strings.TrimSpace(req.Reason) == "" {
+			http.Error(w, "reason is required", http.StatusBadRequest)
+			return
+		}
 		newBalance, ok := new(big.Float).SetString(req.Balance)
 		if !ok {
 			http.Error(w, "invalid balance format", http.StatusBadRequest)
 			return
 		}
-		_, err := db.Postgres.Exec(`UPDATE wallets SET balance=$1 WHERE user_id=$2`, newBalance.Text('f', 12), req.UserID)
+		e := newAuditEntry(r, db.AuditBalanceUpdate, "user", req.UserID, strings.TrimSpace(req.Reason))
+		err := runAudited(e, func(tx *sqlx.Tx) (interface{}, interface{}, error) {
+			var before, after []walletBalance
+			if err := tx.Select(&before, `SELECT id, currency, balance FROM wallets WHERE user_id=$1 ORDER BY id FOR UPDATE`, req.UserID); err != nil {
+				return nil, nil, err
+			}
+			if _, err := tx.Exec(`UPDATE wallets SET balance=$1 WHERE user_id=$2`, newBalance.Text('f', 12), req.UserID); err != nil {
+				return nil, nil, err
+			}
+			if err := tx.Select(&after, `SELECT id, currency, balance FROM wallets WHERE user_id=$1 ORDER BY id`, req.UserID); err != nil {
+				return nil, nil, err
+			}
+			return before, after, nil
+		})
 		if err != nil {
 			http.Error(w, "DB error: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -379,20 +405,26 @@ func AdminAddUserToChatRoom(w http.ResponseWriter, r *http.Request) {
 	chatIDInt, err := strconv.ParseInt(chatID, 10, 64)
 	if err != nil {
 		server.WriteErrorJSON(w, "bad chat id", http.StatusBadRequest)
-
+		return
 	}
 	userIDInt, err := strconv.ParseInt(userId, 10, 64)
 	if err != nil {
 		server.WriteErrorJSON(w, "bad user id", http.StatusBadRequest)
-
+		return
 	}
-	err = db.AddUserToChatRoom(db.Postgres, chatIDInt, userIDInt)
+	e := newAuditEntry(r, db.AuditChatRoomAddUser, "chat_room", chatIDInt, r.URL.Query().Get("reason"))
+	err = runAudited(e, func(tx *sqlx.Tx) (interface{}, interface{}, error) {
+		if err := db.AddUserToChatRoomTx(tx, userIDInt, chatIDInt); err != nil {
+			return nil, nil, err
+		}
+		return nil, map[string]int64{"user_id": userIDInt}, nil
+	})
 	if err != nil {
 		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("{\"res\":\"user added to chat room\"}"))
 }
 // DeleteChatRoom godoc
@@ -401,6 +433,7 @@ func AdminAddUserToChatRoom(w http.ResponseWriter, r *http.Request) {
 // @Tags chats
 // @Produce json
 // @Param chat_id query int true "Chat room ID"
+// @Param reason query string false "Reason stored in the audit log"
 // @Success 200 {object} map[string]string "Result message"
 // @Failure 400 {object} map[string]string "Bad chat_id"
 // @Failure 401 {object} map[string]string "Unauthorized"
@@ -417,15 +450,22 @@ func DeleteChatRoom(w http.ResponseWriter, r *http.Request) {
 	chatIDInt, err := strconv.ParseInt(chatID, 10, 64)
 	if err != nil {
 		server.WriteErrorJSON(w, "bad chat id", http.StatusBadRequest)
-
+		return
 	}
-	err = db.DeleteChatRoom(db.Postgres, chatIDInt)
+	e := newAuditEntry(r, db.AuditChatRoomDelete, "chat_room", chatIDInt, r.URL.Query().Get("reason"))
+	err = runAudited(e, func(tx *sqlx.Tx) (interface{}, interface{}, error) {
+		participants, err := db.DeleteChatRoomTx(tx, chatIDInt)
+		if err != nil {
+			return nil, nil, err
+		}
+		return map[string]interface{}{"participants": participants}, nil, nil
+	})
 	if err != nil {
 		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("{\"res\":\"chat room deleted\"}"))
 }
 
@@ -435,10 +475,11 @@ func DeleteChatRoom(w http.ResponseWriter, r *http.Request) {
 // @Tags administration
 // @Produce json
 // @Param user_id query int true "User ID whose tasks to delete"
+// @Param reason query string false "Reason stored in the audit log"
 // @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"deleted\": 5}"
 // @Failure 400 {object} map[string]string "Example: {\"error\": \"Invalid user_id\"}"
 // @Failure 401 {object} map[string]string "Example: {\"error\": \"Unauthorized\"}"
-// @Failure 403 {object} map[string]string "Example: {\"error\": \"admin rights required\"}"
+// @Failure 403 {string} string "Example: \"insufficient permissions\""
 // @Failure 405 {object} map[string]string "Example: {\"error\": \"Method not allowed\"}"
 // @Failure 500 {object} map[string]string "Example: {\"error\": \"Failed to delete tasks\"}"
 // @Router /api/admin/delete_user_tasks [post]
@@ -448,23 +489,22 @@ func AdminDeleteUserTasksHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	claims := server.GetUserFromContext(r)
-	if claims == nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	isAdmin, err := db.IsAdmin(db.Postgres, claims.UserID)
-	if err != nil || !isAdmin {
-		http.Error(w, "admin rights required", http.StatusForbidden)
-		return
-	}
 	userIDStr := r.URL.Query().Get("user_id")
 	userID, err := strconv.ParseInt(userIDStr, 10, 64)
 	if err != nil || userID <= 0 {
 		http.Error(w, "Invalid user_id", http.StatusBadRequest)
 		return
 	}
-	n, err := db.DeleteTasksByUserID(db.Postgres, userID)
+	var n int
+	e := newAuditEntry(r, db.AuditUserTasksDelete, "user", userID, r.URL.Query().Get("reason"))
+	err = runAudited(e, func(tx *sqlx.Tx) (interface{}, interface{}, error) {
+		ids, err := db.DeleteTasksByUserIDTx(tx, userID)
+		if err != nil {
+			return nil, nil, err
+		}
+		n = len(ids)
+		return map[string]interface{}{"task_ids": ids}, nil, nil
+	})
 	if err != nil {
 		http.Error(w, "Failed to delete tasks", http.StatusInternalServerError)
 		return
@@ -483,27 +523,25 @@ func AdminDeleteUserTasksHandler(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Success 200 {array} models.Ticket "List of pending and assigned tickets"
 // @Failure 401 {string} string "Unauthorized"
-// @Failure 403 {string} string "Admin rights required"
+// @Failure 403 {string} string "Insufficient permissions"
 // @Failure 500 {string} string "Database error"
 // @Security BearerAuth
 // @Router /api/admin/tickets [get]
 func GetAllTicketsHandler(w http.ResponseWriter, r *http.Request) {
-	RequireAdmin(func(w http.ResponseWriter, r *http.Request) {
-		claims := server.GetUserFromContext(r)
-		if claims == nil {
-			http.Error(w, "user not found in context", http.StatusUnauthorized)
-			return
-		}
-		tickets, err := models.GetAllTickets(db.Postgres, claims.UserID)
-		if err != nil {
-			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		// Ensure we return an empty array instead of null
-		if tickets == nil {
-			tickets = []models.Ticket{}
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(tickets)
-	})(w, r)
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		http.Error(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	tickets, err := models.GetAllTickets(db.Postgres, claims.UserID)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Ensure we return an empty array instead of null
+	if tickets == nil {
+		tickets = []models.Ticket{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tickets)
 }
@@ -1,13 +1,21 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"mFrelance/db"
 	"mFrelance/models"
+	"mFrelance/notifications"
+	"mFrelance/reputation"
 	"mFrelance/server"
+	"mFrelance/webhooks"
 	"math/big"
 	"net/http"
 	"strconv"
+
+	"github.com/jmoiron/sqlx"
 )
 // GetOpenDisputesHandler godoc
 // @Summary Retrieve Open Disputes
@@ -95,7 +103,7 @@ type AssignDisputeRequest struct {
     DisputeID int64 `json:"dispute_id"`
 }
 // @Summary Assign dispute
-// @Description Assign a dispute to the current admin
+// @Description Assign a dispute to the current admin. The admin joins the task chat room.
 // @Tags disputes
 // @Accept json
 // @Produce json
@@ -130,13 +138,34 @@ func AssignDisputeHandler() http.HandlerFunc {
 		}
 		adminID := claims.UserID
 
-		_, err := db.GetDisputeByID(req.DisputeID)
+		dispute, err := db.GetDisputeByID(req.DisputeID)
 		if err != nil {
 			http.Error(w, "Dispute not found", http.StatusNotFound)
 			return
 		}
+		task, err := db.GetTask(db.Postgres, dispute.TaskID)
+		if err != nil {
+			http.Error(w, "Task not found", http.StatusNotFound)
+			return
+		}
 
-		if err := db.AssignDisputeToAdmin(req.DisputeID, adminID); err != nil {
+		e := newAuditEntry(r, db.AuditDisputeAssign, "dispute", req.DisputeID, "")
+		err = runAudited(e, func(tx *sqlx.Tx) (interface{}, interface{}, error) {
+			prev, err := db.AssignDisputeToAdminTx(tx, req.DisputeID, adminID)
+			if err != nil {
+				return nil, nil, err
+			}
+			// the arbiter joins the task chat to follow the conversation
+			members := []int64{task.ClientID, adminID}
+			if escrow, err := db.GetEscrowByTaskID(tx, task.ID); err == nil {
+				members = append(members, escrow.FreelancerID)
+			}
+			if _, err := db.EnsureTaskChatRoomTx(tx, task.ID, members...); err != nil {
+				return nil, nil, err
+			}
+			return map[string]*int64{"assigned_admin": prev}, map[string]int64{"assigned_admin": adminID}, nil
+		})
+		if err != nil {
 			http.Error(w, "Failed to assign dispute", http.StatusInternalServerError)
 			return
 		}
@@ -215,21 +244,10 @@ func ResolveDisputeHandler() http.HandlerFunc {
 			return
 		}
 
-		escrow, err := db.GetEscrowBalanceByTaskID(task.ID)
-		if err != nil {
-			http.Error(w, "Escrow balance not found", http.StatusNotFound)
-			return
-		}
-
-		amount := big.NewFloat(escrow.Amount)
-
-		var walletUserID int64
 		var newEscrowStatus string
 		if req.Resolution == "client_won" {
-			walletUserID = task.ClientID
 			newEscrowStatus = "refunded"
 		} else if req.Resolution == "freelancer_won" {
-			walletUserID = escrow.FreelancerID
 			newEscrowStatus = "released"
 		} else {
 			http.Error(w, "Invalid resolution", http.StatusBadRequest)
@@ -244,7 +262,36 @@ func ResolveDisputeHandler() http.HandlerFunc {
 		}
 		defer tx.Rollback()
 
-		userWallet, err := models.GetWalletByUserAndCurrency(db.Postgres, walletUserID, task.Currency)
+		// The escrow is locked so the weekly settlement of an hourly contract
+		// can't pay out of it while the rest is credited here
+		escrow, err := db.LockEscrowBalanceTx(tx, task.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Escrow balance not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to lock escrow: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if escrow.Status != "pending" {
+			http.Error(w, "Escrow is no longer held", http.StatusBadRequest)
+			return
+		}
+
+		amount := big.NewFloat(escrow.Amount)
+		walletUserID := task.ClientID
+		if req.Resolution == "freelancer_won" {
+			walletUserID = escrow.FreelancerID
+		}
+
+		var userWallet models.Funds
+		if req.Resolution == "client_won" {
+			userWallet, err = server.ClientFunds(task)
+		} else {
+			var offer *models.TaskOffer
+			if offer, err = db.GetAcceptedTaskOffer(db.Postgres, task.ID); err == nil {
+				userWallet, err = server.PayoutFunds(task, offer)
+			}
+		}
 		if err != nil {
 			http.Error(w, "Failed to get user wallet: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -260,11 +307,19 @@ func ResolveDisputeHandler() http.HandlerFunc {
 			return
 		}
 
-		if err := db.UpdateDisputeStatusTx(tx, req.DisputeID, "resolved", &req.Resolution); err != nil {
+		if err := db.ResolveDisputeTx(tx, req.DisputeID, req.Resolution); errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Dispute is not open", http.StatusBadRequest)
+			return
+		} else if err != nil {
 			http.Error(w, "Failed to update dispute status", http.StatusInternalServerError)
 			return
 		}
 
+		if err := db.CancelContractWeeksTx(tx, task.ID); err != nil {
+			http.Error(w, "Failed to cancel contract weeks", http.StatusInternalServerError)
+			return
+		}
+
 		if err := db.UpdateTaskStatusTx(tx, task.ID, "completed"); err != nil {
 			http.Error(w, "Failed to update task status", http.StatusInternalServerError)
 			return
@@ -283,11 +338,68 @@ func ResolveDisputeHandler() http.HandlerFunc {
 			}
 		}
 
+		before := map[string]interface{}{
+			"status":        dispute.Status,
+			"escrow_status": escrow.Status,
+		}
+		after := map[string]interface{}{
+			"status":        "resolved",
+			"resolution":    req.Resolution,
+			"escrow_status": newEscrowStatus,
+			"credited_user": walletUserID,
+			"amount":        amount.Text('f', 8),
+			"currency":      task.Currency,
+		}
+		if err := db.InsertAuditLogTx(tx, newAuditEntry(r, db.AuditDisputeResolve, "dispute", req.DisputeID, ""), before, after); err != nil {
+			http.Error(w, "Failed to write audit log", http.StatusInternalServerError)
+			return
+		}
+
 		if err := tx.Commit(); err != nil {
 			http.Error(w, "Failed to commit transaction: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeEscrowReleased,
+			Title: "Dispute resolved in your favour",
+			Body:  fmt.Sprintf("%s: %s %s was credited to your wallet", task.Title, amount.Text('f', 8), task.Currency),
+			Data: map[string]interface{}{
+				"task_id":    task.ID,
+				"dispute_id": req.DisputeID,
+				"resolution": req.Resolution,
+				"amount":     escrow.Amount,
+				"currency":   task.Currency,
+			},
+		}, walletUserID)
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeDisputeResolved,
+			Title: "Dispute resolved",
+			Body:  task.Title,
+			Data: map[string]interface{}{
+				"dispute_id": req.DisputeID,
+				"task_id":    task.ID,
+				"task_title": task.Title,
+				"resolution": req.Resolution,
+			},
+		}, task.ClientID, escrow.FreelancerID)
+		webhooks.Emit(webhooks.EventDisputeResolved, map[string]interface{}{
+			"dispute_id":    req.DisputeID,
+			"task_id":       task.ID,
+			"resolution":    req.Resolution,
+			"credited_user": walletUserID,
+		}, task.ClientID, escrow.FreelancerID)
+		webhooks.Emit(webhooks.EventEscrowReleased, map[string]interface{}{
+			"task_id":       task.ID,
+			"client_id":     task.ClientID,
+			"freelancer_id": escrow.FreelancerID,
+			"credited_user": walletUserID,
+			"amount":        escrow.Amount,
+			"currency":      task.Currency,
+			"reason":        req.Resolution,
+		}, task.ClientID, escrow.FreelancerID)
+		reputation.Touch(reputation.ReasonDisputeResolved, task.ClientID, escrow.FreelancerID)
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": true,
@@ -443,7 +555,7 @@ func GetDisputeDetailsForUserHandler() http.HandlerFunc {
 		if !isParticipant {
 			// Check if user is accepted freelancer
 			for _, offer := range offers {
-				if offer.FreelancerID == userID && offer.Accepted {
+				if offer.FreelancerID == userID && offer.Status == models.OfferAccepted {
 					isParticipant = true
 					break
 				}
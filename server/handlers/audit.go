@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/server"
+)
+
+// newAuditEntry prepares an audit_log row for the current request. The
+// caller passes it to a *Tx helper so it is written in the same transaction.
+func newAuditEntry(r *http.Request, action, targetType string, targetID int64, reason string) *models.AuditEntry {
+	e := &models.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		IP:         getClientIP(r),
+		Reason:     reason,
+	}
+	if claims := server.GetUserFromContext(r); claims != nil {
+		actor := claims.UserID
+		e.ActorID = &actor
+	}
+	if targetID > 0 {
+		e.TargetID = &targetID
+	}
+	return e
+}
+
+// runAudited runs fn in a transaction and appends e, with the before/after
+// state returned by fn, to the audit log before committing
+func runAudited(e *models.AuditEntry, fn func(tx *sqlx.Tx) (before, after interface{}, err error)) error {
+	tx, err := db.Postgres.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, after, err := fn(tx)
+	if err != nil {
+		return err
+	}
+	if err := db.InsertAuditLogTx(tx, e, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdminAuditLogHandler godoc
+// @Summary Admin audit log
+// @Description Lists privileged actions, newest first. All filters are optional.
+// @Tags administration
+// @Produce json
+// @Param actor_id query int false "Admin who performed the action"
+// @Param action query string false "Action, e.g. wallet.balance_update"
+// @Param target_type query string false "Target type, e.g. user, dispute, chat_room, role"
+// @Param target_id query int false "Target ID"
+// @Param from query string false "RFC3339 lower bound (inclusive)"
+// @Param to query string false "RFC3339 upper bound (exclusive)"
+// @Param limit query int false "Page size, default 50, max 500"
+// @Param offset query int false "Offset"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"entries\": []}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"invalid from\"}"
+// @Failure 403 {string} string "Example: \"insufficient permissions\""
+// @Security BearerAuth
+// @Router /api/admin/audit [get]
+func AdminAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	f := models.AuditFilter{
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		Limit:      50,
+	}
+	for name, dst := range map[string]*int64{"actor_id": &f.ActorID, "target_id": &f.TargetID} {
+		if v := q.Get(name); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				server.WriteErrorJSON(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = id
+		}
+	}
+	for name, dst := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				server.WriteErrorJSON(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 500 {
+		f.Limit = l
+	}
+	if o, err := strconv.Atoi(q.Get("offset")); err == nil && o >= 0 {
+		f.Offset = o
+	}
+
+	entries, err := db.ListAuditLog(db.Postgres, f)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"entries": entries,
+	})
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"mFrelance/avatar"
+	"mFrelance/config"
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/server"
+)
+
+// legacyAvatarBatch is how many base64 avatars are converted per query
+const legacyAvatarBatch = 50
+
+var avatarExt = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+}
+
+// saveAvatar processes an uploaded picture into thumbnails, stores them as
+// the user's avatar and returns its URL
+func saveAvatar(userID int64, data []byte) (string, error) {
+	variants, err := avatar.Process(data, config.AppConfig.AvatarSizes)
+	if err != nil {
+		return "", err
+	}
+	if len(variants) == 0 {
+		return "", errors.New("no avatar sizes configured")
+	}
+
+	files := make(map[int]*models.File, len(variants))
+	removeStored := func() {
+		for _, f := range files {
+			os.Remove(filePath(f.StorageKey))
+		}
+	}
+	for _, v := range variants {
+		name := fmt.Sprintf("avatar-%d.%s", v.Size, avatarExt[v.ContentType])
+		f, err := storeFile(userID, name, bytes.NewReader(v.Data), int64(len(v.Data)))
+		if err != nil {
+			removeStored()
+			return "", err
+		}
+		f.ContentType = v.ContentType
+		files[v.Size] = f
+	}
+
+	// variants come largest first; its hash versions the URL
+	url := fmt.Sprintf("/avatar?user_id=%d&v=%s", userID, avatarVersion(files[variants[0].Size].SHA256))
+	old, err := db.SetAvatar(db.Postgres, userID, files, url)
+	if err != nil {
+		removeStored()
+		return "", err
+	}
+	for _, key := range old {
+		os.Remove(filePath(key))
+	}
+	return url, nil
+}
+
+// avatarVersion shortens the hash of the largest thumbnail for avatar URLs
+func avatarVersion(sha string) string {
+	return sha[:16]
+}
+
+// decodeLegacyAvatar decodes a base64 avatar as stored in profiles.avatar,
+// with or without a data URI prefix
+func decodeLegacyAvatar(s string) ([]byte, bool) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "data:") {
+		i := strings.Index(s, ",")
+		if i < 0 {
+			return nil, false
+		}
+		s = s[i+1:]
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	return data, err == nil && len(data) > 0
+}
+
+// MigrateLegacyAvatars converts the base64 avatars left in profiles into
+// processed thumbnails. Values that aren't images are left alone. Returns
+// the number of converted avatars.
+func MigrateLegacyAvatars() (int, error) {
+	converted := 0
+	var after int64
+	for {
+		batch, err := db.ListLegacyAvatars(db.Postgres, after, legacyAvatarBatch)
+		if err != nil {
+			return converted, err
+		}
+		if len(batch) == 0 {
+			return converted, nil
+		}
+		for _, a := range batch {
+			after = a.UserID
+			data, ok := decodeLegacyAvatar(a.Avatar)
+			if !ok {
+				continue
+			}
+			if _, err := saveAvatar(a.UserID, data); err != nil {
+				log.Printf("[MigrateLegacyAvatars] user %d: %v", a.UserID, err)
+				continue
+			}
+			converted++
+		}
+	}
+}
+
+// UploadAvatarHandler godoc
+// @Summary Upload an avatar
+// @Description Replaces the current user's avatar with the picture sent as the "file" field of a multipart form. PNG, JPEG and WebP are accepted up to max.avatar_size_mb. The picture is cropped to a square, its EXIF orientation applied and all metadata stripped, and it is stored as thumbnails of profiles.avatar_sizes pixels. The returned URL changes with every upload.
+// @Tags profile
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "Picture"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"avatar_url\": \"/avatar?user_id=7&v=3f2a9c01d4e5b6a7\"}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"avatar must be a PNG, JPEG or WebP image\"}"
+// @Security BearerAuth
+// @Router /api/avatar [post]
+func UploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	max := config.AppConfig.MaxAvatarSize * 1024 * 1024
+	r.Body = http.MaxBytesReader(w, r.Body, max+1<<20)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		server.WriteErrorJSON(w, "multipart form expected", http.StatusBadRequest)
+		return
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			server.WriteErrorJSON(w, "file field is required", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			server.WriteErrorJSON(w, "invalid multipart form", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, max+1))
+		part.Close()
+		if err != nil {
+			server.WriteErrorJSON(w, "invalid multipart form", http.StatusBadRequest)
+			return
+		}
+		if int64(len(data)) > max {
+			server.WriteErrorJSON(w, "avatar too large", http.StatusBadRequest)
+			return
+		}
+		url, err := saveAvatar(claims.UserID, data)
+		if errors.Is(err, avatar.ErrFormat) || errors.Is(err, avatar.ErrTooLarge) {
+			server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println("[UploadAvatarHandler]", err)
+			server.WriteErrorJSON(w, "failed to store avatar", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"avatar_url": url,
+		})
+		return
+	}
+}
+
+// DeleteAvatarHandler godoc
+// @Summary Remove the avatar
+// @Description Removes the current user's avatar and its thumbnails.
+// @Tags profile
+// @Produce json
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Security BearerAuth
+// @Router /api/avatar/delete [post]
+func DeleteAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	old, err := db.ClearAvatar(db.Postgres, claims.UserID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to remove avatar", http.StatusInternalServerError)
+		return
+	}
+	for _, key := range old {
+		os.Remove(filePath(key))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// AvatarHandler godoc
+// @Summary Get an avatar
+// @Description Serves a user's avatar without authentication so it can be used in image tags. Returns the smallest thumbnail at least size pixels wide, or the largest one. Responses carry an ETag; requests with the current v from avatar_url can be cached for a year.
+// @Tags profile
+// @Produce png
+// @Produce jpeg
+// @Param user_id query int true "User ID"
+// @Param size query int false "Wanted edge length in pixels"
+// @Param v query string false "Avatar version from avatar_url"
+// @Success 200 {file} file "Avatar"
+// @Success 304 {string} string "Not modified"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"avatar not found\"}"
+// @Router /avatar [get]
+func AvatarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	userID, err := strconv.ParseInt(q.Get("user_id"), 10, 64)
+	if err != nil {
+		server.WriteErrorJSON(w, "invalid user_id", http.StatusBadRequest)
+		return
+	}
+	size := math.MaxInt32
+	if s := q.Get("size"); s != "" {
+		if size, err = strconv.Atoi(s); err != nil || size <= 0 {
+			server.WriteErrorJSON(w, "invalid size", http.StatusBadRequest)
+			return
+		}
+	}
+
+	f, version, err := db.GetAvatarFile(db.Postgres, userID, size)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "avatar not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load avatar", http.StatusInternalServerError)
+		return
+	}
+	content, err := os.Open(filePath(f.StorageKey))
+	if err != nil {
+		log.Printf("[AvatarHandler] file %d: %v", f.ID, err)
+		server.WriteErrorJSON(w, "avatar not found", http.StatusNotFound)
+		return
+	}
+	defer content.Close()
+
+	// the URL of the current avatar never changes its content
+	cache := "public, max-age=300"
+	if q.Get("v") == avatarVersion(version) {
+		cache = "public, max-age=31536000, immutable"
+	}
+	w.Header().Set("Cache-Control", cache)
+	w.Header().Set("ETag", `"`+f.SHA256+`"`)
+	w.Header().Set("Content-Type", f.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	http.ServeContent(w, r, "", f.CreatedAt, content)
+}
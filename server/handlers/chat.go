@@ -8,6 +8,7 @@ import (
 	"database/sql"
 	"mFrelance/db"
 	"mFrelance/models"
+	"mFrelance/realtime"
 	"mFrelance/server"
 	time "time"
 )
@@ -51,6 +52,13 @@ func CreateChatRequestHandler() http.HandlerFunc {
 			http.Error(w, "invalid requested_id", http.StatusBadRequest)
 			return
 		}
+		if blocked, err := isBlockedFor(claims.UserID, requestedID); err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		} else if blocked {
+			http.Error(w, "you cannot contact this user", http.StatusForbidden)
+			return
+		}
 
 		existingRequest, err := db.GetChatRequest(db.Postgres, requestedID, claims.UserID)
 		if err != nil && err != sql.ErrNoRows {
@@ -74,6 +82,7 @@ func CreateChatRequestHandler() http.HandlerFunc {
 			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		publishChatRequest(request, claims.UserID)
 
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(request)
@@ -168,6 +177,7 @@ func UpdateChatRequestHandler() http.HandlerFunc {
 				return
 			}
 		}
+		publishChatRequest(request, claims.UserID)
 
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
@@ -176,7 +186,7 @@ func UpdateChatRequestHandler() http.HandlerFunc {
 
 // GetChatRoomsForUserHandler retrieves chat rooms for the logged-in user
 // @Summary Get chat rooms
-// @Description Returns all chat rooms the logged-in user participates in with participant info
+// @Description Returns all chat rooms the logged-in user participates in, most recently active first, with the participants, a preview of the last message and the unread count
 // @Tags Chat
 // @Accept json
 // @Produce json
@@ -192,61 +202,31 @@ func GetChatRoomsForUserHandler() http.HandlerFunc {
 			return
 		}
 
-		chatRooms, err := db.GetChatRoomsForUser(db.Postgres, claims.UserID)
+		rooms, err := db.GetChatRoomSummaries(db.Postgres, claims.UserID)
 		if err != nil {
 			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		log.Print("chatRooms:")
-		log.Println(chatRooms)
-		// Get participant info for each room
 		type ChatRoomInfo struct {
-			ID        int64     `json:"id"`
-			Name      string    `json:"name"`      // For display: "Chat username"
-			Username  string    `json:"username"`  // The other participant's username
-			UserID    int64     `json:"user_id"`   // The other participant's ID
-			CreatedAt time.Time `json:"created_at"`
+			models.ChatRoomSummary
+			Name     string `json:"name"`     // For display: "Chat username"
+			Username string `json:"username"` // The other participant's username
+			UserID   int64  `json:"user_id"`  // The other participant's ID
 		}
 
-		result := make([]ChatRoomInfo, 0)
-		for _, room := range chatRooms {
-			users, err := db.GetUsersInChatRoom(db.Postgres, room.ID)
-			log.Printf("Room %d has %d users", room.ID, len(users))
-			if err != nil {
-				log.Printf("Error getting users for room %d: %v", room.ID, err)
-				continue
-			}
-
+		result := make([]ChatRoomInfo, 0, len(rooms))
+		for _, room := range rooms {
+			info := ChatRoomInfo{ChatRoomSummary: room, Name: "Empty Chat"}
 			// Find the other participant
-			foundOther := false
-			for _, user := range users {
-				log.Printf("User in room %d: ID=%d, Username=%s, CurrentUserID=%d", room.ID, user.ID, user.Username, claims.UserID)
-				if user.ID != claims.UserID {
-					result = append(result, ChatRoomInfo{
-						ID:        room.ID,
-						Name:      "Chat " + user.Username,
-						Username:  user.Username,
-						UserID:    user.ID,
-						CreatedAt: room.CreatedAt,
-					})
-					log.Printf("Added chat room %d with user %s", room.ID, user.Username)
-					foundOther = true
+			for _, p := range room.Participants {
+				if p.UserID != claims.UserID {
+					info.Name = "Chat " + p.Username
+					info.Username = p.Username
+					info.UserID = p.UserID
 					break
 				}
 			}
-
-			// If no other participant found, show as "Empty Chat" or similar
-			if !foundOther && len(users) > 0 {
-				// At least current user is in the room
-				result = append(result, ChatRoomInfo{
-					ID:        room.ID,
-					Name:      "Empty Chat",
-					Username:  "",
-					UserID:    0,
-					CreatedAt: room.CreatedAt,
-				})
-				log.Printf("Added empty chat room %d", room.ID)
-			}
+			result = append(result, info)
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -318,14 +298,24 @@ func GetChatMessagesHandler() http.HandlerFunc {
 	}
 }
 
+// SendMessageRequest is the body of SendMessageHandler. attachment_ids are
+// files uploaded by the sender through /api/files/upload. With encrypted
+// set, message is the base64 ciphertext.
+type SendMessageRequest struct {
+	Message       string                   `json:"message"`
+	ReplyToID     *int64                   `json:"reply_to_id"`
+	AttachmentIDs []int64                  `json:"attachment_ids"`
+	Encrypted     *EncryptedMessageRequest `json:"encrypted"`
+}
+
 // SendMessageHandler sends a message to a chat room
 // @Summary Send message
-// @Description Sends a message to a chat room for the logged-in user
+// @Description Sends a message to a chat room for the logged-in user. The message may quote another message of the room and carry attachments. A message with attachments may have no text. End-to-end encrypted messages carry the base64 ciphertext in message and the content key wrapped for each recipient in encrypted.
 // @Tags Chat
 // @Accept json
 // @Produce json
 // @Param chat_room_id query int true "ID of the chat room"
-// @Param message body models.ChatMessage true "Message object"
+// @Param message body SendMessageRequest true "Message object"
 // @Success 201 {object} models.ChatMessage "Returns the created message"
 // @Failure 400 {string} string "Invalid chat_room_id or request body"
 // @Failure 401 {string} string "Unauthorized — user not logged in"
@@ -351,22 +341,101 @@ func SendMessageHandler() http.HandlerFunc {
 			return
 		}
 
-		var message models.ChatMessage
-		err = json.NewDecoder(r.Body).Decode(&message)
+		participants, err := chatParticipants(claims.UserID, chatRoomID)
+		if err == errNoChatAccess {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		room, err := db.GetChatRoom(db.Postgres, chatRoomID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// task rooms of running contracts stay open so disputes can be
+		// worked out, blocks apply everywhere else
+		if room.TaskID == nil || room.OfferID != nil {
+			if blocked, err := isBlockedFor(claims.UserID, participants...); err != nil {
+				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+				return
+			} else if blocked {
+				http.Error(w, "you cannot contact this user", http.StatusForbidden)
+				return
+			}
+		}
+
+		var req SendMessageRequest
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Encrypted != nil {
+			err = server.ValidateEncryptedMessage(req.Message, len(req.AttachmentIDs))
+		} else {
+			err = server.ValidateChatMessage(req.Message, len(req.AttachmentIDs))
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		message.ChatRoomID = chatRoomID
-		message.SenderID = claims.UserID
-		message.CreatedAt = time.Now()
+		message := models.ChatMessage{
+			ChatRoomID:  chatRoomID,
+			SenderID:    claims.UserID,
+			Message:     req.Message,
+			Kind:        models.MessageKindText,
+			ReplyToID:   req.ReplyToID,
+			CreatedAt:   time.Now(),
+			Attachments: []models.File{},
+		}
+		if req.Encrypted != nil {
+			enc, keys, err := encryptedMessageParams(claims.UserID, participants, req.Encrypted)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			message.Kind = models.MessageKindEncrypted
+			message.Encryption = enc
+			message.Keys = keys
+		}
+		if req.ReplyToID != nil {
+			parent, err := db.GetChatMessage(db.Postgres, *req.ReplyToID)
+			if err != nil || parent.ChatRoomID != chatRoomID {
+				http.Error(w, "invalid reply_to_id", http.StatusBadRequest)
+				return
+			}
+		}
+		if len(req.AttachmentIDs) > 0 {
+			files, err := db.GetOwnedFiles(db.Postgres, claims.UserID, req.AttachmentIDs)
+			if err != nil {
+				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if len(files) != len(req.AttachmentIDs) {
+				http.Error(w, "invalid attachment_ids", http.StatusBadRequest)
+				return
+			}
+			message.Attachments = files
+		}
 
 		err = db.CreateChatMessage(db.Postgres, &message)
 		if err != nil {
 			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		// The sender has obviously read everything up to their own message
+		if _, err := db.MarkChatRead(db.Postgres, chatRoomID, claims.UserID, message.ID); err != nil {
+			log.Printf("failed to move read marker: %v", err)
+		}
+		realtime.Publish(realtime.Event{
+			Type:       realtime.EventMessage,
+			ChatRoomID: chatRoomID,
+			UserID:     claims.UserID,
+			Data:       message,
+		}, participants...)
 
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(message)
@@ -435,6 +504,13 @@ func AcceptChatRequestHandler() http.HandlerFunc {
 			writeErrorJSON(w, "invalid requester_id", http.StatusBadRequest)
 			return
 		}
+		if blocked, err := isBlockedFor(claims.UserID, requesterID); err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		} else if blocked {
+			http.Error(w, "you cannot contact this user", http.StatusForbidden)
+			return
+		}
 		err = db.AcceptChatRequest(db.Postgres, requesterID, claims.UserID)
 		if err != nil {
 			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
@@ -466,6 +542,12 @@ func AcceptChatRequestHandler() http.HandlerFunc {
 			}
 		}
 
+		publishChatRequest(&models.ChatRequest{
+			RequesterID: requesterID,
+			RequestedID: claims.UserID,
+			Status:      "accepted",
+		}, claims.UserID)
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -557,5 +639,10 @@ func CancelChatRequestHandler() http.HandlerFunc {
 			return
 		}
 		db.DeleteChatRequest(db.Postgres, claims.UserID, requesterID)
+		publishChatRequest(&models.ChatRequest{
+			RequesterID: claims.UserID,
+			RequestedID: requesterID,
+			Status:      "cancelled",
+		}, claims.UserID)
 	}
 }
@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/realtime"
+	"mFrelance/server"
+)
+
+type EditChatMessageRequest struct {
+	Message string `json:"message"`
+}
+
+func messageIDParam(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("message_id"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.New("invalid message_id")
+	}
+	return id, nil
+}
+
+// publishMessageDeleted tells the participants that a message is gone. The
+// event carries no content.
+func publishMessageDeleted(m *models.ChatMessage, by int64, participants []int64) {
+	realtime.Publish(realtime.Event{
+		Type:       realtime.EventMessageDeleted,
+		ChatRoomID: m.ChatRoomID,
+		UserID:     by,
+		Data: map[string]interface{}{
+			"id":           m.ID,
+			"chat_room_id": m.ChatRoomID,
+			"deleted_by":   by,
+			"deleted_at":   time.Now(),
+		},
+	}, participants...)
+}
+
+// EditChatMessageHandler godoc
+// @Summary Edit a message
+// @Description Replaces the text of the user's own message. The previous text is kept in the edit history. Deleted and encrypted messages can't be edited.
+// @Tags Chat
+// @Accept json
+// @Produce json
+// @Param message_id query int true "Message ID"
+// @Param request body EditChatMessageRequest true "New text"
+// @Success 200 {object} models.ChatMessage
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"message is required\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"only the sender can edit a message\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"message not found\"}"
+// @Security BearerAuth
+// @Router /api/chat/editMessage [post]
+func EditChatMessageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		id, err := messageIDParam(r)
+		if err != nil {
+			server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req EditChatMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+
+		m, err := db.GetChatMessage(db.Postgres, id)
+		if err != nil || m.DeletedAt != nil {
+			server.WriteErrorJSON(w, "message not found", http.StatusNotFound)
+			return
+		}
+		if m.SenderID != claims.UserID {
+			server.WriteErrorJSON(w, "only the sender can edit a message", http.StatusForbidden)
+			return
+		}
+		if m.Kind == models.MessageKindEncrypted {
+			server.WriteErrorJSON(w, "encrypted messages can't be edited, delete and send it again", http.StatusBadRequest)
+			return
+		}
+		if err := server.ValidateChatMessage(req.Message, len(m.Attachments)); err != nil {
+			server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		participants, err := chatParticipants(claims.UserID, m.ChatRoomID)
+		if err != nil {
+			writeChatRealtimeError(w, err)
+			return
+		}
+		m, err = db.EditChatMessage(db.Postgres, id, claims.UserID, req.Message)
+		if errors.Is(err, sql.ErrNoRows) {
+			server.WriteErrorJSON(w, "message not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to edit message", http.StatusInternalServerError)
+			return
+		}
+		realtime.Publish(realtime.Event{
+			Type:       realtime.EventMessageEdited,
+			ChatRoomID: m.ChatRoomID,
+			UserID:     claims.UserID,
+			Data:       m,
+		}, participants...)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m)
+	}
+}
+
+// DeleteChatMessageHandler godoc
+// @Summary Delete a message
+// @Description Deletes the user's own message. Participants keep seeing a placeholder without text or attachments.
+// @Tags Chat
+// @Produce json
+// @Param message_id query int true "Message ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"only the sender can delete a message\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"message not found\"}"
+// @Security BearerAuth
+// @Router /api/chat/deleteMessage [post]
+func DeleteChatMessageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		id, err := messageIDParam(r)
+		if err != nil {
+			server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m, err := db.GetChatMessage(db.Postgres, id)
+		if err != nil || m.DeletedAt != nil {
+			server.WriteErrorJSON(w, "message not found", http.StatusNotFound)
+			return
+		}
+		if m.SenderID != claims.UserID {
+			server.WriteErrorJSON(w, "only the sender can delete a message", http.StatusForbidden)
+			return
+		}
+		participants, err := chatParticipants(claims.UserID, m.ChatRoomID)
+		if err != nil {
+			writeChatRealtimeError(w, err)
+			return
+		}
+		err = db.DeleteChatMessage(db.Postgres, id, claims.UserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			server.WriteErrorJSON(w, "message not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to delete message", http.StatusInternalServerError)
+			return
+		}
+		publishMessageDeleted(m, claims.UserID, participants)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+	}
+}
+
+// ChatMessageHistoryHandler godoc
+// @Summary Message edit history
+// @Description Returns a message with its previous versions, oldest first. Available to the participants of the room. The history of a deleted message is only shown to users with the chat.manage permission.
+// @Tags Chat
+// @Produce json
+// @Param message_id query int true "Message ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"message\": {...}, \"edits\": [{\"id\": 1, \"message\": \"helo\", \"edited_at\": \"2025-01-01T12:00:00Z\"}]}"
+// @Failure 403 {object} map[string]string "No access to chat room"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"message not found\"}"
+// @Security BearerAuth
+// @Router /api/chat/messageHistory [get]
+func ChatMessageHistoryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		id, err := messageIDParam(r)
+		if err != nil {
+			server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m, err := db.GetChatMessage(db.Postgres, id)
+		if err != nil {
+			server.WriteErrorJSON(w, "message not found", http.StatusNotFound)
+			return
+		}
+		moderator := server.HasPermission(claims, server.PermChatManage)
+		if !moderator {
+			if _, err := chatParticipants(claims.UserID, m.ChatRoomID); err != nil {
+				writeChatRealtimeError(w, err)
+				return
+			}
+			if m.DeletedAt != nil {
+				server.WriteErrorJSON(w, "message not found", http.StatusNotFound)
+				return
+			}
+		}
+		edits, err := db.GetChatMessageEdits(db.Postgres, id)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load history", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": m,
+			"edits":   edits,
+		})
+	}
+}
+
+// AdminDeleteChatMessageHandler godoc
+// @Summary Delete any chat message
+// @Description Allows a moderator to delete a message in any chat room. The text stays available to moderators through the edit history.
+// @Tags chats
+// @Produce json
+// @Param message_id query int true "Message ID"
+// @Param reason query string false "Reason stored in the audit log"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"message not found\"}"
+// @Failure 403 {string} string "Example: \"insufficient permissions\""
+// @Security BearerAuth
+// @Router /api/admin/chat/deleteMessage [post]
+func AdminDeleteChatMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	id, err := messageIDParam(r)
+	if err != nil {
+		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m, err := db.GetChatMessage(db.Postgres, id)
+	if err != nil || m.DeletedAt != nil {
+		server.WriteErrorJSON(w, "message not found", http.StatusNotFound)
+		return
+	}
+
+	e := newAuditEntry(r, db.AuditChatMessageDelete, "chat_message", id, r.URL.Query().Get("reason"))
+	err = runAudited(e, func(tx *sqlx.Tx) (interface{}, interface{}, error) {
+		if err := db.DeleteChatMessageTx(tx, id, claims.UserID); err != nil {
+			return nil, nil, err
+		}
+		return map[string]interface{}{
+			"chat_room_id": m.ChatRoomID,
+			"sender_id":    m.SenderID,
+			"message":      m.Message,
+		}, nil, nil
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to delete message", http.StatusInternalServerError)
+		return
+	}
+	if participants, err := db.GetChatParticipantIDs(db.Postgres, m.ChatRoomID); err != nil {
+		log.Printf("[AdminDeleteChatMessageHandler] participants of room %d: %v", m.ChatRoomID, err)
+	} else {
+		publishMessageDeleted(m, claims.UserID, participants)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/realtime"
+	"mFrelance/server"
+)
+
+// streamPingInterval keeps idle connections open through proxies and
+// detects dead clients
+const streamPingInterval = 25 * time.Second
+
+var errNoChatAccess = errors.New("no access to chat room")
+
+// chatWSFrame is what WebSocket clients send
+type chatWSFrame struct {
+	Type       string `json:"type"`
+	ChatRoomID int64  `json:"chat_room_id"`
+	MessageID  int64  `json:"message_id,omitempty"`
+}
+
+// chatParticipants returns the participants of the room after checking that
+// userID is one of them
+func chatParticipants(userID, chatRoomID int64) ([]int64, error) {
+	ids, err := db.GetChatParticipantIDs(db.Postgres, chatRoomID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if id == userID {
+			return ids, nil
+		}
+	}
+	return nil, errNoChatAccess
+}
+
+// publishTyping tells the other participants that userID is typing
+func publishTyping(userID, chatRoomID int64) error {
+	ids, err := chatParticipants(userID, chatRoomID)
+	if err != nil {
+		return err
+	}
+	realtime.Publish(realtime.Event{
+		Type:       realtime.EventTyping,
+		ChatRoomID: chatRoomID,
+		UserID:     userID,
+	}, realtime.Except(ids, userID)...)
+	return nil
+}
+
+// markChatRead stores the read marker and sends a read receipt to every
+// participant, including the reader's other connections
+func markChatRead(userID, chatRoomID, messageID int64) (*models.ChatRead, error) {
+	ids, err := chatParticipants(userID, chatRoomID)
+	if err != nil {
+		return nil, err
+	}
+	read, err := db.MarkChatRead(db.Postgres, chatRoomID, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	realtime.Publish(realtime.Event{
+		Type:       realtime.EventRead,
+		ChatRoomID: chatRoomID,
+		UserID:     userID,
+		Data:       read,
+	}, ids...)
+	return read, nil
+}
+
+// publishChatRequest notifies both sides of a chat request about its state
+func publishChatRequest(req *models.ChatRequest, actorID int64) {
+	realtime.Publish(realtime.Event{
+		Type:   realtime.EventChatRequest,
+		UserID: actorID,
+		Data:   req,
+	}, req.RequesterID, req.RequestedID)
+}
+
+func writeChatRealtimeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNoChatAccess) {
+		server.WriteErrorJSON(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+}
+
+func chatRoomIDParam(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("chat_room_id"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid chat_room_id")
+	}
+	return id, nil
+}
+
+// registerStream checks the user and registers a connection with the hub
+func registerStream(w http.ResponseWriter, r *http.Request) *realtime.Client {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+	if realtime.Default == nil {
+		http.Error(w, "realtime is not available", http.StatusServiceUnavailable)
+		return nil
+	}
+	if blocked, err := db.IsUserBlocked(db.Postgres, claims.UserID); err != nil {
+		http.Error(w, "Error for check user ban", http.StatusInternalServerError)
+		return nil
+	} else if blocked {
+		http.Error(w, "User is banned", http.StatusForbidden)
+		return nil
+	}
+	return realtime.Default.Register(claims.UserID)
+}
+
+func pingEvent() []byte {
+	raw, _ := json.Marshal(realtime.Event{Type: realtime.EventPing, At: time.Now().UTC()})
+	return raw
+}
+
+// ChatWSHandler godoc
+// @Summary Chat WebSocket
+// @Description Upgrades to a WebSocket that pushes chat events as JSON: new messages ("message"), chat request updates ("chat_request"), typing indicators ("typing"), read receipts ("read") and keep-alives ("ping"). The client may send {"type":"typing","chat_room_id":1} and {"type":"read","chat_room_id":1,"message_id":10}. The JWT can be passed in the Authorization header or as access_token.
+// @Tags Chat
+// @Param access_token query string false "JWT when the Authorization header cannot be set"
+// @Success 101 {string} string "Switching Protocols"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "User is banned"
+// @Router /api/chat/ws [get]
+func ChatWSHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := registerStream(w, r)
+		if c == nil {
+			return
+		}
+		defer realtime.Default.Unregister(c)
+		websocket.Server{
+			// Authentication is by token, not cookies, so any origin may connect
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler:   func(ws *websocket.Conn) { serveChatWS(ws, c) },
+		}.ServeHTTP(w, r)
+	}
+}
+
+func serveChatWS(ws *websocket.Conn, c *realtime.Client) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var in chatWSFrame
+			if err := websocket.JSON.Receive(ws, &in); err != nil {
+				return
+			}
+			var err error
+			switch in.Type {
+			case realtime.EventTyping:
+				err = publishTyping(c.UserID, in.ChatRoomID)
+			case realtime.EventRead:
+				_, err = markChatRead(c.UserID, in.ChatRoomID, in.MessageID)
+			default:
+				err = fmt.Errorf("unknown frame type %q", in.Type)
+			}
+			if err != nil {
+				raw, _ := json.Marshal(realtime.Event{
+					Type:       realtime.EventError,
+					ChatRoomID: in.ChatRoomID,
+					Data:       err.Error(),
+					At:         time.Now().UTC(),
+				})
+				select {
+				case c.Send <- raw:
+				default:
+				}
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(streamPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case msg := <-c.Send:
+			if err := websocket.Message.Send(ws, string(msg)); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := websocket.Message.Send(ws, string(pingEvent())); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ChatEventsHandler godoc
+// @Summary Chat event stream
+// @Description Server-Sent Events fallback for clients without WebSocket support. Every event is a data line with the same JSON as on /api/chat/ws. Typing and read receipts are sent with /api/chat/typing and /api/chat/read.
+// @Tags Chat
+// @Produce text/event-stream
+// @Param access_token query string false "JWT when the Authorization header cannot be set"
+// @Success 200 {string} string "Event stream"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "User is banned"
+// @Router /api/chat/events [get]
+func ChatEventsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		c := registerStream(w, r)
+		if c == nil {
+			return
+		}
+		defer realtime.Default.Unregister(c)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ticker := time.NewTicker(streamPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case msg := <-c.Send:
+				fmt.Fprintf(w, "data: %s\n\n", msg)
+			case <-ticker.C:
+				fmt.Fprintf(w, "data: %s\n\n", pingEvent())
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// ChatTypingHandler godoc
+// @Summary Send typing indicator
+// @Description Tells the other participants of the room that the user is typing
+// @Tags Chat
+// @Produce json
+// @Param chat_room_id query int true "ID of the chat room"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 400 {object} map[string]string "Invalid chat_room_id"
+// @Failure 403 {object} map[string]string "No access to chat room"
+// @Security BearerAuth
+// @Router /api/chat/typing [post]
+func ChatTypingHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		chatRoomID, err := chatRoomIDParam(r)
+		if err != nil {
+			server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := publishTyping(claims.UserID, chatRoomID); err != nil {
+			writeChatRealtimeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+	}
+}
+
+// ChatReadHandler godoc
+// @Summary Mark chat as read
+// @Description Moves the user's read marker to message_id, or to the latest message when it is omitted, and sends a read receipt to the participants
+// @Tags Chat
+// @Produce json
+// @Param chat_room_id query int true "ID of the chat room"
+// @Param message_id query int false "Last read message"
+// @Success 200 {object} models.ChatRead
+// @Failure 400 {object} map[string]string "Invalid chat_room_id or message_id"
+// @Failure 403 {object} map[string]string "No access to chat room"
+// @Security BearerAuth
+// @Router /api/chat/read [post]
+func ChatReadHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		chatRoomID, err := chatRoomIDParam(r)
+		if err != nil {
+			server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var messageID int64
+		if v := r.URL.Query().Get("message_id"); v != "" {
+			messageID, err = strconv.ParseInt(v, 10, 64)
+			if err != nil || messageID <= 0 {
+				server.WriteErrorJSON(w, "invalid message_id", http.StatusBadRequest)
+				return
+			}
+		}
+		read, err := markChatRead(claims.UserID, chatRoomID, messageID)
+		if err != nil {
+			writeChatRealtimeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(read)
+	}
+}
+
+// ChatUnreadHandler godoc
+// @Summary Unread counters
+// @Description Returns the number of unread messages per chat room and in total. Rooms without unread messages are omitted.
+// @Tags Chat
+// @Produce json
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"total\": 3, \"rooms\": {\"12\": 3}}"
+// @Failure 401 {string} string "Unauthorized"
+// @Security BearerAuth
+// @Router /api/chat/unread [get]
+func ChatUnreadHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		counts, err := db.GetUnreadCounts(db.Postgres, claims.UserID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		total := 0
+		for _, n := range counts {
+			total += n
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"total":   total,
+			"rooms":   counts,
+		})
+	}
+}
+
+// ChatReadsHandler godoc
+// @Summary Read markers of a room
+// @Description Returns how far every participant has read, for rendering read receipts
+// @Tags Chat
+// @Produce json
+// @Param chat_room_id query int true "ID of the chat room"
+// @Success 200 {array} models.ChatRead
+// @Failure 403 {object} map[string]string "No access to chat room"
+// @Security BearerAuth
+// @Router /api/chat/reads [get]
+func ChatReadsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		chatRoomID, err := chatRoomIDParam(r)
+		if err != nil {
+			server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := chatParticipants(claims.UserID, chatRoomID); err != nil {
+			writeChatRealtimeError(w, err)
+			return
+		}
+		reads, err := db.GetChatReads(db.Postgres, chatRoomID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reads)
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/server"
+)
+
+// maxSearchQueryLength limits the search text in characters
+const maxSearchQueryLength = 200
+
+// SearchChatMessagesHandler godoc
+// @Summary Search chat messages
+// @Description Full-text search over the messages of the rooms the user participates in. The query supports "quoted phrases", "or" and -excluded words. Words are matched whole, without stemming. Encrypted and deleted messages are not searchable.
+// @Tags Chat
+// @Produce json
+// @Param q query string true "Search text"
+// @Param chat_room_id query int false "Only search this room"
+// @Param sender_id query int false "Only messages from this user"
+// @Param from query string false "Messages sent at or after this time (RFC3339)"
+// @Param to query string false "Messages sent before this time (RFC3339)"
+// @Param sort query string false "recent (default) or relevance"
+// @Param limit query int false "Page size, default 50, max 200"
+// @Param offset query int false "Offset"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"results\": [{\"id\": 124, \"chat_room_id\": 789, \"message\": \"the invoice is attached\", \"headline\": \"the [[invoice]] is attached\", \"rank\": 0.06}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"q is required\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"no access to chat room\"}"
+// @Security BearerAuth
+// @Router /api/chat/search [get]
+func SearchChatMessagesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+		f := models.ChatSearchFilter{Query: strings.TrimSpace(q.Get("q")), Sort: q.Get("sort")}
+		if f.Query == "" {
+			server.WriteErrorJSON(w, "q is required", http.StatusBadRequest)
+			return
+		}
+		if utf8.RuneCountInString(f.Query) > maxSearchQueryLength {
+			server.WriteErrorJSON(w, "q is too long", http.StatusBadRequest)
+			return
+		}
+		if f.Sort != "" && f.Sort != "recent" && f.Sort != "relevance" {
+			server.WriteErrorJSON(w, "sort must be recent or relevance", http.StatusBadRequest)
+			return
+		}
+		for name, dst := range map[string]*int64{"chat_room_id": &f.ChatRoomID, "sender_id": &f.SenderID} {
+			v := q.Get(name)
+			if v == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id <= 0 {
+				server.WriteErrorJSON(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = id
+		}
+		for name, dst := range map[string]**time.Time{"from": &f.From, "to": &f.To} {
+			v := q.Get(name)
+			if v == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				server.WriteErrorJSON(w, "invalid "+name+", RFC3339 expected", http.StatusBadRequest)
+				return
+			}
+			*dst = &t
+		}
+		f.Limit, f.Offset = pageParams(r)
+
+		if f.ChatRoomID > 0 {
+			ok, err := db.IsUserHaveAccessToChatRoom(db.Postgres, claims.UserID, f.ChatRoomID)
+			if err != nil {
+				server.WriteErrorJSON(w, "failed to check access", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				server.WriteErrorJSON(w, "no access to chat room", http.StatusForbidden)
+				return
+			}
+		}
+
+		results, err := db.SearchChatMessages(db.Postgres, claims.UserID, f)
+		if err != nil {
+			log.Println("[SearchChatMessagesHandler]", err)
+			server.WriteErrorJSON(w, "search failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"results": results,
+		})
+	}
+}
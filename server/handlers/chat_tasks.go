@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"mFrelance/db"
+	"mFrelance/server"
+)
+
+// TaskChatRoomHandler godoc
+// @Summary Get the task chat room
+// @Description Returns the chat room of a task. The room is created when an offer is accepted, with the client and the freelancer as participants. Arbiters join it when they take a dispute on the task.
+// @Tags Chat
+// @Produce json
+// @Param task_id query int true "Task ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"chat_room\": {\"id\": 7, \"task_id\": 3, \"offer_id\": null, \"created_at\": \"2025-01-01T12:00:00Z\"}}"
+// @Failure 403 {object} map[string]string "No access to chat room"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"task has no chat room\"}"
+// @Security BearerAuth
+// @Router /api/chat/taskRoom [get]
+func TaskChatRoomHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		taskID, err := strconv.ParseInt(r.URL.Query().Get("task_id"), 10, 64)
+		if err != nil || taskID <= 0 {
+			server.WriteErrorJSON(w, "invalid task_id", http.StatusBadRequest)
+			return
+		}
+		room, err := db.GetTaskChatRoom(db.Postgres, taskID)
+		if errors.Is(err, sql.ErrNoRows) {
+			server.WriteErrorJSON(w, "task has no chat room", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load chat room", http.StatusInternalServerError)
+			return
+		}
+		if _, err := chatParticipants(claims.UserID, room.ID); err != nil {
+			writeChatRealtimeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"chat_room": room,
+		})
+	}
+}
+
+// OfferChatRoomHandler godoc
+// @Summary Open the chat room of an offer
+// @Description Returns the room where the client and the freelancer discuss an offer before it is accepted, creating it on first use. New rooms can only be opened while the task is open.
+// @Tags Chat
+// @Produce json
+// @Param offer_id query int true "Offer ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"chat_room\": {\"id\": 8, \"task_id\": 3, \"offer_id\": 11, \"created_at\": \"2025-01-01T12:00:00Z\"}}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"task is not open\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"only the client and the freelancer can discuss an offer\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"offer not found\"}"
+// @Security BearerAuth
+// @Router /api/chat/offerRoom [post]
+func OfferChatRoomHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		offerID, err := strconv.ParseInt(r.URL.Query().Get("offer_id"), 10, 64)
+		if err != nil || offerID <= 0 {
+			server.WriteErrorJSON(w, "invalid offer_id", http.StatusBadRequest)
+			return
+		}
+		offer, err := db.GetTaskOffer(db.Postgres, offerID)
+		if err != nil {
+			server.WriteErrorJSON(w, "offer not found", http.StatusNotFound)
+			return
+		}
+		task, err := db.GetTask(db.Postgres, offer.TaskID)
+		if err != nil {
+			server.WriteErrorJSON(w, "task not found", http.StatusNotFound)
+			return
+		}
+		if claims.UserID != task.ClientID && claims.UserID != offer.FreelancerID {
+			server.WriteErrorJSON(w, "only the client and the freelancer can discuss an offer", http.StatusForbidden)
+			return
+		}
+		room, err := db.GetOfferChatRoom(db.Postgres, offer.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			if task.Status != "open" {
+				server.WriteErrorJSON(w, "task is not open", http.StatusBadRequest)
+				return
+			}
+			if blocked, err := isBlockedFor(task.ClientID, offer.FreelancerID); err != nil {
+				server.WriteErrorJSON(w, "failed to open chat room", http.StatusInternalServerError)
+				return
+			} else if blocked {
+				server.WriteErrorJSON(w, "you cannot contact this user", http.StatusForbidden)
+				return
+			}
+			room, err = db.EnsureOfferChatRoom(db.Postgres, offer, task.ClientID)
+		}
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to open chat room", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"chat_room": room,
+		})
+	}
+}
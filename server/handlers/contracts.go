@@ -0,0 +1,563 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/notifications"
+	"mFrelance/server"
+)
+
+const (
+	maxTimeEntryDescription = 2000
+	maxDisputeReason        = 2000
+)
+
+type SaveTimeEntryRequest struct {
+	ID          int64  `json:"id"`
+	TaskID      int64  `json:"task_id"`
+	WorkDate    string `json:"work_date"`
+	Minutes     int    `json:"minutes"`
+	Description string `json:"description"`
+}
+
+type DeleteTimeEntryRequest struct {
+	ID int64 `json:"id"`
+}
+
+type ContractWeekRequest struct {
+	TaskID    int64  `json:"task_id"`
+	WeekStart string `json:"week_start"`
+}
+
+type ReviewContractWeekRequest struct {
+	TaskID    int64  `json:"task_id"`
+	WeekStart string `json:"week_start"`
+	Approve   bool   `json:"approve"`
+	Reason    string `json:"reason"`
+}
+
+type EndContractRequest struct {
+	TaskID int64 `json:"task_id"`
+}
+
+// loadContract returns an hourly task and its accepted offer when userID is
+// the client or the freelancer. Writes the error response otherwise.
+func loadContract(w http.ResponseWriter, taskID, userID int64) (*models.Task, *models.TaskOffer, bool) {
+	task, err := db.GetTask(db.Postgres, taskID)
+	if err != nil {
+		server.WriteErrorJSON(w, "task not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if task.ContractType != models.ContractHourly {
+		server.WriteErrorJSON(w, "task is not an hourly contract", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	offer, err := db.GetAcceptedTaskOffer(db.Postgres, task.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "contract has not started", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load contract", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if userID != task.ClientID && userID != offer.FreelancerID {
+		server.WriteErrorJSON(w, "forbidden", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return task, offer, true
+}
+
+// parseWeekStart parses a week_start date, which must be a Monday
+func parseWeekStart(s string) (time.Time, bool) {
+	d, err := time.Parse(time.DateOnly, s)
+	if err != nil || models.WeekStart(d) != d {
+		return time.Time{}, false
+	}
+	return d, true
+}
+
+// weekOpen reports whether time can still be logged on a date: in the
+// running week, or in a week the client disputed
+func weekOpen(taskID int64, date time.Time) (bool, error) {
+	start := models.WeekStart(date)
+	if start.Equal(models.WeekStart(time.Now().UTC())) {
+		return true, nil
+	}
+	week, err := db.GetContractWeek(db.Postgres, taskID, start)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return week.Status == models.WeekDisputed, nil
+}
+
+// TimeEntryHandler godoc
+// @Summary Log time on an hourly contract
+// @Description Creates a time entry, or updates one when id is set (freelancer only). Time can be logged for days of the running week up to today, and changed in weeks the client disputed, until the contract is ended.
+// @Tags contracts
+// @Accept json
+// @Produce json
+// @Param request body SaveTimeEntryRequest true "Time entry"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"entry\": {\"id\": 5, \"task_id\": 12, \"freelancer_id\": 7, \"work_date\": \"2025-03-04T00:00:00Z\", \"minutes\": 150, \"description\": \"Checkout flow\", \"created_at\": \"2025-03-04T18:00:00Z\", \"updated_at\": \"2025-03-04T18:00:00Z\"}}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"week is closed\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"only the freelancer can log time\"}"
+// @Security BearerAuth
+// @Router /api/contracts/time [post]
+func TimeEntryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req SaveTimeEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	var existing *models.TimeEntry
+	if req.ID != 0 {
+		e, err := db.GetTimeEntry(db.Postgres, req.ID)
+		if err != nil || e.FreelancerID != claims.UserID {
+			server.WriteErrorJSON(w, "time entry not found", http.StatusNotFound)
+			return
+		}
+		existing = e
+		req.TaskID = e.TaskID
+	}
+
+	task, offer, ok := loadContract(w, req.TaskID, claims.UserID)
+	if !ok {
+		return
+	}
+	if offer.FreelancerID != claims.UserID {
+		server.WriteErrorJSON(w, "only the freelancer can log time", http.StatusForbidden)
+		return
+	}
+	if task.Status != "in_progress" || task.EndedAt != nil {
+		server.WriteErrorJSON(w, "contract is not running", http.StatusBadRequest)
+		return
+	}
+
+	date, err := time.Parse(time.DateOnly, req.WorkDate)
+	if err != nil {
+		server.WriteErrorJSON(w, "work_date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if date.After(time.Now().UTC()) {
+		server.WriteErrorJSON(w, "work_date is in the future", http.StatusBadRequest)
+		return
+	}
+	if req.Minutes < 1 || req.Minutes > 24*60 {
+		server.WriteErrorJSON(w, "minutes must be between 1 and 1440", http.StatusBadRequest)
+		return
+	}
+	description := server.SanitizeString(strings.TrimSpace(req.Description))
+	if description == "" {
+		server.WriteErrorJSON(w, "description is required", http.StatusBadRequest)
+		return
+	}
+	if len(description) > maxTimeEntryDescription {
+		server.WriteErrorJSON(w, "description too long", http.StatusBadRequest)
+		return
+	}
+
+	dates := []time.Time{date}
+	if existing != nil {
+		dates = append(dates, existing.WorkDate)
+	}
+	for _, d := range dates {
+		open, err := weekOpen(task.ID, d)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load week", http.StatusInternalServerError)
+			return
+		}
+		if !open {
+			server.WriteErrorJSON(w, "week is closed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entry := &models.TimeEntry{
+		ID:           req.ID,
+		TaskID:       task.ID,
+		FreelancerID: claims.UserID,
+		WorkDate:     date,
+		Minutes:      req.Minutes,
+		Description:  description,
+	}
+	if existing != nil {
+		entry.CreatedAt = existing.CreatedAt
+		err = db.UpdateTimeEntry(db.Postgres, entry)
+	} else {
+		err = db.CreateTimeEntry(db.Postgres, entry)
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to save time entry", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"entry":   entry,
+	})
+}
+
+// DeleteTimeEntryHandler godoc
+// @Summary Delete a time entry
+// @Description Deletes one of the current user's time entries while its week is still open.
+// @Tags contracts
+// @Accept json
+// @Produce json
+// @Param request body DeleteTimeEntryRequest true "Time entry ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"week is closed\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"time entry not found\"}"
+// @Security BearerAuth
+// @Router /api/contracts/time/delete [post]
+func DeleteTimeEntryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req DeleteTimeEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	entry, err := db.GetTimeEntry(db.Postgres, req.ID)
+	if err != nil || entry.FreelancerID != claims.UserID {
+		server.WriteErrorJSON(w, "time entry not found", http.StatusNotFound)
+		return
+	}
+	task, err := db.GetTask(db.Postgres, entry.TaskID)
+	if err != nil {
+		server.WriteErrorJSON(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if task.Status != "in_progress" || task.EndedAt != nil {
+		server.WriteErrorJSON(w, "contract is not running", http.StatusBadRequest)
+		return
+	}
+	open, err := weekOpen(task.ID, entry.WorkDate)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load week", http.StatusInternalServerError)
+		return
+	}
+	if !open {
+		server.WriteErrorJSON(w, "week is closed", http.StatusBadRequest)
+		return
+	}
+	if err := db.DeleteTimeEntry(db.Postgres, entry.ID); err != nil {
+		server.WriteErrorJSON(w, "failed to delete time entry", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// ContractHandler godoc
+// @Summary Get an hourly contract
+// @Description Returns the terms of an hourly contract, the amount held in escrow, its closed weeks (newest first) and the time entries of one week (client or freelancer). For the running week, week holds the minutes logged so far and what they would cost.
+// @Tags contracts
+// @Produce json
+// @Param task_id query int true "Task ID"
+// @Param week_start query string false "Monday of the week to list entries for, YYYY-MM-DD (default: running week)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"contract\": {\"task_id\": 12, \"client_id\": 3, \"freelancer_id\": 7, \"hourly_rate\": 0.0002, \"weekly_cap\": 20, \"currency\": \"BTC\", \"escrow\": 0.004, \"status\": \"in_progress\", \"ended_at\": null}, \"weeks\": [{\"task_id\": 12, \"week_start\": \"2025-02-24T00:00:00Z\", \"status\": \"settled\", \"minutes\": 900, \"amount\": 0.003}], \"week\": {\"week_start\": \"2025-03-03\", \"status\": \"open\", \"minutes\": 150, \"amount\": 0.0005}, \"entries\": [...]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"week_start must be a Monday, YYYY-MM-DD\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"forbidden\"}"
+// @Security BearerAuth
+// @Router /api/contracts [get]
+func ContractHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	q := r.URL.Query()
+	taskID, err := strconv.ParseInt(q.Get("task_id"), 10, 64)
+	if err != nil {
+		server.WriteErrorJSON(w, "invalid task_id", http.StatusBadRequest)
+		return
+	}
+	start := models.WeekStart(time.Now().UTC())
+	if s := q.Get("week_start"); s != "" {
+		var ok bool
+		if start, ok = parseWeekStart(s); !ok {
+			server.WriteErrorJSON(w, "week_start must be a Monday, YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	task, offer, ok := loadContract(w, taskID, claims.UserID)
+	if !ok {
+		return
+	}
+	escrow, err := db.GetEscrowBalanceByTaskID(task.ID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load escrow", http.StatusInternalServerError)
+		return
+	}
+	weeks, err := db.ListContractWeeks(db.Postgres, task.ID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load weeks", http.StatusInternalServerError)
+		return
+	}
+	entries, err := db.ListTimeEntries(db.Postgres, task.ID, start, start.AddDate(0, 0, 7))
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load time entries", http.StatusInternalServerError)
+		return
+	}
+
+	// a closed week shows its submitted totals, an open one what's logged so far
+	week := map[string]interface{}{"week_start": start.Format(time.DateOnly), "status": "open"}
+	closed := false
+	for _, cw := range weeks {
+		if cw.WeekStart.Equal(start) {
+			week["status"], week["minutes"], week["amount"] = cw.Status, cw.Minutes, cw.Amount
+			closed = true
+			break
+		}
+	}
+	if !closed {
+		minutes := 0
+		for _, e := range entries {
+			minutes += e.Minutes
+		}
+		week["minutes"] = minutes
+		week["amount"] = models.WeekAmount(minutes, *offer.HourlyRate, *offer.WeeklyCap)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"contract": map[string]interface{}{
+			"task_id":       task.ID,
+			"client_id":     task.ClientID,
+			"freelancer_id": offer.FreelancerID,
+			"hourly_rate":   offer.HourlyRate,
+			"weekly_cap":    offer.WeeklyCap,
+			"currency":      task.Currency,
+			"escrow":        escrow.Amount,
+			"status":        task.Status,
+			"ended_at":      task.EndedAt,
+		},
+		"weeks":   weeks,
+		"week":    week,
+		"entries": entries,
+	})
+}
+
+// ReviewContractWeekHandler godoc
+// @Summary Approve or dispute a week
+// @Description Lets the client approve a submitted week, which is then paid from escrow, or dispute it with a reason. The freelancer can change the time of a disputed week and resubmit it. Weeks left unreviewed for contracts.review_window are approved.
+// @Tags contracts
+// @Accept json
+// @Produce json
+// @Param request body ReviewContractWeekRequest true "Review"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"week\": {\"task_id\": 12, \"week_start\": \"2025-03-03T00:00:00Z\", \"status\": \"approved\", \"minutes\": 900, \"amount\": 0.003}}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"week is not waiting for review\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"only the client can review time\"}"
+// @Security BearerAuth
+// @Router /api/contracts/review [post]
+func ReviewContractWeekHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req ReviewContractWeekRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	start, ok := parseWeekStart(req.WeekStart)
+	if !ok {
+		server.WriteErrorJSON(w, "week_start must be a Monday, YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	reason := server.SanitizeString(strings.TrimSpace(req.Reason))
+	if !req.Approve && reason == "" {
+		server.WriteErrorJSON(w, "reason is required to dispute a week", http.StatusBadRequest)
+		return
+	}
+	if len(reason) > maxDisputeReason {
+		server.WriteErrorJSON(w, "reason too long", http.StatusBadRequest)
+		return
+	}
+
+	task, offer, ok := loadContract(w, req.TaskID, claims.UserID)
+	if !ok {
+		return
+	}
+	if task.ClientID != claims.UserID {
+		server.WriteErrorJSON(w, "only the client can review time", http.StatusForbidden)
+		return
+	}
+	week, err := db.ReviewContractWeek(db.Postgres, task.ID, start, req.Approve, reason)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "week is not waiting for review", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to review week", http.StatusInternalServerError)
+		return
+	}
+	if !req.Approve {
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeContractWeekDisputed,
+			Title: "Timesheet disputed",
+			Body:  fmt.Sprintf("%s, week of %s: %s", task.Title, req.WeekStart, reason),
+			Data: map[string]interface{}{
+				"task_id":    task.ID,
+				"week_start": req.WeekStart,
+				"reason":     reason,
+			},
+		}, offer.FreelancerID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"week":    week,
+	})
+}
+
+// ResubmitContractWeekHandler godoc
+// @Summary Resubmit a disputed week
+// @Description Puts a disputed week up for the client's review again with the time logged now (freelancer only).
+// @Tags contracts
+// @Accept json
+// @Produce json
+// @Param request body ContractWeekRequest true "Week"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"week\": {\"task_id\": 12, \"week_start\": \"2025-03-03T00:00:00Z\", \"status\": \"pending\", \"minutes\": 840, \"amount\": 0.0028}}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"week is not disputed\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"only the freelancer can resubmit time\"}"
+// @Security BearerAuth
+// @Router /api/contracts/resubmit [post]
+func ResubmitContractWeekHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req ContractWeekRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	start, ok := parseWeekStart(req.WeekStart)
+	if !ok {
+		server.WriteErrorJSON(w, "week_start must be a Monday, YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	task, offer, ok := loadContract(w, req.TaskID, claims.UserID)
+	if !ok {
+		return
+	}
+	if offer.FreelancerID != claims.UserID {
+		server.WriteErrorJSON(w, "only the freelancer can resubmit time", http.StatusForbidden)
+		return
+	}
+	if task.Status != "in_progress" {
+		server.WriteErrorJSON(w, "contract is not running", http.StatusBadRequest)
+		return
+	}
+	week, err := db.ResubmitContractWeek(db.Postgres, task.ID, start)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "week is not disputed", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to resubmit week", http.StatusInternalServerError)
+		return
+	}
+	server.NotifyContractWeekSubmitted(*week)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"week":    week,
+	})
+}
+
+// EndContractHandler godoc
+// @Summary End an hourly contract
+// @Description Lets the client or the freelancer end an hourly contract. No more time can be logged; the running week is submitted at the next settlement run, and once they are all paid the rest of the escrow goes back to the client and the task is completed. Disputed weeks keep the contract open until they are resubmitted and approved, or a task dispute is resolved.
+// @Tags contracts
+// @Accept json
+// @Produce json
+// @Param request body EndContractRequest true "Task ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"ended_at\": \"2025-03-05T10:00:00Z\"}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"contract is not running\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"forbidden\"}"
+// @Security BearerAuth
+// @Router /api/contracts/end [post]
+func EndContractHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req EndContractRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	task, _, ok := loadContract(w, req.TaskID, claims.UserID)
+	if !ok {
+		return
+	}
+	endedAt, err := db.EndContract(db.Postgres, task.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "contract is not running", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to end contract", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"ended_at": endedAt,
+	})
+}
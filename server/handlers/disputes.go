@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"mFrelance/db"
 	"mFrelance/models"
+	"mFrelance/notifications"
 	"mFrelance/server"
+	"mFrelance/webhooks"
 	"net/http"
 	"strconv"
 	"time"
@@ -61,7 +63,7 @@ func CreateDisputeHandler() http.HandlerFunc {
 
 		var acceptedOffer *models.TaskOffer
 		for _, offer := range offers {
-			if offer.Accepted {
+			if offer.Status == models.OfferAccepted {
 				acceptedOffer = offer
 				break
 			}
@@ -110,6 +112,13 @@ func CreateDisputeHandler() http.HandlerFunc {
 			http.Error(w, "Failed to update task status", http.StatusInternalServerError)
 			return
 		}
+		webhooks.Emit(webhooks.EventDisputeOpened, map[string]interface{}{
+			"dispute_id":    dispute.ID,
+			"task_id":       task.ID,
+			"opened_by":     userID,
+			"client_id":     task.ClientID,
+			"freelancer_id": acceptedOffer.FreelancerID,
+		}, task.ClientID, acceptedOffer.FreelancerID)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -257,7 +266,7 @@ func SendDisputeMessageHandler() http.HandlerFunc {
 
 		var acceptedOffer *models.TaskOffer
 		for _, offer := range offers {
-			if offer.Accepted {
+			if offer.Status == models.OfferAccepted {
 				acceptedOffer = offer
 				break
 			}
@@ -268,8 +277,8 @@ func SendDisputeMessageHandler() http.HandlerFunc {
 			return
 		}
 
-		isAdmin, _ := db.IsAdmin(db.Postgres, userID)
-		isAllowed := task.ClientID == userID || acceptedOffer.FreelancerID == userID || isAdmin || (dispute.AssignedAdmin != nil && *dispute.AssignedAdmin == userID)
+		isArbiter := server.HasPermission(claims, server.PermDisputeManage)
+		isAllowed := task.ClientID == userID || acceptedOffer.FreelancerID == userID || isArbiter || (dispute.AssignedAdmin != nil && *dispute.AssignedAdmin == userID)
 		if !isAllowed {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
@@ -286,6 +295,27 @@ func SendDisputeMessageHandler() http.HandlerFunc {
 			http.Error(w, "Failed to create message", http.StatusInternalServerError)
 			return
 		}
+		var recipients []int64
+		for _, id := range []int64{task.ClientID, acceptedOffer.FreelancerID} {
+			if id != userID {
+				recipients = append(recipients, id)
+			}
+		}
+		if dispute.AssignedAdmin != nil && *dispute.AssignedAdmin != userID {
+			recipients = append(recipients, *dispute.AssignedAdmin)
+		}
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeDisputeMessage,
+			Title: "New message in dispute",
+			Body:  task.Title,
+			Data: map[string]interface{}{
+				"dispute_id": dispute.ID,
+				"task_id":    task.ID,
+				"task_title": task.Title,
+				"message_id": message.ID,
+				"sender_id":  userID,
+			},
+		}, recipients...)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -341,7 +371,7 @@ func GetUserDisputesHandler() http.HandlerFunc {
 		}
 
 		for _, offer := range offers {
-			if offer.Accepted {
+			if offer.Status == models.OfferAccepted {
 				taskDisputes, err := db.GetDisputesByTaskID(offer.TaskID)
 				if err != nil {
 					continue
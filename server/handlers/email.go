@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"mFrelance/config"
+	"mFrelance/db"
+	"mFrelance/mailer"
+	"mFrelance/server"
+)
+
+// resendInterval is how long a user has to wait before asking for another
+// verification email
+const resendInterval = time.Minute
+
+type SetEmailRequest struct {
+	Email  string `json:"email"`
+	Locale string `json:"locale"`
+}
+
+func hashEmailToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendEmailVerification stores a fresh token for the user's address and
+// queues the verification email. With set the address itself is replaced.
+func sendEmailVerification(userID int64, email, locale string, set bool) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(b)
+	ttl := config.AppConfig.EmailVerifyTTL
+	expires := time.Now().Add(ttl)
+
+	var err error
+	if set {
+		err = db.SetUserEmail(db.Postgres, userID, email, locale, hashEmailToken(token), expires)
+	} else {
+		err = db.SetEmailVerificationToken(db.Postgres, userID, hashEmailToken(token), expires)
+	}
+	if err != nil {
+		return err
+	}
+	return mailer.Enqueue(userID, email, locale, "verify_email", map[string]interface{}{
+		"Email": email,
+		"URL":   fmt.Sprintf(config.AppConfig.EmailVerifyURL, token),
+		"TTL":   formatTTL(ttl),
+	})
+}
+
+func formatTTL(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return d.String()
+}
+
+// EmailHandler godoc
+// @Summary Get or set the email address
+// @Description GET returns the user's email address and whether it is verified. POST sets a new address and sends a verification link to it. Emails are only sent to verified addresses. Posting the current verified address only changes the locale.
+// @Tags email
+// @Accept json
+// @Produce json
+// @Param request body SetEmailRequest false "Address and email locale (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"email\": {\"email\": \"user@example.com\", \"locale\": \"en\", \"verified_at\": null}}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"invalid email address\"}"
+// @Security BearerAuth
+// @Router /api/email [get]
+// @Router /api/email [post]
+func EmailHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	current, err := db.GetUserEmail(db.Postgres, claims.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		current = nil
+	} else if err != nil {
+		server.WriteErrorJSON(w, "failed to load email", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req SetEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		req.Email = strings.TrimSpace(req.Email)
+		addr, err := mail.ParseAddress(req.Email)
+		if err != nil || addr.Address != req.Email || len(req.Email) > 254 {
+			server.WriteErrorJSON(w, "invalid email address", http.StatusBadRequest)
+			return
+		}
+		if req.Locale == "" {
+			req.Locale = config.AppConfig.EmailDefaultLocale
+			if current != nil {
+				req.Locale = current.Locale
+			}
+		}
+		if !mailer.HasLocale(req.Locale) {
+			server.WriteErrorJSON(w, "unsupported locale", http.StatusBadRequest)
+			return
+		}
+
+		if current != nil && current.Verified() && strings.EqualFold(current.Email, req.Email) {
+			err = db.SetUserEmailLocale(db.Postgres, claims.UserID, req.Locale)
+		} else {
+			err = sendEmailVerification(claims.UserID, req.Email, req.Locale, true)
+		}
+		if err != nil {
+			log.Println("[EmailHandler]", err)
+			server.WriteErrorJSON(w, "failed to save email", http.StatusInternalServerError)
+			return
+		}
+		if current, err = db.GetUserEmail(db.Postgres, claims.UserID); err != nil {
+			server.WriteErrorJSON(w, "failed to load email", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"email":   current,
+	})
+}
+
+// ResendEmailVerificationHandler godoc
+// @Summary Resend the verification email
+// @Description Sends a new verification link to the unverified address. Older links stop working.
+// @Tags email
+// @Produce json
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"email is already verified\"}"
+// @Failure 429 {object} map[string]string "Example: {\"error\": \"please wait before requesting another email\"}"
+// @Security BearerAuth
+// @Router /api/email/resend [post]
+func ResendEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	current, err := db.GetUserEmail(db.Postgres, claims.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "no email address set", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load email", http.StatusInternalServerError)
+		return
+	}
+	if current.Verified() {
+		server.WriteErrorJSON(w, "email is already verified", http.StatusBadRequest)
+		return
+	}
+	if current.TokenSentAt != nil && time.Since(*current.TokenSentAt) < resendInterval {
+		server.WriteErrorJSON(w, "please wait before requesting another email", http.StatusTooManyRequests)
+		return
+	}
+	if err := sendEmailVerification(claims.UserID, current.Email, current.Locale, false); err != nil {
+		log.Println("[ResendEmailVerificationHandler]", err)
+		server.WriteErrorJSON(w, "failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// DeleteEmailHandler godoc
+// @Summary Remove the email address
+// @Description Removes the address. No more emails are sent to the user.
+// @Tags email
+// @Produce json
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Security BearerAuth
+// @Router /api/email/delete [post]
+func DeleteEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	if err := db.DeleteUserEmail(db.Postgres, claims.UserID); err != nil {
+		server.WriteErrorJSON(w, "failed to remove email", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// VerifyEmailHandler godoc
+// @Summary Verify an email address
+// @Description Confirms the address the token was sent to. This is the link in the verification email, no authentication is needed.
+// @Tags email
+// @Produce json
+// @Param token query string true "Verification token"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"message\": \"Email address verified\"}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"invalid or expired token\"}"
+// @Failure 409 {object} map[string]string "Example: {\"error\": \"email address is already in use\"}"
+// @Router /verify_email [get]
+// @Router /verify_email [post]
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		server.WriteErrorJSON(w, "token is required", http.StatusBadRequest)
+		return
+	}
+	_, err := db.VerifyUserEmail(db.Postgres, hashEmailToken(token))
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		server.WriteErrorJSON(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	case errors.As(err, &pqErr) && pqErr.Code == "23505":
+		server.WriteErrorJSON(w, "email address is already in use", http.StatusConflict)
+		return
+	case err != nil:
+		server.WriteErrorJSON(w, "failed to verify email", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Email address verified",
+	})
+}
@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/notifications"
+	"mFrelance/server"
+)
+
+// Limits on the opaque values clients send for end-to-end encryption
+const (
+	maxPublicKeySize  = 4096
+	maxWrappedKeySize = 1024
+	maxNonceSize      = 64
+	maxAlgorithmLen   = 64
+	maxDisclosedKeys  = 500
+)
+
+type PublishKeyRequest struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+}
+
+type MessageKeyRequest struct {
+	UserID     int64  `json:"user_id"`
+	KeyID      int64  `json:"key_id"`
+	WrappedKey string `json:"wrapped_key"`
+}
+
+// EncryptedMessageRequest describes the ciphertext of an end-to-end
+// encrypted message: the cipher, its nonce and the content key wrapped
+// with the public key of each recipient
+type EncryptedMessageRequest struct {
+	Algorithm   string              `json:"algorithm"`
+	Nonce       string              `json:"nonce"`
+	SenderKeyID int64               `json:"sender_key_id"`
+	Keys        []MessageKeyRequest `json:"keys"`
+}
+
+type DisclosedKeyRequest struct {
+	MessageID  int64  `json:"message_id"`
+	WrappedKey string `json:"wrapped_key"`
+}
+
+type DiscloseMessagesRequest struct {
+	DisputeID int64                 `json:"dispute_id"`
+	Keys      []DisclosedKeyRequest `json:"keys"`
+}
+
+// checkBase64 decodes s and checks it is 1..max bytes long
+func checkBase64(name, s string, max int) error {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(raw) == 0 {
+		return fmt.Errorf("%s must be non-empty base64", name)
+	}
+	if len(raw) > max {
+		return fmt.Errorf("%s is too long", name)
+	}
+	return nil
+}
+
+func checkAlgorithm(algorithm string) error {
+	if algorithm == "" || len(algorithm) > maxAlgorithmLen || strings.ContainsAny(algorithm, " \t\r\n") {
+		return errors.New("invalid algorithm")
+	}
+	return nil
+}
+
+// encryptedMessageParams validates the encryption of a message the sender
+// posts to a room with the given participants. Every wrapped key must be
+// for a participant and one of their active keys.
+func encryptedMessageParams(senderID int64, participants []int64, req *EncryptedMessageRequest) (*models.MessageEncryption, []models.ChatMessageKey, error) {
+	if err := checkAlgorithm(req.Algorithm); err != nil {
+		return nil, nil, err
+	}
+	if err := checkBase64("nonce", req.Nonce, maxNonceSize); err != nil {
+		return nil, nil, err
+	}
+	if len(req.Keys) == 0 || len(req.Keys) > len(participants) {
+		return nil, nil, errors.New("keys must hold one entry per recipient")
+	}
+
+	member := make(map[int64]bool, len(participants))
+	for _, id := range participants {
+		member[id] = true
+	}
+	keyIDs := []int64{req.SenderKeyID}
+	seen := make(map[int64]bool, len(req.Keys))
+	for _, k := range req.Keys {
+		if !member[k.UserID] {
+			return nil, nil, fmt.Errorf("user %d is not a participant", k.UserID)
+		}
+		if seen[k.UserID] {
+			return nil, nil, fmt.Errorf("duplicate key for user %d", k.UserID)
+		}
+		seen[k.UserID] = true
+		if err := checkBase64("wrapped_key", k.WrappedKey, maxWrappedKeySize); err != nil {
+			return nil, nil, err
+		}
+		keyIDs = append(keyIDs, k.KeyID)
+	}
+
+	active, err := db.GetActivePublicKeys(db.Postgres, keyIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if k, ok := active[req.SenderKeyID]; !ok || k.UserID != senderID {
+		return nil, nil, errors.New("sender_key_id is not your active key")
+	}
+	keys := make([]models.ChatMessageKey, 0, len(req.Keys))
+	for _, k := range req.Keys {
+		if pk, ok := active[k.KeyID]; !ok || pk.UserID != k.UserID {
+			return nil, nil, fmt.Errorf("key %d is not the active key of user %d", k.KeyID, k.UserID)
+		}
+		keys = append(keys, models.ChatMessageKey{UserID: k.UserID, KeyID: k.KeyID, WrappedKey: k.WrappedKey})
+	}
+	return &models.MessageEncryption{
+		Algorithm:   req.Algorithm,
+		Nonce:       req.Nonce,
+		SenderKeyID: req.SenderKeyID,
+	}, keys, nil
+}
+
+// PublicKeysHandler godoc
+// @Summary List or publish encryption keys
+// @Description GET lists the user's public keys, newest first, including revoked ones. POST publishes a new public key for end-to-end encrypted messages and revokes the previous one. Revoked keys can't receive new messages.
+// @Tags encryption
+// @Accept json
+// @Produce json
+// @Param request body PublishKeyRequest false "Algorithm and base64 public key (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"key\": {\"id\": 3, \"algorithm\": \"x25519\", \"public_key\": \"q83v...\", \"fingerprint\": \"5e88...\"}}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"public_key must be non-empty base64\"}"
+// @Security BearerAuth
+// @Router /api/keys [get]
+// @Router /api/keys [post]
+func PublicKeysHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := db.ListPublicKeys(db.Postgres, claims.UserID)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load keys", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"keys":    keys,
+		})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PublishKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := checkAlgorithm(req.Algorithm); err != nil {
+		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkBase64("public_key", req.PublicKey, maxPublicKeySize); err != nil {
+		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	raw, _ := base64.StdEncoding.DecodeString(req.PublicKey)
+	sum := sha256.Sum256(raw)
+	key := &models.PublicKey{
+		UserID:      claims.UserID,
+		Algorithm:   req.Algorithm,
+		PublicKey:   req.PublicKey,
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+	if err := db.PublishPublicKey(db.Postgres, key); err != nil {
+		server.WriteErrorJSON(w, "failed to publish key", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"key":     key,
+	})
+}
+
+// RevokePublicKeyHandler godoc
+// @Summary Revoke the encryption key
+// @Description Revokes the user's active public key, for example when the private key was lost. Others can no longer send the user encrypted messages until a new key is published.
+// @Tags encryption
+// @Produce json
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"no active key\"}"
+// @Security BearerAuth
+// @Router /api/keys/revoke [post]
+func RevokePublicKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	revoked, err := db.RevokePublicKey(db.Postgres, claims.UserID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to revoke key", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		server.WriteErrorJSON(w, "no active key", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// UserPublicKeyHandler godoc
+// @Summary Get a user's encryption key
+// @Description Returns the active public key of a user, used to wrap message keys for them. Compare the fingerprint out of band to rule out a substituted key.
+// @Tags encryption
+// @Produce json
+// @Param user_id query int true "User ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"key\": {\"id\": 3, \"user_id\": 7, \"algorithm\": \"x25519\", \"public_key\": \"q83v...\", \"fingerprint\": \"5e88...\"}}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"user has no active key\"}"
+// @Security BearerAuth
+// @Router /api/keys/user [get]
+func UserPublicKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil || userID <= 0 {
+		server.WriteErrorJSON(w, "invalid user_id", http.StatusBadRequest)
+		return
+	}
+	key, err := db.GetActivePublicKey(db.Postgres, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "user has no active key", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load key", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"key":     key,
+	})
+}
+
+// disputeParties returns the client and the freelancer of a disputed task
+func disputeParties(dispute *models.Dispute) (clientID, freelancerID int64, err error) {
+	task, err := db.GetTask(db.Postgres, dispute.TaskID)
+	if err != nil {
+		return 0, 0, err
+	}
+	offers, err := db.GetTaskOffersByTaskID(db.Postgres, dispute.TaskID)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, offer := range offers {
+		if offer.Status == models.OfferAccepted {
+			return task.ClientID, offer.FreelancerID, nil
+		}
+	}
+	return task.ClientID, 0, nil
+}
+
+// DiscloseMessagesHandler godoc
+// @Summary Disclose encrypted messages to the arbiter
+// @Description Lets a party of a dispute share end-to-end encrypted messages with the assigned arbiter. The client decrypts the content key of each message and wraps it again with the arbiter's active public key. Only messages of rooms the user takes part in, that the user could read, can be disclosed.
+// @Tags disputes
+// @Accept json
+// @Produce json
+// @Param request body DiscloseMessagesRequest true "Dispute and the re-wrapped content keys"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"disclosed\": 12, \"key_id\": 9}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"the arbiter has no active key\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"only the parties of the dispute can disclose messages\"}"
+// @Security BearerAuth
+// @Router /api/disputes/disclose [post]
+func DiscloseMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var req DiscloseMessagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if len(req.Keys) == 0 || len(req.Keys) > maxDisclosedKeys {
+		server.WriteErrorJSON(w, fmt.Sprintf("keys must hold 1 to %d entries", maxDisclosedKeys), http.StatusBadRequest)
+		return
+	}
+
+	dispute, err := db.GetDisputeByID(req.DisputeID)
+	if err != nil {
+		server.WriteErrorJSON(w, "dispute not found", http.StatusNotFound)
+		return
+	}
+	clientID, freelancerID, err := disputeParties(dispute)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load dispute", http.StatusInternalServerError)
+		return
+	}
+	if claims.UserID != clientID && claims.UserID != freelancerID {
+		server.WriteErrorJSON(w, "only the parties of the dispute can disclose messages", http.StatusForbidden)
+		return
+	}
+	if dispute.Status == "resolved" {
+		server.WriteErrorJSON(w, "dispute is resolved", http.StatusBadRequest)
+		return
+	}
+	if dispute.AssignedAdmin == nil {
+		server.WriteErrorJSON(w, "no arbiter is assigned yet", http.StatusBadRequest)
+		return
+	}
+	arbiterKey, err := db.GetActivePublicKey(db.Postgres, *dispute.AssignedAdmin)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "the arbiter has no active key", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load key", http.StatusInternalServerError)
+		return
+	}
+
+	keys := make([]models.ChatMessageKey, 0, len(req.Keys))
+	rooms := make(map[int64]bool)
+	for _, k := range req.Keys {
+		if err := checkBase64("wrapped_key", k.WrappedKey, maxWrappedKeySize); err != nil {
+			server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m, err := db.GetChatMessage(db.Postgres, k.MessageID)
+		if err != nil || m.DeletedAt != nil || m.Kind != models.MessageKindEncrypted {
+			server.WriteErrorJSON(w, fmt.Sprintf("message %d is not an encrypted message", k.MessageID), http.StatusBadRequest)
+			return
+		}
+		allowed, checked := rooms[m.ChatRoomID]
+		if !checked {
+			_, err := chatParticipants(claims.UserID, m.ChatRoomID)
+			allowed = err == nil
+			rooms[m.ChatRoomID] = allowed
+		}
+		readable := false
+		for _, mk := range m.Keys {
+			readable = readable || mk.UserID == claims.UserID
+		}
+		if !allowed || !readable {
+			server.WriteErrorJSON(w, fmt.Sprintf("you cannot disclose message %d", k.MessageID), http.StatusForbidden)
+			return
+		}
+		keys = append(keys, models.ChatMessageKey{
+			MessageID:  k.MessageID,
+			UserID:     arbiterKey.UserID,
+			KeyID:      arbiterKey.ID,
+			WrappedKey: k.WrappedKey,
+		})
+	}
+
+	n, err := db.DiscloseMessageKeys(db.Postgres, dispute.ID, claims.UserID, keys)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to disclose messages", http.StatusInternalServerError)
+		return
+	}
+	notifications.Publish(notifications.Event{
+		Type:  notifications.TypeDisputeMessage,
+		Title: "Messages disclosed",
+		Body:  fmt.Sprintf("%d encrypted messages were disclosed for dispute #%d", n, dispute.ID),
+		Data: map[string]interface{}{
+			"dispute_id":   dispute.ID,
+			"disclosed_by": claims.UserID,
+			"disclosed":    n,
+		},
+	}, arbiterKey.UserID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"disclosed": n,
+		"key_id":    arbiterKey.ID,
+	})
+}
+
+// DisclosedMessagesHandler godoc
+// @Summary Read disclosed messages
+// @Description Returns the encrypted messages the parties disclosed for a dispute, each with the content key wrapped for the arbiter. Only the assigned arbiter can read them.
+// @Tags disputes
+// @Produce json
+// @Param dispute_id query int true "Dispute ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"messages\": [{\"id\": 120, \"chat_room_id\": 7, \"kind\": \"encrypted\", \"message\": \"ZW5j...\", \"keys\": [{\"user_id\": 2, \"key_id\": 9, \"wrapped_key\": \"d3Jh...\", \"disclosed_by\": 5}]}]}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"dispute is not assigned to you\"}"
+// @Security BearerAuth
+// @Router /api/disputes/disclosures [get]
+func DisclosedMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	disputeID, err := strconv.ParseInt(r.URL.Query().Get("dispute_id"), 10, 64)
+	if err != nil || disputeID <= 0 {
+		server.WriteErrorJSON(w, "invalid dispute_id", http.StatusBadRequest)
+		return
+	}
+	dispute, err := db.GetDisputeByID(disputeID)
+	if err != nil {
+		server.WriteErrorJSON(w, "dispute not found", http.StatusNotFound)
+		return
+	}
+	if dispute.AssignedAdmin == nil || *dispute.AssignedAdmin != claims.UserID {
+		server.WriteErrorJSON(w, "dispute is not assigned to you", http.StatusForbidden)
+		return
+	}
+	messages, err := db.GetDisclosedMessages(db.Postgres, dispute.ID, claims.UserID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load messages", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"messages": messages,
+	})
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"mFrelance/config"
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/server"
+)
+
+// inlineTypes are served inline, everything else is downloaded
+var inlineTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+var errFileTooLarge = errors.New("file too large")
+
+func filePath(key string) string {
+	return filepath.Join(config.AppConfig.FilesDir, key[:2], key)
+}
+
+func cleanFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+	return name
+}
+
+// storeFile copies src to the file store and returns the stored file
+// without an ID. It fails with errFileTooLarge above max bytes.
+func storeFile(ownerID int64, name string, src io.Reader, max int64) (*models.File, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	key := hex.EncodeToString(b)
+	path := filePath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		out.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	head = head[:n]
+	size, err := io.Copy(io.MultiWriter(out, h), io.LimitReader(io.MultiReader(bytes.NewReader(head), src), max+1))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && size > max {
+		err = errFileTooLarge
+	}
+	if err == nil && size == 0 {
+		err = errors.New("file is empty")
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	contentType := http.DetectContentType(head)
+	if byExt := mime.TypeByExtension(filepath.Ext(name)); byExt != "" && strings.HasPrefix(contentType, "text/plain") {
+		contentType = byExt
+	}
+	return &models.File{
+		OwnerID:     ownerID,
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(h.Sum(nil)),
+		StorageKey:  key,
+	}, nil
+}
+
+// UploadFileHandler godoc
+// @Summary Upload a file
+// @Description Stores a file sent as the "file" field of a multipart form. The returned ID can be attached to chat messages.
+// @Tags files
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "File"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"file\": {\"id\": 5, \"name\": \"spec.pdf\", \"content_type\": \"application/pdf\", \"size\": 48213}}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"file too large\"}"
+// @Security BearerAuth
+// @Router /api/files/upload [post]
+func UploadFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	max := config.AppConfig.FilesMaxSize
+	r.Body = http.MaxBytesReader(w, r.Body, max+1<<20)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		server.WriteErrorJSON(w, "multipart form expected", http.StatusBadRequest)
+		return
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			server.WriteErrorJSON(w, "file field is required", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			server.WriteErrorJSON(w, "invalid multipart form", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		f, err := storeFile(claims.UserID, cleanFileName(part.FileName()), part, max)
+		part.Close()
+		if err == errFileTooLarge {
+			server.WriteErrorJSON(w, "file too large", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println("[UploadFileHandler]", err)
+			server.WriteErrorJSON(w, "failed to store file", http.StatusBadRequest)
+			return
+		}
+		if err := db.CreateFile(db.Postgres, f); err != nil {
+			os.Remove(filePath(f.StorageKey))
+			server.WriteErrorJSON(w, "failed to store file", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"file":    f,
+		})
+		return
+	}
+}
+
+// DownloadFileHandler godoc
+// @Summary Download a file
+// @Description Returns the file content. Available to the uploader and to the participants of chat rooms where the file is attached.
+// @Tags files
+// @Produce octet-stream
+// @Param id query int true "File ID"
+// @Success 200 {file} file "File content"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"file not found\"}"
+// @Security BearerAuth
+// @Router /api/files/download [get]
+func DownloadFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		server.WriteErrorJSON(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	ok, err := db.CanAccessFile(db.Postgres, claims.UserID, id)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load file", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		server.WriteErrorJSON(w, "file not found", http.StatusNotFound)
+		return
+	}
+	f, err := db.GetFile(db.Postgres, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "file not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load file", http.StatusInternalServerError)
+		return
+	}
+	content, err := os.Open(filePath(f.StorageKey))
+	if err != nil {
+		log.Printf("[DownloadFileHandler] file %d: %v", f.ID, err)
+		server.WriteErrorJSON(w, "file not found", http.StatusNotFound)
+		return
+	}
+	defer content.Close()
+
+	disposition := "attachment"
+	if inlineTypes[f.ContentType] {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", f.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": f.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src 'self'; sandbox")
+	http.ServeContent(w, r, "", f.CreatedAt, content)
+}
@@ -0,0 +1,505 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/notifications"
+	"mFrelance/server"
+)
+
+// Moderation actions on a report
+const (
+	ReportActionDismiss       = "dismiss"
+	ReportActionWarn          = "warn"
+	ReportActionDeleteContent = "delete_content"
+	ReportActionBan           = "ban"
+)
+
+// maxReportDetails limits the free text of a report
+const maxReportDetails = 2000
+
+type BlockUserRequest struct {
+	UserID int64 `json:"user_id"`
+}
+
+type CreateReportRequest struct {
+	TargetType string `json:"target_type"`
+	TargetID   int64  `json:"target_id"`
+	Category   string `json:"category"`
+	Details    string `json:"details"`
+}
+
+type ResolveReportRequest struct {
+	ReportID int64  `json:"report_id"`
+	Action   string `json:"action"`
+	Note     string `json:"note"`
+}
+
+// isBlockedFor reports whether a block stands between userID and others
+func isBlockedFor(userID int64, others ...int64) (bool, error) {
+	others = withoutUser(others, userID)
+	if len(others) == 0 {
+		return false, nil
+	}
+	return db.IsBlockedBetween(db.Postgres, userID, others...)
+}
+
+func withoutUser(ids []int64, userID int64) []int64 {
+	out := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if id != userID {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func pageParams(r *http.Request) (limit, offset int) {
+	q := r.URL.Query()
+	limit = 50
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 200 {
+		limit = l
+	}
+	if o, err := strconv.Atoi(q.Get("offset")); err == nil && o >= 0 {
+		offset = o
+	}
+	return limit, offset
+}
+
+// UserBlocksHandler godoc
+// @Summary Get or extend the block list
+// @Description GET returns the users the current user blocked. POST blocks a user: neither side can send the other chat requests, messages in direct and offer chats, or offers. Task chats of running contracts are not affected.
+// @Tags moderation
+// @Accept json
+// @Produce json
+// @Param request body BlockUserRequest false "User to block (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"blocks\": [{\"blocked_id\": 7, \"username\": \"spammer\", \"created_at\": \"2025-01-01T12:00:00Z\"}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"cannot block yourself\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"user not found\"}"
+// @Security BearerAuth
+// @Router /api/blocks [get]
+// @Router /api/blocks [post]
+func UserBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req BlockUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if req.UserID == claims.UserID {
+			server.WriteErrorJSON(w, "cannot block yourself", http.StatusBadRequest)
+			return
+		}
+		if name, err := db.GetUsernameByID(db.Postgres, req.UserID); err != nil {
+			server.WriteErrorJSON(w, "failed to load user", http.StatusInternalServerError)
+			return
+		} else if name == "" {
+			server.WriteErrorJSON(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if err := db.AddUserBlock(db.Postgres, claims.UserID, req.UserID); err != nil {
+			server.WriteErrorJSON(w, "failed to block user", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list, err := db.ListUserBlocks(db.Postgres, claims.UserID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load block list", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"blocks":  list,
+	})
+}
+
+// RemoveUserBlockHandler godoc
+// @Summary Unblock a user
+// @Description Removes a user from the current user's block list
+// @Tags moderation
+// @Accept json
+// @Produce json
+// @Param request body BlockUserRequest true "User to unblock"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"user is not blocked\"}"
+// @Security BearerAuth
+// @Router /api/blocks/delete [post]
+func RemoveUserBlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var req BlockUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	removed, err := db.RemoveUserBlock(db.Postgres, claims.UserID, req.UserID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to unblock user", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		server.WriteErrorJSON(w, "user is not blocked", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// reportTarget checks that the reporter may report the target and returns
+// its author and a copy of the content
+func reportTarget(reporterID int64, targetType string, targetID int64) (authorID int64, snapshot string, err error) {
+	switch targetType {
+	case db.ReportTargetMessage:
+		m, err := db.GetChatMessage(db.Postgres, targetID)
+		if err != nil {
+			return 0, "", errReportTargetNotFound
+		}
+		if _, err := chatParticipants(reporterID, m.ChatRoomID); err != nil {
+			return 0, "", errReportTargetNotFound
+		}
+		return m.SenderID, m.Message, nil
+	case db.ReportTargetUser:
+		name, err := db.GetUsernameByID(db.Postgres, targetID)
+		if err != nil {
+			return 0, "", err
+		}
+		if name == "" {
+			return 0, "", errReportTargetNotFound
+		}
+		return targetID, name, nil
+	case db.ReportTargetTask:
+		task, err := db.GetTask(db.Postgres, targetID)
+		if err != nil {
+			return 0, "", errReportTargetNotFound
+		}
+		return task.ClientID, task.Title + "\n\n" + task.Description, nil
+	}
+	return 0, "", errors.New("invalid target_type")
+}
+
+var errReportTargetNotFound = errors.New("reported object not found")
+
+func isReportCategory(c string) bool {
+	for _, v := range db.ReportCategories {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportsHandler godoc
+// @Summary File or list reports
+// @Description POST reports a chat message, a user or a task to the moderators. Messages can only be reported by participants of their room. GET lists the reports the current user filed.
+// @Tags moderation
+// @Accept json
+// @Produce json
+// @Param request body CreateReportRequest false "Report (POST). target_type is message, user or task; category is spam, scam, harassment, inappropriate or other"
+// @Param limit query int false "Page size, default 50, max 200 (GET)"
+// @Param offset query int false "Offset (GET)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"report\": {\"id\": 4, \"target_type\": \"message\", \"target_id\": 120, \"category\": \"scam\", \"status\": \"open\"}}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"invalid category\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"reported object not found\"}"
+// @Failure 409 {object} map[string]string "Example: {\"error\": \"you already reported this\"}"
+// @Security BearerAuth
+// @Router /api/reports [get]
+// @Router /api/reports [post]
+func ReportsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		limit, offset := pageParams(r)
+		list, err := db.ListReportsByReporter(db.Postgres, claims.UserID, limit, offset)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load reports", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"reports": list,
+		})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	req.Details = strings.TrimSpace(req.Details)
+	if !isReportCategory(req.Category) {
+		server.WriteErrorJSON(w, "invalid category", http.StatusBadRequest)
+		return
+	}
+	if len(req.Details) > maxReportDetails {
+		server.WriteErrorJSON(w, fmt.Sprintf("details must be at most %d characters", maxReportDetails), http.StatusBadRequest)
+		return
+	}
+	authorID, snapshot, err := reportTarget(claims.UserID, req.TargetType, req.TargetID)
+	if errors.Is(err, errReportTargetNotFound) {
+		server.WriteErrorJSON(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if authorID == claims.UserID {
+		server.WriteErrorJSON(w, "cannot report yourself", http.StatusBadRequest)
+		return
+	}
+
+	reporterID := claims.UserID
+	report := &models.Report{
+		ReporterID:   &reporterID,
+		TargetType:   req.TargetType,
+		TargetID:     req.TargetID,
+		TargetUserID: &authorID,
+		Category:     req.Category,
+		Details:      req.Details,
+		Snapshot:     snapshot,
+	}
+	err = db.CreateReport(db.Postgres, report)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		server.WriteErrorJSON(w, "you already reported this", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to save report", http.StatusInternalServerError)
+		return
+	}
+	report.Snapshot = ""
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"report":  report,
+	})
+}
+
+// AdminReportsHandler godoc
+// @Summary Moderation queue
+// @Description Lists user reports with a copy of the reported content. Open reports come oldest first, target_reports counts the open reports on the same object.
+// @Tags moderation
+// @Produce json
+// @Param status query string false "open (default), dismissed, actioned or all"
+// @Param target_type query string false "message, user or task"
+// @Param limit query int false "Page size, default 50, max 200"
+// @Param offset query int false "Offset"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"reports\": [{\"id\": 4, \"target_type\": \"message\", \"target_id\": 120, \"target_user_id\": 7, \"category\": \"scam\", \"snapshot\": \"send 1 XMR to...\", \"status\": \"open\", \"target_reports\": 3}]}"
+// @Failure 403 {string} string "Example: \"insufficient permissions\""
+// @Security BearerAuth
+// @Router /api/admin/reports [get]
+func AdminReportsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f := models.ReportFilter{
+		Status:     r.URL.Query().Get("status"),
+		TargetType: r.URL.Query().Get("target_type"),
+	}
+	switch f.Status {
+	case "":
+		f.Status = db.ReportOpen
+	case "all":
+		f.Status = ""
+	}
+	f.Limit, f.Offset = pageParams(r)
+	list, err := db.ListReports(db.Postgres, f)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load reports", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"reports": list,
+	})
+}
+
+// ResolveReportHandler godoc
+// @Summary Act on a report
+// @Description Closes a report and every other open report on the same object. dismiss takes no action, warn sends the author a warning, delete_content deletes the message or the open task (needs chat.manage or task.moderate), ban blocks the author's account (needs user.block). The action is written to the audit log.
+// @Tags moderation
+// @Accept json
+// @Produce json
+// @Param request body ResolveReportRequest true "Report, action and a note for the audit log"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"resolved\": [4, 5]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"report is already closed\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"insufficient permissions\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"report not found\"}"
+// @Security BearerAuth
+// @Router /api/admin/reports/resolve [post]
+func ResolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var req ResolveReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	report, err := db.GetReport(db.Postgres, req.ReportID)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "report not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load report", http.StatusInternalServerError)
+		return
+	}
+	if report.Status != db.ReportOpen {
+		server.WriteErrorJSON(w, "report is already closed", http.StatusBadRequest)
+		return
+	}
+
+	status := db.ReportActioned
+	var perm string
+	switch req.Action {
+	case ReportActionDismiss:
+		status = db.ReportDismissed
+	case ReportActionWarn:
+	case ReportActionDeleteContent:
+		switch report.TargetType {
+		case db.ReportTargetMessage:
+			perm = server.PermChatManage
+		case db.ReportTargetTask:
+			perm = server.PermTaskModerate
+		default:
+			server.WriteErrorJSON(w, "a user has no content to delete", http.StatusBadRequest)
+			return
+		}
+	case ReportActionBan:
+		perm = server.PermUserBlock
+	default:
+		server.WriteErrorJSON(w, "invalid action", http.StatusBadRequest)
+		return
+	}
+	if perm != "" && !server.HasPermission(claims, perm) {
+		server.WriteErrorJSON(w, "insufficient permissions", http.StatusForbidden)
+		return
+	}
+	if req.Action != ReportActionDismiss && report.TargetUserID == nil {
+		server.WriteErrorJSON(w, "the author no longer exists", http.StatusBadRequest)
+		return
+	}
+
+	var resolved []int64
+	e := newAuditEntry(r, db.AuditReportResolve, "report", report.ID, req.Note)
+	err = runAudited(e, func(tx *sqlx.Tx) (interface{}, interface{}, error) {
+		switch req.Action {
+		case ReportActionDeleteContent:
+			var err error
+			if report.TargetType == db.ReportTargetMessage {
+				err = db.DeleteChatMessageTx(tx, report.TargetID, claims.UserID)
+			} else {
+				err = db.DeleteOpenTaskTx(tx, report.TargetID)
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, nil, errors.New("the content is already deleted or the task has a contract")
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		case ReportActionBan:
+			if _, err := db.SetUserBlockedTx(tx, *report.TargetUserID, true); err != nil {
+				return nil, nil, err
+			}
+		}
+		var err error
+		resolved, err = db.ResolveReportsTx(tx, report.ID, status, req.Action, req.Note, claims.UserID)
+		if err != nil {
+			return nil, nil, err
+		}
+		before := map[string]interface{}{
+			"status":         report.Status,
+			"target_type":    report.TargetType,
+			"target_id":      report.TargetID,
+			"target_user_id": report.TargetUserID,
+			"snapshot":       report.Snapshot,
+		}
+		return before, map[string]interface{}{"status": status, "action": req.Action, "reports": resolved}, nil
+	})
+	if err != nil {
+		log.Println("[ResolveReportHandler]", err)
+		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch req.Action {
+	case ReportActionWarn:
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeModerationWarning,
+			Title: "Warning from the moderators",
+			Body:  fmt.Sprintf("Your %s was reported and reviewed by a moderator. Repeated violations lead to a ban.", report.TargetType),
+			Data: map[string]interface{}{
+				"target_type": report.TargetType,
+				"target_id":   report.TargetID,
+				"category":    report.Category,
+				"note":        req.Note,
+			},
+		}, *report.TargetUserID)
+	case ReportActionDeleteContent:
+		if report.TargetType == db.ReportTargetMessage {
+			if m, err := db.GetChatMessage(db.Postgres, report.TargetID); err == nil {
+				if participants, err := db.GetChatParticipantIDs(db.Postgres, m.ChatRoomID); err == nil {
+					publishMessageDeleted(m, claims.UserID, participants)
+				}
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"resolved": resolved,
+	})
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/notifications"
+	"mFrelance/server"
+)
+
+type MarkNotificationsReadRequest struct {
+	IDs []int64 `json:"ids"`
+	All bool    `json:"all"`
+}
+
+// NotificationPreferenceUpdate changes the channels of one type, omitted
+// channels keep their setting
+type NotificationPreferenceUpdate struct {
+	Type  string `json:"type"`
+	InApp *bool  `json:"in_app"`
+	Email *bool  `json:"email"`
+}
+
+type NotificationPreferencesRequest struct {
+	Preferences []NotificationPreferenceUpdate `json:"preferences"`
+}
+
+// NotificationsHandler godoc
+// @Summary List notifications
+// @Description Returns the user's notifications, newest first, together with the unread count
+// @Tags notifications
+// @Produce json
+// @Param unread query bool false "Only unread notifications"
+// @Param limit query int false "Page size, default 50, max 200"
+// @Param offset query int false "Offset"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"notifications\": [], \"unread_count\": 0}"
+// @Failure 401 {string} string "Unauthorized"
+// @Security BearerAuth
+// @Router /api/notifications [get]
+func NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	q := r.URL.Query()
+	limit, offset := 50, 0
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 200 {
+		limit = l
+	}
+	if o, err := strconv.Atoi(q.Get("offset")); err == nil && o >= 0 {
+		offset = o
+	}
+	unreadOnly, _ := strconv.ParseBool(q.Get("unread"))
+
+	list, err := db.ListNotifications(db.Postgres, claims.UserID, unreadOnly, limit, offset)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load notifications", http.StatusInternalServerError)
+		return
+	}
+	unread, err := db.CountUnreadNotifications(db.Postgres, claims.UserID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load notifications", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":       true,
+		"notifications": list,
+		"unread_count":  unread,
+	})
+}
+
+// NotificationsUnreadCountHandler godoc
+// @Summary Unread notification count
+// @Tags notifications
+// @Produce json
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"unread_count\": 3}"
+// @Failure 401 {string} string "Unauthorized"
+// @Security BearerAuth
+// @Router /api/notifications/unread_count [get]
+func NotificationsUnreadCountHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	unread, err := db.CountUnreadNotifications(db.Postgres, claims.UserID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to count notifications", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"unread_count": unread,
+	})
+}
+
+// MarkNotificationsReadHandler godoc
+// @Summary Mark notifications as read
+// @Description Marks the listed notifications, or all of them with "all": true, as read
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Param request body MarkNotificationsReadRequest true "Notification IDs or all"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"updated\": 2, \"unread_count\": 0}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"ids or all is required\"}"
+// @Security BearerAuth
+// @Router /api/notifications/read [post]
+func MarkNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var req MarkNotificationsReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if !req.All && len(req.IDs) == 0 {
+		server.WriteErrorJSON(w, "ids or all is required", http.StatusBadRequest)
+		return
+	}
+	ids := req.IDs
+	if req.All {
+		ids = nil
+	}
+	updated, err := db.MarkNotificationsRead(db.Postgres, claims.UserID, ids)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to update notifications", http.StatusInternalServerError)
+		return
+	}
+	unread, err := db.CountUnreadNotifications(db.Postgres, claims.UserID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to count notifications", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"updated":      updated,
+		"unread_count": unread,
+	})
+}
+
+// NotificationPreferencesHandler godoc
+// @Summary Notification preferences
+// @Description GET returns the in-app and email setting of every event type. POST changes the listed types and channels, everything else is left as it is.
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Param request body NotificationPreferencesRequest false "Preferences to change (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"preferences\": [{\"type\": \"offer.created\", \"in_app\": true, \"email\": true}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"unknown notification type\"}"
+// @Security BearerAuth
+// @Router /api/notifications/preferences [get]
+// @Router /api/notifications/preferences [post]
+func NotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req NotificationPreferencesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		for _, p := range req.Preferences {
+			if !notifications.IsKnownType(p.Type) {
+				server.WriteErrorJSON(w, "unknown notification type: "+p.Type, http.StatusBadRequest)
+				return
+			}
+		}
+		for _, p := range req.Preferences {
+			if err := db.SetNotificationPreference(db.Postgres, claims.UserID, p.Type, p.InApp, p.Email); err != nil {
+				server.WriteErrorJSON(w, "failed to save preferences", http.StatusInternalServerError)
+				return
+			}
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stored, err := db.GetNotificationPreferences(db.Postgres, claims.UserID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load preferences", http.StatusInternalServerError)
+		return
+	}
+	prefs := make([]models.NotificationPreference, 0, len(notifications.Types))
+	for _, t := range notifications.Types {
+		p, ok := stored[t]
+		if !ok {
+			p = models.NotificationPreference{Type: t, InApp: true, Email: true}
+		}
+		prefs = append(prefs, p)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"preferences": prefs,
+	})
+}
@@ -0,0 +1,782 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/notifications"
+	"mFrelance/server"
+)
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+type OrgMemberRequest struct {
+	OrgID  int64  `json:"org_id"`
+	UserID int64  `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+type JoinOrganizationRequest struct {
+	OrgID int64 `json:"org_id"`
+}
+
+type OrgSpendingLimitRequest struct {
+	OrgID    int64  `json:"org_id"`
+	UserID   int64  `json:"user_id"`
+	Currency string `json:"currency"`
+	// MonthlyLimit is null to lift the limit
+	MonthlyLimit *float64 `json:"monthly_limit"`
+}
+
+type OrgTransferRequest struct {
+	OrgID    int64   `json:"org_id"`
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+}
+
+// orgCurrencies are the currencies organization wallets are opened in
+var orgCurrencies = []string{"BTC", "XMR"}
+
+// activeOrgMember returns the user's membership, or writes a 404 and returns
+// nil when they aren't an active member: organizations are private to their
+// members
+func activeOrgMember(w http.ResponseWriter, orgID, userID int64) *models.OrgMember {
+	m, err := db.GetOrgMember(db.Postgres, orgID, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "failed to load organization", http.StatusInternalServerError)
+		return nil
+	}
+	if m == nil || !m.Active() {
+		server.WriteErrorJSON(w, "organization not found", http.StatusNotFound)
+		return nil
+	}
+	return m
+}
+
+// canManageMember reports whether a member may invite, remove or limit
+// members of role: owners manage everyone, managers manage members
+func canManageMember(actor *models.OrgMember, role string) bool {
+	switch actor.Role {
+	case models.OrgRoleOwner:
+		return true
+	case models.OrgRoleManager:
+		return role == models.OrgRoleMember
+	}
+	return false
+}
+
+// checkOrgSpending returns why userID can't move amount into escrow from
+// the organization wallet, or "" when they can. The check holds until tx
+// ends, debit the wallet in it.
+func checkOrgSpending(tx *sqlx.Tx, orgID, userID int64, currency string, amount float64) (string, error) {
+	limit, err := db.CheckOrgSpendingTx(tx, orgID, userID, currency, amount)
+	switch {
+	case errors.Is(err, db.ErrNotOrgMember):
+		return "You are no longer a member of the organization this task was posted for", nil
+	case errors.Is(err, db.ErrSpendingLimit):
+		return fmt.Sprintf("This exceeds your monthly spending limit of %v %s (%v %s spent)", limit.MonthlyLimit, currency, limit.Spent, currency), nil
+	case err != nil:
+		return "", err
+	}
+	return "", nil
+}
+
+// checkOrgAssignee returns why callerID can't make an agency offer for
+// assigneeID, or "" when they can
+func checkOrgAssignee(orgID, callerID, assigneeID int64) (string, error) {
+	caller, err := db.GetOrgMember(db.Postgres, orgID, callerID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	if caller == nil || !caller.Active() {
+		return "You are not a member of this organization", nil
+	}
+	if assigneeID == callerID {
+		return "", nil
+	}
+	if models.OrgRoleRank(caller.Role) < models.OrgRoleRank(models.OrgRoleManager) {
+		return "Only owners and managers can make offers for other members", nil
+	}
+	assignee, err := db.GetOrgMember(db.Postgres, orgID, assigneeID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	if assignee == nil || !assignee.Active() {
+		return "The freelancer is not a member of this organization", nil
+	}
+	return "", nil
+}
+
+// canActForOffer reports whether userID may revise or withdraw offer: the
+// freelancer it is for, or an active owner or manager of the agency that
+// made it
+func canActForOffer(offer *models.TaskOffer, userID int64) (bool, error) {
+	if offer.FreelancerID == userID {
+		return true, nil
+	}
+	if offer.OrgID == nil {
+		return false, nil
+	}
+	m, err := db.GetOrgMember(db.Postgres, *offer.OrgID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return m.Active() && models.OrgRoleRank(m.Role) >= models.OrgRoleRank(models.OrgRoleManager), nil
+}
+
+// OrganizationsHandler godoc
+// @Summary List or create organizations
+// @Description GET returns the organizations the current user belongs to or is invited to, with their role; joined_at is null while the invitation is pending. POST creates an organization owned by the current user, with an empty wallet per currency.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Param request body CreateOrganizationRequest false "Organization (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"organizations\": [{\"id\": 3, \"name\": \"Acme Studio\", \"created_at\": \"2025-01-01T12:00:00Z\", \"role\": \"owner\", \"joined_at\": \"2025-01-01T12:00:00Z\"}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"name must be 2 to 100 characters\"}"
+// @Failure 409 {object} map[string]string "Example: {\"error\": \"name is taken\"}"
+// @Security BearerAuth
+// @Router /api/orgs [get]
+// @Router /api/orgs [post]
+func OrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req CreateOrganizationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+		if n := utf8.RuneCountInString(name); n < 2 || n > 100 {
+			server.WriteErrorJSON(w, "name must be 2 to 100 characters", http.StatusBadRequest)
+			return
+		}
+		if blocked, err := db.IsUserBlocked(db.Postgres, claims.UserID); err != nil {
+			server.WriteErrorJSON(w, "failed to check user status", http.StatusInternalServerError)
+			return
+		} else if blocked {
+			server.WriteErrorJSON(w, "user is blocked", http.StatusForbidden)
+			return
+		}
+		if exists, err := db.OrganizationNameExists(db.Postgres, name); err != nil {
+			server.WriteErrorJSON(w, "failed to check name", http.StatusInternalServerError)
+			return
+		} else if exists {
+			server.WriteErrorJSON(w, "name is taken", http.StatusConflict)
+			return
+		}
+		org := &models.Organization{Name: name}
+		if err := db.CreateOrganization(db.Postgres, org, claims.UserID, orgCurrencies); err != nil {
+			server.WriteErrorJSON(w, "failed to create organization", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	orgs, err := db.ListUserOrganizations(db.Postgres, claims.UserID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load organizations", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":       true,
+		"organizations": orgs,
+	})
+}
+
+// OrgMembersHandler godoc
+// @Summary List or invite organization members
+// @Description GET returns the members of an organization, pending invitations included. POST invites a user with a role (default member); the user gets an org.invited notification and joins through /api/orgs/join. Owners invite any role, managers invite members. Active members only.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Param org_id query int false "Organization ID (GET)"
+// @Param request body OrgMemberRequest false "Invitation (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"members\": [{\"org_id\": 3, \"user_id\": 7, \"username\": \"alice\", \"role\": \"owner\", \"invited_by\": null, \"created_at\": \"2025-01-01T12:00:00Z\", \"joined_at\": \"2025-01-01T12:00:00Z\"}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"user is a member or invited already\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"you cannot invite members with this role\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"organization not found\"}"
+// @Security BearerAuth
+// @Router /api/orgs/members [get]
+// @Router /api/orgs/members [post]
+func OrgMembersHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req OrgMemberRequest
+	switch r.Method {
+	case http.MethodGet:
+		id, err := strconv.ParseInt(r.URL.Query().Get("org_id"), 10, 64)
+		if err != nil {
+			server.WriteErrorJSON(w, "invalid org_id", http.StatusBadRequest)
+			return
+		}
+		req.OrgID = id
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	actor := activeOrgMember(w, req.OrgID, claims.UserID)
+	if actor == nil {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if req.Role == "" {
+			req.Role = models.OrgRoleMember
+		}
+		if !models.IsOrgRole(req.Role) {
+			server.WriteErrorJSON(w, "role must be owner, manager or member", http.StatusBadRequest)
+			return
+		}
+		if !canManageMember(actor, req.Role) {
+			server.WriteErrorJSON(w, "you cannot invite members with this role", http.StatusForbidden)
+			return
+		}
+		if name, err := db.GetUsernameByID(db.Postgres, req.UserID); err != nil {
+			server.WriteErrorJSON(w, "failed to load user", http.StatusInternalServerError)
+			return
+		} else if name == "" {
+			server.WriteErrorJSON(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if blocked, err := db.IsUserBlocked(db.Postgres, req.UserID); err != nil {
+			server.WriteErrorJSON(w, "failed to check user status", http.StatusInternalServerError)
+			return
+		} else if blocked {
+			server.WriteErrorJSON(w, "user is blocked", http.StatusBadRequest)
+			return
+		}
+		if blocked, err := isBlockedFor(claims.UserID, req.UserID); err != nil {
+			server.WriteErrorJSON(w, "failed to check user status", http.StatusInternalServerError)
+			return
+		} else if blocked {
+			server.WriteErrorJSON(w, "you cannot invite this user", http.StatusForbidden)
+			return
+		}
+
+		member := &models.OrgMember{OrgID: req.OrgID, UserID: req.UserID, Role: req.Role, InvitedBy: &claims.UserID}
+		created, err := db.InviteOrgMember(db.Postgres, member)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to invite user", http.StatusInternalServerError)
+			return
+		}
+		if !created {
+			server.WriteErrorJSON(w, "user is a member or invited already", http.StatusBadRequest)
+			return
+		}
+		org, err := db.GetOrganization(db.Postgres, req.OrgID)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load organization", http.StatusInternalServerError)
+			return
+		}
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeOrgInvited,
+			Title: "You're invited to an organization",
+			Body:  fmt.Sprintf("%s: join as %s", org.Name, member.Role),
+			Data: map[string]interface{}{
+				"org_id":     org.ID,
+				"role":       member.Role,
+				"invited_by": claims.UserID,
+			},
+		}, member.UserID)
+	}
+
+	members, err := db.ListOrgMembers(db.Postgres, req.OrgID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load members", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"members": members,
+	})
+}
+
+// JoinOrganizationHandler godoc
+// @Summary Join an organization
+// @Description Accepts a pending invitation to an organization. Decline one through /api/orgs/members/delete.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Param request body JoinOrganizationRequest true "org_id"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"no pending invitation\"}"
+// @Security BearerAuth
+// @Router /api/orgs/join [post]
+func JoinOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var req JoinOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	err := db.JoinOrganization(db.Postgres, req.OrgID, claims.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "no pending invitation", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to join organization", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// RemoveOrgMemberHandler godoc
+// @Summary Remove an organization member
+// @Description Removes a member or withdraws an invitation, along with the member's spending limits. Owners remove anyone, managers remove members, and every user can leave or decline an invitation by passing their own user_id. The last owner can't leave. Tasks and offers of the member stay with the organization.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Param request body OrgMemberRequest true "org_id and user_id"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"the last owner cannot leave\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"you cannot remove this member\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"member not found\"}"
+// @Security BearerAuth
+// @Router /api/orgs/members/delete [post]
+func RemoveOrgMemberHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var req OrgMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	member, err := db.GetOrgMember(db.Postgres, req.OrgID, req.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "member not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load member", http.StatusInternalServerError)
+		return
+	}
+	if req.UserID != claims.UserID {
+		actor := activeOrgMember(w, req.OrgID, claims.UserID)
+		if actor == nil {
+			return
+		}
+		if !canManageMember(actor, member.Role) {
+			server.WriteErrorJSON(w, "you cannot remove this member", http.StatusForbidden)
+			return
+		}
+	}
+	if member.Role == models.OrgRoleOwner {
+		if n, err := db.CountOrgOwners(db.Postgres, req.OrgID); err != nil {
+			server.WriteErrorJSON(w, "failed to count owners", http.StatusInternalServerError)
+			return
+		} else if n <= 1 {
+			server.WriteErrorJSON(w, "the last owner cannot leave", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := db.RemoveOrgMember(db.Postgres, req.OrgID, req.UserID); errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "member not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		server.WriteErrorJSON(w, "failed to remove member", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// OrgMemberRoleHandler godoc
+// @Summary Change an organization member's role
+// @Description Sets the role of a member or invited user to owner, manager or member (owners only). The last owner can't step down.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Param request body OrgMemberRequest true "org_id, user_id and role"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"the last owner cannot step down\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"only owners can change roles\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"member not found\"}"
+// @Security BearerAuth
+// @Router /api/orgs/members/role [post]
+func OrgMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var req OrgMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if !models.IsOrgRole(req.Role) {
+		server.WriteErrorJSON(w, "role must be owner, manager or member", http.StatusBadRequest)
+		return
+	}
+
+	actor := activeOrgMember(w, req.OrgID, claims.UserID)
+	if actor == nil {
+		return
+	}
+	if actor.Role != models.OrgRoleOwner {
+		server.WriteErrorJSON(w, "only owners can change roles", http.StatusForbidden)
+		return
+	}
+	member, err := db.GetOrgMember(db.Postgres, req.OrgID, req.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "member not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load member", http.StatusInternalServerError)
+		return
+	}
+	if member.Role == models.OrgRoleOwner && req.Role != models.OrgRoleOwner {
+		if n, err := db.CountOrgOwners(db.Postgres, req.OrgID); err != nil {
+			server.WriteErrorJSON(w, "failed to count owners", http.StatusInternalServerError)
+			return
+		} else if n <= 1 {
+			server.WriteErrorJSON(w, "the last owner cannot step down", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := db.SetOrgMemberRole(db.Postgres, req.OrgID, req.UserID, req.Role); errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "member not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		server.WriteErrorJSON(w, "failed to change role", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// OrgSpendingLimitsHandler godoc
+// @Summary List or set spending limits
+// @Description GET returns the monthly spending limits of an organization's members with what each spent this calendar month. POST sets a member's limit in a currency, or lifts it when monthly_limit is null. A member's spending is what they moved into escrow from the organization wallet by accepting offers on its tasks; members without a limit are not limited. Owners limit anyone, managers limit members.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Param org_id query int false "Organization ID (GET)"
+// @Param request body OrgSpendingLimitRequest false "Limit (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"limits\": [{\"org_id\": 3, \"user_id\": 9, \"currency\": \"BTC\", \"monthly_limit\": 0.05, \"spent\": 0.012}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"monthly_limit cannot be negative\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"you cannot limit this member\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"organization not found\"}"
+// @Security BearerAuth
+// @Router /api/orgs/limits [get]
+// @Router /api/orgs/limits [post]
+func OrgSpendingLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req OrgSpendingLimitRequest
+	switch r.Method {
+	case http.MethodGet:
+		id, err := strconv.ParseInt(r.URL.Query().Get("org_id"), 10, 64)
+		if err != nil {
+			server.WriteErrorJSON(w, "invalid org_id", http.StatusBadRequest)
+			return
+		}
+		req.OrgID = id
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	actor := activeOrgMember(w, req.OrgID, claims.UserID)
+	if actor == nil {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if !validCurrency(req.Currency) {
+			server.WriteErrorJSON(w, "currency must be BTC or XMR", http.StatusBadRequest)
+			return
+		}
+		if req.MonthlyLimit != nil && *req.MonthlyLimit < 0 {
+			server.WriteErrorJSON(w, "monthly_limit cannot be negative", http.StatusBadRequest)
+			return
+		}
+		member, err := db.GetOrgMember(db.Postgres, req.OrgID, req.UserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			server.WriteErrorJSON(w, "member not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load member", http.StatusInternalServerError)
+			return
+		}
+		if !canManageMember(actor, member.Role) {
+			server.WriteErrorJSON(w, "you cannot limit this member", http.StatusForbidden)
+			return
+		}
+		if err := db.SetOrgSpendingLimit(db.Postgres, req.OrgID, req.UserID, req.Currency, req.MonthlyLimit); err != nil {
+			server.WriteErrorJSON(w, "failed to set limit", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	limits, err := db.ListOrgSpendingLimits(db.Postgres, req.OrgID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load limits", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"limits":  limits,
+	})
+}
+
+// OrgWalletHandler godoc
+// @Summary Get an organization's wallet
+// @Description Returns the balances of an organization and a page of its wallet entries, newest first. Entries are deposit and withdrawal (between a member's wallet and the organization's), escrow and refund (tasks posted for the organization) and payout (offers the organization made). amount is negative when leaving the wallet. Active members only.
+// @Tags organizations
+// @Produce json
+// @Param org_id query int true "Organization ID"
+// @Param limit query int false "Page size, default 50, max 200"
+// @Param offset query int false "Offset"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"wallets\": [{\"org_id\": 3, \"currency\": \"BTC\", \"balance\": \"0.250000000000\"}], \"entries\": [{\"id\": 12, \"org_id\": 3, \"currency\": \"BTC\", \"kind\": \"escrow\", \"amount\": -0.01, \"user_id\": 9, \"task_id\": 42, \"created_at\": \"2025-01-02T12:00:00Z\"}]}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"organization not found\"}"
+// @Security BearerAuth
+// @Router /api/orgs/wallet [get]
+func OrgWalletHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	orgID, err := strconv.ParseInt(r.URL.Query().Get("org_id"), 10, 64)
+	if err != nil {
+		server.WriteErrorJSON(w, "invalid org_id", http.StatusBadRequest)
+		return
+	}
+	if activeOrgMember(w, orgID, claims.UserID) == nil {
+		return
+	}
+
+	wallets, err := db.ListOrgWallets(db.Postgres, orgID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load wallets", http.StatusInternalServerError)
+		return
+	}
+	limit, offset := pageParams(r)
+	entries, err := db.ListOrgWalletEntries(db.Postgres, orgID, limit, offset)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load wallet entries", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"wallets": wallets,
+		"entries": entries,
+	})
+}
+
+// OrgWalletDepositHandler godoc
+// @Summary Fund an organization's wallet
+// @Description Moves an amount from the current user's wallet to the organization wallet. Any active member can deposit.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Param request body OrgTransferRequest true "org_id, currency and amount"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"insufficient balance\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"organization not found\"}"
+// @Security BearerAuth
+// @Router /api/orgs/wallet/deposit [post]
+func OrgWalletDepositHandler(w http.ResponseWriter, r *http.Request) {
+	orgTransfer(w, r, true)
+}
+
+// OrgWalletWithdrawHandler godoc
+// @Summary Withdraw from an organization's wallet
+// @Description Moves an amount from the organization wallet to the current user's wallet (owners only). Funds held in escrow for the organization's tasks can't be withdrawn.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Param request body OrgTransferRequest true "org_id, currency and amount"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"insufficient balance\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"only owners can withdraw\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"organization not found\"}"
+// @Security BearerAuth
+// @Router /api/orgs/wallet/withdraw [post]
+func OrgWalletWithdrawHandler(w http.ResponseWriter, r *http.Request) {
+	orgTransfer(w, r, false)
+}
+
+// orgTransfer moves funds between the caller's wallet and an organization
+// wallet: in when deposit is set, out otherwise
+func orgTransfer(w http.ResponseWriter, r *http.Request, deposit bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var req OrgTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if !validCurrency(req.Currency) {
+		server.WriteErrorJSON(w, "currency must be BTC or XMR", http.StatusBadRequest)
+		return
+	}
+	if req.Amount <= 0 {
+		server.WriteErrorJSON(w, "amount must be positive", http.StatusBadRequest)
+		return
+	}
+
+	actor := activeOrgMember(w, req.OrgID, claims.UserID)
+	if actor == nil {
+		return
+	}
+	if !deposit && actor.Role != models.OrgRoleOwner {
+		server.WriteErrorJSON(w, "only owners can withdraw", http.StatusForbidden)
+		return
+	}
+	if blocked, err := db.IsUserBlocked(db.Postgres, claims.UserID); err != nil {
+		server.WriteErrorJSON(w, "failed to check user status", http.StatusInternalServerError)
+		return
+	} else if blocked {
+		server.WriteErrorJSON(w, "user is blocked", http.StatusForbidden)
+		return
+	}
+
+	err := db.TransferOrgFunds(db.Postgres, req.OrgID, claims.UserID, req.Currency, big.NewFloat(req.Amount), deposit)
+	if errors.Is(err, db.ErrInsufficientBalance) {
+		server.WriteErrorJSON(w, "insufficient balance", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to transfer funds", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// OrgTasksHandler godoc
+// @Summary List an organization's tasks and offers
+// @Description Returns a page of the tasks posted for an organization and a page of the offers it made as an agency, newest first. Active members only.
+// @Tags organizations
+// @Produce json
+// @Param org_id query int true "Organization ID"
+// @Param limit query int false "Page size, default 50, max 200"
+// @Param offset query int false "Offset"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"tasks\": [{\"id\": 42, \"client_id\": 9, \"org_id\": 3, \"title\": \"Landing page\", \"status\": \"open\"}], \"offers\": [{\"id\": 77, \"task_id\": 51, \"freelancer_id\": 12, \"org_id\": 3, \"price\": 0.02, \"status\": \"pending\"}]}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"organization not found\"}"
+// @Security BearerAuth
+// @Router /api/orgs/tasks [get]
+func OrgTasksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	orgID, err := strconv.ParseInt(r.URL.Query().Get("org_id"), 10, 64)
+	if err != nil {
+		server.WriteErrorJSON(w, "invalid org_id", http.StatusBadRequest)
+		return
+	}
+	if activeOrgMember(w, orgID, claims.UserID) == nil {
+		return
+	}
+
+	limit, offset := pageParams(r)
+	tasks, err := db.ListOrgTasks(db.Postgres, orgID, limit, offset)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load tasks", http.StatusInternalServerError)
+		return
+	}
+	offers, err := db.ListOrgOffers(db.Postgres, orgID, limit, offset)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load offers", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"tasks":   tasks,
+		"offers":  offers,
+	})
+}
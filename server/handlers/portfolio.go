@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"mFrelance/config"
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/server"
+)
+
+const (
+	maxPortfolioTitle       = 200
+	maxPortfolioDescription = 5000
+	maxPortfolioLink        = 500
+	// maxDirectoryPage limits one page of the freelancer directory
+	maxDirectoryPage = 50
+)
+
+type SavePortfolioItemRequest struct {
+	ID          int64    `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Links       []string `json:"links"`
+	FileIDs     []int64  `json:"file_ids"`
+}
+
+type DeletePortfolioItemRequest struct {
+	ID int64 `json:"id"`
+}
+
+type HistoryConsentRequest struct {
+	TaskID int64 `json:"task_id"`
+	Allow  bool  `json:"allow"`
+}
+
+// validPortfolioLink accepts absolute http and https URLs
+func validPortfolioLink(link string) bool {
+	if len(link) > maxPortfolioLink {
+		return false
+	}
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// PortfolioHandler godoc
+// @Summary List or save portfolio items
+// @Description GET returns the portfolio of user_id (default: current user), newest first. POST creates an item, or updates one of the current user's items when id is set. file_ids replace the item's images in order; they must be images uploaded by the current user through /api/files/upload. Portfolio images can be downloaded by every user.
+// @Tags profile
+// @Accept json
+// @Produce json
+// @Param user_id query int false "User ID (GET)"
+// @Param request body SavePortfolioItemRequest false "Portfolio item (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"items\": [{\"id\": 3, \"user_id\": 7, \"title\": \"Shop redesign\", \"description\": \"...\", \"links\": [\"https://example.com\"], \"images\": [{\"id\": 12, \"name\": \"shop.png\", \"content_type\": \"image/png\", \"size\": 48213}], \"created_at\": \"2025-01-01T12:00:00Z\", \"updated_at\": \"2025-01-01T12:00:00Z\"}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"title is required\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"portfolio item not found\"}"
+// @Security BearerAuth
+// @Router /api/portfolio [get]
+// @Router /api/portfolio [post]
+func PortfolioHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		userID := claims.UserID
+		if s := r.URL.Query().Get("user_id"); s != "" {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || id <= 0 {
+				server.WriteErrorJSON(w, "invalid user_id", http.StatusBadRequest)
+				return
+			}
+			userID = id
+		}
+		items, err := db.ListPortfolioItems(db.Postgres, userID)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load portfolio", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"items":   items,
+		})
+
+	case http.MethodPost:
+		var req SavePortfolioItemRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		item := &models.PortfolioItem{
+			ID:          req.ID,
+			UserID:      claims.UserID,
+			Title:       server.SanitizeString(strings.TrimSpace(req.Title)),
+			Description: server.SanitizeString(strings.TrimSpace(req.Description)),
+			Links:       models.JSONStrings{},
+		}
+		if item.Title == "" {
+			server.WriteErrorJSON(w, "title is required", http.StatusBadRequest)
+			return
+		}
+		if len(item.Title) > maxPortfolioTitle || len(item.Description) > maxPortfolioDescription {
+			server.WriteErrorJSON(w, "title or description too long", http.StatusBadRequest)
+			return
+		}
+		if len(req.Links) > config.AppConfig.PortfolioMaxLinks {
+			server.WriteErrorJSON(w, "too many links", http.StatusBadRequest)
+			return
+		}
+		for _, link := range req.Links {
+			link = strings.TrimSpace(link)
+			if !validPortfolioLink(link) {
+				server.WriteErrorJSON(w, "links must be http or https URLs", http.StatusBadRequest)
+				return
+			}
+			item.Links = append(item.Links, link)
+		}
+		if len(req.FileIDs) > config.AppConfig.PortfolioMaxImages {
+			server.WriteErrorJSON(w, "too many images", http.StatusBadRequest)
+			return
+		}
+		files, err := db.GetOwnedFiles(db.Postgres, claims.UserID, req.FileIDs)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load files", http.StatusInternalServerError)
+			return
+		}
+		if len(files) != len(req.FileIDs) {
+			server.WriteErrorJSON(w, "unknown file", http.StatusBadRequest)
+			return
+		}
+		for _, f := range files {
+			if !inlineTypes[f.ContentType] {
+				server.WriteErrorJSON(w, "portfolio files must be images", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if item.ID == 0 {
+			count, err := db.CountPortfolioItems(db.Postgres, claims.UserID)
+			if err != nil {
+				server.WriteErrorJSON(w, "failed to load portfolio", http.StatusInternalServerError)
+				return
+			}
+			if count >= config.AppConfig.PortfolioMaxItems {
+				server.WriteErrorJSON(w, "portfolio is full", http.StatusBadRequest)
+				return
+			}
+		}
+		err = db.SavePortfolioItem(db.Postgres, item, req.FileIDs)
+		if errors.Is(err, sql.ErrNoRows) {
+			server.WriteErrorJSON(w, "portfolio item not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("[PortfolioHandler]", err)
+			server.WriteErrorJSON(w, "failed to save portfolio item", http.StatusInternalServerError)
+			return
+		}
+		item.Images = files
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"item":    item,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// DeletePortfolioItemHandler godoc
+// @Summary Delete a portfolio item
+// @Description Deletes one of the current user's portfolio items. The image files stay in the user's uploads.
+// @Tags profile
+// @Accept json
+// @Produce json
+// @Param request body DeletePortfolioItemRequest true "Portfolio item"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"portfolio item not found\"}"
+// @Security BearerAuth
+// @Router /api/portfolio/delete [post]
+func DeletePortfolioItemHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req DeletePortfolioItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	ok, err := db.DeletePortfolioItem(db.Postgres, req.ID, claims.UserID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to delete portfolio item", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		server.WriteErrorJSON(w, "portfolio item not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// TaskHistoryConsentHandler godoc
+// @Summary Get or set work history consent
+// @Description The client of a completed task decides whether it appears in the freelancer's public work history, together with the client's review. Tasks are hidden until the client allows them. GET returns the current choice.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param task_id query int false "Task ID (GET)"
+// @Param request body HistoryConsentRequest false "Task and choice (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"task_id\": 42, \"allow\": true}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"task is not completed\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"only the client can decide\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"task not found\"}"
+// @Security BearerAuth
+// @Router /api/tasks/history_consent [get]
+// @Router /api/tasks/history_consent [post]
+func TaskHistoryConsentHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req HistoryConsentRequest
+	switch r.Method {
+	case http.MethodGet:
+		id, err := strconv.ParseInt(r.URL.Query().Get("task_id"), 10, 64)
+		if err != nil {
+			server.WriteErrorJSON(w, "invalid task_id", http.StatusBadRequest)
+			return
+		}
+		req.TaskID = id
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	task, err := db.GetTask(db.Postgres, req.TaskID)
+	if err != nil {
+		server.WriteErrorJSON(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if task.ClientID != claims.UserID {
+		server.WriteErrorJSON(w, "only the client can decide", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if task.Status != "completed" {
+			server.WriteErrorJSON(w, "task is not completed", http.StatusBadRequest)
+			return
+		}
+		if err := db.SetTaskHistoryConsent(db.Postgres, task.ID, claims.UserID, req.Allow); err != nil {
+			server.WriteErrorJSON(w, "failed to save consent", http.StatusInternalServerError)
+			return
+		}
+	} else if req.Allow, err = db.HasTaskHistoryConsent(db.Postgres, task.ID); err != nil {
+		server.WriteErrorJSON(w, "failed to load consent", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"task_id": task.ID,
+		"allow":   req.Allow,
+	})
+}
+
+// ProfileSearchHandler godoc
+// @Summary Search the freelancer directory
+// @Description Lists non-empty profiles of users that aren't blocked. Filters combine; skills must all be present (case-insensitive). Hourly rate filters need currency. Every profile carries its badges: verified (confirmed email) and top_rated (reputation score and review count above the configured bar).
+// @Tags profile
+// @Produce json
+// @Param q query string false "Text in name, bio or username"
+// @Param skills query string false "Comma separated skills"
+// @Param min_rating query number false "Minimum average rating"
+// @Param min_completed query int false "Minimum completed tasks"
+// @Param min_hourly_rate query number false "Minimum hourly rate"
+// @Param max_hourly_rate query number false "Maximum hourly rate"
+// @Param currency query string false "Currency of the hourly rate: BTC or XMR"
+// @Param sort query string false "reputation (default), rating, completed_tasks or hourly_rate"
+// @Param limit query int false "Page size, default 20, max 50"
+// @Param offset query int false "Offset"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"total\": 1, \"profiles\": [{\"user_id\": 7, \"username\": \"alice\", \"full_name\": \"Alice\", \"skills\": [\"go\"], \"rating\": 4.8, \"reputation\": 88.1, \"review_count\": 12, \"completed_tasks\": 14, \"hourly_rate\": 0.0004, \"hourly_currency\": \"BTC\", \"badges\": [\"verified\", \"top_rated\"]}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"currency is required with hourly rate filters\"}"
+// @Security BearerAuth
+// @Router /profiles/search [get]
+func ProfileSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	f := models.ProfileSearchFilter{
+		Query:          strings.TrimSpace(q.Get("q")),
+		HourlyCurrency: strings.ToUpper(q.Get("currency")),
+		Sort:           q.Get("sort"),
+		Limit:          20,
+	}
+	for _, s := range strings.Split(q.Get("skills"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			f.Skills = append(f.Skills, s)
+		}
+	}
+
+	var err error
+	parseFloat := func(name string, dst *float64) bool {
+		if s := q.Get(name); s != "" {
+			if *dst, err = strconv.ParseFloat(s, 64); err != nil || *dst < 0 {
+				server.WriteErrorJSON(w, "invalid "+name, http.StatusBadRequest)
+				return false
+			}
+		}
+		return true
+	}
+	if !parseFloat("min_rating", &f.MinRating) ||
+		!parseFloat("min_hourly_rate", &f.MinHourlyRate) ||
+		!parseFloat("max_hourly_rate", &f.MaxHourlyRate) {
+		return
+	}
+	if s := q.Get("min_completed"); s != "" {
+		if f.MinCompleted, err = strconv.Atoi(s); err != nil || f.MinCompleted < 0 {
+			server.WriteErrorJSON(w, "invalid min_completed", http.StatusBadRequest)
+			return
+		}
+	}
+	if (f.MinHourlyRate > 0 || f.MaxHourlyRate > 0) && f.HourlyCurrency == "" {
+		server.WriteErrorJSON(w, "currency is required with hourly rate filters", http.StatusBadRequest)
+		return
+	}
+	if f.HourlyCurrency != "" && !validCurrency(f.HourlyCurrency) {
+		server.WriteErrorJSON(w, "invalid currency", http.StatusBadRequest)
+		return
+	}
+	if f.Sort == "" {
+		f.Sort = "reputation"
+	}
+	if !db.IsProfileSort(f.Sort) {
+		server.WriteErrorJSON(w, "invalid sort", http.StatusBadRequest)
+		return
+	}
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= maxDirectoryPage {
+		f.Limit = l
+	}
+	if o, err := strconv.Atoi(q.Get("offset")); err == nil && o >= 0 {
+		f.Offset = o
+	}
+
+	profiles, total, err := db.SearchProfiles(db.Postgres, f)
+	if err != nil {
+		log.Println("[ProfileSearchHandler]", err)
+		server.WriteErrorJSON(w, "failed to search profiles", http.StatusInternalServerError)
+		return
+	}
+	for i := range profiles {
+		p := &profiles[i]
+		server.SanitizeProfile(&p.Profile)
+		p.Badges = server.ProfileBadges(p.EmailVerified, p.Reputation, p.ReviewCount)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"profiles": profiles,
+		"total":    total,
+	})
+}
+
+// validCurrency reports whether currency is one the platform settles in
+func validCurrency(currency string) bool {
+	return currency == "BTC" || currency == "XMR"
+}
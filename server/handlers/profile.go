@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"mFrelance/avatar"
 	"mFrelance/config"
 	"mFrelance/db"
 	"mFrelance/models"
@@ -84,6 +88,27 @@ func ProfileHandler() http.HandlerFunc {
 				http.Error(w, "avatar too large", http.StatusBadRequest)
 				return
 			}
+			p.HourlyCurrency = strings.ToUpper(p.HourlyCurrency)
+			if p.HourlyCurrency == "" {
+				p.HourlyCurrency = "BTC"
+			}
+			if !validCurrency(p.HourlyCurrency) || (p.HourlyRate != nil && *p.HourlyRate < 0) {
+				http.Error(w, "invalid hourly rate", http.StatusBadRequest)
+				return
+			}
+			// Base64 avatars from older clients go through the avatar
+			// pipeline too; anything else is kept as it was
+			if data, ok := decodeLegacyAvatar(p.Avatar); ok {
+				if _, err := saveAvatar(claims.UserID, data); err != nil {
+					if errors.Is(err, avatar.ErrFormat) || errors.Is(err, avatar.ErrTooLarge) {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					http.Error(w, "failed to store avatar", http.StatusInternalServerError)
+					return
+				}
+				p.Avatar = ""
+			}
 			server.SanitizeProfile(&p)
 			if err := models.UpsertProfile(db.Postgres, &p); err != nil {
 				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
@@ -153,7 +178,7 @@ func ProfilesHandler() http.HandlerFunc {
 
 // ProfileByIDHandler godoc
 // @Summary Get public profile by user_id
-// @Description Returns sanitized profile and username by user_id
+// @Description Returns sanitized profile, username, the active public key for encrypted messages (null if none), badges, portfolio and the public work history (completed tasks the clients agreed to show, with their reviews) by user_id
 // @Tags profile
 // @Produce json
 // @Param user_id query int true "User ID"
@@ -198,12 +223,41 @@ func ProfileByIDHandler() http.HandlerFunc {
 			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		publicKey, err := db.GetActivePublicKey(db.Postgres, userID)
+		if err != nil && err != sql.ErrNoRows {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		verified, err := db.IsEmailVerified(db.Postgres, userID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reviewCount, err := db.GetReputationReviewCount(db.Postgres, userID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		portfolio, err := db.ListPortfolioItems(db.Postgres, userID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		history, err := db.ListWorkHistory(db.Postgres, userID, 50, 0)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
 		// включим username в ответе отдельно
 		server.SanitizeProfile(prof)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]any{
-			"username": username,
-			"profile":  prof,
+			"username":     username,
+			"profile":      prof,
+			"public_key":   publicKey,
+			"badges":       server.ProfileBadges(verified, prof.Reputation, reviewCount),
+			"portfolio":    portfolio,
+			"work_history": history,
 		})
 	}
 }
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"mFrelance/db"
+	"mFrelance/server"
+)
+
+var roleNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]{1,63}$`)
+
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type UserRoleRequest struct {
+	UserID int64  `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+func writeRoleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrRoleNotFound):
+		server.WriteErrorJSON(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, db.ErrBuiltinRole), errors.Is(err, db.ErrUnknownPermName):
+		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+	default:
+		server.WriteErrorJSON(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// ListPermissionsHandler godoc
+// @Summary List permissions
+// @Description Returns every named permission that can be put into a role
+// @Tags administration
+// @Produce json
+// @Success 200 {array} models.Permission
+// @Failure 403 {string} string "Example: \"insufficient permissions\""
+// @Security BearerAuth
+// @Router /api/admin/permissions [get]
+func ListPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	perms, err := db.ListPermissions(db.Postgres)
+	if err != nil {
+		server.WriteErrorJSON(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(perms)
+}
+
+// RolesHandler godoc
+// @Summary List or save roles
+// @Description GET lists all roles with their permissions. POST creates a role or replaces the description and permissions of an existing one. The superadmin role cannot be changed.
+// @Tags administration
+// @Accept json
+// @Produce json
+// @Param request body RoleRequest false "Role to create or update (POST)"
+// @Success 200 {array} models.Role
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"unknown permission\"}"
+// @Failure 403 {string} string "Example: \"insufficient permissions\""
+// @Security BearerAuth
+// @Router /api/admin/roles [get]
+// @Router /api/admin/roles [post]
+func RolesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		roles, err := db.ListRoles(db.Postgres)
+		if err != nil {
+			server.WriteErrorJSON(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(roles)
+	case http.MethodPost:
+		var req RoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if !roleNameRe.MatchString(req.Name) {
+			server.WriteErrorJSON(w, "invalid role name", http.StatusBadRequest)
+			return
+		}
+		if req.Permissions == nil {
+			req.Permissions = []string{}
+		}
+		userIDs, err := db.UpsertRole(db.Postgres, req.Name, req.Description, req.Permissions,
+			newAuditEntry(r, db.AuditRoleUpsert, "role", 0, ""))
+		if err != nil {
+			writeRoleError(w, err)
+			return
+		}
+		server.InvalidatePermissions(userIDs...)
+		role, err := db.GetRoleByName(db.Postgres, req.Name)
+		if err != nil {
+			writeRoleError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(role)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// DeleteRoleHandler godoc
+// @Summary Delete role
+// @Description Deletes a custom role and revokes it from every user. Builtin roles cannot be deleted.
+// @Tags administration
+// @Accept json
+// @Produce json
+// @Param request body RoleRequest true "Only name is used"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"builtin role cannot be changed\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"role not found\"}"
+// @Security BearerAuth
+// @Router /api/admin/roles/delete [post]
+func DeleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	userIDs, err := db.DeleteRole(db.Postgres, req.Name, newAuditEntry(r, db.AuditRoleDelete, "role", 0, ""))
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	server.InvalidatePermissions(userIDs...)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// AssignRoleHandler godoc
+// @Summary Assign role to user
+// @Tags administration
+// @Accept json
+// @Produce json
+// @Param request body UserRoleRequest true "User and role"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"roles\": [\"support\"]}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"role not found\"}"
+// @Security BearerAuth
+// @Router /api/admin/roles/assign [post]
+func AssignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	var req UserRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID <= 0 {
+		server.WriteErrorJSON(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := db.AssignRole(db.Postgres, req.UserID, req.Role, claims.UserID,
+		newAuditEntry(r, db.AuditRoleAssign, "user", req.UserID, "")); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	server.InvalidatePermissions(req.UserID)
+	writeUserRoles(w, req.UserID)
+}
+
+// RevokeRoleHandler godoc
+// @Summary Revoke role from user
+// @Description Revoking your own superadmin role is refused so the last superadmin cannot lock everyone out.
+// @Tags administration
+// @Accept json
+// @Produce json
+// @Param request body UserRoleRequest true "User and role"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"roles\": []}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"cannot revoke superadmin from yourself\"}"
+// @Security BearerAuth
+// @Router /api/admin/roles/revoke [post]
+func RevokeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	var req UserRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID <= 0 {
+		server.WriteErrorJSON(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == claims.UserID && req.Role == db.RoleSuperadmin {
+		server.WriteErrorJSON(w, "cannot revoke superadmin from yourself", http.StatusBadRequest)
+		return
+	}
+	if err := db.RevokeRole(db.Postgres, req.UserID, req.Role,
+		newAuditEntry(r, db.AuditRoleRevoke, "user", req.UserID, "")); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	server.InvalidatePermissions(req.UserID)
+	writeUserRoles(w, req.UserID)
+}
+
+// UserRolesHandler godoc
+// @Summary Get roles of a user
+// @Tags administration
+// @Produce json
+// @Param user_id query int true "User ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"roles\": [\"support\"], \"permissions\": [\"chat.manage\"]}"
+// @Security BearerAuth
+// @Router /api/admin/users/roles [get]
+func UserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil || userID <= 0 {
+		server.WriteErrorJSON(w, "invalid user_id", http.StatusBadRequest)
+		return
+	}
+	writeUserRoles(w, userID)
+}
+
+func writeUserRoles(w http.ResponseWriter, userID int64) {
+	roles, err := db.GetUserRoles(db.Postgres, userID)
+	if err != nil {
+		server.WriteErrorJSON(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	perms, _, err := server.UserPermissions(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"user_id":     userID,
+		"roles":       roles,
+		"permissions": perms,
+	})
+}
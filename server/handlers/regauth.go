@@ -7,22 +7,13 @@ import (
 	"net/http"
 	"strings"
 	"time"
-	"io/ioutil"
 	"github.com/go-redis/redis/v8"
 
-	"golang.org/x/image/font"
-	"golang.org/x/image/font/opentype"
-	"golang.org/x/image/math/fixed"
-	"image"
-	"image/color"
-	"image/draw"
-	"image/png"
-	"math/rand"
-	"strconv"
-
 	"mFrelance/auth"
+	"mFrelance/captcha"
 	"mFrelance/config"
 	"mFrelance/db"
+	"mFrelance/notifications"
 	"mFrelance/server"
 )
 import "unicode/utf8"
@@ -30,8 +21,9 @@ import "unicode/utf8"
 type RegisterRequest struct {
 	Username      string `json:"username"`
 	Password      string `json:"password"`
-	CaptchaID     string `json:"captcha_id"`
-	CaptchaAnswer string `json:"captcha_answer"`
+	CaptchaID       string `json:"captcha_id"`
+	CaptchaAnswer   string `json:"captcha_answer"`
+	CaptchaProvider string `json:"captcha_provider,omitempty"`
 }
 
 type Response struct {
@@ -87,67 +79,17 @@ func resetAllCaptchas(rdb *redis.Client) {
 	}
 }
 
-func generateCaptchaText() string {
-	rand.Seed(time.Now().UnixNano())
-	return strconv.Itoa(1000 + rand.Intn(9000)) // 4-digit number
-}
-
-func generateCaptchaImage(text string) image.Image {
-	width, height := 200, 60
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
-	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
-
-	for i := 0; i < 100; i++ {
-		x := rand.Intn(width)
-		y := rand.Intn(height)
-		img.Set(x, y, color.Black)
-	}
-
-	fontBytes, err := ioutil.ReadFile(config.AppConfig.CaptchaFontPath)
-	if err != nil {
-		log.Fatal(err)
-	}
-	ttfFont, err := opentype.Parse(fontBytes)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	face, err := opentype.NewFace(ttfFont, &opentype.FaceOptions{
-		Size:    32,          // размер шрифта (можно увеличить)
-		DPI:     72,
-		Hinting: font.HintingFull,
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	d := &font.Drawer{
-		Dst:  img,
-		Src:  image.NewUniform(color.Black),
-		Face: face,
-	}
-
-	textWidth := d.MeasureString(text).Ceil()
-	x := (width - textWidth) / 2
-	y := (height + int(face.Metrics().Ascent.Ceil()) - int(face.Metrics().Descent.Ceil())) / 2
-
-	d.Dot = fixed.Point26_6{
-		X: fixed.I(x),
-		Y: fixed.I(y),
-	}
-
-	d.DrawString(text)
-
-	return img
-}
-
 // CaptchaHandler godoc
 // @Summary Generate CAPTCHA Challenge
-// @Description Generates a new CAPTCHA image with 4-digit code for user verification. Includes rate limiting per IP to prevent abuse.
+// @Description Generates a new CAPTCHA challenge for user verification. The image provider returns a PNG with a 4-digit code, audio returns a WAV reading the code out loud, pow returns a JSON proof-of-work challenge. Includes rate limiting per IP to prevent abuse.
 // @Tags authentication
 // @Produce png
+// @Produce json
+// @Param provider query string false "Captcha provider: image (default), audio or pow"
 // @Success 200 "image/png" "CAPTCHA image in PNG format"
 // @Header 200 {string} X-Captcha-ID "Unique identifier for the CAPTCHA challenge"
+// @Header 200 {string} X-Captcha-Provider "Provider that issued the challenge"
+// @Failure 400 {object} map[string]string "Unknown captcha provider"
 // @Failure 429 {object} map[string]string "Rate limit exceeded - too many CAPTCHA requests"
 // @Failure 503 {object} map[string]string "CAPTCHA is disabled in server configuration"
 // @Router /captcha [get]
@@ -157,6 +99,15 @@ func CaptchaHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
 		return
 	}
 
+	provider := r.URL.Query().Get("provider")
+	if provider == "" {
+		provider = captcha.Image
+	}
+	if _, err := captcha.Get(provider); err != nil {
+		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	ip := getClientIP(r)
 
 	minuteKey := "captcha:count:" + ip + ":minute"
@@ -181,22 +132,36 @@ func CaptchaHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
 		return
 	}
 
-	text := generateCaptchaText()
-	id := strconv.Itoa(int(time.Now().UnixNano()))
-	rdb.Set(ctx, "captcha:"+id, text, 5*time.Minute)
+	c, err := captcha.Create(ctx, rdb, provider)
+	if err != nil {
+		log.Println("[CaptchaHandler] failed to create captcha:", err)
+		server.WriteErrorJSON(w, "failed to create captcha", http.StatusInternalServerError)
+		return
+	}
 
-	img := generateCaptchaImage(text)
-	w.Header().Set("Content-Type", "image/png")
-	w.Header().Set("X-Captcha-ID", id)
-	png.Encode(w, img)
+	w.Header().Set("X-Captcha-ID", c.ID)
+	w.Header().Set("X-Captcha-Provider", c.Provider)
+	w.Header().Set("Cache-Control", "no-store")
+	if c.Body == nil {
+		resp := map[string]interface{}{"id": c.ID, "provider": c.Provider}
+		for k, v := range c.Meta {
+			resp[k] = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.Header().Set("Content-Type", c.ContentType)
+	w.Write(c.Body)
 }
 
 // VerifyHandler godoc
 // @Summary Validate CAPTCHA Response
-// @Description Verifies the user's answer against the stored CAPTCHA challenge. Consumes the CAPTCHA token upon successful verification.
+// @Description Verifies the user's answer against the stored CAPTCHA challenge. Consumes the CAPTCHA token upon successful verification. For the pow provider the answer is the nonce.
 // @Tags authentication
 // @Param id query string true "CAPTCHA identifier received from /captcha endpoint"
 // @Param answer query string true "User's answer to the CAPTCHA challenge"
+// @Param provider query string false "Captcha provider: image (default), audio or pow"
 // @Success 200 {object} map[string]bool "ok: true if verification successful"
 // @Failure 400 {object} map[string]string "CAPTCHA expired or invalid ID"
 // @Router /verify [get]
@@ -207,15 +172,18 @@ func VerifyHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
 	}
 	id := r.URL.Query().Get("id")
 	answer := r.URL.Query().Get("answer")
+	provider := r.URL.Query().Get("provider")
+	if provider == "" {
+		provider = captcha.Image
+	}
 
-	stored, err := rdb.Get(ctx, "captcha:"+id).Result()
+	ok, err := captcha.Verify(ctx, rdb, provider, id, answer)
 	if err != nil {
 		server.WriteErrorJSON(w, "Captcha expired", http.StatusBadRequest)
 		return
 	}
 
-	if answer == stored {
-		rdb.Del(ctx, "captcha:"+id)
+	if ok {
 		w.Write([]byte(`{"ok":true}`))
 		return
 	}
@@ -228,16 +196,23 @@ func VerifyHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
 // @Description Returns whether CAPTCHA verification is currently enabled on the server. Used by frontend to conditionally show CAPTCHA fields.
 // @Tags authentication
 // @Produce json
-// @Success 200 {object} map[string]bool "Example: {\"enabled\": true}"
+// @Success 200 {object} map[string]interface{} "Example: {\"enabled\": true, \"endpoints\": {\"register\": [\"image\", \"pow\"]}}"
 // @Router /captcha/status [get]
 func CaptchaStatusHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"enabled": config.AppConfig.CaptchaEnabled})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": config.AppConfig.CaptchaEnabled,
+		"endpoints": map[string][]string{
+			captcha.EndpointRegister: captcha.AllowedProviders(captcha.EndpointRegister),
+			captcha.EndpointAuth:     captcha.AllowedProviders(captcha.EndpointAuth),
+			captcha.EndpointRestore:  captcha.AllowedProviders(captcha.EndpointRestore),
+		},
+	})
 }
 
 // RegisterHandler godoc
 // @Summary Register New User Account
-// @Description Creates a new user account with username, password, and CAPTCHA verification. Generates a recovery mnemonic phrase for account restoration.
+// @Description Creates a new user account with username, password, and CAPTCHA verification. Generates a recovery mnemonic phrase for account restoration. The phrase is returned only once, the server keeps just its hash.
 // @Tags authentication
 // @Accept json
 // @Produce json
@@ -254,15 +229,9 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client)
 		log.Println("[RegisterHandler] invalid json")
 		return
 	}
-	if utf8.RuneCountInString(req.Password) > 70 {
-		server.WriteErrorJSON(w, "password too long", http.StatusBadRequest)
-		log.Println("[RegisterHandler] password too long")
-		return
-	}
-	log.Println(req.Password)
-	if utf8.RuneCountInString(req.Password) < 6 {
-		server.WriteErrorJSON(w, "password too small", http.StatusBadRequest)
-		log.Println("[RegisterHandler] password too small")
+	if err := auth.ValidatePassword(req.Password); err != nil {
+		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+		log.Println("[RegisterHandler]", err)
 		return
 	}
 	if utf8.RuneCountInString(req.Username) < 2 {
@@ -277,29 +246,35 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client)
 	}
 
 	if config.AppConfig.CaptchaEnabled {
-		storedCaptcha, err := rdb.Get(ctx, "captcha:"+req.CaptchaID).Result()
-		if err != nil || storedCaptcha != req.CaptchaAnswer {
+		if !captcha.VerifyFor(ctx, rdb, captcha.EndpointRegister, req.CaptchaProvider, req.CaptchaID, req.CaptchaAnswer) {
 			server.WriteErrorJSON(w, "invalid captcha", http.StatusBadRequest)
 			log.Println("[RegisterHandler] invalid captcha")
 			return
 		}
-		rdb.Del(ctx, "captcha:"+req.CaptchaID)
 	}
 
-  mnemonic := server.GenerateMnemonic()
+	mnemonic := server.GenerateMnemonic()
+	mnemonicHash, err := auth.HashMnemonic(mnemonic)
+	if err != nil {
+		log.Println("[RegisterHandler] failed to hash mnemonic:", err)
+		server.WriteErrorJSON(w, "failed to create user", http.StatusInternalServerError)
+		return
+	}
 	passwordHash := server.HashPassword(req.Password)
 
-	err := db.CreateUser(db.Postgres, req.Username, passwordHash, mnemonic)
+	err = db.CreateUser(db.Postgres, req.Username, passwordHash, mnemonicHash)
 	if err != nil {
 		log.Println("[RegisterHandler] failed to create user")
 		server.WriteErrorJSON(w, "failed to create user, maybe user exists", http.StatusInternalServerError)
 		return
 	}
 
+	// Only a hash is stored, so this is the one and only time the phrase is shown
 	resp := Response{
 		Message:   "Account created successfully. Save your recovery phrase!",
 		Encrypted: mnemonic,
 	}
+	w.Header().Set("Cache-Control", "no-store")
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
@@ -308,8 +283,9 @@ type RestoreRequest struct {
 	Username      string `json:"username"`
 	Mnemonic      string `json:"mnemonic"`
 	NewPassword   string `json:"new_password"`
-	CaptchaID     string `json:"captcha_id"`
-	CaptchaAnswer string `json:"captcha_answer"`
+	CaptchaID       string `json:"captcha_id"`
+	CaptchaAnswer   string `json:"captcha_answer"`
+	CaptchaProvider string `json:"captcha_provider,omitempty"`
 }
 
 // RestoreHandler godoc
@@ -329,25 +305,21 @@ func RestoreHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
 		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
 		return
 	}
-	if utf8.RuneCountInString(req.NewPassword) > 128 {
-		server.WriteErrorJSON(w, "password too long", http.StatusBadRequest)
-		log.Println("[RegisterHandler] password too long")
-		return
-	}
-	log.Println(req.NewPassword)
-	if utf8.RuneCountInString(req.NewPassword) < 6 {
-		server.WriteErrorJSON(w, "password too small", http.StatusBadRequest)
-		log.Println("[RegisterHandler] password too small")
+	if err := auth.ValidatePassword(req.NewPassword); err != nil {
+		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+		log.Println("[RestoreHandler]", err)
 		return
 	}
 
 	if config.AppConfig.CaptchaEnabled {
-		storedCaptcha, err := rdb.Get(ctx, "captcha:"+req.CaptchaID).Result()
-		if err != nil || storedCaptcha != req.CaptchaAnswer {
+		if !captcha.VerifyFor(ctx, rdb, captcha.EndpointRestore, req.CaptchaProvider, req.CaptchaID, req.CaptchaAnswer) {
 			server.WriteErrorJSON(w, "invalid captcha", http.StatusBadRequest)
 			return
 		}
-		rdb.Del(ctx, "captcha:"+req.CaptchaID)
+	}
+	if !auth.IsValidMnemonic(req.Mnemonic) {
+		server.WriteErrorJSON(w, "invalid mnemonic", http.StatusBadRequest)
+		return
 	}
 	userID, username, err := db.RestoreUser(db.Postgres, req.Username, req.Mnemonic)
 	if err != nil || userID == 0 || username == "" {
@@ -360,7 +332,15 @@ func RestoreHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
 		server.WriteErrorJSON(w, "Failed to change user password", http.StatusInternalServerError)
 		return
 	}
-	token, err := auth.GenerateJWT(userID, username)
+	notifications.Publish(notifications.Event{
+		Type:  notifications.TypePasswordRestored,
+		Title: "Your password was changed",
+		Body:  "The password was reset with the recovery phrase",
+		Data: map[string]interface{}{
+			"username": username,
+		},
+	}, userID)
+	token, err := server.GenerateUserJWT(userID, username)
 	if err != nil {
 		server.WriteErrorJSON(w, "Failed to generate token", http.StatusInternalServerError)
 		return
@@ -373,11 +353,76 @@ func RestoreHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+type RotateRecoveryRequest struct {
+	Password string `json:"password"`
+}
+
+// RotateRecoveryHandler godoc
+// @Summary Rotate Recovery Phrase
+// @Description Generates a new recovery mnemonic for the authenticated user and invalidates the old one. Requires the current password. The new phrase is returned only once.
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body RotateRecoveryRequest true "Current password"
+// @Success 200 {object} Response "Example: {\"message\": \"Recovery phrase rotated. Save your new recovery phrase!\", \"encrypted\": \"word1 word2 word3...\"}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"invalid json\"}"
+// @Failure 401 {object} map[string]string "Example: {\"error\": \"invalid password\"}"
+// @Failure 500 {object} map[string]string "Example: {\"error\": \"failed to rotate recovery phrase\"}"
+// @Security BearerAuth
+// @Router /api/recovery/rotate [post]
+func RotateRecoveryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		server.WriteErrorJSON(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var req RotateRecoveryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	user, err := db.GetUserByID(claims.UserID)
+	if err != nil {
+		server.WriteErrorJSON(w, "user not found", http.StatusUnauthorized)
+		return
+	}
+	if !server.VerifyPassword(req.Password, user.PasswordHash) {
+		server.WriteErrorJSON(w, "invalid password", http.StatusUnauthorized)
+		return
+	}
+
+	mnemonic := server.GenerateMnemonic()
+	mnemonicHash, err := auth.HashMnemonic(mnemonic)
+	if err != nil {
+		log.Println("[RotateRecoveryHandler] failed to hash mnemonic:", err)
+		server.WriteErrorJSON(w, "failed to rotate recovery phrase", http.StatusInternalServerError)
+		return
+	}
+	if err := db.SetMnemonicHash(db.Postgres, claims.UserID, mnemonicHash); err != nil {
+		log.Println("[RotateRecoveryHandler] failed to store mnemonic hash:", err)
+		server.WriteErrorJSON(w, "failed to rotate recovery phrase", http.StatusInternalServerError)
+		return
+	}
+
+	resp := Response{
+		Message:   "Recovery phrase rotated. Save your new recovery phrase!",
+		Encrypted: mnemonic,
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
 type AuthRequest struct {
 	Username      string `json:"username"`
 	Password      string `json:"password"`
-	CaptchaID     string `json:"captcha_id"`
-	CaptchaAnswer string `json:"captcha_answer"`
+	CaptchaID       string `json:"captcha_id"`
+	CaptchaAnswer   string `json:"captcha_answer"`
+	CaptchaProvider string `json:"captcha_provider,omitempty"`
 }
 
 type AuthResponse struct {
@@ -404,12 +449,16 @@ func AuthHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
 	}
 	if config.AppConfig.CaptchaEnabled {
 		log.Print("[AuthHandler] Test captcha")
-		storedCaptcha, err := rdb.Get(ctx, "captcha:"+req.CaptchaID).Result()
-		if err != nil || storedCaptcha != req.CaptchaAnswer {
+		if !captcha.VerifyFor(ctx, rdb, captcha.EndpointAuth, req.CaptchaProvider, req.CaptchaID, req.CaptchaAnswer) {
 			server.WriteErrorJSON(w, "invalid captcha", http.StatusBadRequest)
 			return
 		}
-		rdb.Del(ctx, "captcha:"+req.CaptchaID)
+	}
+	// no account has a longer password, and hashing one costs more than it
+	// should for a failed login
+	if utf8.RuneCountInString(req.Password) > auth.MaxPasswordLength {
+		server.WriteErrorJSON(w, "invalid username or password", http.StatusUnauthorized)
+		return
 	}
 	log.Print("[AuthHandler] Get User by Username")
 	userID, passwordHash, err := db.GetUserByUsername(db.Postgres, req.Username)
@@ -422,12 +471,21 @@ func AuthHandler(w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
 		return
 	}
 	log.Print("[AuthHandler] Check Password")
-	if !server.VerifyPassword(req.Password, passwordHash) {
+	ok, needsRehash := auth.VerifyPassword(req.Password, passwordHash)
+	if !ok {
 		server.WriteErrorJSON(w, "invalid username or password", http.StatusUnauthorized)
 		return
 	}
+	if needsRehash {
+		// Upgrade bcrypt or outdated argon2id hashes while we have the plaintext
+		if newHash, err := auth.HashPassword(req.Password); err != nil {
+			log.Println("[AuthHandler] failed to rehash password:", err)
+		} else if err := db.ChangeUserPassword(db.Postgres, req.Username, newHash); err != nil {
+			log.Println("[AuthHandler] failed to store rehashed password:", err)
+		}
+	}
 	log.Print("[AuthHandler] Generate JWT")
-	token, err := auth.GenerateJWT(userID, req.Username)
+	token, err := server.GenerateUserJWT(userID, req.Username)
 	if err != nil {
 		server.WriteErrorJSON(w, "failed to generate token", http.StatusInternalServerError)
 		return
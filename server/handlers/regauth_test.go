@@ -6,6 +6,8 @@ import (
 	"strings"
 	"testing"
 
+	"mFrelance/auth"
+	"mFrelance/config"
 	"mFrelance/server/handlers"
 	"mFrelance/server/testutil"
 )
@@ -21,7 +23,17 @@ func TestHelloHandler(t *testing.T) {
 	}
 }
 
+func enableCaptcha(t *testing.T) {
+	prev := config.AppConfig
+	config.AppConfig.CaptchaEnabled = true
+	config.AppConfig.CaptchaRateLimitPerMinute = 10
+	config.AppConfig.CaptchaRateLimitPerHour = 100
+	config.AppConfig.CaptchaFontPath = "../../fonts/captchaFont.ttf"
+	t.Cleanup(func() { config.AppConfig = prev })
+}
+
 func TestCaptchaHandler_SaveToRedis(t *testing.T) {
+	enableCaptcha(t)
 	mr, rdb := testutil.NewMiniRedis(t)
 	defer mr.Close()
 
@@ -42,7 +54,33 @@ func TestCaptchaHandler_SaveToRedis(t *testing.T) {
 	}
 }
 
+func TestCaptchaHandler_PoW(t *testing.T) {
+	enableCaptcha(t)
+	mr, rdb := testutil.NewMiniRedis(t)
+	defer mr.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/captcha?provider=pow", nil)
+	rr := httptest.NewRecorder()
+
+	handlers.CaptchaHandler(rr, req, rdb)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("content-type=%q", ct)
+	}
+	id := rr.Header().Get("X-Captcha-ID")
+	if !mr.Exists("captcha:pow:" + id) {
+		t.Fatalf("pow captcha not saved in redis")
+	}
+	if mr.Exists("captcha:" + id) {
+		t.Fatalf("pow captcha must not be redeemable as an image captcha")
+	}
+}
+
 func TestVerifyHandler_OKAndFail(t *testing.T) {
+	enableCaptcha(t)
 	mr, rdb := testutil.NewMiniRedis(t)
 	defer mr.Close()
 
@@ -78,3 +116,16 @@ func TestAuthHandler_InvalidJSON(t *testing.T) {
 		t.Fatalf("want 400, got %d", rr.Code)
 	}
 }
+
+func TestAuthHandler_PasswordTooLong(t *testing.T) {
+	_, rdb := testutil.NewMiniRedis(t)
+
+	// rejected before the user is looked up, no database is needed
+	body := `{"username":"alice","password":"` + strings.Repeat("a", auth.MaxPasswordLength+1) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/auth", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handlers.AuthHandler(rr, req, rdb)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("want 401, got %d", rr.Code)
+	}
+}
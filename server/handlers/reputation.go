@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"mFrelance/db"
+	"mFrelance/reputation"
+	"mFrelance/server"
+)
+
+// ReputationHandler godoc
+// @Summary Get a user's reputation
+// @Description Returns the reputation score (0-100) of a user, the components it was computed from and the history of score changes, newest first. Scores are recalculated when a task is completed, a dispute resolved or a review published, and refreshed daily.
+// @Tags reviews
+// @Produce json
+// @Param user_id query int true "User ID"
+// @Param limit query int false "History entries, default 50, max 200"
+// @Param offset query int false "History offset"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"reputation\": {\"user_id\": 7, \"score\": 87.4, \"rating\": 4.61, \"review_count\": 23, \"contracts\": 25, \"completion_rate\": 0.96, \"dispute_loss_rate\": 0.04, \"on_time_rate\": 0.9, \"earnings_score\": 0.78, \"account_age_days\": 410, \"updated_at\": \"2025-01-01T12:00:00Z\"}, \"history\": [{\"id\": 90, \"user_id\": 7, \"score\": 87.4, \"reason\": \"review\", \"created_at\": \"2025-01-01T12:00:00Z\"}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"invalid user_id\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"user not found\"}"
+// @Security BearerAuth
+// @Router /api/reputation [get]
+func ReputationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil || userID <= 0 {
+		server.WriteErrorJSON(w, "invalid user_id", http.StatusBadRequest)
+		return
+	}
+	if name, err := db.GetUsernameByID(db.Postgres, userID); err != nil {
+		server.WriteErrorJSON(w, "failed to load user", http.StatusInternalServerError)
+		return
+	} else if name == "" {
+		server.WriteErrorJSON(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	rep, err := db.GetReputation(db.Postgres, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		rep, err = reputation.Recalculate(userID, reputation.ReasonRefresh)
+	}
+	if err != nil {
+		log.Println("[ReputationHandler]", err)
+		server.WriteErrorJSON(w, "failed to load reputation", http.StatusInternalServerError)
+		return
+	}
+
+	limit, offset := pageParams(r)
+	history, err := db.ListReputationHistory(db.Postgres, userID, limit, offset)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load reputation history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"reputation": rep,
+		"history":    history,
+	})
+}
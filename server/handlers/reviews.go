@@ -1,22 +1,57 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
+	"mFrelance/config"
 	"mFrelance/db"
 	"mFrelance/models"
+	"mFrelance/reputation"
 	"mFrelance/server"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/jmoiron/sqlx"
 )
+
+// maxReviewReply limits the reply of the reviewed user
+const maxReviewReply = 2000
+
+type CreateReviewRequest struct {
+	TaskID        int64  `json:"task_id"`
+	Rating        int    `json:"rating"`
+	Communication int    `json:"communication"`
+	Quality       int    `json:"quality"`
+	Timeliness    int    `json:"timeliness"`
+	Comment       string `json:"comment"`
+}
+
+type ReplyReviewRequest struct {
+	ReviewID int64  `json:"review_id"`
+	Reply    string `json:"reply"`
+}
+
+type HideReviewRequest struct {
+	ReviewID int64  `json:"review_id"`
+	Hidden   bool   `json:"hidden"`
+	Reason   string `json:"reason"`
+}
+
+func validRating(v int) bool {
+	return v >= 1 && v <= 5
+}
 // CreateReviewHandler godoc
 // @Summary Submit Task Review
-// @Description Allows clients or accepted freelancers to submit a review for a completed task. Each user can only review a task once.
+// @Description Allows clients or accepted freelancers to submit a review for a completed task. Each user can only review a task once. In double-blind mode the review stays unpublished (published_at is null) until the other party reviews the task too or the blind window runs out.
 // @Tags reviews
 // @Accept json
 // @Produce json
-// @Param review body models.Review true "Review data including task ID, rating (1-5), and optional comment"
-// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"review\": {\"id\": 123, \"task_id\": 456, \"reviewer_id\": 78, \"reviewed_id\": 90, \"rating\": 5, \"comment\": \"Great work!\", \"created_at\": \"2023-12-01T10:00:00Z\"}}"
+// @Param review body CreateReviewRequest true "Task ID, overall rating and communication, quality and timeliness sub-ratings (1-5 each), and an optional comment"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"review\": {\"id\": 123, \"task_id\": 456, \"reviewer_id\": 78, \"reviewed_id\": 90, \"rating\": 5, \"communication_rating\": 5, \"quality_rating\": 4, \"timeliness_rating\": 5, \"comment\": \"Great work!\", \"published_at\": null, \"created_at\": \"2023-12-01T10:00:00Z\"}}"
 // @Failure 400 {object} map[string]string "Example: {\"error\": \"Task is not completed\"}"
 // @Failure 401 {object} map[string]string "Example: {\"error\": \"Unauthorized\"}"
 // @Failure 403 {object} map[string]string "Example: {\"error\": \"Forbidden\"}"
@@ -31,11 +66,19 @@ func CreateReviewHandler() http.HandlerFunc {
 			return
 		}
 
-		var review models.Review
-		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+		var req CreateReviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid JSON", http.StatusBadRequest)
 			return
 		}
+		review := models.Review{
+			TaskID:              req.TaskID,
+			Rating:              req.Rating,
+			CommunicationRating: &req.Communication,
+			QualityRating:       &req.Quality,
+			TimelinessRating:    &req.Timeliness,
+			Comment:             req.Comment,
+		}
 
 		claims := server.GetUserFromContext(r)
 		if claims == nil {
@@ -63,7 +106,7 @@ func CreateReviewHandler() http.HandlerFunc {
 
 		var acceptedOffer *models.TaskOffer
 		for _, offer := range offers {
-			if offer.Accepted {
+			if offer.Status == models.OfferAccepted {
 				acceptedOffer = offer
 				break
 			}
@@ -99,15 +142,21 @@ func CreateReviewHandler() http.HandlerFunc {
 			review.ReviewedID = task.ClientID
 		}
 
-		if review.Rating < 1 || review.Rating > 5 {
+		if !validRating(review.Rating) || !validRating(req.Communication) || !validRating(req.Quality) || !validRating(req.Timeliness) {
 			http.Error(w, "Rating must be between 1 and 5", http.StatusBadRequest)
 			return
 		}
 
-		if err := db.CreateReview(&review); err != nil {
+		published, err := db.SubmitReview(&review, config.AppConfig.ReviewDoubleBlind)
+		if errors.Is(err, db.ErrReviewExists) {
+			http.Error(w, "Review already exists for this task", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
 			http.Error(w, "Failed to create review", http.StatusInternalServerError)
 			return
 		}
+		server.NotifyReviewsPublished(published)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -118,7 +167,7 @@ func CreateReviewHandler() http.HandlerFunc {
 }
 // GetReviewsByUserHandler godoc
 // @Summary Get Reviews for User
-// @Description Retrieves all reviews received by a specific user (reviews about them). Used to display user reputation and feedback history.
+// @Description Retrieves the published reviews received by a specific user (reviews about them), with the user's reply if any. Hidden reviews and double-blind reviews still waiting for the other party are left out.
 // @Tags reviews
 // @Produce json
 // @Param user_id query int true "ID of the user to get reviews for"
@@ -156,7 +205,7 @@ func GetReviewsByUserHandler() http.HandlerFunc {
 }
 // GetReviewsByTaskHandler godoc
 // @Summary Get Reviews for Task
-// @Description Retrieves all reviews submitted for a specific task. Used to display feedback on task completion pages.
+// @Description Retrieves the published reviews of a specific task, plus the caller's own review while it waits for the other party in double-blind mode.
 // @Tags reviews
 // @Produce json
 // @Param task_id query int true "ID of the task to get reviews for"
@@ -179,7 +228,13 @@ func GetReviewsByTaskHandler() http.HandlerFunc {
 			return
 		}
 
-		reviews, err := db.GetReviewsByTaskID(taskID)
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		reviews, err := db.GetReviewsByTaskID(taskID, claims.UserID)
 		if err != nil {
 			http.Error(w, "Failed to get reviews", http.StatusInternalServerError)
 			return
@@ -194,11 +249,11 @@ func GetReviewsByTaskHandler() http.HandlerFunc {
 }
 // GetUserRatingHandler godoc
 // @Summary Get User Average Rating
-// @Description Calculates and returns the average rating received by a user across all their completed tasks. Used for reputation scoring.
+// @Description Calculates the average overall rating and the average communication, quality and timeliness sub-ratings over the published, non-hidden reviews a user received.
 // @Tags reviews
 // @Produce json
 // @Param user_id query int true "ID of the user to get average rating for"
-// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"rating\": 4.5}"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"rating\": 4.5, \"breakdown\": {\"rating\": 4.5, \"communication\": 4.8, \"quality\": 4.3, \"timeliness\": 4.6, \"count\": 12}}"
 // @Failure 400 {object} map[string]string "Example: {\"error\": \"Invalid user ID\"}"
 // @Failure 500 {object} map[string]string "Example: {\"error\": \"Database error\"}"
 // @Security BearerAuth
@@ -223,11 +278,199 @@ func GetUserRatingHandler() http.HandlerFunc {
 			return
 		}
 
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"rating":    rating.Rating,
+			"breakdown": rating,
+		})
+	}
+}
+
+
+// ReplyReviewHandler godoc
+// @Summary Reply to a review
+// @Description The reviewed user can publicly reply once to a published review about them. The reply can't be edited.
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param request body ReplyReviewRequest true "Review and reply text"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"review\": {\"id\": 123, \"reply\": \"Thanks!\", \"replied_at\": \"2023-12-02T10:00:00Z\"}}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"Reply is required\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"Review not found or already answered\"}"
+// @Security BearerAuth
+// @Router /api/reviews/reply [post]
+func ReplyReviewHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req ReplyReviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		req.Reply = strings.TrimSpace(req.Reply)
+		if req.Reply == "" {
+			http.Error(w, "Reply is required", http.StatusBadRequest)
+			return
+		}
+		if len(req.Reply) > maxReviewReply {
+			http.Error(w, "Reply is too long", http.StatusBadRequest)
+			return
+		}
+
+		review, err := db.ReplyToReview(req.ReviewID, claims.UserID, req.Reply)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Review not found or already answered", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to save reply", http.StatusInternalServerError)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": true,
-			"rating":  rating,
+			"review":  review,
 		})
 	}
 }
 
+// disputeLostBy reports whether the resolved dispute went against userID
+func disputeLostBy(d *models.Dispute, task *models.Task, userID int64) bool {
+	if d.Status != "resolved" || d.Resolution == nil {
+		return false
+	}
+	if userID == task.ClientID {
+		return *d.Resolution == "freelancer_won"
+	}
+	return *d.Resolution == "client_won"
+}
+
+// HideReviewHandler godoc
+// @Summary Hide or restore a review
+// @Description Hides a review from everyone, including rating aggregation, or makes it visible again. Only reviews of tasks with a dispute resolved against the reviewer can be hidden. Both actions are written to the audit log.
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param request body HideReviewRequest true "Review, hidden flag and a reason"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"review\": {\"id\": 123, \"hidden_at\": \"2023-12-02T10:00:00Z\", \"hidden_reason\": \"retaliation after lost dispute\"}}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"No dispute on this task was resolved against the reviewer\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"insufficient permissions\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"Review not found\"}"
+// @Security BearerAuth
+// @Router /api/admin/reviews/hide [post]
+func HideReviewHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req HideReviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+
+		review, err := db.GetReviewByID(req.ReviewID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Review not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to load review", http.StatusInternalServerError)
+			return
+		}
+		if req.Hidden == (review.HiddenAt != nil) {
+			http.Error(w, "Review is already in that state", http.StatusBadRequest)
+			return
+		}
+
+		action := db.AuditReviewUnhide
+		var disputeID int64
+		if req.Hidden {
+			action = db.AuditReviewHide
+			if req.Reason == "" {
+				http.Error(w, "Reason is required", http.StatusBadRequest)
+				return
+			}
+			task, err := db.GetTask(db.Postgres, review.TaskID)
+			if err != nil {
+				http.Error(w, "Task not found", http.StatusNotFound)
+				return
+			}
+			disputes, err := db.GetDisputesByTaskID(review.TaskID)
+			if err != nil {
+				http.Error(w, "Failed to get disputes", http.StatusInternalServerError)
+				return
+			}
+			for _, d := range disputes {
+				if disputeLostBy(d, task, review.ReviewerID) {
+					disputeID = d.ID
+					break
+				}
+			}
+			if disputeID == 0 {
+				http.Error(w, "No dispute on this task was resolved against the reviewer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		e := newAuditEntry(r, action, "review", review.ID, req.Reason)
+		err = runAudited(e, func(tx *sqlx.Tx) (interface{}, interface{}, error) {
+			if err := db.SetReviewHiddenTx(tx, review.ID, req.Hidden, claims.UserID, req.Reason); err != nil {
+				return nil, nil, err
+			}
+			before := map[string]interface{}{
+				"hidden":        review.HiddenAt != nil,
+				"hidden_reason": review.HiddenReason,
+				"task_id":       review.TaskID,
+				"reviewer_id":   review.ReviewerID,
+				"reviewed_id":   review.ReviewedID,
+				"rating":        review.Rating,
+			}
+			after := map[string]interface{}{"hidden": req.Hidden}
+			if disputeID != 0 {
+				after["dispute_id"] = disputeID
+			}
+			return before, after, nil
+		})
+		if err != nil {
+			log.Println("[HideReviewHandler]", err)
+			http.Error(w, "Failed to update review", http.StatusInternalServerError)
+			return
+		}
+
+		reputation.Touch(reputation.ReasonReview, review.ReviewedID)
+
+		review, err = db.GetReviewByID(req.ReviewID)
+		if err != nil {
+			http.Error(w, "Failed to load review", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"review":  review,
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"mFrelance/auth"
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/notifications"
+	"mFrelance/server"
+)
+
+type TaskInviteRequest struct {
+	TaskID       int64  `json:"task_id"`
+	FreelancerID int64  `json:"freelancer_id"`
+	Message      string `json:"message"`
+}
+
+// canSeeTask reports whether the task may be shown to the user. Invite-only
+// tasks are hidden from everyone but the client, the freelancers invited or
+// bidding on it and moderators.
+func canSeeTask(task *models.Task, claims *auth.Claims) (bool, error) {
+	if task.Visibility != models.VisibilityInvite {
+		return true, nil
+	}
+	if claims == nil {
+		return false, nil
+	}
+	if task.ClientID == claims.UserID || server.HasPermission(claims, server.PermTaskModerate) {
+		return true, nil
+	}
+	return db.CanSeeTask(db.Postgres, task.ID, claims.UserID)
+}
+
+// TaskInvitesHandler godoc
+// @Summary List or send task invites
+// @Description GET returns the freelancers invited to a task. POST invites a freelancer to an open task, or changes the message of an invite; the freelancer gets a task.invited notification. Invites work on tasks of any visibility, but only make a difference on invite-only tasks, which only invited freelancers can see and make offers on. Task owner only.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param task_id query int false "Task ID (GET)"
+// @Param request body TaskInviteRequest false "Invite (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"invites\": [{\"task_id\": 42, \"freelancer_id\": 7, \"username\": \"alice\", \"message\": \"Same as last time?\", \"created_at\": \"2025-01-01T12:00:00Z\"}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"task is not open\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"only the task owner can manage invites\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"user not found\"}"
+// @Security BearerAuth
+// @Router /api/tasks/invites [get]
+// @Router /api/tasks/invites [post]
+func TaskInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req TaskInviteRequest
+	switch r.Method {
+	case http.MethodGet:
+		id, err := strconv.ParseInt(r.URL.Query().Get("task_id"), 10, 64)
+		if err != nil {
+			server.WriteErrorJSON(w, "invalid task_id", http.StatusBadRequest)
+			return
+		}
+		req.TaskID = id
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	task, err := db.GetTask(db.Postgres, req.TaskID)
+	if err != nil {
+		server.WriteErrorJSON(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if task.ClientID != claims.UserID {
+		server.WriteErrorJSON(w, "only the task owner can manage invites", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if task.Status != "open" {
+			server.WriteErrorJSON(w, "task is not open", http.StatusBadRequest)
+			return
+		}
+		if req.FreelancerID == claims.UserID {
+			server.WriteErrorJSON(w, "cannot invite yourself", http.StatusBadRequest)
+			return
+		}
+		if name, err := db.GetUsernameByID(db.Postgres, req.FreelancerID); err != nil {
+			server.WriteErrorJSON(w, "failed to load user", http.StatusInternalServerError)
+			return
+		} else if name == "" {
+			server.WriteErrorJSON(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if blocked, err := db.IsUserBlocked(db.Postgres, req.FreelancerID); err != nil {
+			server.WriteErrorJSON(w, "failed to check user status", http.StatusInternalServerError)
+			return
+		} else if blocked {
+			server.WriteErrorJSON(w, "user is blocked", http.StatusBadRequest)
+			return
+		}
+		if blocked, err := isBlockedFor(claims.UserID, req.FreelancerID); err != nil {
+			server.WriteErrorJSON(w, "failed to check user status", http.StatusInternalServerError)
+			return
+		} else if blocked {
+			server.WriteErrorJSON(w, "you cannot invite this user", http.StatusForbidden)
+			return
+		}
+
+		invite := &models.TaskInvite{TaskID: task.ID, FreelancerID: req.FreelancerID, Message: req.Message}
+		created, err := db.CreateTaskInvite(db.Postgres, invite)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to invite user", http.StatusInternalServerError)
+			return
+		}
+		if created {
+			notifications.Publish(notifications.Event{
+				Type:  notifications.TypeTaskInvited,
+				Title: "You're invited to a task",
+				Body:  task.Title,
+				Data: map[string]interface{}{
+					"task_id":   task.ID,
+					"client_id": task.ClientID,
+					"message":   invite.Message,
+				},
+			}, invite.FreelancerID)
+		}
+	}
+
+	invites, err := db.ListTaskInvites(db.Postgres, task.ID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load invites", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"invites": invites,
+	})
+}
+
+// RevokeTaskInviteHandler godoc
+// @Summary Revoke a task invite
+// @Description Removes a freelancer's invite to a task (task owner only). An open offer of the freelancer on the task is rejected.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param request body TaskInviteRequest true "task_id and freelancer_id"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"only the task owner can manage invites\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"user is not invited\"}"
+// @Security BearerAuth
+// @Router /api/tasks/invites/delete [post]
+func RevokeTaskInviteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var req TaskInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	task, err := db.GetTask(db.Postgres, req.TaskID)
+	if err != nil {
+		server.WriteErrorJSON(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if task.ClientID != claims.UserID {
+		server.WriteErrorJSON(w, "only the task owner can manage invites", http.StatusForbidden)
+		return
+	}
+
+	offer, err := db.DeleteTaskInvite(db.Postgres, task.ID, req.FreelancerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "user is not invited", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to revoke invite", http.StatusInternalServerError)
+		return
+	}
+	if offer != nil {
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeOfferRejected,
+			Title: "Offer rejected",
+			Body:  fmt.Sprintf("%s: your invite was revoked", task.Title),
+			Data: map[string]interface{}{
+				"task_id":  task.ID,
+				"offer_id": offer.ID,
+			},
+		}, offer.FreelancerID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// MyTaskInvitesHandler godoc
+// @Summary List my task invites
+// @Description Returns the open tasks the current user was invited to, newest invite first
+// @Tags tasks
+// @Produce json
+// @Param limit query int false "Page size, default 50, max 200"
+// @Param offset query int false "Offset"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"tasks\": [{\"id\": 42, \"title\": \"Website Design\", \"visibility\": \"invite\", \"status\": \"open\"}]}"
+// @Security BearerAuth
+// @Router /api/invites [get]
+func MyTaskInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	limit, offset := pageParams(r)
+	tasks, err := db.ListInvitedTasks(db.Postgres, claims.UserID, limit, offset)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load invites", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"tasks":   tasks,
+	})
+}
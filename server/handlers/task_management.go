@@ -11,6 +11,7 @@ import (
 	"mFrelance/db"
 	"mFrelance/models"
 	"mFrelance/server"
+	"mFrelance/webhooks"
 )
 
 type CreateTaskRequest struct {
@@ -32,12 +33,12 @@ type UpdateTaskRequest struct {
 
 // CreateTaskHandler godoc
 // @Summary Create a new task
-// @Description Allows a user to create a new task
+// @Description Allows a user to create a new task. With template_id the fields left out are taken from the client's template. visibility is public (default, listed with the open tasks), unlisted (only reachable by its ID) or invite (only the freelancers the client invites through /api/tasks/invite can see it and make offers). With org_id the task is posted for an organization the user is an active member of and paid from its wallet.
 // @Tags tasks
 // @Accept json
 // @Produce json
 // @Param body body CreateTaskRequest true "Task payload"
-// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"task\": {\"id\": 123, \"title\": \"Website Design\", \"description\": \"Need a modern website\", \"price\": 500.0, \"currency\": \"USD\", \"deadline\": \"2023-12-31T23:59:59Z\", \"client_id\": 456, \"status\": \"open\", \"created_at\": \"2023-12-01T10:00:00Z\"}}"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"task\": {\"id\": 123, \"title\": \"Website Design\", \"description\": \"Need a modern website\", \"price\": 500.0, \"currency\": \"USD\", \"deadline\": \"2023-12-31T23:59:59Z\", \"client_id\": 456, \"status\": \"open\", \"visibility\": \"public\", \"created_at\": \"2023-12-01T10:00:00Z\"}}"
 // @Failure 400 {string} string "Example: \"Invalid JSON\""
 // @Failure 401 {string} string "Example: \"Unauthorized\""
 // @Failure 500 {string} string "Example: \"Failed to create task\""
@@ -84,10 +85,28 @@ func CreateTaskHandler() http.HandlerFunc {
 
 		log.Printf("[CreateTaskHandler] User ID: %d", userID)
 
-		// Duplicate content protection: last N hours same title+description
+		task.ScheduleID = nil
+		if task.TemplateID != nil {
+			tmpl, err := db.GetTaskTemplate(db.Postgres, *task.TemplateID)
+			if err != nil || tmpl.ClientID != userID {
+				http.Error(w, "Template not found", http.StatusNotFound)
+				return
+			}
+			applyTemplate(&task, tmpl)
+		}
+		// tasks posted for an organization are paid from its wallet
+		if task.OrgID != nil {
+			if m, err := db.GetOrgMember(db.Postgres, *task.OrgID, userID); err != nil || !m.Active() {
+				http.Error(w, "You are not a member of this organization", http.StatusForbidden)
+				return
+			}
+		}
+
+		// Duplicate content protection: last N hours same title+description.
+		// Tasks posted by schedules are left out of both limits.
 		dupWindow := config.AppConfig.TaskDuplicateWindow
 		var nDup int64
-		err := db.Postgres.Get(&nDup, `SELECT COUNT(*) FROM tasks WHERE client_id=$1 AND title=$2 AND description=$3 AND created_at > now() - $4::interval`, userID, task.Title, task.Description, dupWindow.String())
+		err := db.Postgres.Get(&nDup, `SELECT COUNT(*) FROM tasks WHERE client_id=$1 AND title=$2 AND description=$3 AND created_at > now() - $4::interval AND schedule_id IS NULL`, userID, task.Title, task.Description, dupWindow.String())
 		if err == nil && nDup > 0 {
 			http.Error(w, "Duplicate task detected", http.StatusBadRequest)
 			return
@@ -97,16 +116,32 @@ func CreateTaskHandler() http.HandlerFunc {
 		if !config.AppConfig.TaskRateLimitDisabled {
 			minInt := config.AppConfig.TaskMinInterval
 			var last time.Time
-			err = db.Postgres.Get(&last, `SELECT COALESCE(max(created_at), to_timestamp(0)) FROM tasks WHERE client_id=$1`, userID)
+			err = db.Postgres.Get(&last, `SELECT COALESCE(max(created_at), to_timestamp(0)) FROM tasks WHERE client_id=$1 AND schedule_id IS NULL`, userID)
 			if err == nil && time.Since(last) < minInt {
 				http.Error(w, "Rate limit: please wait before creating another task", http.StatusTooManyRequests)
 				return
 			}
 		}
 
+		switch task.ContractType {
+		case "":
+			task.ContractType = models.ContractFixed
+		case models.ContractFixed, models.ContractHourly:
+		default:
+			http.Error(w, "contract_type must be fixed or hourly", http.StatusBadRequest)
+			return
+		}
+		if task.Visibility == "" {
+			task.Visibility = models.VisibilityPublic
+		} else if !models.IsVisibility(task.Visibility) {
+			http.Error(w, "visibility must be public, unlisted or invite", http.StatusBadRequest)
+			return
+		}
+
 		task.ClientID = userID
 		task.Status = "open"
 		task.CreatedAt = time.Now()
+		task.EndedAt = nil
 
 		log.Printf("[CreateTaskHandler] Task before creation: %+v", task)
 
@@ -117,6 +152,7 @@ func CreateTaskHandler() http.HandlerFunc {
 		}
 
 		log.Printf("[CreateTaskHandler] Task created successfully with ID: %d", task.ID)
+		webhooks.Emit(webhooks.EventTaskCreated, task, userID)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -127,7 +163,7 @@ func CreateTaskHandler() http.HandlerFunc {
 }
 // GetTasksHandler godoc
 // @Summary Get tasks
-// @Description Returns list of tasks. Use query param `status=open` to get only open tasks
+// @Description Returns list of tasks. Use query param `status=open` to get the open public tasks; without it the user's own tasks are returned
 // @Tags tasks
 // @Produce json
 // @Param status query string false "Filter tasks by status"
@@ -203,7 +239,7 @@ func GetTasksHandler() http.HandlerFunc {
 }
 // GetTaskHandler godoc
 // @Summary Get task details
-// @Description Returns details of a single task
+// @Description Returns details of a single task. Invite-only tasks are only returned to the client, the invited freelancers, freelancers with an offer on the task and moderators.
 // @Tags tasks
 // @Produce json
 // @Param id query int true "Task ID"
@@ -231,6 +267,13 @@ func GetTaskHandler() http.HandlerFunc {
 			http.Error(w, "Task not found", http.StatusNotFound)
 			return
 		}
+		if ok, err := canSeeTask(task, server.GetUserFromContext(r)); err != nil {
+			http.Error(w, "Failed to get task", http.StatusInternalServerError)
+			return
+		} else if !ok {
+			http.Error(w, "Task not found", http.StatusNotFound)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -241,7 +284,7 @@ func GetTaskHandler() http.HandlerFunc {
 }
 // UpdateTaskHandler godoc
 // @Summary Update a task
-// @Description Allows the task owner to update a task
+// @Description Allows the task owner to update a task. Leaving out visibility keeps the current one.
 // @Tags tasks
 // @Accept json
 // @Produce json
@@ -284,6 +327,12 @@ func UpdateTaskHandler() http.HandlerFunc {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
+		if task.Visibility == "" {
+			task.Visibility = existingTask.Visibility
+		} else if !models.IsVisibility(task.Visibility) {
+			http.Error(w, "visibility must be public, unlisted or invite", http.StatusBadRequest)
+			return
+		}
 
 		if err := db.UpdateTask(db.Postgres, &task); err != nil {
 			http.Error(w, "Failed to update task", http.StatusInternalServerError)
@@ -338,17 +387,9 @@ func DeleteTaskHandler() http.HandlerFunc {
 			return
 		}
 
-		if existingTask.ClientID != userID {
-			// allow admin
-			isAdmin, err := db.IsAdmin(db.Postgres, userID)
-			if err != nil {
-				http.Error(w, "Failed to check admin", http.StatusInternalServerError)
-				return
-			}
-			if !isAdmin {
-				http.Error(w, "Forbidden", http.StatusForbidden)
-				return
-			}
+		if existingTask.ClientID != userID && !server.HasPermission(claims, server.PermTaskModerate) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
 		}
 
 		if err := db.DeleteTask(db.Postgres, taskID); err != nil {
@@ -1,11 +1,17 @@
 package handlers
 
 import (
+    "database/sql"
     "encoding/json"
+    "errors"
+    "fmt"
     "mFrelance/config"
     "mFrelance/db"
     "mFrelance/models"
+    "mFrelance/notifications"
+    "mFrelance/reputation"
     "mFrelance/server"
+    "mFrelance/webhooks"
     "net/http"
     "math/big"
     "strconv"
@@ -24,14 +30,67 @@ type AcceptTaskOfferRequest struct {
 type CompleteTaskRequest struct {
 	TaskID int64 `json:"task_id"`
 }
+
+type CounterTaskOfferRequest struct {
+	OfferID    int64    `json:"offer_id"`
+	Price      float64  `json:"price"`
+	HourlyRate *float64 `json:"hourly_rate"`
+	WeeklyCap  *float64 `json:"weekly_cap"`
+	Message    string   `json:"message"`
+}
+
+type OfferActionRequest struct {
+	OfferID int64 `json:"offer_id"`
+}
+
+// maxWeeklyCap is the most hours a week has
+const maxWeeklyCap = 168
+
+// normalizeOffer checks the pricing fields of an offer against the task's
+// contract type. Offers on hourly tasks are priced at one week at the cap,
+// which is what the client prefunds into escrow on acceptance. Returns a
+// message for the user when the offer is invalid.
+func normalizeOffer(task *models.Task, offer *models.TaskOffer) string {
+	if task.ContractType != models.ContractHourly {
+		offer.HourlyRate = nil
+		offer.WeeklyCap = nil
+		return ""
+	}
+	if offer.HourlyRate == nil || *offer.HourlyRate <= 0 {
+		return "hourly_rate is required for hourly tasks"
+	}
+	if offer.WeeklyCap == nil || *offer.WeeklyCap <= 0 || *offer.WeeklyCap > maxWeeklyCap {
+		return fmt.Sprintf("weekly_cap must be between 0 and %d hours", maxWeeklyCap)
+	}
+	offer.Price = *offer.HourlyRate * *offer.WeeklyCap
+	return ""
+}
+
+// offerExpiry returns when an offer expires: at the requested time, or
+// offers.default_ttl from now. Returns a message for the user when the
+// requested time is in the past or beyond offers.max_ttl.
+func offerExpiry(requested *time.Time) (*time.Time, string) {
+	now := time.Now()
+	if requested == nil {
+		t := now.Add(config.AppConfig.OfferDefaultTTL)
+		return &t, ""
+	}
+	if !requested.After(now) {
+		return nil, "expires_at must be in the future"
+	}
+	if requested.After(now.Add(config.AppConfig.OfferMaxTTL)) {
+		return nil, fmt.Sprintf("expires_at can be at most %s away", config.AppConfig.OfferMaxTTL)
+	}
+	return requested, ""
+}
 // CreateTaskOfferHandler godoc
 // @Summary Create a task offer
-// @Description Allows a freelancer to make an offer on an open task
+// @Description Allows a freelancer to make an offer on an open task. Invite-only tasks only take offers from invited freelancers. The offer expires at expires_at, or after offers.default_ttl. Offers on hourly tasks carry hourly_rate and weekly_cap (hours) instead of a price; their price is set to one week at the cap, which the client prefunds into escrow when accepting. With org_id the offer is made by an agency for freelancer_id (default: the caller), an active member; owners and managers can make offers for other members. Agency offers are paid out to the organization wallet.
 // @Tags offers
 // @Accept json
 // @Produce json
 // @Param body body CreateTaskOfferRequest true "Offer payload"
-// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"offer\": {\"id\": 123, \"task_id\": 456, \"freelancer_id\": 78, \"price\": 50.0, \"message\": \"I can do this\", \"accepted\": false, \"created_at\": \"2023-12-01T10:00:00Z\"}}"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"offer\": {\"id\": 123, \"task_id\": 456, \"freelancer_id\": 78, \"price\": 50.0, \"message\": \"I can do this\", \"status\": \"pending\", \"expires_at\": \"2023-12-15T10:00:00Z\", \"created_at\": \"2023-12-01T10:00:00Z\"}}"
 // @Failure 400 {string} string "Example: \"Invalid JSON\""
 // @Failure 401 {string} string "Example: \"Unauthorized\""
 // @Failure 404 {string} string "Example: \"Task not found\""
@@ -67,6 +126,32 @@ func CreateTaskOfferHandler() http.HandlerFunc {
             return
         }
 
+		// An agency makes offers for one of its members, who does the work
+		// and is checked below in place of the caller
+		if offer.OrgID != nil {
+			assignee := offer.FreelancerID
+			if assignee == 0 {
+				assignee = userID
+			}
+			if reason, err := checkOrgAssignee(*offer.OrgID, userID, assignee); err != nil {
+				http.Error(w, "Failed to check organization membership", http.StatusInternalServerError)
+				return
+			} else if reason != "" {
+				http.Error(w, reason, http.StatusForbidden)
+				return
+			}
+			if assignee != userID {
+				if blocked, err := db.IsUserBlocked(db.Postgres, assignee); err != nil {
+					http.Error(w, "Failed to check user status", http.StatusInternalServerError)
+					return
+				} else if blocked {
+					http.Error(w, "User is blocked", http.StatusForbidden)
+					return
+				}
+			}
+			userID = assignee
+		}
+
 		task, err := db.GetTask(db.Postgres, offer.TaskID)
 		if err != nil {
 			http.Error(w, "Task not found", http.StatusNotFound)
@@ -82,6 +167,26 @@ func CreateTaskOfferHandler() http.HandlerFunc {
 			http.Error(w, "Cannot make offer on your own task", http.StatusBadRequest)
 			return
 		}
+		if task.OrgID != nil && offer.OrgID != nil && *task.OrgID == *offer.OrgID {
+			http.Error(w, "Cannot make offer on your organization's task", http.StatusBadRequest)
+			return
+		}
+		if task.Visibility == models.VisibilityInvite {
+			if invited, err := db.IsTaskInvited(db.Postgres, task.ID, userID); err != nil {
+				http.Error(w, "Failed to check invites", http.StatusInternalServerError)
+				return
+			} else if !invited {
+				http.Error(w, "Task not found", http.StatusNotFound)
+				return
+			}
+		}
+		if blocked, err := isBlockedFor(userID, task.ClientID); err != nil {
+			http.Error(w, "Failed to check user status", http.StatusInternalServerError)
+			return
+		} else if blocked {
+			http.Error(w, "You cannot make offers to this client", http.StatusForbidden)
+			return
+		}
 
         // Limit: only one offer per user per task
         existingOffers, err := db.GetTaskOffersByTaskID(db.Postgres, offer.TaskID)
@@ -97,8 +202,17 @@ func CreateTaskOfferHandler() http.HandlerFunc {
         }
 
 		offer.FreelancerID = userID
-		offer.Accepted = false
+		offer.Status = models.OfferPending
 		offer.CreatedAt = time.Now()
+		if msg := normalizeOffer(task, &offer); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		var msg string
+		if offer.ExpiresAt, msg = offerExpiry(offer.ExpiresAt); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
 
 		// Check minimum transaction amount
 		if offer.Price < config.AppConfig.MinTransactionAmount {
@@ -110,6 +224,19 @@ func CreateTaskOfferHandler() http.HandlerFunc {
 			http.Error(w, "Failed to create offer", http.StatusInternalServerError)
 			return
 		}
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeOfferCreated,
+			Title: "New offer on your task",
+			Body:  fmt.Sprintf("%s: %v %s", task.Title, offer.Price, task.Currency),
+			Data: map[string]interface{}{
+				"task_id":       task.ID,
+				"offer_id":      offer.ID,
+				"freelancer_id": offer.FreelancerID,
+				"price":         offer.Price,
+				"currency":      task.Currency,
+			},
+		}, task.ClientID)
+		reputation.Touch(reputation.ReasonOffer, offer.FreelancerID)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -121,13 +248,13 @@ func CreateTaskOfferHandler() http.HandlerFunc {
 
 // UpdateTaskOfferHandler godoc
 // @Summary Update user's own task offer
-// @Description Allows a freelancer to update their own offer (not accepted yet)
+// @Description Allows a freelancer to change the terms of their own offer while it is pending or countered. Owners and managers of the organization that made an agency offer can change it too. The new terms are recorded in the offer's revisions and the offer becomes pending again; this is also how a freelancer agrees to a counter-offer. The expiry is reset to expires_at or offers.default_ttl.
 // @Tags offers
 // @Accept json
 // @Produce json
 // @Param offer body models.TaskOffer true "Offer data"
 // @Success 200 {object} map[string]interface{} "success and updated offer"
-// @Failure 400 {object} map[string]string "Invalid JSON or accepted offer cannot be edited"
+// @Failure 400 {object} map[string]string "Invalid JSON or offer is no longer open"
 // @Failure 401 {object} map[string]string "Unauthorized"
 // @Failure 403 {object} map[string]string "Forbidden (not owner)"
 // @Failure 404 {object} map[string]string "Offer not found"
@@ -160,24 +287,68 @@ func UpdateTaskOfferHandler() http.HandlerFunc {
 			http.Error(w, "Offer not found", http.StatusNotFound)
 			return
 		}
-		if existing.FreelancerID != claims.UserID {
+		if ok, err := canActForOffer(existing, claims.UserID); err != nil {
+			http.Error(w, "Failed to check organization membership", http.StatusInternalServerError)
+			return
+		} else if !ok {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
-		if existing.Accepted {
-			http.Error(w, "Accepted offer cannot be edited", http.StatusBadRequest)
+		if !existing.Open() {
+			http.Error(w, "Offer is no longer open", http.StatusBadRequest)
 			return
 		}
 
 		// Keep immutable fields
 		offer.TaskID = existing.TaskID
 		offer.FreelancerID = existing.FreelancerID
-		offer.Accepted = false
+		offer.OrgID = existing.OrgID
+		offer.CreatedAt = existing.CreatedAt
 
-		if err := db.UpdateTaskOffer(db.Postgres, &offer); err != nil {
+		task, err := db.GetTask(db.Postgres, existing.TaskID)
+		if err != nil {
+			http.Error(w, "Task not found", http.StatusNotFound)
+			return
+		}
+		if task.Status != "open" {
+			http.Error(w, "Task is not open for offers", http.StatusBadRequest)
+			return
+		}
+		if msg := normalizeOffer(task, &offer); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		if offer.Price < config.AppConfig.MinTransactionAmount {
+			http.Error(w, "Offer amount is below minimum transaction amount", http.StatusBadRequest)
+			return
+		}
+		var msg string
+		if offer.ExpiresAt, msg = offerExpiry(offer.ExpiresAt); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		err = db.ReviseTaskOffer(db.Postgres, &offer, claims.UserID, models.RevisionOffer, offer.Message)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Offer is no longer open", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
 			http.Error(w, "Failed to update offer", http.StatusInternalServerError)
 			return
 		}
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeOfferRevised,
+			Title: "Offer updated",
+			Body:  fmt.Sprintf("%s: %v %s", task.Title, offer.Price, task.Currency),
+			Data: map[string]interface{}{
+				"task_id":       task.ID,
+				"offer_id":      offer.ID,
+				"freelancer_id": offer.FreelancerID,
+				"price":         offer.Price,
+				"currency":      task.Currency,
+			},
+		}, task.ClientID)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -227,20 +398,21 @@ func DeleteTaskOfferHandler() http.HandlerFunc {
 			http.Error(w, "Offer not found", http.StatusNotFound)
 			return
 		}
-		// Owner or admin can delete
-		if existing.FreelancerID != claims.UserID {
-			isAdmin, err := db.IsAdmin(db.Postgres, claims.UserID)
-			if err != nil || !isAdmin {
-				http.Error(w, "Forbidden", http.StatusForbidden)
-				return
-			}
+		// Owner or moderator can delete
+		if existing.FreelancerID != claims.UserID && !server.HasPermission(claims, server.PermTaskModerate) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
 		}
-		if existing.Accepted {
+		if existing.Status == models.OfferAccepted {
 			http.Error(w, "Accepted offer cannot be deleted", http.StatusBadRequest)
 			return
 		}
 
-		if err := db.DeleteTaskOffer(db.Postgres, offerID); err != nil {
+		// the offer may have been accepted since it was read
+		if err := db.DeleteTaskOffer(db.Postgres, offerID); errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Accepted offer cannot be deleted", http.StatusBadRequest)
+			return
+		} else if err != nil {
 			http.Error(w, "Failed to delete offer", http.StatusInternalServerError)
 			return
 		}
@@ -253,10 +425,12 @@ func DeleteTaskOfferHandler() http.HandlerFunc {
 }
 // GetTaskOffersHandler godoc
 // @Summary Get task offers
-// @Description Returns list of offers for a task. Freelancers can only see their own offers
+// @Description Returns list of offers for a task with the reputation score of each freelancer. Freelancers can only see their own offers
 // @Tags offers
 // @Produce json
 // @Param task_id query int true "Task ID"
+// @Param sort query string false "created (default, newest first), reputation (highest first) or price (lowest first)"
+// @Param min_reputation query number false "Leave out freelancers scoring below this (0-100)"
 // @Success 200 {object} map[string]interface{} "success flag and offers list"
 // @Failure 400 {string} string "Invalid task ID"
 // @Failure 401 {string} string "Unauthorized"
@@ -280,6 +454,23 @@ func GetTaskOffersHandler() http.HandlerFunc {
 			return
 		}
 
+		sort := r.URL.Query().Get("sort")
+		if sort == "" {
+			sort = db.OfferSortCreated
+		}
+		if !db.IsOfferSort(sort) {
+			http.Error(w, "Invalid sort", http.StatusBadRequest)
+			return
+		}
+		var minReputation float64
+		if v := r.URL.Query().Get("min_reputation"); v != "" {
+			minReputation, err = strconv.ParseFloat(v, 64)
+			if err != nil || minReputation < 0 || minReputation > 100 {
+				http.Error(w, "Invalid min_reputation", http.StatusBadRequest)
+				return
+			}
+		}
+
 		task, err := db.GetTask(db.Postgres, taskID)
 		if err != nil {
 			http.Error(w, "Task not found", http.StatusNotFound)
@@ -315,7 +506,7 @@ func GetTaskOffersHandler() http.HandlerFunc {
 			}
 		}
 
-		offers, err := db.GetTaskOffersByTaskID(db.Postgres, taskID)
+		offers, err := db.ListRankedTaskOffers(db.Postgres, taskID, sort, minReputation)
 		if err != nil {
 			http.Error(w, "Failed to get offers", http.StatusInternalServerError)
 			return
@@ -328,9 +519,299 @@ func GetTaskOffersHandler() http.HandlerFunc {
 		})
 	}
 }
+// CounterTaskOfferHandler godoc
+// @Summary Make a counter-offer
+// @Description Lets the task owner propose other terms on a pending or countered offer: a price, or hourly_rate and weekly_cap on hourly tasks. The offer becomes countered and expires after offers.default_ttl. The freelancer agrees by updating the offer with these terms, which makes it pending again, or answers with terms of their own.
+// @Tags offers
+// @Accept json
+// @Produce json
+// @Param body body CounterTaskOfferRequest true "Counter-offer"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"offer\": {\"id\": 456, \"task_id\": 123, \"freelancer_id\": 789, \"price\": 80.0, \"message\": \"I can do this\", \"status\": \"countered\", \"expires_at\": \"2023-12-15T10:00:00Z\", \"created_at\": \"2023-12-01T10:00:00Z\"}}"
+// @Failure 400 {string} string "Offer is no longer open"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Offer not found"
+// @Router /api/offers/counter [post]
+// @Security BearerAuth
+func CounterTaskOfferHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req CounterTaskOfferRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		offer, err := db.GetTaskOffer(db.Postgres, req.OfferID)
+		if err != nil {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+		task, err := db.GetTask(db.Postgres, offer.TaskID)
+		if err != nil {
+			http.Error(w, "Task not found", http.StatusNotFound)
+			return
+		}
+		if task.ClientID != claims.UserID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if task.Status != "open" || !offer.Open() {
+			http.Error(w, "Offer is no longer open", http.StatusBadRequest)
+			return
+		}
+
+		offer.Price = req.Price
+		offer.HourlyRate = req.HourlyRate
+		offer.WeeklyCap = req.WeeklyCap
+		if msg := normalizeOffer(task, offer); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		if offer.Price < config.AppConfig.MinTransactionAmount {
+			http.Error(w, "Offer amount is below minimum transaction amount", http.StatusBadRequest)
+			return
+		}
+		message := server.SanitizeString(req.Message)
+		offer.ExpiresAt, _ = offerExpiry(nil)
+
+		err = db.ReviseTaskOffer(db.Postgres, offer, claims.UserID, models.RevisionCounter, message)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Offer is no longer open", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to save counter-offer", http.StatusInternalServerError)
+			return
+		}
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeOfferCountered,
+			Title: "Counter-offer received",
+			Body:  fmt.Sprintf("%s: %v %s", task.Title, offer.Price, task.Currency),
+			Data: map[string]interface{}{
+				"task_id":  task.ID,
+				"offer_id": offer.ID,
+				"price":    offer.Price,
+				"currency": task.Currency,
+				"message":  message,
+			},
+		}, offer.FreelancerID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"offer":   offer,
+		})
+	}
+}
+
+// RejectTaskOfferHandler godoc
+// @Summary Reject an offer
+// @Description Lets the task owner turn down a pending or countered offer. The freelancer sees the offer as rejected.
+// @Tags offers
+// @Accept json
+// @Produce json
+// @Param body body OfferActionRequest true "Offer"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 400 {string} string "Offer is no longer open"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Offer not found"
+// @Router /api/offers/reject [post]
+// @Security BearerAuth
+func RejectTaskOfferHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req OfferActionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		offer, err := db.GetTaskOffer(db.Postgres, req.OfferID)
+		if err != nil {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+		task, err := db.GetTask(db.Postgres, offer.TaskID)
+		if err != nil {
+			http.Error(w, "Task not found", http.StatusNotFound)
+			return
+		}
+		if task.ClientID != claims.UserID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		err = db.CloseTaskOffer(db.Postgres, offer.ID, models.OfferRejected)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Offer is no longer open", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to reject offer", http.StatusInternalServerError)
+			return
+		}
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeOfferRejected,
+			Title: "Your offer was declined",
+			Body:  task.Title,
+			Data: map[string]interface{}{
+				"task_id":  task.ID,
+				"offer_id": offer.ID,
+			},
+		}, offer.FreelancerID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+	}
+}
+
+// WithdrawTaskOfferHandler godoc
+// @Summary Withdraw an offer
+// @Description Lets a freelancer take back their own pending or countered offer, or an owner or manager of the organization that made an agency offer. Withdrawn offers stay visible with their revisions; delete the offer to make a new one on the same task.
+// @Tags offers
+// @Accept json
+// @Produce json
+// @Param body body OfferActionRequest true "Offer"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 400 {string} string "Offer is no longer open"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Offer not found"
+// @Router /api/offers/withdraw [post]
+// @Security BearerAuth
+func WithdrawTaskOfferHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req OfferActionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		offer, err := db.GetTaskOffer(db.Postgres, req.OfferID)
+		if err != nil {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+		if ok, err := canActForOffer(offer, claims.UserID); err != nil {
+			http.Error(w, "Failed to check organization membership", http.StatusInternalServerError)
+			return
+		} else if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		err = db.CloseTaskOffer(db.Postgres, offer.ID, models.OfferWithdrawn)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Offer is no longer open", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to withdraw offer", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+	}
+}
+
+// GetOfferRevisionsHandler godoc
+// @Summary Get the negotiation history of an offer
+// @Description Returns every set of terms put on the table for an offer, oldest first: the freelancer's offers (kind offer) and the client's counter-offers (kind counter). Visible to the task owner and the offer's freelancer.
+// @Tags offers
+// @Produce json
+// @Param offer_id query int true "Offer ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"revisions\": [{\"id\": 1, \"offer_id\": 456, \"author_id\": 789, \"kind\": \"offer\", \"price\": 95.0, \"hourly_rate\": null, \"weekly_cap\": null, \"message\": \"I can do this\", \"created_at\": \"2023-12-01T10:00:00Z\"}]}"
+// @Failure 400 {string} string "Invalid offer ID"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Offer not found"
+// @Router /api/offers/revisions [get]
+// @Security BearerAuth
+func GetOfferRevisionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		offerID, err := strconv.ParseInt(r.URL.Query().Get("offer_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid offer ID", http.StatusBadRequest)
+			return
+		}
+
+		claims := server.GetUserFromContext(r)
+		if claims == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		offer, err := db.GetTaskOffer(db.Postgres, offerID)
+		if err != nil {
+			http.Error(w, "Offer not found", http.StatusNotFound)
+			return
+		}
+		task, err := db.GetTask(db.Postgres, offer.TaskID)
+		if err != nil {
+			http.Error(w, "Task not found", http.StatusNotFound)
+			return
+		}
+		if task.ClientID != claims.UserID && offer.FreelancerID != claims.UserID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		revisions, err := db.ListOfferRevisions(db.Postgres, offer.ID)
+		if err != nil {
+			http.Error(w, "Failed to get revisions", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"revisions": revisions,
+		})
+	}
+}
+
 // AcceptTaskOfferHandler godoc
 // @Summary Accept a task offer
-// @Description Allows the task owner to accept a freelancer's offer, debit wallet, and put funds in escrow
+// @Description Allows the task owner to accept a freelancer's offer, debit wallet, and put funds in escrow. Tasks posted for an organization are paid from its wallet, within the owner's monthly spending limit. Only pending offers that haven't expired can be accepted; a countered offer needs the freelancer to agree to the counter first. The other open offers on the task are rejected.
 // @Tags offers
 // @Accept json
 // @Produce json
@@ -388,6 +869,14 @@ func AcceptTaskOfferHandler() http.HandlerFunc {
 			http.Error(w, "Task is not open", http.StatusBadRequest)
 			return
 		}
+		if offer.Status == models.OfferCountered {
+			http.Error(w, "The freelancer hasn't agreed to your counter-offer yet", http.StatusBadRequest)
+			return
+		}
+		if offer.Status != models.OfferPending {
+			http.Error(w, "Offer is no longer open", http.StatusBadRequest)
+			return
+		}
 
 		// Use transaction to ensure atomicity
 		tx, err := db.Postgres.Beginx()
@@ -397,19 +886,37 @@ func AcceptTaskOfferHandler() http.HandlerFunc {
 		}
 		defer tx.Rollback()
 
-		userWallet, err := models.GetWalletByUserAndCurrency(db.Postgres, userID, task.Currency)
+		// The escrow is funded on the terms the offer is accepted on, not on
+		// the ones read above
+		offer, err = db.AcceptTaskOfferTx(tx, req.OfferID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Offer is no longer open", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to accept offer: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if task.OrgID != nil {
+			if reason, err := checkOrgSpending(tx, *task.OrgID, userID, task.Currency, offer.Price); err != nil {
+				http.Error(w, "Failed to check spending limit: "+err.Error(), http.StatusInternalServerError)
+				return
+			} else if reason != "" {
+				http.Error(w, reason, http.StatusForbidden)
+				return
+			}
+		}
+
+		userWallet, err := server.ClientFunds(task)
 		if err != nil {
 			http.Error(w, "Failed to get wallet: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		amountBig := big.NewFloat(offer.Price)
-		if userWallet.BigBalance().Cmp(amountBig) < 0 {
+		if err := userWallet.SubBalance(tx, big.NewFloat(offer.Price)); errors.Is(err, models.ErrInsufficientBalance) {
 			http.Error(w, "Insufficient balance", http.StatusBadRequest)
 			return
-		}
-
-		if err := userWallet.SubBalance(tx, amountBig); err != nil {
+		} else if err != nil {
 			http.Error(w, "Failed to debit wallet: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -429,12 +936,8 @@ func AcceptTaskOfferHandler() http.HandlerFunc {
 			return
 		}
 
-		if err := db.AcceptTaskOfferTx(tx, req.OfferID); err != nil {
-			http.Error(w, "Failed to accept offer: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		if err := db.RejectOtherOffersForTaskTx(tx, task.ID, req.OfferID); err != nil {
+		rejected, err := db.RejectOtherOffersForTaskTx(tx, task.ID, req.OfferID)
+		if err != nil {
 			http.Error(w, "Failed to reject other offers: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -444,15 +947,53 @@ func AcceptTaskOfferHandler() http.HandlerFunc {
 			return
 		}
 
+		room, err := db.EnsureTaskChatRoomTx(tx, task.ID, task.ClientID, offer.FreelancerID)
+		if err != nil {
+			http.Error(w, "Failed to create task chat: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		if err := tx.Commit(); err != nil {
 			http.Error(w, "Failed to commit transaction: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeOfferAccepted,
+			Title: "Your offer was accepted",
+			Body:  fmt.Sprintf("%s: %v %s is held in escrow", task.Title, offer.Price, task.Currency),
+			Data: map[string]interface{}{
+				"task_id":      task.ID,
+				"offer_id":     offer.ID,
+				"amount":       offer.Price,
+				"currency":     task.Currency,
+				"chat_room_id": room.ID,
+			},
+		}, offer.FreelancerID)
+		if len(rejected) > 0 {
+			notifications.Publish(notifications.Event{
+				Type:  notifications.TypeOfferRejected,
+				Title: "Your offer was declined",
+				Body:  fmt.Sprintf("%s: the client accepted another offer", task.Title),
+				Data: map[string]interface{}{
+					"task_id": task.ID,
+				},
+			}, rejected...)
+		}
+		webhooks.Emit(webhooks.EventOfferAccepted, map[string]interface{}{
+			"task_id":       task.ID,
+			"offer_id":      offer.ID,
+			"client_id":     task.ClientID,
+			"freelancer_id": offer.FreelancerID,
+			"amount":        offer.Price,
+			"currency":      task.Currency,
+		}, task.ClientID, offer.FreelancerID)
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": true,
-			"message": "Offer accepted successfully",
+			"success":      true,
+			"message":      "Offer accepted successfully",
+			"chat_room_id": room.ID,
 		})
 	}
 }
@@ -505,6 +1046,10 @@ func CompleteTaskHandler() http.HandlerFunc {
 			http.Error(w, "Forbidden: only client can confirm completion", http.StatusForbidden)
 			return
 		}
+		if task.ContractType == models.ContractHourly {
+			http.Error(w, "Hourly contracts are paid weekly, end them with /api/contracts/end", http.StatusBadRequest)
+			return
+		}
 
 		offers, err := db.GetTaskOffersByTaskID(db.Postgres, req.TaskID)
 		if err != nil {
@@ -514,7 +1059,7 @@ func CompleteTaskHandler() http.HandlerFunc {
 
 		var acceptedOffer *models.TaskOffer
 		for _, offer := range offers {
-			if offer.Accepted {
+			if offer.Status == models.OfferAccepted {
 				acceptedOffer = offer
 				break
 			}
@@ -538,7 +1083,7 @@ func CompleteTaskHandler() http.HandlerFunc {
 
 		amountBig := big.NewFloat(escrow.Amount)
 
-		freelancerWallet, err := models.GetWalletByUserAndCurrency(db.Postgres, acceptedOffer.FreelancerID, task.Currency)
+		freelancerWallet, err := server.PayoutFunds(task, acceptedOffer)
 		if err != nil {
 			http.Error(w, "Failed to get freelancer wallet: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -581,6 +1126,25 @@ func CompleteTaskHandler() http.HandlerFunc {
 			http.Error(w, "Failed to commit transaction: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeEscrowReleased,
+			Title: "Payment released",
+			Body:  fmt.Sprintf("%s: %v %s was credited to your wallet", task.Title, escrow.Amount, task.Currency),
+			Data: map[string]interface{}{
+				"task_id":  task.ID,
+				"amount":   escrow.Amount,
+				"currency": task.Currency,
+			},
+		}, acceptedOffer.FreelancerID)
+		webhooks.Emit(webhooks.EventEscrowReleased, map[string]interface{}{
+			"task_id":       task.ID,
+			"client_id":     task.ClientID,
+			"freelancer_id": acceptedOffer.FreelancerID,
+			"amount":        escrow.Amount,
+			"currency":      task.Currency,
+			"reason":        "completed",
+		}, task.ClientID, acceptedOffer.FreelancerID)
+		reputation.Touch(reputation.ReasonTaskCompleted, task.ClientID, acceptedOffer.FreelancerID)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": true,
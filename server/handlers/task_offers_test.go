@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"mFrelance/config"
+	"mFrelance/models"
+)
+
+func ptr(f float64) *float64 { return &f }
+
+func TestNormalizeOffer(t *testing.T) {
+	cases := []struct {
+		name      string
+		contract  string
+		rate, cap *float64
+		price     float64
+		wantErr   bool
+	}{
+		{name: "fixed keeps price", contract: models.ContractFixed, rate: ptr(10), cap: ptr(5), price: 70},
+		{name: "hourly is rate times cap", contract: models.ContractHourly, rate: ptr(12.5), cap: ptr(20), price: 250},
+		{name: "hourly max cap", contract: models.ContractHourly, rate: ptr(1), cap: ptr(maxWeeklyCap), price: maxWeeklyCap},
+		{name: "missing rate", contract: models.ContractHourly, cap: ptr(20), wantErr: true},
+		{name: "negative rate", contract: models.ContractHourly, rate: ptr(-1), cap: ptr(20), wantErr: true},
+		{name: "zero rate", contract: models.ContractHourly, rate: ptr(0), cap: ptr(20), wantErr: true},
+		{name: "missing cap", contract: models.ContractHourly, rate: ptr(10), wantErr: true},
+		{name: "negative cap", contract: models.ContractHourly, rate: ptr(10), cap: ptr(-5), wantErr: true},
+		{name: "cap over a week", contract: models.ContractHourly, rate: ptr(10), cap: ptr(maxWeeklyCap + 1), wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			task := &models.Task{ContractType: c.contract}
+			offer := &models.TaskOffer{Price: 70, HourlyRate: c.rate, WeeklyCap: c.cap}
+			msg := normalizeOffer(task, offer)
+			if (msg != "") != c.wantErr {
+				t.Fatalf("msg=%q, want error %v", msg, c.wantErr)
+			}
+			if c.wantErr {
+				return
+			}
+			if offer.Price != c.price {
+				t.Errorf("price=%v, want %v", offer.Price, c.price)
+			}
+			if c.contract == models.ContractFixed && (offer.HourlyRate != nil || offer.WeeklyCap != nil) {
+				t.Errorf("fixed offer kept hourly terms")
+			}
+		})
+	}
+}
+
+func TestOfferExpiry(t *testing.T) {
+	prev := config.AppConfig
+	config.AppConfig.OfferDefaultTTL = 24 * time.Hour
+	config.AppConfig.OfferMaxTTL = 7 * 24 * time.Hour
+	t.Cleanup(func() { config.AppConfig = prev })
+
+	at := func(d time.Duration) *time.Time {
+		t := time.Now().Add(d)
+		return &t
+	}
+	cases := []struct {
+		name      string
+		requested *time.Time
+		wantErr   bool
+	}{
+		{name: "in range", requested: at(48 * time.Hour)},
+		{name: "just under max", requested: at(7*24*time.Hour - time.Minute)},
+		{name: "beyond max", requested: at(7*24*time.Hour + time.Minute), wantErr: true},
+		{name: "in the past", requested: at(-time.Minute), wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, msg := offerExpiry(c.requested)
+			if (msg != "") != c.wantErr {
+				t.Fatalf("msg=%q, want error %v", msg, c.wantErr)
+			}
+			if !c.wantErr && !got.Equal(*c.requested) {
+				t.Errorf("expiry=%v, want %v", got, c.requested)
+			}
+		})
+	}
+
+	t.Run("default ttl", func(t *testing.T) {
+		before := time.Now()
+		got, msg := offerExpiry(nil)
+		if msg != "" {
+			t.Fatalf("msg=%q", msg)
+		}
+		if got.Before(before.Add(24*time.Hour)) || got.After(time.Now().Add(24*time.Hour)) {
+			t.Errorf("expiry=%v, want a day from now", got)
+		}
+	})
+}
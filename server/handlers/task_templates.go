@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"mFrelance/config"
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/schedule"
+	"mFrelance/server"
+)
+
+// scheduleGapRuns is how many upcoming runs of a schedule are checked
+// against tasks.min_interval
+const scheduleGapRuns = 100
+
+type DeleteTaskTemplateRequest struct {
+	ID int64 `json:"id"`
+}
+
+type DeleteTaskScheduleRequest struct {
+	ID int64 `json:"id"`
+}
+
+// applyTemplate fills the fields of a task the client left out from the
+// template
+func applyTemplate(task *models.Task, tmpl *models.TaskTemplate) {
+	from := tmpl.Task(time.Now())
+	if task.Title == "" {
+		task.Title = from.Title
+	}
+	if task.Description == "" {
+		task.Description = from.Description
+	}
+	if task.Category == "" {
+		task.Category = from.Category
+	}
+	if task.Budget == 0 {
+		task.Budget = from.Budget
+	}
+	if task.Currency == "" {
+		task.Currency = from.Currency
+	}
+	if task.Deadline.IsZero() {
+		task.Deadline = from.Deadline
+	}
+	if task.ContractType == "" {
+		task.ContractType = from.ContractType
+	}
+	if task.Visibility == "" {
+		task.Visibility = from.Visibility
+	}
+}
+
+// validateTemplate normalizes a template and returns a message for the user
+// when it is invalid
+func validateTemplate(t *models.TaskTemplate) string {
+	t.Name = strings.TrimSpace(t.Name)
+	t.Title = strings.TrimSpace(t.Title)
+	t.Currency = strings.ToUpper(t.Currency)
+	if t.Name == "" {
+		t.Name = t.Title
+	}
+	if t.ContractType == "" {
+		t.ContractType = models.ContractFixed
+	}
+	if t.Visibility == "" {
+		t.Visibility = models.VisibilityPublic
+	}
+	switch {
+	case t.Title == "":
+		return "title is required"
+	case len(t.Name) > 100 || len(t.Title) > 255:
+		return "name or title too long"
+	case !validCurrency(t.Currency):
+		return "currency must be BTC or XMR"
+	case t.Budget < 0:
+		return "budget can't be negative"
+	case t.ContractType != models.ContractFixed && t.ContractType != models.ContractHourly:
+		return "contract_type must be fixed or hourly"
+	case !models.IsVisibility(t.Visibility):
+		return "visibility must be public, unlisted or invite"
+	case t.DeadlineDays < 0:
+		return "deadline_days can't be negative"
+	}
+	return ""
+}
+
+// TaskTemplatesHandler godoc
+// @Summary List or save task templates
+// @Description GET returns the current user's task templates. POST creates a template, or updates it when id is set. Post a template with POST /api/tasks/create and template_id, or on a cron schedule with /api/task_schedules. deadline_days sets the deadline of the created tasks that many days after they are posted; 0 leaves it open.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param request body models.TaskTemplate false "Template (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"templates\": [{\"id\": 3, \"client_id\": 7, \"name\": \"Weekly report\", \"title\": \"Weekly sales report\", \"description\": \"Compile the numbers\", \"category\": \"writing\", \"budget\": 0.001, \"currency\": \"BTC\", \"contract_type\": \"fixed\", \"visibility\": \"invite\", \"deadline_days\": 3}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"currency must be BTC or XMR\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"template not found\"}"
+// @Security BearerAuth
+// @Router /api/task_templates [get]
+// @Router /api/task_templates [post]
+func TaskTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		templates, err := db.ListTaskTemplates(db.Postgres, claims.UserID)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load templates", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"templates": templates,
+		})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var t models.TaskTemplate
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	t.ClientID = claims.UserID
+	if msg := validateTemplate(&t); msg != "" {
+		server.WriteErrorJSON(w, msg, http.StatusBadRequest)
+		return
+	}
+	if t.ID == 0 {
+		if n, err := db.CountTaskTemplates(db.Postgres, claims.UserID); err != nil {
+			server.WriteErrorJSON(w, "failed to save template", http.StatusInternalServerError)
+			return
+		} else if n >= config.AppConfig.TaskTemplateMax {
+			server.WriteErrorJSON(w, fmt.Sprintf("at most %d templates", config.AppConfig.TaskTemplateMax), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := db.SaveTaskTemplate(db.Postgres, &t); errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "template not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		server.WriteErrorJSON(w, "failed to save template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"template": t,
+	})
+}
+
+// DeleteTaskTemplateHandler godoc
+// @Summary Delete a task template
+// @Description Deletes one of the current user's templates and its schedules. Tasks posted from it are kept.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param request body DeleteTaskTemplateRequest true "Template ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"template not found\"}"
+// @Security BearerAuth
+// @Router /api/task_templates/delete [post]
+func DeleteTaskTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var req DeleteTaskTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := db.DeleteTaskTemplate(db.Postgres, req.ID, claims.UserID); errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "template not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		server.WriteErrorJSON(w, "failed to delete template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// TaskSchedulesHandler godoc
+// @Summary List or save task schedules
+// @Description GET returns the current user's schedules. POST creates a schedule, or updates it when id is set. A schedule posts its template whenever the cron expression (minute hour day-of-month month day-of-week, or @daily, @weekly, @monthly) matches in its time zone; runs must be at least tasks.min_interval apart. A run is skipped while the schedule's previous task is still open, and last_error says why. With rehire the task goes straight to the freelancer of the last task posted by the schedule or from its template, on the same terms, and the escrow is funded from the client's wallet; when the balance doesn't cover it the task is posted and the freelancer invited instead. Scheduled tasks don't count towards the rate and duplicate limits of POST /api/tasks/create. Set active to false to pause a schedule.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param request body models.TaskSchedule false "Schedule (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"schedules\": [{\"id\": 5, \"template_id\": 3, \"client_id\": 7, \"cron\": \"0 9 * * MON\", \"timezone\": \"Europe/Berlin\", \"rehire\": true, \"active\": true, \"next_run_at\": \"2025-03-10T08:00:00Z\", \"last_run_at\": null, \"last_task_id\": null, \"last_error\": \"\"}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"runs must be at least 12m0s apart\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"template not found\"}"
+// @Security BearerAuth
+// @Router /api/task_schedules [get]
+// @Router /api/task_schedules [post]
+func TaskSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		schedules, err := db.ListTaskSchedules(db.Postgres, claims.UserID)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load schedules", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"schedules": schedules,
+		})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := models.TaskSchedule{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	s.ClientID = claims.UserID
+	if s.Timezone == "" {
+		s.Timezone = "UTC"
+	}
+
+	tmpl, err := db.GetTaskTemplate(db.Postgres, s.TemplateID)
+	if err != nil || tmpl.ClientID != claims.UserID {
+		server.WriteErrorJSON(w, "template not found", http.StatusNotFound)
+		return
+	}
+	cron, err := schedule.Parse(s.Cron)
+	if err != nil {
+		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if s.NextRunAt, err = server.NextScheduleRun(s.Cron, s.Timezone, now); err != nil {
+		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.NextRunAt == nil {
+		server.WriteErrorJSON(w, "cron expression never matches", http.StatusBadRequest)
+		return
+	}
+	if !config.AppConfig.TaskRateLimitDisabled {
+		min := config.AppConfig.TaskMinInterval
+		if gap := cron.MinGap(now, scheduleGapRuns); gap > 0 && gap < min {
+			server.WriteErrorJSON(w, fmt.Sprintf("runs must be at least %s apart", min), http.StatusBadRequest)
+			return
+		}
+	}
+	if !s.Active {
+		s.NextRunAt = nil
+	} else if n, err := db.CountActiveTaskSchedules(db.Postgres, claims.UserID); err != nil {
+		server.WriteErrorJSON(w, "failed to save schedule", http.StatusInternalServerError)
+		return
+	} else if n >= config.AppConfig.TaskScheduleMax {
+		// an active schedule being updated is one of the n
+		if existing, err := db.GetTaskSchedule(db.Postgres, s.ID); s.ID == 0 || err != nil || !existing.Active {
+			server.WriteErrorJSON(w, fmt.Sprintf("at most %d active schedules", config.AppConfig.TaskScheduleMax), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := db.SaveTaskSchedule(db.Postgres, &s); errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "schedule not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		server.WriteErrorJSON(w, "failed to save schedule", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"schedule": s,
+	})
+}
+
+// DeleteTaskScheduleHandler godoc
+// @Summary Delete a task schedule
+// @Description Deletes one of the current user's schedules. Tasks it posted are kept.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param request body DeleteTaskScheduleRequest true "Schedule ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"schedule not found\"}"
+// @Security BearerAuth
+// @Router /api/task_schedules/delete [post]
+func DeleteTaskScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var req DeleteTaskScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := db.DeleteTaskSchedule(db.Postgres, req.ID, claims.UserID); errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "schedule not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		server.WriteErrorJSON(w, "failed to delete schedule", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
@@ -4,18 +4,22 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
 
 	"github.com/lib/pq"
 
 	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/notifications"
 	"mFrelance/server"
 )
 
 // TicketCreateRequest represents request to create ticket
 type TicketCreateRequest struct {
-	Message string `json:"message"`
-	Subject string `json:"subject"`
+	Message  string `json:"message"`
+	Subject  string `json:"subject"`
+	Category string `json:"category"`
 }
 
 // TicketCreateAnswer represents response after creating ticket
@@ -25,7 +29,7 @@ type TicketCreateAnswer struct {
 
 // CreateTicket godoc
 // @Summary Create new ticket
-// @Description Create new ticket
+// @Description Create new ticket. The category (account, payment, task, dispute, technical, other) defaults to other. New tickets have normal priority until support triages them.
 // @Tags ticket
 // @Accept  json
 // @Produce  json
@@ -51,7 +55,14 @@ func CreateTicket(w http.ResponseWriter, r *http.Request) {
 		server.WriteErrorJSON(w, "invalid parameters for ticket: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	id, err := db.CreateTicket(db.Postgres, server.SanitizeString(t.Subject), claims.UserID)
+	if t.Category == "" {
+		t.Category = "other"
+	}
+	if !slices.Contains(models.TicketCategories, t.Category) {
+		server.WriteErrorJSON(w, "invalid category", http.StatusBadRequest)
+		return
+	}
+	id, err := db.CreateTicket(db.Postgres, server.SanitizeString(t.Subject), claims.UserID, t.Category, models.TicketPriorityNormal, ticketSLA(models.TicketPriorityNormal))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -65,13 +76,14 @@ func CreateTicket(w http.ResponseWriter, r *http.Request) {
 }
 
 type WriteTicketRequest struct {
-	TicketID int64  `json:"ticket_id"`
-	Message  string `json:"message"`
+	TicketID         int64  `json:"ticket_id"`
+	Message          string `json:"message"`
+	CannedResponseID int64  `json:"canned_response_id"`
 }
 
 // WriteToTicketHandler godoc
 // @Summary Write to ticket
-// @Description Add message to ticket
+// @Description Add message to ticket. Admins can send a canned response by its canned_response_id instead of a message. The first message of the assigned admin stops the first response timer.
 // @Tags ticket
 // @Accept  json
 // @Produce  json
@@ -125,6 +137,19 @@ func WriteToTicketHandler(w http.ResponseWriter, r *http.Request) {
 		server.WriteErrorJSON(w, "you do not have access to this ticket", http.StatusForbidden)
 		return
 	}
+	isAdmin := ticket.AdminID != nil && *ticket.AdminID == claims.UserID
+	if req.CannedResponseID != 0 {
+		if !server.HasPermission(claims, server.PermTicketManage) {
+			server.WriteErrorJSON(w, "insufficient permissions", http.StatusForbidden)
+			return
+		}
+		c, err := db.GetCannedResponse(db.Postgres, req.CannedResponseID)
+		if err != nil {
+			server.WriteErrorJSON(w, "canned response not found", http.StatusNotFound)
+			return
+		}
+		req.Message = renderCannedResponse(c.Body, req.TicketID, ticket.UserID)
+	}
 	if err := server.ValidateMessage(req.Message); err != nil {
 		server.WriteErrorJSON(w, "invalid message: "+err.Error(), http.StatusBadRequest)
 		return
@@ -133,6 +158,31 @@ func WriteToTicketHandler(w http.ResponseWriter, r *http.Request) {
 		server.WriteErrorJSON(w, "failed to add message: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if isAdmin {
+		if err := db.RecordTicketFirstResponse(db.Postgres, req.TicketID); err != nil {
+			log.Printf("[WriteToTicketHandler] first response of ticket %d: %v", req.TicketID, err)
+		}
+	}
+	var recipients []int64
+	if ticket.UserID != nil && *ticket.UserID != claims.UserID {
+		recipients = append(recipients, *ticket.UserID)
+	}
+	if ticket.AdminID != nil && *ticket.AdminID != claims.UserID {
+		recipients = append(recipients, *ticket.AdminID)
+	}
+	for _, id := range ticket.AdditionalUsers {
+		if id != claims.UserID {
+			recipients = append(recipients, id)
+		}
+	}
+	notifications.Publish(notifications.Event{
+		Type:  notifications.TypeTicketReply,
+		Title: "New reply in ticket #" + strconv.FormatInt(req.TicketID, 10),
+		Data: map[string]interface{}{
+			"ticket_id": req.TicketID,
+			"sender_id": claims.UserID,
+		},
+	}, recipients...)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
@@ -0,0 +1,592 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"mFrelance/config"
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/server"
+)
+
+// Limits of the free text fields of the ticket tools
+const (
+	maxTicketNoteLength     = 4000
+	maxCannedTitleLength    = 100
+	maxCannedResponseLength = 4000
+)
+
+type TriageTicketRequest struct {
+	TicketID int64  `json:"ticket_id"`
+	Priority string `json:"priority"`
+	Category string `json:"category"`
+}
+
+type TicketNoteRequest struct {
+	TicketID int64  `json:"ticket_id"`
+	Note     string `json:"note"`
+}
+
+type TicketLinkRequest struct {
+	TicketID   int64  `json:"ticket_id"`
+	TargetType string `json:"target_type"`
+	TargetID   int64  `json:"target_id"`
+}
+
+type CannedResponseRequest struct {
+	ID       int64   `json:"id"`
+	Title    string  `json:"title"`
+	Body     string  `json:"body"`
+	Category *string `json:"category"`
+}
+
+// ticketSLA returns the configured SLA targets for a priority
+func ticketSLA(priority string) models.TicketSLA {
+	return models.TicketSLA{
+		FirstResponse: config.AppConfig.TicketFirstResponseSLA[priority],
+		Resolution:    config.AppConfig.TicketResolutionSLA[priority],
+	}
+}
+
+// renderCannedResponse fills the {{ticket_id}} and {{username}}
+// placeholders of a canned response
+func renderCannedResponse(body string, ticketID int64, userID *int64) string {
+	username := ""
+	if userID != nil {
+		username, _ = db.GetUsernameByID(db.Postgres, *userID)
+	}
+	return strings.NewReplacer(
+		"{{ticket_id}}", strconv.FormatInt(ticketID, 10),
+		"{{username}}", username,
+	).Replace(body)
+}
+
+func ticketIDParam(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("ticket_id"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.New("invalid ticket_id")
+	}
+	return id, nil
+}
+
+// ticketExists writes 404 and returns false for a missing ticket
+func ticketExists(w http.ResponseWriter, ticketID int64) bool {
+	if _, err := db.GetTicketByID(db.Postgres, ticketID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			server.WriteErrorJSON(w, "ticket not found", http.StatusNotFound)
+		} else {
+			server.WriteErrorJSON(w, "failed to load ticket", http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+// AdminTicketQueueHandler godoc
+// @Summary Ticket queue
+// @Description Tickets without an assigned admin, ordered by priority (urgent first) and then by age (oldest first). Each ticket carries its SLA due dates and breach flags.
+// @Tags admin
+// @Produce json
+// @Param priority query string false "low, normal, high or urgent"
+// @Param category query string false "Ticket category"
+// @Param breached query bool false "Only tickets with a breached SLA timer"
+// @Param limit query int false "Page size, default 50, max 200"
+// @Param offset query int false "Offset"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"tickets\": [{\"id\": 12, \"priority\": \"urgent\", \"category\": \"payment\", \"first_response_breached\": true}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"invalid priority\"}"
+// @Failure 403 {string} string "Example: \"insufficient permissions\""
+// @Security BearerAuth
+// @Router /api/admin/tickets/queue [get]
+func AdminTicketQueueHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	f := models.TicketQueueFilter{
+		Priority: q.Get("priority"),
+		Category: q.Get("category"),
+		Breached: q.Get("breached") == "true",
+	}
+	if f.Priority != "" && !slices.Contains(models.TicketPriorities, f.Priority) {
+		server.WriteErrorJSON(w, "invalid priority", http.StatusBadRequest)
+		return
+	}
+	if f.Category != "" && !slices.Contains(models.TicketCategories, f.Category) {
+		server.WriteErrorJSON(w, "invalid category", http.StatusBadRequest)
+		return
+	}
+	f.Limit, f.Offset = pageParams(r)
+	tickets, err := db.ListTicketQueue(db.Postgres, f)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load queue", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"tickets": tickets,
+	})
+}
+
+// TakeTicketHandler godoc
+// @Summary Take a ticket from the queue
+// @Description Assigns a waiting ticket to the current admin. Without ticket_id the head of the queue is taken. Two admins never get the same ticket.
+// @Tags admin
+// @Produce json
+// @Param ticket_id query int false "Ticket ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"ticket\": {\"id\": 12, \"admin_id\": 3, \"status\": \"pending\"}}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"no tickets in the queue\"}"
+// @Failure 403 {string} string "Example: \"insufficient permissions\""
+// @Security BearerAuth
+// @Router /api/admin/tickets/take [post]
+func TakeTicketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var ticketID int64
+	if r.URL.Query().Get("ticket_id") != "" {
+		id, err := ticketIDParam(r)
+		if err != nil {
+			server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ticketID = id
+	}
+	ticket, err := db.TakeTicket(db.Postgres, claims.UserID, ticketID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if ticketID != 0 {
+			server.WriteErrorJSON(w, "ticket is not in the queue", http.StatusNotFound)
+		} else {
+			server.WriteErrorJSON(w, "no tickets in the queue", http.StatusNotFound)
+		}
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to take ticket", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"ticket":  ticket,
+	})
+}
+
+// TriageTicketHandler godoc
+// @Summary Set ticket priority and category
+// @Description Changes the priority and category of a ticket. The SLA due dates are recalculated from the creation of the ticket for the new priority.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body TriageTicketRequest true "Priority and category"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"ticket\": {\"id\": 12, \"priority\": \"high\", \"category\": \"payment\"}}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"invalid priority\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"ticket not found\"}"
+// @Security BearerAuth
+// @Router /api/admin/tickets/triage [post]
+func TriageTicketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req TriageTicketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	current, err := db.GetTicketByID(db.Postgres, req.TicketID)
+	if err != nil {
+		server.WriteErrorJSON(w, "ticket not found", http.StatusNotFound)
+		return
+	}
+	if req.Priority == "" {
+		req.Priority = current.Priority
+	}
+	if req.Category == "" {
+		req.Category = current.Category
+	}
+	if !slices.Contains(models.TicketPriorities, req.Priority) {
+		server.WriteErrorJSON(w, "invalid priority", http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(models.TicketCategories, req.Category) {
+		server.WriteErrorJSON(w, "invalid category", http.StatusBadRequest)
+		return
+	}
+	ticket, err := db.TriageTicket(db.Postgres, req.TicketID, req.Priority, req.Category, ticketSLA(req.Priority))
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "ticket not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to update ticket", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"ticket":  ticket,
+	})
+}
+
+// AdminTicketDetailsHandler godoc
+// @Summary Ticket details for support
+// @Description Returns a ticket with its internal notes and links
+// @Tags admin
+// @Produce json
+// @Param ticket_id query int true "Ticket ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"ticket\": {...}, \"notes\": [...], \"links\": [...]}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"ticket not found\"}"
+// @Security BearerAuth
+// @Router /api/admin/tickets/details [get]
+func AdminTicketDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := ticketIDParam(r)
+	if err != nil {
+		server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ticket, err := db.GetTicketByID(db.Postgres, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "ticket not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load ticket", http.StatusInternalServerError)
+		return
+	}
+	notes, err := db.ListTicketNotes(db.Postgres, id)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load notes", http.StatusInternalServerError)
+		return
+	}
+	links, err := db.ListTicketLinks(db.Postgres, id)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load links", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"ticket":  ticket,
+		"notes":   notes,
+		"links":   links,
+	})
+}
+
+// TicketNotesHandler godoc
+// @Summary Internal ticket notes
+// @Description GET lists the internal notes of a ticket, POST adds one. Notes are only visible to support, never to the ticket's users.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param ticket_id query int false "Ticket ID (GET)"
+// @Param request body TicketNoteRequest false "Note (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"notes\": [{\"id\": 1, \"ticket_id\": 12, \"author_id\": 3, \"author_username\": \"support1\", \"note\": \"User already refunded once\"}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"note is required\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"ticket not found\"}"
+// @Security BearerAuth
+// @Router /api/admin/tickets/notes [get]
+// @Router /api/admin/tickets/notes [post]
+func TicketNotesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		id, err := ticketIDParam(r)
+		if err != nil {
+			server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !ticketExists(w, id) {
+			return
+		}
+		notes, err := db.ListTicketNotes(db.Postgres, id)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load notes", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"notes":   notes,
+		})
+	case http.MethodPost:
+		var req TicketNoteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		req.Note = strings.TrimSpace(req.Note)
+		if req.Note == "" {
+			server.WriteErrorJSON(w, "note is required", http.StatusBadRequest)
+			return
+		}
+		if utf8.RuneCountInString(req.Note) > maxTicketNoteLength {
+			server.WriteErrorJSON(w, "note is too long", http.StatusBadRequest)
+			return
+		}
+		if !ticketExists(w, req.TicketID) {
+			return
+		}
+		note, err := db.AddTicketNote(db.Postgres, req.TicketID, claims.UserID, req.Note)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to add note", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"note":    note,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// TicketLinksHandler godoc
+// @Summary Ticket links
+// @Description GET lists what a ticket is linked to, POST links it to a task, dispute or transaction
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param ticket_id query int false "Ticket ID (GET)"
+// @Param request body TicketLinkRequest false "Link (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"links\": [{\"ticket_id\": 12, \"target_type\": \"transaction\", \"target_id\": 311}]}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"invalid target_type\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"link target not found\"}"
+// @Security BearerAuth
+// @Router /api/admin/tickets/links [get]
+// @Router /api/admin/tickets/links [post]
+func TicketLinksHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		id, err := ticketIDParam(r)
+		if err != nil {
+			server.WriteErrorJSON(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !ticketExists(w, id) {
+			return
+		}
+		links, err := db.ListTicketLinks(db.Postgres, id)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load links", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"links":   links,
+		})
+	case http.MethodPost:
+		var req TicketLinkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		switch req.TargetType {
+		case models.TicketLinkTask, models.TicketLinkDispute, models.TicketLinkTransaction:
+		default:
+			server.WriteErrorJSON(w, "invalid target_type", http.StatusBadRequest)
+			return
+		}
+		if !ticketExists(w, req.TicketID) {
+			return
+		}
+		link, err := db.AddTicketLink(db.Postgres, req.TicketID, req.TargetType, req.TargetID, claims.UserID)
+		if errors.Is(err, db.ErrTicketLinkTarget) {
+			server.WriteErrorJSON(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to link ticket", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"link":    link,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// DeleteTicketLinkHandler godoc
+// @Summary Remove a ticket link
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body TicketLinkRequest true "Link"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"link not found\"}"
+// @Security BearerAuth
+// @Router /api/admin/tickets/links/delete [post]
+func DeleteTicketLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req TicketLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	err := db.DeleteTicketLink(db.Postgres, req.TicketID, req.TargetType, req.TargetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "link not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to remove link", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// CannedResponsesHandler godoc
+// @Summary List canned responses
+// @Description Returns the canned responses by title. With a category only the responses for it and the general ones are returned.
+// @Tags admin
+// @Produce json
+// @Param category query string false "Ticket category"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"responses\": [{\"id\": 1, \"title\": \"Refund started\", \"body\": \"Hi {{username}}, ...\", \"category\": \"payment\"}]}"
+// @Security BearerAuth
+// @Router /api/admin/tickets/canned [get]
+func CannedResponsesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	list, err := db.ListCannedResponses(db.Postgres, r.URL.Query().Get("category"))
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load canned responses", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"responses": list,
+	})
+}
+
+// SaveCannedResponseHandler godoc
+// @Summary Create or update a canned response
+// @Description Creates a canned response, or updates the one with the given id. The body may contain the {{username}} and {{ticket_id}} placeholders.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body CannedResponseRequest true "Canned response"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"response\": {\"id\": 1, \"title\": \"Refund started\"}}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"title is required\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"canned response not found\"}"
+// @Security BearerAuth
+// @Router /api/admin/tickets/canned/save [post]
+func SaveCannedResponseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	var req CannedResponseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	req.Title = strings.TrimSpace(req.Title)
+	switch {
+	case req.Title == "":
+		server.WriteErrorJSON(w, "title is required", http.StatusBadRequest)
+		return
+	case utf8.RuneCountInString(req.Title) > maxCannedTitleLength:
+		server.WriteErrorJSON(w, "title is too long", http.StatusBadRequest)
+		return
+	case strings.TrimSpace(req.Body) == "":
+		server.WriteErrorJSON(w, "body is required", http.StatusBadRequest)
+		return
+	case utf8.RuneCountInString(req.Body) > maxCannedResponseLength:
+		server.WriteErrorJSON(w, "body is too long", http.StatusBadRequest)
+		return
+	case req.Category != nil && !slices.Contains(models.TicketCategories, *req.Category):
+		server.WriteErrorJSON(w, "invalid category", http.StatusBadRequest)
+		return
+	}
+	c := models.CannedResponse{ID: req.ID, Title: req.Title, Body: req.Body, Category: req.Category, CreatedBy: &claims.UserID}
+	err := db.SaveCannedResponse(db.Postgres, &c)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "canned response not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to save canned response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"response": c,
+	})
+}
+
+// DeleteCannedResponseHandler godoc
+// @Summary Delete a canned response
+// @Tags admin
+// @Produce json
+// @Param id query int true "Canned response ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"canned response not found\"}"
+// @Security BearerAuth
+// @Router /api/admin/tickets/canned/delete [post]
+func DeleteCannedResponseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		server.WriteErrorJSON(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	err = db.DeleteCannedResponse(db.Postgres, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "canned response not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to delete canned response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
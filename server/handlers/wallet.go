@@ -208,7 +208,7 @@ func SendMoneroHandler(w http.ResponseWriter, r *http.Request, mClient *walletrp
 	}
 
 	if !isOur {
-		server.AddToTxPool(destAddress, remaining, "XMR")
+		server.AddWithdrawalToTxPool(userID, destAddress, remaining, "XMR")
 		server.AddToTxPool(config.AppConfig.MoneroAddress, commission, "XMR")
 		log.Printf("Added to pool: to=%s amount=%s", destAddress, amount.Text('f', 12))
 	} else {
@@ -356,7 +356,7 @@ func SendElectrumHandler(w http.ResponseWriter, r *http.Request, client *electru
 
 	if !isOur {
 		server.AddToTxPool(config.AppConfig.BitcoinAddress, commission, "BTC")
-		server.AddToTxPool(destAddress, remaining, "BTC")
+		server.AddWithdrawalToTxPool(userID, destAddress, remaining, "BTC")
 		log.Printf("Added to pool: to=%s amount=%s commission=%s", destAddress, remaining.Text('f', 8), commission.Text('f', 8))
 	} else {
 		if err := models.AddToWalletBalance(db.Postgres, destAddress, "BTC", remaining); err != nil {
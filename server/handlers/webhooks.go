@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/server"
+	"mFrelance/webhooks"
+)
+
+type WebhookEndpointRequest struct {
+	ID          int64    `json:"id"`
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Global      bool     `json:"global"`
+	Active      *bool    `json:"active"`
+	Description string   `json:"description"`
+}
+
+type WebhookIDRequest struct {
+	ID int64 `json:"id"`
+}
+
+func validateWebhookEndpoint(req *WebhookEndpointRequest) string {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "url must be an absolute http(s) URL"
+	}
+	if len(req.Events) == 0 {
+		return "at least one event is required"
+	}
+	for _, e := range req.Events {
+		if !webhooks.IsKnownEvent(e) {
+			return "unknown event: " + e
+		}
+	}
+	return ""
+}
+
+// loadWebhookEndpoint returns the endpoint if the caller owns it or manages
+// webhooks, writing the error response otherwise
+func loadWebhookEndpoint(w http.ResponseWriter, r *http.Request, id int64) *models.WebhookEndpoint {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return nil
+	}
+	e, err := db.GetWebhookEndpoint(db.Postgres, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "webhook not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load webhook", http.StatusInternalServerError)
+		return nil
+	}
+	if e.OwnerID != claims.UserID && !server.HasPermission(claims, server.PermWebhookManage) {
+		server.WriteErrorJSON(w, "webhook not found", http.StatusNotFound)
+		return nil
+	}
+	return e
+}
+
+// WebhooksHandler godoc
+// @Summary List or register webhook endpoints
+// @Description GET lists the caller's endpoints (all endpoints for webhook.manage). POST registers a new endpoint and returns its signing secret, which is not shown again. Global endpoints receive events of every user and require webhook.manage.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param request body WebhookEndpointRequest false "Endpoint (POST)"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"webhook\": {...}, \"secret\": \"whsec_...\"}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"unknown event: task.deleted\"}"
+// @Failure 403 {object} map[string]string "Example: {\"error\": \"global webhooks require webhook.manage\"}"
+// @Security BearerAuth
+// @Router /api/webhooks [get]
+// @Router /api/webhooks [post]
+func WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	claims := server.GetUserFromContext(r)
+	if claims == nil {
+		server.WriteErrorJSON(w, "user not found in context", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		owner := claims.UserID
+		if server.HasPermission(claims, server.PermWebhookManage) {
+			owner = 0
+		}
+		list, err := db.ListWebhookEndpoints(db.Postgres, owner)
+		if err != nil {
+			server.WriteErrorJSON(w, "failed to load webhooks", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"webhooks": list,
+			"events":   webhooks.Events,
+		})
+	case http.MethodPost:
+		var req WebhookEndpointRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if msg := validateWebhookEndpoint(&req); msg != "" {
+			server.WriteErrorJSON(w, msg, http.StatusBadRequest)
+			return
+		}
+		if req.Global && !server.HasPermission(claims, server.PermWebhookManage) {
+			server.WriteErrorJSON(w, "global webhooks require webhook.manage", http.StatusForbidden)
+			return
+		}
+		e := &models.WebhookEndpoint{
+			OwnerID:     claims.UserID,
+			URL:         req.URL,
+			Secret:      webhooks.NewSecret(),
+			Events:      req.Events,
+			Global:      req.Global,
+			Active:      req.Active == nil || *req.Active,
+			Description: req.Description,
+		}
+		if err := db.CreateWebhookEndpoint(db.Postgres, e); err != nil {
+			server.WriteErrorJSON(w, "failed to create webhook", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"webhook": e,
+			"secret":  e.Secret,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// UpdateWebhookHandler godoc
+// @Summary Update a webhook endpoint
+// @Description Replaces the URL, events, description and active flag of an endpoint
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param request body WebhookEndpointRequest true "Endpoint with id"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"webhook\": {...}}"
+// @Failure 400 {object} map[string]string "Example: {\"error\": \"at least one event is required\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"webhook not found\"}"
+// @Security BearerAuth
+// @Router /api/webhooks/update [post]
+func UpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req WebhookEndpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if msg := validateWebhookEndpoint(&req); msg != "" {
+		server.WriteErrorJSON(w, msg, http.StatusBadRequest)
+		return
+	}
+	e := loadWebhookEndpoint(w, r, req.ID)
+	if e == nil {
+		return
+	}
+	claims := server.GetUserFromContext(r)
+	if req.Global && !e.Global && !server.HasPermission(claims, server.PermWebhookManage) {
+		server.WriteErrorJSON(w, "global webhooks require webhook.manage", http.StatusForbidden)
+		return
+	}
+	e.URL = req.URL
+	e.Events = req.Events
+	e.Global = req.Global
+	e.Description = req.Description
+	if req.Active != nil {
+		e.Active = *req.Active
+	}
+	if err := db.UpdateWebhookEndpoint(db.Postgres, e); err != nil {
+		server.WriteErrorJSON(w, "failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"webhook": e,
+	})
+}
+
+// DeleteWebhookHandler godoc
+// @Summary Delete a webhook endpoint
+// @Description Deletes the endpoint together with its deliveries and attempt log
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param request body WebhookIDRequest true "Endpoint ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"webhook not found\"}"
+// @Security BearerAuth
+// @Router /api/webhooks/delete [post]
+func DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req WebhookIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	e := loadWebhookEndpoint(w, r, req.ID)
+	if e == nil {
+		return
+	}
+	if err := db.DeleteWebhookEndpoint(db.Postgres, e.ID); err != nil {
+		server.WriteErrorJSON(w, "failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// RotateWebhookSecretHandler godoc
+// @Summary Rotate a webhook signing secret
+// @Description Generates a new signing secret and returns it. Deliveries sent from now on are signed with it.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param request body WebhookIDRequest true "Endpoint ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"secret\": \"whsec_...\"}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"webhook not found\"}"
+// @Security BearerAuth
+// @Router /api/webhooks/rotate_secret [post]
+func RotateWebhookSecretHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req WebhookIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	e := loadWebhookEndpoint(w, r, req.ID)
+	if e == nil {
+		return
+	}
+	secret := webhooks.NewSecret()
+	if err := db.SetWebhookSecret(db.Postgres, e.ID, secret); err != nil {
+		server.WriteErrorJSON(w, "failed to rotate secret", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"secret":  secret,
+	})
+}
+
+// TestWebhookHandler godoc
+// @Summary Send a test ping
+// @Description Queues a ping event for the endpoint. It is delivered and logged like any other event.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param request body WebhookIDRequest true "Endpoint ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"delivery\": {...}}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"webhook not found\"}"
+// @Security BearerAuth
+// @Router /api/webhooks/test [post]
+func TestWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req WebhookIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	e := loadWebhookEndpoint(w, r, req.ID)
+	if e == nil {
+		return
+	}
+	p := webhooks.NewPayload(webhooks.EventPing, map[string]interface{}{"webhook_id": e.ID})
+	body, _ := json.Marshal(p)
+	d, err := db.EnqueueWebhookDelivery(db.Postgres, e.ID, p.Type, p.ID, string(body))
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to queue ping", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"delivery": d,
+	})
+}
+
+// WebhookDeliveriesHandler godoc
+// @Summary List webhook deliveries
+// @Description Returns the deliveries of an endpoint, newest first. Use status=dead for the dead-letter list.
+// @Tags webhooks
+// @Produce json
+// @Param endpoint_id query int true "Endpoint ID"
+// @Param status query string false "pending, succeeded or dead"
+// @Param limit query int false "Page size, default 50, max 200"
+// @Param offset query int false "Offset"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"deliveries\": []}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"webhook not found\"}"
+// @Security BearerAuth
+// @Router /api/webhooks/deliveries [get]
+func WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	id, err := strconv.ParseInt(q.Get("endpoint_id"), 10, 64)
+	if err != nil {
+		server.WriteErrorJSON(w, "invalid endpoint_id", http.StatusBadRequest)
+		return
+	}
+	status := q.Get("status")
+	switch status {
+	case "", db.WebhookPending, db.WebhookSucceeded, db.WebhookDead:
+	default:
+		server.WriteErrorJSON(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+	limit, offset := 50, 0
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 200 {
+		limit = l
+	}
+	if o, err := strconv.Atoi(q.Get("offset")); err == nil && o >= 0 {
+		offset = o
+	}
+	e := loadWebhookEndpoint(w, r, id)
+	if e == nil {
+		return
+	}
+	list, err := db.ListWebhookDeliveries(db.Postgres, e.ID, status, limit, offset)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load deliveries", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"deliveries": list,
+	})
+}
+
+// loadWebhookDelivery returns the delivery if the caller can see its
+// endpoint, writing the error response otherwise
+func loadWebhookDelivery(w http.ResponseWriter, r *http.Request, id int64) *models.WebhookDelivery {
+	d, err := db.GetWebhookDelivery(db.Postgres, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.WriteErrorJSON(w, "delivery not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load delivery", http.StatusInternalServerError)
+		return nil
+	}
+	if loadWebhookEndpoint(w, r, d.EndpointID) == nil {
+		return nil
+	}
+	return d
+}
+
+// WebhookAttemptsHandler godoc
+// @Summary Delivery attempt log
+// @Description Returns every attempt of a delivery with the status code, error, response excerpt and duration
+// @Tags webhooks
+// @Produce json
+// @Param delivery_id query int true "Delivery ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true, \"delivery\": {...}, \"attempts\": []}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"delivery not found\"}"
+// @Security BearerAuth
+// @Router /api/webhooks/attempts [get]
+func WebhookAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("delivery_id"), 10, 64)
+	if err != nil {
+		server.WriteErrorJSON(w, "invalid delivery_id", http.StatusBadRequest)
+		return
+	}
+	d := loadWebhookDelivery(w, r, id)
+	if d == nil {
+		return
+	}
+	attempts, err := db.ListWebhookAttempts(db.Postgres, d.ID)
+	if err != nil {
+		server.WriteErrorJSON(w, "failed to load attempts", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"delivery": d,
+		"attempts": attempts,
+	})
+}
+
+// RedeliverWebhookHandler godoc
+// @Summary Redeliver a webhook
+// @Description Puts a delivery, usually a dead one, back in the queue with a fresh retry budget. The same event ID and payload are sent again.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param request body WebhookIDRequest true "Delivery ID"
+// @Success 200 {object} map[string]interface{} "Example: {\"success\": true}"
+// @Failure 404 {object} map[string]string "Example: {\"error\": \"delivery not found\"}"
+// @Security BearerAuth
+// @Router /api/webhooks/redeliver [post]
+func RedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req WebhookIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		server.WriteErrorJSON(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	d := loadWebhookDelivery(w, r, req.ID)
+	if d == nil {
+		return
+	}
+	if err := db.RedeliverWebhook(db.Postgres, d.ID); err != nil {
+		server.WriteErrorJSON(w, "failed to queue delivery", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
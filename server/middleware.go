@@ -1,12 +1,9 @@
 package server
 
 import (
-	"bytes"
 	"context"
-	"io"
 	"log"
 	"mFrelance/auth"
-	"mFrelance/db"
 	"net/http"
 	"strings"
 )
@@ -17,10 +14,8 @@ const userContextKey = contextKey("user")
 
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		body, _ := io.ReadAll(r.Body)
-		log.Printf("[AuthMiddleware] Request body: %s", string(body))
-
-		r.Body = io.NopCloser(bytes.NewBuffer(body))
+		// Neither the body nor the headers are logged: they carry passwords,
+		// recovery phrases and tokens
 		log.Printf("[AuthMiddleware] Processing request to %s", r.URL.Path)
 
 		authHeader := r.Header.Get("Authorization")
@@ -29,15 +24,10 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			http.Error(w, "missing Authorization header", http.StatusUnauthorized)
 			return
 		}
-		for name, values := range r.Header {
-			for _, v := range values {
-				log.Printf("[AuthMiddleware] Header: %s=%s", name, v)
-			}
-		}
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			log.Printf("[AuthMiddleware] Invalid Authorization header format: %s", authHeader)
+			log.Printf("[AuthMiddleware] Invalid Authorization header format")
 			http.Error(w, "invalid Authorization header", http.StatusUnauthorized)
 			return
 		}
@@ -65,36 +55,31 @@ func GetUserFromContext(r *http.Request) *auth.Claims {
 	return claims
 }
 
-const (
-	PermBalanceChange = 1 << iota // 1 - право изменять баланс
-	PermUserBlock                 // 2 - право блокировать пользователей
-	PermTransactionView           // 4 - право просматривать транзакции
-	PermTicketManage              // 8 - право управлять тикетами
-	PermDisputeManage             // 16 - право управлять диспутами
-) // 16 + 8 + 4 + 2 + 1 (1<<n =2^n) = 31
-
-func HasPermission(userID int64, perm int) bool {
-	var permissions int
-	err := db.Postgres.Get(&permissions, "SELECT permissions FROM users WHERE id=$1", userID)
-	if err != nil {
-		return false
-	}
-	return permissions&perm != 0
-}
-
-func RequirePermission(perm int) func(http.HandlerFunc) http.HandlerFunc {
-	return func(next http.HandlerFunc) http.HandlerFunc {
-		return func(w http.ResponseWriter, r *http.Request) {
-			claims := GetUserFromContext(r)
-			if claims == nil {
-				http.Error(w, "user not found", http.StatusUnauthorized)
-				return
-			}
-			if !HasPermission(claims.UserID, perm) {
-				http.Error(w, "insufficient permissions", http.StatusForbidden)
+// StreamAuthMiddleware authenticates long-lived connections. Browsers cannot
+// set headers on WebSocket and EventSource requests, so besides the
+// Authorization header the token may be passed as ?access_token=. The body is
+// left untouched.
+func StreamAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("access_token")
+		if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				http.Error(w, "invalid Authorization header", http.StatusUnauthorized)
 				return
 			}
-			next(w, r)
+			token = parts[1]
 		}
-	}
+		if token == "" {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return
+		}
+		claims, err := auth.ParseJWT(token)
+		if err != nil {
+			http.Error(w, "invalid token: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"mFrelance/db"
+	"mFrelance/notifications"
+)
+
+// StartOfferExpiry closes open offers past their expiry every interval and
+// tells their freelancers
+func StartOfferExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			offers, err := db.ExpireTaskOffers(db.Postgres)
+			if err != nil {
+				log.Println("[StartOfferExpiry]", err)
+				continue
+			}
+			for _, o := range offers {
+				notifications.Publish(notifications.Event{
+					Type:  notifications.TypeOfferExpired,
+					Title: "Your offer expired",
+					Body:  "The offer expired before it was accepted",
+					Data: map[string]interface{}{
+						"task_id":  o.TaskID,
+						"offer_id": o.ID,
+					},
+				}, o.FreelancerID)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"mFrelance/db"
+	"mFrelance/models"
+)
+
+// ClientFunds returns the balance a task's escrow is paid from and refunded
+// to: the wallet of the organization it was posted for, otherwise the
+// client's wallet
+func ClientFunds(task *models.Task) (models.Funds, error) {
+	if task.OrgID == nil {
+		return models.GetWalletByUserAndCurrency(db.Postgres, task.ClientID, task.Currency)
+	}
+	w, err := models.GetOrgWallet(db.Postgres, *task.OrgID, task.Currency)
+	if err != nil {
+		return nil, err
+	}
+	w.UserID = &task.ClientID
+	w.TaskID = &task.ID
+	w.DebitKind = models.OrgEntryEscrow
+	w.CreditKind = models.OrgEntryRefund
+	return w, nil
+}
+
+// PayoutFunds returns the balance the accepted offer on a task is paid out
+// to: the wallet of the agency that made it, otherwise the freelancer's
+// wallet
+func PayoutFunds(task *models.Task, offer *models.TaskOffer) (models.Funds, error) {
+	if offer.OrgID == nil {
+		return models.GetWalletByUserAndCurrency(db.Postgres, offer.FreelancerID, task.Currency)
+	}
+	w, err := models.GetOrgWallet(db.Postgres, *offer.OrgID, task.Currency)
+	if err != nil {
+		return nil, err
+	}
+	w.UserID = &offer.FreelancerID
+	w.TaskID = &task.ID
+	w.CreditKind = models.OrgEntryPayout
+	return w, nil
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mFrelance/auth"
+	"mFrelance/db"
+)
+
+// Named permissions, see the permissions table
+const (
+	PermBalanceChange        = "balance.change"
+	PermUserBlock            = "user.block"
+	PermTransactionView      = "transaction.view"
+	PermWalletView           = "wallet.view"
+	PermTicketManage         = "ticket.manage"
+	PermDisputeManage        = "dispute.manage"
+	PermChatManage           = "chat.manage"
+	PermTaskModerate         = "task.moderate"
+	PermRoleManage           = "role.manage"
+	PermAuditView            = "audit.view"
+	PermWebhookManage        = "webhook.manage"
+	PermReportManage         = "report.manage"
+	PermCannedResponseManage = "canned_response.manage"
+	PermReviewModerate       = "review.moderate"
+)
+
+const permCacheTTL = 10 * time.Minute
+
+type cachedPerms struct {
+	Version     int64    `json:"v"`
+	Permissions []string `json:"perms"`
+}
+
+func permVersionKey(userID int64) string {
+	return "rbac:pv:" + strconv.FormatInt(userID, 10)
+}
+
+func permCacheKey(userID int64) string {
+	return "rbac:perms:" + strconv.FormatInt(userID, 10)
+}
+
+// currentPermVersion returns users.perm_version, cached in Redis
+func currentPermVersion(userID int64) (int64, error) {
+	if db.RedisClient != nil {
+		if v, err := db.RedisClient.Get(db.Ctx, permVersionKey(userID)).Int64(); err == nil {
+			return v, nil
+		}
+	}
+	v, err := db.GetPermVersion(db.Postgres, userID)
+	if err != nil {
+		return 0, err
+	}
+	if db.RedisClient != nil {
+		db.RedisClient.Set(db.Ctx, permVersionKey(userID), v, permCacheTTL)
+	}
+	return v, nil
+}
+
+// UserPermissions returns the user's permissions and their version, served
+// from Redis when possible
+func UserPermissions(userID int64) ([]string, int64, error) {
+	if db.RedisClient != nil {
+		if raw, err := db.RedisClient.Get(db.Ctx, permCacheKey(userID)).Bytes(); err == nil {
+			var c cachedPerms
+			if json.Unmarshal(raw, &c) == nil {
+				return c.Permissions, c.Version, nil
+			}
+		}
+	}
+	perms, version, err := db.GetUserPermissions(db.Postgres, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if db.RedisClient != nil {
+		if raw, err := json.Marshal(cachedPerms{Version: version, Permissions: perms}); err == nil {
+			db.RedisClient.Set(db.Ctx, permCacheKey(userID), raw, permCacheTTL)
+		}
+		db.RedisClient.Set(db.Ctx, permVersionKey(userID), version, permCacheTTL)
+	}
+	return perms, version, nil
+}
+
+// InvalidatePermissions drops the cached permissions of the given users.
+// Call it after every role change, the perm_version bump in the database
+// takes care of JWTs already handed out.
+func InvalidatePermissions(userIDs ...int64) {
+	if db.RedisClient == nil || len(userIDs) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(userIDs)*2)
+	for _, id := range userIDs {
+		keys = append(keys, permCacheKey(id), permVersionKey(id))
+	}
+	if err := db.RedisClient.Del(db.Ctx, keys...).Err(); err != nil {
+		log.Println("[RBAC] failed to invalidate permission cache:", err)
+	}
+}
+
+// GenerateUserJWT issues a token carrying the user's current permissions
+func GenerateUserJWT(userID int64, username string) (string, error) {
+	perms, version, err := UserPermissions(userID)
+	if err != nil {
+		return "", err
+	}
+	return auth.GenerateJWTWithPermissions(userID, username, perms, version)
+}
+
+func containsPerm(perms []string, perm string) bool {
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission checks perm for the token owner. Permissions embedded in the
+// JWT are trusted while their version is current, otherwise the cached or
+// stored role assignments are used.
+func HasPermission(claims *auth.Claims, perm string) bool {
+	if claims == nil {
+		return false
+	}
+	if containsPerm(claims.Permissions, perm) {
+		if v, err := currentPermVersion(claims.UserID); err == nil && v == claims.PermVersion {
+			return true
+		}
+	}
+	perms, _, err := UserPermissions(claims.UserID)
+	if err != nil {
+		return false
+	}
+	return containsPerm(perms, perm)
+}
+
+func RequirePermission(perm string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			claims := GetUserFromContext(r)
+			if claims == nil {
+				http.Error(w, "user not found", http.StatusUnauthorized)
+				return
+			}
+			if !HasPermission(claims, perm) {
+				http.Error(w, "insufficient permissions", http.StatusForbidden)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
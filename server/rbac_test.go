@@ -0,0 +1,98 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"mFrelance/auth"
+	"mFrelance/db"
+	"mFrelance/server"
+	"mFrelance/server/testutil"
+)
+
+// useMiniRedis points the permission cache at miniredis. Every test seeds
+// both cache keys, so Postgres is never reached.
+func useMiniRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr, rdb := testutil.NewMiniRedis(t)
+	prev := db.RedisClient
+	db.RedisClient = rdb
+	t.Cleanup(func() {
+		db.RedisClient = prev
+		rdb.Close()
+		mr.Close()
+	})
+	return mr
+}
+
+func cachePerms(t *testing.T, mr *miniredis.Miniredis, userID string, version string, perms string) {
+	t.Helper()
+	if err := mr.Set("rbac:pv:"+userID, version); err != nil {
+		t.Fatal(err)
+	}
+	if err := mr.Set("rbac:perms:"+userID, `{"v":`+version+`,"perms":`+perms+`}`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHasPermission_NilClaims(t *testing.T) {
+	if server.HasPermission(nil, server.PermUserBlock) {
+		t.Fatalf("nil claims must not have permissions")
+	}
+}
+
+func TestHasPermission_CurrentJWT(t *testing.T) {
+	mr := useMiniRedis(t)
+	// The cache no longer lists the permission, the current token still does
+	cachePerms(t, mr, "1", "3", `[]`)
+
+	claims := &auth.Claims{UserID: 1, Permissions: []string{server.PermUserBlock}, PermVersion: 3}
+	if !server.HasPermission(claims, server.PermUserBlock) {
+		t.Fatalf("permission in a current token was rejected")
+	}
+	if server.HasPermission(claims, server.PermRoleManage) {
+		t.Fatalf("permission missing from token and cache was granted")
+	}
+}
+
+func TestHasPermission_StaleJWT(t *testing.T) {
+	mr := useMiniRedis(t)
+	cachePerms(t, mr, "1", "4", `["`+server.PermTicketManage+`"]`)
+
+	claims := &auth.Claims{UserID: 1, Permissions: []string{server.PermUserBlock}, PermVersion: 3}
+	if server.HasPermission(claims, server.PermUserBlock) {
+		t.Fatalf("permission in a stale token was granted")
+	}
+	if !server.HasPermission(claims, server.PermTicketManage) {
+		t.Fatalf("permission granted since the token was issued was rejected")
+	}
+}
+
+func TestUserPermissions_Cached(t *testing.T) {
+	mr := useMiniRedis(t)
+	cachePerms(t, mr, "7", "2", `["`+server.PermAuditView+`"]`)
+
+	perms, version, err := server.UserPermissions(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 || len(perms) != 1 || perms[0] != server.PermAuditView {
+		t.Fatalf("perms=%v version=%d", perms, version)
+	}
+}
+
+func TestInvalidatePermissions(t *testing.T) {
+	mr := useMiniRedis(t)
+	cachePerms(t, mr, "1", "1", `[]`)
+	cachePerms(t, mr, "2", "1", `[]`)
+
+	server.InvalidatePermissions(1)
+
+	if mr.Exists("rbac:pv:1") || mr.Exists("rbac:perms:1") {
+		t.Fatalf("cache of user 1 survived invalidation")
+	}
+	if !mr.Exists("rbac:pv:2") || !mr.Exists("rbac:perms:2") {
+		t.Fatalf("cache of user 2 was dropped")
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/notifications"
+	"mFrelance/reputation"
+)
+
+// NotifyReviewsPublished tells the reviewed users about reviews that just
+// became visible
+func NotifyReviewsPublished(reviews []*models.Review) {
+	for _, r := range reviews {
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeReviewReceived,
+			Title: "New review",
+			Body:  fmt.Sprintf("You were rated %d/5", r.Rating),
+			Data: map[string]interface{}{
+				"review_id":   r.ID,
+				"task_id":     r.TaskID,
+				"reviewer_id": r.ReviewerID,
+				"rating":      r.Rating,
+			},
+		}, r.ReviewedID)
+		reputation.Touch(reputation.ReasonReview, r.ReviewedID)
+	}
+}
+
+// StartReviewPublisher publishes double-blind reviews once the other party
+// had window to answer with their own review
+func StartReviewPublisher(ctx context.Context, window, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reviews, err := db.PublishExpiredReviews(window)
+			if err != nil {
+				log.Println("[StartReviewPublisher]", err)
+				continue
+			}
+			NotifyReviewsPublished(reviews)
+		}
+	}
+}
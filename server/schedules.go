@@ -0,0 +1,279 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"mFrelance/config"
+	"mFrelance/db"
+	"mFrelance/models"
+	"mFrelance/notifications"
+	"mFrelance/schedule"
+	"mFrelance/webhooks"
+)
+
+// StartTaskSchedules runs RunTaskSchedules every interval
+func StartTaskSchedules(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			RunTaskSchedules()
+		}
+	}
+}
+
+// NextScheduleRun returns the first run of a cron expression in timezone
+// after t, in UTC, or nil when the expression doesn't match anymore
+func NextScheduleRun(expr, timezone string, t time.Time) (*time.Time, error) {
+	cron, err := schedule.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", timezone)
+	}
+	next := cron.Next(t.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+	next = next.UTC()
+	return &next, nil
+}
+
+// RunTaskSchedules posts the tasks of the schedules that are due. Runs
+// missed while the server was down are made up by a single task.
+func RunTaskSchedules() {
+	now := time.Now().UTC()
+	due, err := db.ListDueTaskSchedules(db.Postgres, now)
+	if err != nil {
+		log.Println("[RunTaskSchedules]", err)
+		return
+	}
+	for _, s := range due {
+		next, err := NextScheduleRun(s.Cron, s.Timezone, now)
+		if err != nil {
+			log.Printf("[RunTaskSchedules] schedule %d: %v", s.ID, err)
+		}
+		if ok, err := db.ClaimTaskSchedule(db.Postgres, s.ID, *s.NextRunAt, next); err != nil || !ok {
+			if err != nil {
+				log.Printf("[RunTaskSchedules] schedule %d: %v", s.ID, err)
+			}
+			continue
+		}
+
+		task, reason, err := postScheduledTask(&s)
+		if err != nil {
+			log.Printf("[RunTaskSchedules] schedule %d: %v", s.ID, err)
+			reason = "failed to post the task"
+		}
+		var taskID *int64
+		if task != nil {
+			taskID = &task.ID
+		}
+		if err := db.SetTaskScheduleResult(db.Postgres, s.ID, taskID, reason); err != nil {
+			log.Printf("[RunTaskSchedules] schedule %d: %v", s.ID, err)
+		}
+	}
+}
+
+// postScheduledTask posts a run of a schedule. Returns why no task was
+// posted when the run is skipped.
+func postScheduledTask(s *models.TaskSchedule) (*models.Task, string, error) {
+	if blocked, err := db.IsUserBlocked(db.Postgres, s.ClientID); err != nil {
+		return nil, "", err
+	} else if blocked {
+		return nil, "your account is blocked", nil
+	}
+	// scheduled tasks skip the rate and duplicate limits of manual ones, but
+	// never pile up: a run waits until the previous task was taken
+	if s.LastTaskID != nil {
+		last, err := db.GetTask(db.Postgres, *s.LastTaskID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, "", err
+		}
+		if err == nil && last.Status == "open" {
+			return nil, fmt.Sprintf("task %d is still open", last.ID), nil
+		}
+	}
+	tmpl, err := db.GetTaskTemplate(db.Postgres, s.TemplateID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	task := tmpl.Task(time.Now())
+	task.ScheduleID = &s.ID
+	var rehire *models.TaskOffer
+	if s.Rehire {
+		if rehire, err = rehireOffer(s, tmpl); err != nil {
+			return nil, "", err
+		}
+	}
+
+	tx, err := db.Postgres.Beginx()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	if err := db.CreateTaskTx(tx, task); err != nil {
+		return nil, "", err
+	}
+	// the rehire is funded when the debit goes through, the balance is
+	// checked by the debit itself
+	funded := false
+	if rehire != nil {
+		if wallet, err := ClientFunds(task); err == nil {
+			err = wallet.SubBalance(tx, big.NewFloat(rehire.Price))
+			if err != nil && !errors.Is(err, models.ErrInsufficientBalance) {
+				return nil, "", err
+			}
+			funded = err == nil
+		}
+	}
+	var room *models.ChatRoom
+	switch {
+	case funded:
+		if err := db.UpdateTaskStatusTx(tx, task.ID, "in_progress"); err != nil {
+			return nil, "", err
+		}
+		task.Status = "in_progress"
+		err := db.CreateEscrowBalanceTx(tx, &models.EscrowBalance{
+			TaskID:       task.ID,
+			ClientID:     task.ClientID,
+			FreelancerID: rehire.FreelancerID,
+			Amount:       rehire.Price,
+			Currency:     task.Currency,
+			Status:       "pending",
+			CreatedAt:    time.Now(),
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		rehire.TaskID = task.ID
+		if err := db.CreateTaskOfferTx(tx, rehire); err != nil {
+			return nil, "", err
+		}
+		if room, err = db.EnsureTaskChatRoomTx(tx, task.ID, task.ClientID, rehire.FreelancerID); err != nil {
+			return nil, "", err
+		}
+	case rehire != nil:
+		invite := &models.TaskInvite{TaskID: task.ID, FreelancerID: rehire.FreelancerID, Message: rehire.Message}
+		if err := db.CreateTaskInviteTx(tx, invite); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+
+	webhooks.Emit(webhooks.EventTaskCreated, task, task.ClientID)
+	body := task.Title
+	switch {
+	case funded:
+		body = fmt.Sprintf("%s: the previous freelancer was hired again, %v %s is held in escrow", task.Title, rehire.Price, task.Currency)
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeOfferAccepted,
+			Title: "You were hired again",
+			Body:  fmt.Sprintf("%s: %v %s is held in escrow", task.Title, rehire.Price, task.Currency),
+			Data: map[string]interface{}{
+				"task_id":      task.ID,
+				"offer_id":     rehire.ID,
+				"amount":       rehire.Price,
+				"currency":     task.Currency,
+				"chat_room_id": room.ID,
+			},
+		}, rehire.FreelancerID)
+		webhooks.Emit(webhooks.EventOfferAccepted, map[string]interface{}{
+			"task_id":       task.ID,
+			"offer_id":      rehire.ID,
+			"client_id":     task.ClientID,
+			"freelancer_id": rehire.FreelancerID,
+			"amount":        rehire.Price,
+			"currency":      task.Currency,
+		}, task.ClientID, rehire.FreelancerID)
+	case rehire != nil:
+		body = fmt.Sprintf("%s: your balance doesn't cover %v %s, so the previous freelancer was invited to make an offer", task.Title, rehire.Price, task.Currency)
+		notifications.Publish(notifications.Event{
+			Type:  notifications.TypeTaskInvited,
+			Title: "You're invited to a task",
+			Body:  task.Title,
+			Data: map[string]interface{}{
+				"task_id":   task.ID,
+				"client_id": task.ClientID,
+				"message":   rehire.Message,
+			},
+		}, rehire.FreelancerID)
+	}
+	notifications.Publish(notifications.Event{
+		Type:  notifications.TypeTaskScheduled,
+		Title: "Scheduled task posted",
+		Body:  body,
+		Data: map[string]interface{}{
+			"task_id":     task.ID,
+			"schedule_id": s.ID,
+			"funded":      funded,
+		},
+	}, task.ClientID)
+	return task, "", nil
+}
+
+// rehireOffer returns an accepted offer on the terms of the schedule's
+// previous contract, or nil when there was none or its freelancer can't be
+// hired for the template as it is now
+func rehireOffer(s *models.TaskSchedule, tmpl *models.TaskTemplate) (*models.TaskOffer, error) {
+	prev, err := db.GetScheduleRehireOffer(db.Postgres, s)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prevTask, err := db.GetTask(db.Postgres, prev.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	if prevTask.Currency != tmpl.Currency || prevTask.ContractType != tmpl.ContractType {
+		return nil, nil
+	}
+	if prev.Price < config.AppConfig.MinTransactionAmount {
+		return nil, nil
+	}
+	if blocked, err := db.IsUserBlocked(db.Postgres, prev.FreelancerID); err != nil || blocked {
+		return nil, err
+	}
+	if blocked, err := db.IsBlockedBetween(db.Postgres, s.ClientID, prev.FreelancerID); err != nil || blocked {
+		return nil, err
+	}
+	// an agency's offer is renewed while the freelancer is still its member
+	orgID := prev.OrgID
+	if orgID != nil {
+		m, err := db.GetOrgMember(db.Postgres, *orgID, prev.FreelancerID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if m == nil || !m.Active() {
+			orgID = nil
+		}
+	}
+	return &models.TaskOffer{
+		OrgID:        orgID,
+		FreelancerID: prev.FreelancerID,
+		Price:        prev.Price,
+		HourlyRate:   prev.HourlyRate,
+		WeeklyCap:    prev.WeeklyCap,
+		Message:      fmt.Sprintf("Same terms as task %d", prev.TaskID),
+		Status:       models.OfferAccepted,
+		CreatedAt:    time.Now(),
+	}, nil
+}
@@ -31,3 +31,15 @@ func AddToTxPool(address string, amount *big.Float, currency string) {
         Currency: currency,
     })
 }
+
+// AddWithdrawalToTxPool is AddToTxPool for an output paid out to a user,
+// a withdrawal.broadcast webhook is emitted once it has been sent
+func AddWithdrawalToTxPool(userID int64, address string, amount *big.Float, currency string) {
+    txPool.Lock()
+    defer txPool.Unlock()
+    txPool.outputs[address] = append(txPool.outputs[address], TxPoolItem{
+        Amount:   amount,
+        Currency: currency,
+        UserID:   userID,
+    })
+}
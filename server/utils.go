@@ -5,13 +5,14 @@ import (
 	"encoding/json"
 	"errors"
 	bip39 "github.com/tyler-smith/go-bip39"
-	"golang.org/x/crypto/bcrypt"
 	"html"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 	"log"
+	"mFrelance/auth"
+	"mFrelance/config"
 	"mFrelance/models"
 	"net/http"
 	"regexp"
@@ -20,6 +21,10 @@ import (
 
 const MAX_MESSAGE_SIZE = 256
 
+// MAX_CIPHERTEXT_SIZE bounds the decoded ciphertext of an encrypted message,
+// leaving room for padding and the authentication tag
+const MAX_CIPHERTEXT_SIZE = 1024
+
 func writeErrorJSON(w http.ResponseWriter, msg string, code int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -44,6 +49,37 @@ func ValidateMessage(message string) error {
 	return nil
 }
 
+// ValidateChatMessage applies ValidateMessage to chat messages. A message
+// may have no text when it carries attachments.
+func ValidateChatMessage(message string, attachments int) error {
+	if attachments > config.AppConfig.ChatMaxAttachments {
+		return errors.New("Too many attachments")
+	}
+	if message == "" && attachments > 0 {
+		return nil
+	}
+	return ValidateMessage(message)
+}
+
+// ValidateEncryptedMessage checks the base64 ciphertext of an end-to-end
+// encrypted chat message. The server can't look inside.
+func ValidateEncryptedMessage(ciphertext string, attachments int) error {
+	if attachments > config.AppConfig.ChatMaxAttachments {
+		return errors.New("Too many attachments")
+	}
+	raw, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return errors.New("Ciphertext must be base64")
+	}
+	if len(raw) == 0 {
+		return errors.New("Message is null")
+	}
+	if len(raw) > MAX_CIPHERTEXT_SIZE {
+		return errors.New("Very big message")
+	}
+	return nil
+}
+
 func ValidateTicketField(subject, message string) error {
 	subject = strings.TrimSpace(subject)
 	message = strings.TrimSpace(message)
@@ -95,17 +131,19 @@ func GenerateMnemonic() string {
 	return mnemonic
 }
 
+// HashPassword hashes with argon2id, see auth.HashPassword
 func HashPassword(password string) string {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashed, err := auth.HashPassword(password)
 	if err != nil {
 		log.Fatal("Failed to hash password:", err)
 	}
-	return string(hashed)
+	return hashed
 }
 
+// VerifyPassword accepts both argon2id and legacy bcrypt hashes
 func VerifyPassword(password, hashed string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
-	return err == nil
+	ok, _ := auth.VerifyPassword(password, hashed)
+	return ok
 }
 
 func IsValidBTCAddress(address string) bool {
@@ -131,6 +169,19 @@ func SanitizeProfile(p *models.Profile) {
 	p.Avatar = SanitizeString(p.Avatar)
 }
 
+// ProfileBadges returns the badges a user earned: verified for a confirmed
+// email address, top_rated for a reputation score above the configured bar
+func ProfileBadges(emailVerified bool, reputation float64, reviewCount int) []string {
+	badges := []string{}
+	if emailVerified {
+		badges = append(badges, models.BadgeVerified)
+	}
+	if reputation >= config.AppConfig.TopRatedScore && reviewCount >= config.AppConfig.TopRatedMinReviews {
+		badges = append(badges, models.BadgeTopRated)
+	}
+	return badges
+}
+
 func IsBase64(s string) bool {
 	s = strings.TrimSpace(s)
 	_, err := base64.StdEncoding.DecodeString(s)
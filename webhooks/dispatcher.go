@@ -0,0 +1,170 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"mFrelance/config"
+	"mFrelance/db"
+	"mFrelance/models"
+)
+
+// responseLimit is how much of the endpoint's response is kept in the log
+const responseLimit = 1024
+
+var errPrivateAddress = errors.New("webhook target resolves to a private address")
+
+// Dispatcher sends queued deliveries
+type Dispatcher struct {
+	Client      *http.Client
+	MaxAttempts int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	BatchSize   int
+	Interval    time.Duration
+}
+
+// NewDispatcher builds a dispatcher from the webhooks section of the config
+func NewDispatcher() *Dispatcher {
+	c := config.AppConfig
+	return &Dispatcher{
+		Client:      NewClient(c.WebhookTimeout, c.WebhookAllowPrivate),
+		MaxAttempts: c.WebhookMaxAttempts,
+		BackoffBase: c.WebhookBackoffBase,
+		BackoffMax:  c.WebhookBackoffMax,
+		BatchSize:   c.WebhookBatchSize,
+		Interval:    c.WebhookDispatchInterval,
+	}
+}
+
+// NewClient returns an HTTP client for webhook targets. Unless allowPrivate
+// is set it refuses to connect to loopback, private and link-local
+// addresses, so endpoints can't be used to reach internal services.
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || isPrivateIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// Start runs the dispatcher until ctx is done
+func (d *Dispatcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce claims a batch of due deliveries and sends them
+func (d *Dispatcher) RunOnce(ctx context.Context) {
+	// the lease outlives the whole batch, a crashed instance's
+	// deliveries are picked up again once it expires
+	lease := d.Client.Timeout*time.Duration(d.BatchSize) + time.Minute
+	jobs, err := db.ClaimWebhookDeliveries(db.Postgres, d.BatchSize, lease)
+	if err != nil {
+		log.Printf("[webhooks] failed to claim deliveries: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return
+		}
+		d.deliver(ctx, job)
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, job models.WebhookJob) {
+	attempt := &models.WebhookAttempt{
+		DeliveryID: job.ID,
+		Attempt:    job.Attempts + 1,
+	}
+	start := time.Now()
+	code, resp, err := d.send(ctx, job)
+	attempt.DurationMS = time.Since(start).Milliseconds()
+	attempt.Response = resp
+	if code != 0 {
+		attempt.StatusCode = &code
+	}
+
+	status := db.WebhookSucceeded
+	next := time.Now()
+	switch {
+	case err != nil:
+		attempt.Error = err.Error()
+	case code < 200 || code > 299:
+		attempt.Error = fmt.Sprintf("unexpected status %d", code)
+	}
+	if attempt.Error != "" {
+		status = db.WebhookPending
+		next = next.Add(Backoff(attempt.Attempt, d.BackoffBase, d.BackoffMax))
+		if attempt.Attempt >= d.MaxAttempts {
+			status = db.WebhookDead
+			log.Printf("[webhooks] delivery %d to %s is dead after %d attempts: %s", job.ID, job.URL, attempt.Attempt, attempt.Error)
+		}
+	}
+	if err := db.RecordWebhookAttempt(db.Postgres, attempt, status, next); err != nil {
+		log.Printf("[webhooks] failed to record attempt of delivery %d: %v", job.ID, err)
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, job models.WebhookJob) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.URL, bytes.NewReader(job.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Symbio-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Id", job.EventID)
+	req.Header.Set("X-Webhook-Event", job.Event)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-Webhook-Signature", Sign(job.Secret, ts, job.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, responseLimit))
+	return resp.StatusCode, string(body), nil
+}
@@ -0,0 +1,141 @@
+// Package webhooks delivers domain events to URLs registered by integrators.
+// Emit queues one delivery per subscribed endpoint in Postgres, the
+// dispatcher sends them signed and retries failures with exponential backoff
+// until they succeed or end up in the dead-letter list.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"mFrelance/db"
+)
+
+// Events integrators can subscribe to
+const (
+	EventTaskCreated         = "task.created"
+	EventOfferAccepted       = "offer.accepted"
+	EventEscrowReleased      = "escrow.released"
+	EventDisputeOpened       = "dispute.opened"
+	EventDisputeResolved     = "dispute.resolved"
+	EventDepositConfirmed    = "deposit.confirmed"
+	EventWithdrawalBroadcast = "withdrawal.broadcast"
+
+	// EventPing is only sent by the test endpoint
+	EventPing = "ping"
+)
+
+// Events lists every event an endpoint can subscribe to
+var Events = []string{
+	EventTaskCreated,
+	EventOfferAccepted,
+	EventEscrowReleased,
+	EventDisputeOpened,
+	EventDisputeResolved,
+	EventDepositConfirmed,
+	EventWithdrawalBroadcast,
+}
+
+// IsKnownEvent reports whether event is one of Events
+func IsKnownEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload is the body POSTed to endpoints
+type Payload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// NewPayload wraps data in an envelope with a fresh event ID
+func NewPayload(event string, data interface{}) Payload {
+	return Payload{
+		ID:        NewID(),
+		Type:      event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+}
+
+// NewID returns a random hex identifier, used for event IDs and secrets
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewSecret returns a signing secret for a new endpoint
+func NewSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// Emit queues event for the global endpoints and for the endpoints owned
+// by userIDs. Errors are logged, emitting never fails the caller.
+func Emit(event string, data interface{}, userIDs ...int64) {
+	if db.Postgres == nil {
+		return
+	}
+	p := NewPayload(event, data)
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("[webhooks] failed to encode %s: %v", event, err)
+		return
+	}
+	ids := make([]int64, 0, len(userIDs))
+	for _, id := range userIDs {
+		if id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	if _, err := db.EnqueueWebhookDeliveries(db.Postgres, event, p.ID, string(body), ids); err != nil {
+		log.Printf("[webhooks] failed to queue %s: %v", event, err)
+	}
+}
+
+// Sign returns the signature header value for body sent at ts. Receivers
+// compute the same HMAC over "<timestamp>.<body>" with their secret.
+func Sign(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns the delay before the next try after attempt failed
+// attempts: base * 2^(attempt-1), capped at max
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := base
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= max || d <= 0 {
+			return max
+		}
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
@@ -0,0 +1,59 @@
+package webhooks_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mFrelance/webhooks"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"1","type":"ping"}`)
+	a := webhooks.Sign("secret", 1700000000, body)
+	if a != webhooks.Sign("secret", 1700000000, body) {
+		t.Fatalf("signature is not deterministic")
+	}
+	if a == webhooks.Sign("other", 1700000000, body) {
+		t.Fatalf("signature does not depend on the secret")
+	}
+	if a == webhooks.Sign("secret", 1700000001, body) {
+		t.Fatalf("signature does not depend on the timestamp")
+	}
+	if len(a) != len("sha256=")+64 || a[:7] != "sha256=" {
+		t.Fatalf("unexpected signature format %q", a)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := 30*time.Second, 10*time.Minute
+	cases := map[int]time.Duration{
+		0:  30 * time.Second,
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		5:  8 * time.Minute,
+		6:  max,
+		60: max,
+	}
+	for attempt, want := range cases {
+		if got := webhooks.Backoff(attempt, base, max); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestClient_RefusesPrivateTargets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	if _, err := webhooks.NewClient(time.Second, false).Post(srv.URL, "application/json", nil); err == nil {
+		t.Fatalf("request to loopback target was allowed")
+	}
+	resp, err := webhooks.NewClient(time.Second, true).Post(srv.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("allow_private client failed: %v", err)
+	}
+	resp.Body.Close()
+}